@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	stdimage "image"
 	"io"
 	stdmath "math"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	spectralapp "spectralmark/internal/app"
 	spectralbench "spectralmark/internal/bench"
@@ -15,6 +23,7 @@ import (
 	spectralmath "spectralmark/internal/math"
 	spectralutil "spectralmark/internal/util"
 	spectralwm "spectralmark/internal/wm"
+	"spectralmark/pkg/spectralmark"
 )
 
 func main() {
@@ -40,12 +49,22 @@ func run(args []string) int {
 		return runToGray(args[1:])
 	case "dct-check":
 		return runDCTCheck(args[1:])
+	case "bitexact-check":
+		return runBitExactCheck(args[1:])
 	case "detect":
 		return runDetect(args[1:])
 	case "bench":
 		return runBench(args[1:])
 	case "demo":
 		return runDemo(args[1:])
+	case "fingerprint":
+		return runFingerprint(args[1:])
+	case "trace":
+		return runTrace(args[1:])
+	case "video-embed":
+		return runVideoEmbed(args[1:])
+	case "video-detect":
+		return runVideoDetect(args[1:])
 	case "serve":
 		return runServe(args[1:])
 	case "metrics":
@@ -64,15 +83,20 @@ func printUsage(w io.Writer) {
 	fmt.Fprintln(w, "Usage: spectralmark <command>")
 	fmt.Fprintln(w)
 	fmt.Fprintln(w, "Commands:")
-	fmt.Fprintln(w, "  embed    Embed payload into PPM image")
+	fmt.Fprintln(w, "  embed    Embed payload into a PPM, PGM, PNG, JPEG, GIF, BMP or TGA image")
 	fmt.Fprintln(w, "  prng-demo Print deterministic PRNG samples from a key")
 	fmt.Fprintln(w, "  payload-demo Encode/decode payload bits with repetition coding")
 	fmt.Fprintln(w, "  ppm-copy Copy a P6 PPM file")
 	fmt.Fprintln(w, "  to-gray  Convert a PPM image to grayscale")
-	fmt.Fprintln(w, "  dct-check Print max reconstruction error for DCT8->IDCT8")
+	fmt.Fprintln(w, "  dct-check Check DCT8 round trip and fast DCT against the reference")
+	fmt.Fprintln(w, "  bitexact-check Verify bit-exact embed output against the determinism vectors")
 	fmt.Fprintln(w, "  detect   Detect watermark and recover message")
 	fmt.Fprintln(w, "  bench    Run attack robustness benchmark")
 	fmt.Fprintln(w, "  demo     One-command embed + 3 attack demo")
+	fmt.Fprintln(w, "  fingerprint Embed a per-recipient collusion-resistant fingerprint")
+	fmt.Fprintln(w, "  trace    Accuse recipients whose fingerprints are in a (colluded) copy")
+	fmt.Fprintln(w, "  video-embed Embed payload into every frame of a sequence")
+	fmt.Fprintln(w, "  video-detect Detect over frames, combining evidence across them")
 	fmt.Fprintln(w, "  serve    Start local web UI for embed/detect")
 	fmt.Fprintln(w, "  metrics  Compute PSNR and write amplified diff image")
 	fmt.Fprintln(w, "  help     Show this help")
@@ -128,12 +152,40 @@ func runEmbed(args []string) int {
 	var key string
 	var msg string
 	var alpha float64
-
-	fs.StringVar(&inPath, "in", "", "input PPM path")
-	fs.StringVar(&outPath, "out", "", "output PPM path")
+	var workers int
+	var timeout time.Duration
+	var closedLoop int
+	var pyramid int
+	var profile string
+	var bitExact bool
+	var ascii bool
+	var quality int
+	var jpegCoeff bool
+	var rle bool
+	var keepPalette bool
+	var maskPath string
+	var include string
+	var exclude string
+
+	fs.StringVar(&inPath, "in", "", "input image path (PPM, PGM, PNG, JPEG, GIF, BMP or TGA), - for stdin")
+	fs.StringVar(&outPath, "out", "", outPathUsage)
 	fs.StringVar(&key, "key", "", "embedding key")
 	fs.StringVar(&msg, "msg", "", "message payload")
 	fs.Float64Var(&alpha, "alpha", 3.0, "embedding strength")
+	fs.IntVar(&closedLoop, "closed-loop", 0, "re-measure after 8-bit rounding and top up weak slots, up to n times per block")
+	fs.IntVar(&pyramid, "pyramid", 0, fmt.Sprintf("also mark the 1/2 .. 1/2^n downscaled levels so thumbnails keep the mark (0-%d)", spectralmark.MaxPyramidLevels))
+	fs.StringVar(&profile, "profile", spectralwm.DefaultProfileName, "block size profile ("+strings.Join(spectralwm.ProfileNames(), ", ")+")")
+	fs.BoolVar(&bitExact, "bit-exact", false, "use integer arithmetic so output is byte-identical on every platform")
+	fs.BoolVar(&ascii, "ascii", false, "write plain (ASCII) PPM/PGM")
+	fs.IntVar(&quality, "quality", spectralmark.DefaultJPEGQuality, "JPEG output quality (1-100)")
+	fs.BoolVar(&rle, "rle", false, "write run-length encoded BMP (grayscale only) or TGA")
+	fs.BoolVar(&keepPalette, "keep-palette", false, "mark paletted input using only its original palette entries")
+	fs.StringVar(&maskPath, "mask", "", "region mask image the size of the input: the mark stays out of dark or transparent areas")
+	fs.StringVar(&include, "include", "", "only mark inside these rectangles, x0,y0,x1,y1 separated by ;")
+	fs.StringVar(&exclude, "exclude", "", "keep these rectangles unmarked, x0,y0,x1,y1 separated by ;")
+	fs.BoolVar(&jpegCoeff, "jpeg-coeff", false, "embed in the quantized DCT coefficients of a JPEG input, with no decode/re-encode")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
 	fs.SetOutput(io.Discard)
 
 	if err := fs.Parse(args); err != nil {
@@ -152,22 +204,307 @@ func runEmbed(args []string) int {
 		printEmbedUsage(os.Stderr)
 		return 1
 	}
+	if workers < 0 {
+		fmt.Fprintln(os.Stderr, "--workers must be >= 0")
+		printEmbedUsage(os.Stderr)
+		return 1
+	}
+	if timeout < 0 {
+		fmt.Fprintln(os.Stderr, "--timeout must be >= 0")
+		printEmbedUsage(os.Stderr)
+		return 1
+	}
+	if closedLoop < 0 {
+		fmt.Fprintln(os.Stderr, "--closed-loop must be >= 0")
+		printEmbedUsage(os.Stderr)
+		return 1
+	}
+	if pyramid < 0 || pyramid > spectralmark.MaxPyramidLevels {
+		fmt.Fprintf(os.Stderr, "--pyramid must be between 0 and %d\n", spectralmark.MaxPyramidLevels)
+		printEmbedUsage(os.Stderr)
+		return 1
+	}
+	if quality < 1 || quality > 100 {
+		fmt.Fprintln(os.Stderr, "--quality must be between 1 and 100")
+		printEmbedUsage(os.Stderr)
+		return 1
+	}
+	if f, ok := spectralimage.FormatFromPath(outPath); jpegCoeff && f != spectralimage.FormatJPEG && (ok || outPath != spectralimage.StdioPath) {
+		fmt.Fprintln(os.Stderr, "--jpeg-coeff needs a .jpg or .jpeg --out, or -")
+		printEmbedUsage(os.Stderr)
+		return 1
+	}
 	if fs.NArg() != 0 {
 		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
 		printEmbedUsage(os.Stderr)
 		return 1
 	}
 
-	if err := spectralwm.EmbedPPM(inPath, outPath, key, msg, float32(alpha)); err != nil {
+	region, err := readRegion(maskPath, include, exclude)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "embed failed: %v\n", err)
 		return 1
 	}
 
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	opts := spectralmark.EmbedOptions{
+		Key:                  key,
+		Message:              msg,
+		Alpha:                float32(alpha),
+		Profile:              profile,
+		ClosedLoopIterations: closedLoop,
+		PyramidLevels:        pyramid,
+		BitExact:             bitExact,
+		KeepPalette:          keepPalette,
+		Region:               region,
+	}
+
+	var report spectralmark.EmbedReport
+	if jpegCoeff {
+		r, err := embedJPEGFile(ctx, inPath, outPath, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "embed failed: %v\n", err)
+			return 1
+		}
+		report = *r
+	} else {
+		encodeOpts := spectralimage.EncodeOptions{ASCII: ascii, Quality: quality, RLE: rle}
+		r, err := embedImageFile(ctx, inPath, outPath, opts, encodeOpts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "embed failed: %v\n", err)
+			return 1
+		}
+		report = *r
+	}
+
+	if closedLoop > 0 {
+		out := reportWriter(outPath)
+		fmt.Fprintf(out, "slots: %d\n", report.Slots)
+		fmt.Fprintf(out, "target margin: %.4f\n", report.Target)
+		fmt.Fprintf(out, "min margin: %.4f\n", report.MinMargin)
+		fmt.Fprintf(out, "mean margin: %.4f\n", report.MeanMargin)
+		fmt.Fprintf(out, "below target: %d\n", report.Deficient)
+		fmt.Fprintf(out, "max iterations: %d\n", report.MaxIterations)
+	}
+
 	return 0
 }
 
+// embedImageFile marks the image at inPath into outPath. An animated GIF or
+// APNG written as GIF or PNG, or to stdout, is marked frame by frame and
+// reported by its weakest frame; any other output takes the first frame.
+func embedImageFile(ctx context.Context, inPath, outPath string, opts spectralmark.EmbedOptions, encodeOpts spectralimage.EncodeOptions) (*spectralmark.EmbedReport, error) {
+	data, err := spectralimage.ReadFile(inPath)
+	if err != nil {
+		return nil, err
+	}
+	img, meta, inFormat, err := decodeImageData(inPath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	outFormat := spectralimage.OutputFormat(outPath, inFormat)
+	if spectralimage.IsAnimation(data) {
+		if outPath == spectralimage.StdioPath {
+			outFormat = inFormat
+		}
+		if outFormat == spectralimage.FormatGIF || outFormat == spectralimage.FormatPNG {
+			return embedAnimationFile(ctx, data, outPath, outFormat, opts)
+		}
+	}
+	if !outFormat.HasAlpha() {
+		img = spectralmark.WithoutAlpha(img)
+	}
+
+	// The mark is laid on the grid as displayed, as /embed does. PNG and
+	// JPEG keep the pixels as stored along with the EXIF, ICC profile and
+	// text; the other formats cannot hold EXIF, so they get the orientation
+	// applied to the pixels instead.
+	opts.Orientation = meta.Orientation()
+	res, err := spectralmark.Embed(ctx, img, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := res.Native
+	if !outFormat.KeepsMetadata() {
+		out = spectralimage.Orient(out, opts.Orientation)
+	}
+	if err := spectralimage.WriteImageFileMetadata(outPath, out, outFormat, encodeOpts, meta); err != nil {
+		return nil, err
+	}
+	return &res.Report, nil
+}
+
+func embedAnimationFile(ctx context.Context, data []byte, outPath string, outFormat spectralimage.Format, opts spectralmark.EmbedOptions) (report *spectralmark.EmbedReport, err error) {
+	var buf bytes.Buffer
+	r, err := spectralmark.EmbedAnimation(ctx, bytes.NewReader(data), &buf, spectralmark.AnimationOptions{
+		EmbedOptions: opts,
+		Format:       string(outFormat),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := spectralimage.CreateFile(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	if _, err := out.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(reportWriter(outPath), "frames marked: %d/%d\n", r.Marked, r.Frames)
+	for _, fr := range r.FrameReports {
+		if fr != nil && (report == nil || fr.MinMargin < report.MinMargin) {
+			report = fr
+		}
+	}
+	return report, nil
+}
+
+// readImageFile decodes path ("-" for stdin) as it is displayed, with any
+// EXIF orientation applied, and reports its format. It is for commands that
+// read pixels without writing the image back.
+func readImageFile(path string) (stdimage.Image, spectralimage.Format, error) {
+	data, err := spectralimage.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	img, meta, format, err := decodeImageData(path, data)
+	if err != nil {
+		return nil, "", err
+	}
+	return spectralimage.Orient(img, meta.Orientation()), format, nil
+}
+
+// decodeImageData decodes data read from path as stored, with its metadata
+// and format. An animation decodes to its first frame.
+func decodeImageData(path string, data []byte) (stdimage.Image, *spectralimage.Metadata, spectralimage.Format, error) {
+	img, format, err := spectralimage.DecodeImage(data)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("read %s: %w", path, err)
+	}
+	meta, err := spectralimage.ReadMetadata(data)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("read %s: %w", path, err)
+	}
+	return img, meta, format, nil
+}
+
+// readRegion builds the region of the --mask, --include and --exclude
+// flags, nil if none is set.
+func readRegion(maskPath, include, exclude string) (*spectralmark.Region, error) {
+	if maskPath == "" && include == "" && exclude == "" {
+		return nil, nil
+	}
+	region := &spectralmark.Region{}
+	var err error
+	if maskPath != "" {
+		if region.Mask, _, err = readImageFile(maskPath); err != nil {
+			return nil, err
+		}
+	}
+	if region.Include, err = spectralmark.ParseRectangles(include); err != nil {
+		return nil, err
+	}
+	if region.Exclude, err = spectralmark.ParseRectangles(exclude); err != nil {
+		return nil, err
+	}
+	return region, nil
+}
+
+const outPathUsage = "output path, format by extension (.png, .jpg/.jpeg, .gif, .bmp, .tga, anything else PPM or PGM); - for stdout in the input's format"
+
+// reportWriter is where a command prints its results: stderr when the image
+// itself goes to stdout.
+func reportWriter(outPath string) io.Writer {
+	if outPath == spectralimage.StdioPath {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// embedJPEGFile marks a JPEG in its DCT coefficients. The output keeps the
+// input's EXIF, so the orientation is honoured rather than baked in.
+func embedJPEGFile(ctx context.Context, inPath, outPath string, opts spectralmark.EmbedOptions) (report *spectralmark.EmbedReport, err error) {
+	data, err := spectralimage.ReadFile(inPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	report, err = spectralmark.EmbedJPEG(ctx, bytes.NewReader(data), &buf, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", inPath, err)
+	}
+
+	out, err := spectralimage.CreateFile(outPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	if _, err := out.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// commandContext is cancelled on Ctrl-C and, if timeout > 0, after timeout.
+func commandContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
 func printEmbedUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: spectralmark embed --in <input.ppm> --out <output.ppm> --key <key> --msg <msg> --alpha <strength>")
+	fmt.Fprintln(w, "Usage: spectralmark embed --in <input.ppm|pgm|png|jpg|gif|bmp|tga|-> --out <output.ppm|pgm|png|jpg|gif|bmp|tga|-> --key <key> --msg <msg> --alpha <strength> [--profile <8x8|16x16|32x32>] [--closed-loop <n>] [--pyramid <0-3>] [--bit-exact] [--keep-palette] [--mask <image>] [--include <rects>] [--exclude <rects>] [--ascii] [--quality <1-100>] [--rle] [--jpeg-coeff] [--workers <n>] [--timeout <duration>]")
+}
+
+func runBitExactCheck(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: spectralmark bitexact-check")
+		return 1
+	}
+
+	results, err := spectralwm.CheckBitExactVectors(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitexact-check failed: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if !r.OK {
+			status = "MISMATCH"
+			failed++
+		}
+		fmt.Printf("%-20s %s %s\n", r.Vector.Name, r.Got, status)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d vectors differ from the expected digest\n", failed, len(results))
+		return 1
+	}
+	return 0
 }
 
 func printPPMCopyUsage(w io.Writer) {
@@ -229,6 +566,10 @@ func printToGrayUsage(w io.Writer) {
 	fmt.Fprintln(w, "Usage: spectralmark to-gray --in <input.ppm> --out <output.ppm>")
 }
 
+// dctCheckTolerance is the largest difference allowed between the fast and
+// reference transforms on 8-bit-range input before dct-check fails.
+const dctCheckTolerance = 1e-3
+
 func runDCTCheck(args []string) int {
 	if len(args) != 0 {
 		fmt.Fprintln(os.Stderr, "Usage: spectralmark dct-check")
@@ -242,21 +583,68 @@ func runDCTCheck(args []string) int {
 		}
 	}
 
-	coeff := spectralmath.DCT8(block)
-	recon := spectralmath.IDCT8(coeff)
+	coeff := spectralmath.DCT8Ref(block)
+	recon := spectralmath.IDCT8Ref(coeff)
+	fmt.Printf("max reconstruction error: %.9f\n", maxBlockDiff(recon, block))
+
+	// Compare the fast transform with the reference on random 8-bit blocks.
+	rng := spectralwm.NewPRNG(spectralwm.SeedFromKey("dct-check"))
+	maxFwd := float32(0)
+	maxInv := float32(0)
+	maxRoundTrip := float32(0)
+	for n := 0; n < 1000; n++ {
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				block[y][x] = rng.NextF32() * 255
+			}
+		}
+
+		refCoeff := spectralmath.DCT8Ref(block)
+		fastCoeff := spectralmath.DCT8(block)
+		maxFwd = max(maxFwd, maxBlockDiff(fastCoeff, refCoeff))
+		maxInv = max(maxInv, maxBlockDiff(spectralmath.IDCT8(refCoeff), spectralmath.IDCT8Ref(refCoeff)))
+		maxRoundTrip = max(maxRoundTrip, maxBlockDiff(spectralmath.IDCT8(fastCoeff), block))
+	}
+
+	fmt.Printf("fast vs reference forward max diff: %.9f\n", maxFwd)
+	fmt.Printf("fast vs reference inverse max diff: %.9f\n", maxInv)
+	fmt.Printf("fast round-trip max error: %.9f\n", maxRoundTrip)
+	if maxFwd > dctCheckTolerance || maxInv > dctCheckTolerance || maxRoundTrip > dctCheckTolerance {
+		fmt.Fprintf(os.Stderr, "fast DCT exceeds tolerance %g\n", dctCheckTolerance)
+		return 1
+	}
+
+	for _, n := range []int{16, 32} {
+		src := make([]float32, n*n)
+		for i := range src {
+			src[i] = rng.NextF32() * 255
+		}
+		recon := spectralmath.IDCTN(spectralmath.DCTN(src, n), n)
 
+		maxErr := float32(0)
+		for i := range src {
+			maxErr = max(maxErr, float32(stdmath.Abs(float64(recon[i]-src[i]))))
+		}
+		fmt.Printf("DCT%d round-trip max error: %.9f\n", n, maxErr)
+		if maxErr > dctCheckTolerance {
+			fmt.Fprintf(os.Stderr, "DCT%d exceeds tolerance %g\n", n, dctCheckTolerance)
+			return 1
+		}
+	}
+	return 0
+}
+
+func maxBlockDiff(a, b [8][8]float32) float32 {
 	maxErr := float32(0)
 	for y := 0; y < 8; y++ {
 		for x := 0; x < 8; x++ {
-			err := float32(stdmath.Abs(float64(recon[y][x] - block[y][x])))
+			err := float32(stdmath.Abs(float64(a[y][x] - b[y][x])))
 			if err > maxErr {
 				maxErr = err
 			}
 		}
 	}
-
-	fmt.Printf("max reconstruction error: %.9f\n", maxErr)
-	return 0
+	return maxErr
 }
 
 func runDetect(args []string) int {
@@ -264,8 +652,20 @@ func runDetect(args []string) int {
 
 	var inPath string
 	var key string
-	fs.StringVar(&inPath, "in", "", "input PPM path")
+	var workers int
+	var timeout time.Duration
+	var profile string
+	var maskPath string
+	var include string
+	var exclude string
+	fs.StringVar(&inPath, "in", "", "input image path (PPM, PGM, PNG, JPEG, GIF, BMP or TGA), - for stdin")
 	fs.StringVar(&key, "key", "", "detection key")
+	fs.StringVar(&profile, "profile", "", "only try this block size profile (default: try all)")
+	fs.StringVar(&maskPath, "mask", "", "region mask the image was embedded with")
+	fs.StringVar(&include, "include", "", "--include rectangles the image was embedded with")
+	fs.StringVar(&exclude, "exclude", "", "--exclude rectangles the image was embedded with")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
 	fs.SetOutput(io.Discard)
 
 	if err := fs.Parse(args); err != nil {
@@ -278,30 +678,75 @@ func runDetect(args []string) int {
 		printDetectUsage(os.Stderr)
 		return 1
 	}
+	if workers < 0 {
+		fmt.Fprintln(os.Stderr, "--workers must be >= 0")
+		printDetectUsage(os.Stderr)
+		return 1
+	}
+	if timeout < 0 {
+		fmt.Fprintln(os.Stderr, "--timeout must be >= 0")
+		printDetectUsage(os.Stderr)
+		return 1
+	}
 	if fs.NArg() != 0 {
 		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
 		printDetectUsage(os.Stderr)
 		return 1
 	}
 
-	score, present, msg, ok, err := spectralwm.DetectPPM(inPath, key)
+	region, err := readRegion(maskPath, include, exclude)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "detect failed: %v\n", err)
 		return 1
 	}
 
-	fmt.Printf("score: %.4f\n", score)
-	fmt.Printf("present: %v\n", present)
-	fmt.Printf("decode ok: %v\n", ok)
-	if ok {
-		fmt.Printf("msg: %s\n", msg)
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	data, err := spectralimage.ReadFile(inPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "detect failed: %v\n", err)
+		return 1
+	}
+
+	// An animation combines the evidence of all its frames.
+	opts := spectralmark.DetectOptions{Key: key, Profile: profile, Region: region}
+	var det *spectralmark.DetectResult
+	if spectralimage.IsAnimation(data) {
+		res, err := spectralmark.DetectAnimation(ctx, bytes.NewReader(data), opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "detect failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("frames used: %d/%d\n", res.FramesUsed, res.Frames)
+		det = &res.DetectResult
+	} else {
+		img, meta, _, err := decodeImageData(inPath, data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "detect failed: %v\n", err)
+			return 1
+		}
+		opts.Orientation = meta.Orientation()
+		det, err = spectralmark.Detect(ctx, img, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "detect failed: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("score: %.4f\n", det.Score)
+	fmt.Printf("present: %v\n", det.Present)
+	fmt.Printf("decode ok: %v\n", det.OK)
+	if det.OK {
+		fmt.Printf("msg: %s\n", det.Message)
 	}
 
 	return 0
 }
 
 func printDetectUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: spectralmark detect --in <input.ppm> --key <key>")
+	fmt.Fprintln(w, "Usage: spectralmark detect --in <input.ppm|pgm|png|jpg|gif|bmp|tga|-> --key <key> [--profile <name>] [--mask <image>] [--include <rects>] [--exclude <rects>] [--workers <n>] [--timeout <duration>]")
 }
 
 func runPRNGDemo(args []string) int {
@@ -392,10 +837,14 @@ func runBench(args []string) int {
 	var key string
 	var msg string
 	var alpha float64
-	fs.StringVar(&inPath, "in", "", "input PPM path")
+	var workers int
+	var timeout time.Duration
+	fs.StringVar(&inPath, "in", "", "input image path (PPM, PGM, PNG, JPEG, GIF, BMP or TGA), - for stdin")
 	fs.StringVar(&key, "key", "", "embedding key")
 	fs.StringVar(&msg, "msg", "", "message payload")
 	fs.Float64Var(&alpha, "alpha", 5.0, "embedding strength")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
 	fs.SetOutput(io.Discard)
 
 	if err := fs.Parse(args); err != nil {
@@ -413,13 +862,27 @@ func runBench(args []string) int {
 		printBenchUsage(os.Stderr)
 		return 1
 	}
+	if workers < 0 {
+		fmt.Fprintln(os.Stderr, "--workers must be >= 0")
+		printBenchUsage(os.Stderr)
+		return 1
+	}
+	if timeout < 0 {
+		fmt.Fprintln(os.Stderr, "--timeout must be >= 0")
+		printBenchUsage(os.Stderr)
+		return 1
+	}
 	if fs.NArg() != 0 {
 		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
 		printBenchUsage(os.Stderr)
 		return 1
 	}
 
-	results, err := spectralbench.RunBench(inPath, key, msg, float32(alpha))
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	results, err := spectralbench.RunBenchContext(ctx, inPath, key, msg, float32(alpha))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "bench failed: %v\n", err)
 		return 1
@@ -430,7 +893,7 @@ func runBench(args []string) int {
 }
 
 func printBenchUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: spectralmark bench --in <input.ppm> --key <key> --msg <msg> [--alpha <strength>]")
+	fmt.Fprintln(w, "Usage: spectralmark bench --in <input> --key <key> --msg <msg> [--alpha <strength>] [--workers <n>] [--timeout <duration>]")
 }
 
 func runDemo(args []string) int {
@@ -441,11 +904,15 @@ func runDemo(args []string) int {
 	var key string
 	var msg string
 	var alpha float64
-	fs.StringVar(&inPath, "in", "", "input PPM path")
-	fs.StringVar(&outPath, "out", "", "output watermarked PPM path")
+	var workers int
+	var timeout time.Duration
+	fs.StringVar(&inPath, "in", "", "input image path (PPM, PGM, PNG, JPEG, GIF, BMP or TGA), - for stdin")
+	fs.StringVar(&outPath, "out", "", outPathUsage+" (default: <input>_watermarked next to the input)")
 	fs.StringVar(&key, "key", "k", "embedding key")
 	fs.StringVar(&msg, "msg", "HELLO", "message payload")
 	fs.Float64Var(&alpha, "alpha", 5.0, "embedding strength")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
 	fs.SetOutput(io.Discard)
 
 	if err := fs.Parse(args); err != nil {
@@ -463,6 +930,16 @@ func runDemo(args []string) int {
 		printDemoUsage(os.Stderr)
 		return 1
 	}
+	if workers < 0 {
+		fmt.Fprintln(os.Stderr, "--workers must be >= 0")
+		printDemoUsage(os.Stderr)
+		return 1
+	}
+	if timeout < 0 {
+		fmt.Fprintln(os.Stderr, "--timeout must be >= 0")
+		printDemoUsage(os.Stderr)
+		return 1
+	}
 	if fs.NArg() != 0 {
 		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
 		printDemoUsage(os.Stderr)
@@ -472,23 +949,31 @@ func runDemo(args []string) int {
 		outPath = defaultDemoOutputPath(inPath)
 	}
 
-	results, err := spectralbench.RunDemo(inPath, outPath, key, msg, float32(alpha))
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	results, err := spectralbench.RunDemoContext(ctx, inPath, outPath, key, msg, float32(alpha))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "demo failed: %v\n", err)
 		return 1
 	}
 
-	fmt.Printf("embedded file: %s\n", outPath)
-	fmt.Printf("key=%s msg=%q alpha=%.2f\n", key, msg, alpha)
-	fmt.Print(spectralbench.FormatResultsTable(results))
+	out := reportWriter(outPath)
+	fmt.Fprintf(out, "embedded file: %s\n", outPath)
+	fmt.Fprintf(out, "key=%s msg=%q alpha=%.2f\n", key, msg, alpha)
+	fmt.Fprint(out, spectralbench.FormatResultsTable(results))
 	return 0
 }
 
 func printDemoUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: spectralmark demo --in <input.ppm> [--out <watermarked.ppm>] [--key <key>] [--msg <msg>] [--alpha <strength>]")
+	fmt.Fprintln(w, "Usage: spectralmark demo --in <input> [--out <watermarked>] [--key <key>] [--msg <msg>] [--alpha <strength>] [--workers <n>] [--timeout <duration>]")
 }
 
 func defaultDemoOutputPath(inPath string) string {
+	if inPath == spectralimage.StdioPath {
+		return spectralimage.StdioPath
+	}
 	dir := filepath.Dir(inPath)
 	base := filepath.Base(inPath)
 	ext := filepath.Ext(base)
@@ -499,11 +984,610 @@ func defaultDemoOutputPath(inPath string) string {
 	return filepath.Join(dir, name+"_watermarked"+ext)
 }
 
+func runFingerprint(args []string) int {
+	fs := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+
+	var inPath string
+	var outPath string
+	var key string
+	var registryPath string
+	var id string
+	var note string
+	var colluders int
+	var maxRecipients int
+	var epsilon float64
+	var alpha float64
+	var profile string
+	var closedLoop int
+	var workers int
+	var timeout time.Duration
+
+	fs.StringVar(&inPath, "in", "", "input image path (PPM, PGM, PNG, JPEG, GIF, BMP or TGA), - for stdin")
+	fs.StringVar(&outPath, "out", "", outPathUsage)
+	fs.StringVar(&key, "key", "", "embedding key")
+	fs.StringVar(&registryPath, "registry", "", "recipient registry JSON path (created if missing)")
+	fs.StringVar(&id, "id", "", "recipient id")
+	fs.StringVar(&note, "note", "", "free-form note stored with a new recipient")
+	fs.IntVar(&colluders, "colluders", 2, "largest coalition to resist (new registry only)")
+	fs.IntVar(&maxRecipients, "max-recipients", 1000, "most recipients the registry will hold (new registry only)")
+	fs.Float64Var(&epsilon, "epsilon", 0.01, "bound on the probability of accusing any innocent recipient (new registry only)")
+	fs.Float64Var(&alpha, "alpha", 3.0, "embedding strength")
+	fs.StringVar(&profile, "profile", spectralmark.DefaultProfile, "block size profile ("+strings.Join(spectralmark.Profiles(), ", ")+")")
+	fs.IntVar(&closedLoop, "closed-loop", 0, "re-measure after 8-bit rounding and top up weak slots, up to n times per block")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		printFingerprintUsage(os.Stderr)
+		return 1
+	}
+	if inPath == "" || outPath == "" || key == "" || registryPath == "" || id == "" {
+		fmt.Fprintln(os.Stderr, "--in, --out, --key, --registry, and --id are required")
+		printFingerprintUsage(os.Stderr)
+		return 1
+	}
+	if alpha <= 0 {
+		fmt.Fprintln(os.Stderr, "--alpha must be > 0")
+		printFingerprintUsage(os.Stderr)
+		return 1
+	}
+	if workers < 0 || timeout < 0 || closedLoop < 0 {
+		fmt.Fprintln(os.Stderr, "--workers, --timeout, and --closed-loop must be >= 0")
+		printFingerprintUsage(os.Stderr)
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		printFingerprintUsage(os.Stderr)
+		return 1
+	}
+
+	reg, err := readRegistryFile(registryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		reg = spectralmark.NewRegistry(spectralmark.FingerprintParams{
+			Colluders:     colluders,
+			MaxRecipients: maxRecipients,
+			Epsilon:       epsilon,
+		})
+		err = nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fingerprint failed: %v\n", err)
+		return 1
+	}
+	// Re-issuing a copy to a known recipient reuses their codeword.
+	if !reg.Has(id) {
+		if err := reg.Add(id, note); err != nil {
+			fmt.Fprintf(os.Stderr, "fingerprint failed: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	img, inFormat, err := readImageFile(inPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fingerprint failed: %v\n", err)
+		return 1
+	}
+
+	outFormat := spectralimage.OutputFormat(outPath, inFormat)
+	if !outFormat.HasAlpha() {
+		img = spectralmark.WithoutAlpha(img)
+	}
+
+	res, err := spectralmark.EmbedFingerprint(ctx, img, spectralmark.FingerprintOptions{
+		Key:                  key,
+		Registry:             reg,
+		RecipientID:          id,
+		Alpha:                float32(alpha),
+		Profile:              profile,
+		ClosedLoopIterations: closedLoop,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fingerprint failed: %v\n", err)
+		return 1
+	}
+	if err := spectralimage.WriteImageFile(outPath, res.Image, outFormat, spectralimage.EncodeOptions{}); err != nil {
+		fmt.Fprintf(os.Stderr, "fingerprint failed: %v\n", err)
+		return 1
+	}
+	if err := writeRegistryFile(registryPath, reg); err != nil {
+		fmt.Fprintf(os.Stderr, "fingerprint failed: %v\n", err)
+		return 1
+	}
+
+	out := reportWriter(outPath)
+	fmt.Fprintf(out, "recipient: %s\n", id)
+	fmt.Fprintf(out, "code length: %d\n", res.Report.Slots-spectralmark.FingerprintSyncSymbols)
+	fmt.Fprintf(out, "registered recipients: %d/%d\n", len(reg.Recipients), reg.Params.MaxRecipients)
+	return 0
+}
+
+func printFingerprintUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: spectralmark fingerprint --in <input> --out <output> --key <key> --registry <registry.json> --id <recipient> [--note <text>] [--colluders <c>] [--max-recipients <n>] [--epsilon <e>] [--alpha <strength>] [--profile <name>] [--closed-loop <n>] [--workers <n>] [--timeout <duration>]")
+}
+
+func runTrace(args []string) int {
+	fs := flag.NewFlagSet("trace", flag.ContinueOnError)
+
+	var inPath string
+	var key string
+	var registryPath string
+	var profile string
+	var top int
+	var workers int
+	var timeout time.Duration
+
+	fs.StringVar(&inPath, "in", "", "suspect image path (PPM, PGM, PNG, JPEG, GIF, BMP or TGA), - for stdin")
+	fs.StringVar(&key, "key", "", "embedding key")
+	fs.StringVar(&registryPath, "registry", "", "recipient registry JSON path")
+	fs.StringVar(&profile, "profile", "", "only try this block size profile (default: try all)")
+	fs.IntVar(&top, "top", 10, "how many of the highest-scoring recipients to list")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		printTraceUsage(os.Stderr)
+		return 1
+	}
+	if inPath == "" || key == "" || registryPath == "" {
+		fmt.Fprintln(os.Stderr, "--in, --key, and --registry are required")
+		printTraceUsage(os.Stderr)
+		return 1
+	}
+	if workers < 0 || timeout < 0 || top < 0 {
+		fmt.Fprintln(os.Stderr, "--workers, --timeout, and --top must be >= 0")
+		printTraceUsage(os.Stderr)
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		printTraceUsage(os.Stderr)
+		return 1
+	}
+
+	reg, err := readRegistryFile(registryPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trace failed: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	img, _, err := readImageFile(inPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trace failed: %v\n", err)
+		return 1
+	}
+
+	res, err := spectralmark.Trace(ctx, img, spectralmark.TraceOptions{Key: key, Registry: reg, Profile: profile})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "trace failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("profile: %s\n", res.Profile)
+	fmt.Printf("sync match: %.4f\n", res.SyncScore)
+	fmt.Printf("code length: %d\n", res.CodeLength)
+	fmt.Printf("threshold: %.2f\n", res.Threshold)
+	for i, s := range res.Scores {
+		if i >= top {
+			break
+		}
+		mark := ""
+		if s.Accused {
+			mark = " ACCUSED"
+		}
+		fmt.Printf("  %-24s %10.2f%s\n", s.ID, s.Score, mark)
+	}
+	if len(res.Accused) == 0 {
+		fmt.Println("accused: none")
+	} else {
+		fmt.Printf("accused: %s\n", strings.Join(res.Accused, ", "))
+	}
+	return 0
+}
+
+func printTraceUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: spectralmark trace --in <suspect> --key <key> --registry <registry.json> [--profile <name>] [--top <n>] [--workers <n>] [--timeout <duration>]")
+}
+
+func readRegistryFile(path string) (*spectralmark.Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return spectralmark.ReadRegistry(f)
+}
+
+// writeRegistryFile replaces the registry via a temporary file so a failed
+// write never leaves a truncated registry behind.
+func writeRegistryFile(path string, reg *spectralmark.Registry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := reg.Write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func runVideoEmbed(args []string) int {
+	fs := flag.NewFlagSet("video-embed", flag.ContinueOnError)
+
+	var inPath string
+	var outPath string
+	var size string
+	var yuvLayout string
+	var key string
+	var msg string
+	var alpha float64
+	var profile string
+	var keyPeriod int
+	var workers int
+	var timeout time.Duration
+
+	fs.StringVar(&inPath, "in", "", "input .y4m or raw .yuv file, or a directory of frames (any image format, in name order)")
+	fs.StringVar(&outPath, "out", "", "output .y4m or raw .yuv file (any input), or a directory for PPM frames (frame directory input)")
+	fs.StringVar(&size, "size", "", "frame size WxH of a raw .yuv input")
+	fs.StringVar(&yuvLayout, "yuv-layout", "i420", "plane layout of raw .yuv input and output (i420 or nv12)")
+	fs.StringVar(&key, "key", "", "embedding key")
+	fs.StringVar(&msg, "msg", "", "message payload")
+	fs.Float64Var(&alpha, "alpha", 3.0, "embedding strength")
+	fs.StringVar(&profile, "profile", spectralmark.DefaultProfile, "block size profile ("+strings.Join(spectralmark.Profiles(), ", ")+")")
+	fs.IntVar(&keyPeriod, "key-period", 0, "vary the slot mapping with frame index modulo n (0 = same mapping for every frame)")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		printVideoEmbedUsage(os.Stderr)
+		return 1
+	}
+	if inPath == "" || outPath == "" || key == "" || msg == "" {
+		fmt.Fprintln(os.Stderr, "--in, --out, --key, and --msg are required")
+		printVideoEmbedUsage(os.Stderr)
+		return 1
+	}
+	if alpha <= 0 {
+		fmt.Fprintln(os.Stderr, "--alpha must be > 0")
+		printVideoEmbedUsage(os.Stderr)
+		return 1
+	}
+	if workers < 0 || timeout < 0 || keyPeriod < 0 {
+		fmt.Fprintln(os.Stderr, "--workers, --timeout, and --key-period must be >= 0")
+		printVideoEmbedUsage(os.Stderr)
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		printVideoEmbedUsage(os.Stderr)
+		return 1
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	opts := spectralmark.VideoEmbedOptions{
+		EmbedOptions: spectralmark.EmbedOptions{
+			Key:     key,
+			Message: msg,
+			Alpha:   float32(alpha),
+			Profile: profile,
+		},
+		KeyPeriod: keyPeriod,
+	}
+
+	if isYUVStreamPath(inPath) {
+		n, err := embedYUVFile(ctx, inPath, outPath, size, yuvLayout, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "video-embed failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("frames: %d\n", n)
+		return 0
+	}
+
+	// A path without an extension is a frame directory.
+	if filepath.Ext(outPath) != "" && !isYUVStreamPath(outPath) {
+		fmt.Fprintf(os.Stderr, "video-embed failed: --out %s must be a .y4m or .yuv file, or a directory for frames\n", outPath)
+		return 1
+	}
+
+	names, frames, err := readFrameDir(inPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "video-embed failed: %v\n", err)
+		return 1
+	}
+
+	for i, f := range frames {
+		frames[i] = spectralmark.WithoutAlpha(f)
+	}
+	out, err := spectralmark.EmbedVideo(ctx, frames, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "video-embed failed: %v\n", err)
+		return 1
+	}
+
+	if err := writeVideoFrames(outPath, yuvLayout, names, out); err != nil {
+		fmt.Fprintf(os.Stderr, "video-embed failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("frames: %d\n", len(out))
+	return 0
+}
+
+// writeVideoFrames writes marked frames as a .y4m or raw .yuv stream, or as
+// PPM files named after the input frames in the directory outPath.
+func writeVideoFrames(outPath, yuvLayout string, names []string, frames []*stdimage.NRGBA) error {
+	if isYUVStreamPath(outPath) {
+		images := make([]stdimage.Image, len(frames))
+		for i, f := range frames {
+			images[i] = f
+		}
+		return writeFileAtomic(outPath, func(w io.Writer) error {
+			if strings.EqualFold(filepath.Ext(outPath), ".yuv") {
+				return spectralmark.WriteRawYUV(w, images, yuvLayout)
+			}
+			return spectralmark.WriteY4M(w, images)
+		})
+	}
+
+	if err := os.MkdirAll(outPath, 0o755); err != nil {
+		return err
+	}
+	for i, f := range frames {
+		base := strings.TrimSuffix(names[i], filepath.Ext(names[i]))
+		if err := spectralimage.WriteImageFile(filepath.Join(outPath, base+".ppm"), f, spectralimage.FormatNetpbm, spectralimage.EncodeOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes path through a temporary file in the same
+// directory, so a failed write never leaves a partial file behind.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriter(tmp)
+	if err := write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func printVideoEmbedUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: spectralmark video-embed --in <clip.y4m|clip.yuv|frame-dir> --out <clip.y4m|clip.yuv|frame-dir> --key <key> --msg <msg> [--size <WxH>] [--yuv-layout <i420|nv12>] [--alpha <strength>] [--profile <name>] [--key-period <n>] [--workers <n>] [--timeout <duration>]")
+}
+
+func runVideoDetect(args []string) int {
+	fs := flag.NewFlagSet("video-detect", flag.ContinueOnError)
+
+	var inPath string
+	var size string
+	var yuvLayout string
+	var key string
+	var profile string
+	var keyPeriod int
+	var workers int
+	var timeout time.Duration
+
+	fs.StringVar(&inPath, "in", "", ".y4m or raw .yuv file, or a directory of frames (any image format; any subset, any order)")
+	fs.StringVar(&size, "size", "", "frame size WxH of a raw .yuv input")
+	fs.StringVar(&yuvLayout, "yuv-layout", "i420", "plane layout of a raw .yuv input (i420 or nv12)")
+	fs.StringVar(&key, "key", "", "detection key")
+	fs.StringVar(&profile, "profile", "", "only try this block size profile (default: try all)")
+	fs.IntVar(&keyPeriod, "key-period", 0, "key period used at embed time")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines (0 = all CPUs)")
+	fs.DurationVar(&timeout, "timeout", 0, "abort after this long (0 = no limit)")
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to parse flags: %v\n", err)
+		printVideoDetectUsage(os.Stderr)
+		return 1
+	}
+	if inPath == "" || key == "" {
+		fmt.Fprintln(os.Stderr, "--in and --key are required")
+		printVideoDetectUsage(os.Stderr)
+		return 1
+	}
+	if workers < 0 || timeout < 0 || keyPeriod < 0 {
+		fmt.Fprintln(os.Stderr, "--workers, --timeout, and --key-period must be >= 0")
+		printVideoDetectUsage(os.Stderr)
+		return 1
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		printVideoDetectUsage(os.Stderr)
+		return 1
+	}
+
+	ctx, cancel := commandContext(timeout)
+	defer cancel()
+
+	spectralmark.SetWorkers(workers)
+	opts := spectralmark.VideoDetectOptions{
+		DetectOptions: spectralmark.DetectOptions{Key: key, Profile: profile},
+		KeyPeriod:     keyPeriod,
+	}
+
+	var det *spectralmark.VideoDetectResult
+	var err error
+	if isYUVStreamPath(inPath) {
+		det, err = detectYUVFile(ctx, inPath, size, yuvLayout, opts)
+	} else {
+		var frames []stdimage.Image
+		_, frames, err = readFrameDir(inPath)
+		if err == nil {
+			det, err = spectralmark.DetectVideo(ctx, frames, opts)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "video-detect failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("frames used: %d/%d\n", det.FramesUsed, det.Frames)
+	fmt.Printf("score: %.4f\n", det.Score)
+	fmt.Printf("present: %v\n", det.Present)
+	fmt.Printf("decode ok: %v\n", det.OK)
+	if det.OK {
+		fmt.Printf("msg: %s\n", det.Message)
+	}
+	return 0
+}
+
+func printVideoDetectUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: spectralmark video-detect --in <clip.y4m|clip.yuv|frame-dir> --key <key> [--size <WxH>] [--yuv-layout <i420|nv12>] [--profile <name>] [--key-period <n>] [--workers <n>] [--timeout <duration>]")
+}
+
+func isYUVStreamPath(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".y4m", ".yuv":
+		return true
+	}
+	return false
+}
+
+func parseFrameSize(s string) (w, h int, err error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	if ok {
+		w, err = strconv.Atoi(ws)
+		if err == nil {
+			h, err = strconv.Atoi(hs)
+		}
+	}
+	if !ok || err != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid --size %q (expected WxH)", s)
+	}
+	return w, h, nil
+}
+
+// embedYUVFile streams a .y4m or raw .yuv file through the Y-plane embedder
+// into a .y4m or raw .yuv file, converting the container when the extensions
+// differ. The output only appears once every frame is written.
+func embedYUVFile(ctx context.Context, inPath, outPath, size, layout string, opts spectralmark.VideoEmbedOptions) (n int, err error) {
+	if !isYUVStreamPath(outPath) {
+		return 0, fmt.Errorf("--out %s must be a .y4m or .yuv file for %s input", outPath, filepath.Ext(inPath))
+	}
+	var inFormat *spectralmark.RawYUVFormat
+	if strings.EqualFold(filepath.Ext(inPath), ".yuv") {
+		w, h, err := parseFrameSize(size)
+		if err != nil {
+			return 0, err
+		}
+		inFormat = &spectralmark.RawYUVFormat{Width: w, Height: h, Layout: layout}
+	}
+	outLayout := ""
+	if strings.EqualFold(filepath.Ext(outPath), ".yuv") {
+		outLayout = layout
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	err = writeFileAtomic(outPath, func(w io.Writer) error {
+		n, err = spectralmark.EmbedYUV(ctx, bufio.NewReader(in), inFormat, w, outLayout, opts)
+		return err
+	})
+	return n, err
+}
+
+func detectYUVFile(ctx context.Context, inPath, size, layout string, opts spectralmark.VideoDetectOptions) (*spectralmark.VideoDetectResult, error) {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	if !strings.EqualFold(filepath.Ext(inPath), ".yuv") {
+		return spectralmark.DetectY4M(ctx, in, opts)
+	}
+	w, h, err := parseFrameSize(size)
+	if err != nil {
+		return nil, err
+	}
+	return spectralmark.DetectRawYUV(ctx, bufio.NewReader(in), spectralmark.RawYUVFormat{Width: w, Height: h, Layout: layout}, opts)
+}
+
+// readFrameDir loads every file in dir whose extension names an image
+// format (see spectralimage.FormatFromPath), sorted by name, applying EXIF
+// orientation as readImageFile does. Other files and subdirectories are
+// skipped.
+func readFrameDir(dir string) (names []string, frames []stdimage.Image, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if _, ok := spectralimage.FormatFromPath(e.Name()); !ok {
+			continue
+		}
+
+		img, _, err := readImageFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, e.Name())
+		frames = append(frames, img)
+	}
+	if len(frames) == 0 {
+		return nil, nil, fmt.Errorf("no frames in %s", dir)
+	}
+	return names, frames, nil
+}
+
 func runServe(args []string) int {
 	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
 
 	var port int
+	var workers int
 	fs.IntVar(&port, "port", 8080, "HTTP port")
+	fs.IntVar(&workers, "workers", 0, "worker goroutines per request (0 = all CPUs)")
 	fs.SetOutput(io.Discard)
 
 	if err := fs.Parse(args); err != nil {
@@ -516,12 +1600,18 @@ func runServe(args []string) int {
 		printServeUsage(os.Stderr)
 		return 1
 	}
+	if workers < 0 {
+		fmt.Fprintln(os.Stderr, "--workers must be >= 0")
+		printServeUsage(os.Stderr)
+		return 1
+	}
 	if fs.NArg() != 0 {
 		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
 		printServeUsage(os.Stderr)
 		return 1
 	}
 
+	spectralmark.SetWorkers(workers)
 	fmt.Printf("SpectralMark UI running at http://localhost:%d\n", port)
 	if err := spectralapp.Serve(port); err != nil {
 		fmt.Fprintf(os.Stderr, "serve failed: %v\n", err)
@@ -531,7 +1621,7 @@ func runServe(args []string) int {
 }
 
 func printServeUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: spectralmark serve [--port <port>]")
+	fmt.Fprintln(w, "Usage: spectralmark serve [--port <port>] [--workers <n>]")
 }
 
 func runMetrics(args []string) int {
@@ -540,9 +1630,9 @@ func runMetrics(args []string) int {
 	var aPath string
 	var bPath string
 	var diffPath string
-	fs.StringVar(&aPath, "a", "", "reference/original PPM path")
-	fs.StringVar(&bPath, "b", "", "comparison PPM path")
-	fs.StringVar(&diffPath, "diff", "", "output diff PPM path")
+	fs.StringVar(&aPath, "a", "", "reference/original image path, - for stdin")
+	fs.StringVar(&bPath, "b", "", "comparison image path, - for stdin")
+	fs.StringVar(&diffPath, "diff", "", "output diff image path, format by extension (anything else PPM); - for stdout in the format of --a")
 	fs.SetOutput(io.Discard)
 
 	if err := fs.Parse(args); err != nil {
@@ -561,44 +1651,52 @@ func runMetrics(args []string) int {
 		return 1
 	}
 
-	imgA, err := spectralimage.ReadPPM(aPath)
+	if aPath == spectralimage.StdioPath && bPath == spectralimage.StdioPath {
+		fmt.Fprintln(os.Stderr, "--a and --b cannot both be stdin")
+		printMetricsUsage(os.Stderr)
+		return 1
+	}
+
+	srcA, formatA, err := readImageFile(aPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read --a image: %v\n", err)
 		return 1
 	}
-	imgB, err := spectralimage.ReadPPM(bPath)
+	srcB, _, err := readImageFile(bPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read --b image: %v\n", err)
 		return 1
 	}
+	imgA := spectralimage.FromStdImage(srcA)
+	imgB := spectralimage.FromStdImage(srcB)
 
 	if imgA.W != imgB.W || imgA.H != imgB.H {
 		fmt.Fprintf(os.Stderr, "image size mismatch: --a=%dx%d --b=%dx%d\n", imgA.W, imgA.H, imgB.W, imgB.H)
 		return 1
 	}
 
-	yA, _, _ := spectralimage.RGBToYCbCr(imgA)
-	yB, _, _ := spectralimage.RGBToYCbCr(imgB)
-	psnr := spectralutil.PSNR(yA, yB)
+	psnr := spectralutil.PlanarPSNR(spectralimage.PlanarFromImage(imgA), spectralimage.PlanarFromImage(imgB))
 
 	diffImg := buildDiffImage(imgA, imgB, 8)
-	if err := spectralimage.WritePPM(diffPath, diffImg); err != nil {
+	diffFormat := spectralimage.OutputFormat(diffPath, formatA)
+	if err := spectralimage.WriteImageFile(diffPath, spectralimage.ToNRGBA(diffImg), diffFormat, spectralimage.EncodeOptions{}); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to write --diff image: %v\n", err)
 		return 1
 	}
 
+	out := reportWriter(diffPath)
 	if stdmath.IsInf(float64(psnr), 1) {
-		fmt.Println("PSNR: +Inf dB")
+		fmt.Fprintln(out, "PSNR: +Inf dB")
 	} else {
-		fmt.Printf("PSNR: %.4f dB\n", psnr)
+		fmt.Fprintf(out, "PSNR: %.4f dB\n", psnr)
 	}
-	fmt.Printf("diff image: %s\n", diffPath)
+	fmt.Fprintf(out, "diff image: %s\n", diffPath)
 
 	return 0
 }
 
 func printMetricsUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: spectralmark metrics --a <orig.ppm> --b <other.ppm> --diff <diff.ppm>")
+	fmt.Fprintln(w, "Usage: spectralmark metrics --a <orig> --b <other> --diff <diff>")
 }
 
 func buildDiffImage(a, b *spectralimage.Image, amplify int) *spectralimage.Image {
@@ -3,20 +3,15 @@ package app
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	stdimage "image"
-	"image/png"
 	"io"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
-	_ "image/jpeg"
-	_ "image/png"
-
-	spectralimage "spectralmark/internal/image"
-	spectralwm "spectralmark/internal/wm"
+	"spectralmark/pkg/spectralmark"
 )
 
 const maxUploadBytes int64 = 64 << 20
@@ -26,6 +21,22 @@ type detectResponse struct {
 	Present bool    `json:"present"`
 	Msg     string  `json:"msg"`
 	OK      bool    `json:"ok"`
+	// Frames and FramesUsed are set for an animated upload.
+	Frames     int `json:"frames,omitempty"`
+	FramesUsed int `json:"frames_used,omitempty"`
+}
+
+type statsResponse struct {
+	SlotCache slotCacheStatsResponse `json:"slot_cache"`
+}
+
+type slotCacheStatsResponse struct {
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	Entries   int     `json:"entries"`
+	Slots     int     `json:"slots"`
+	HitRate   float64 `json:"hit_rate"`
 }
 
 type errorResponse struct {
@@ -37,6 +48,7 @@ func NewHandler() http.Handler {
 	mux.HandleFunc("/", handleIndex)
 	mux.HandleFunc("/embed", handleEmbed)
 	mux.HandleFunc("/detect", handleDetect)
+	mux.HandleFunc("/stats", handleStats)
 	return mux
 }
 
@@ -91,35 +103,153 @@ func handleEmbed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("file")
+	format, err := parseOutputFormat(r.FormValue("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	quality, err := parseQuality(r.FormValue("quality"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pyramid, err := parsePyramid(r.FormValue("pyramid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rle := formBool(r.FormValue("rle"))
+	jpegCoeff := formBool(r.FormValue("jpeg_coeff"))
+	if jpegCoeff && format != "jpeg" {
+		http.Error(w, "jpeg_coeff needs format=jpeg", http.StatusBadRequest)
+		return
+	}
+
+	file, _, err := r.FormFile("file")
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to read uploaded file: %v", err), http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	img, err := decodeUploadImage(file, fileHeader.Filename)
+	region, err := formRegion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := spectralmark.EmbedOptions{
+		Key:           key,
+		Message:       msg,
+		Alpha:         alpha,
+		Profile:       strings.TrimSpace(r.FormValue("profile")),
+		PyramidLevels: pyramid,
+		BitExact:      formBool(r.FormValue("bit_exact")),
+		KeepPalette:   formBool(r.FormValue("keep_palette")),
+		Region:        region,
+	}
+
+	var out bytes.Buffer
+	if jpegCoeff {
+		// The upload is marked in its DCT coefficients and keeps all of its
+		// segments, metadata included.
+		_, err := spectralmark.EmbedJPEG(r.Context(), file, &out, opts)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("embed failed: %v", err), http.StatusBadRequest)
+			return
+		}
+		writeImage(w, format, out.Bytes())
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read uploaded file: %v", err), http.StatusBadRequest)
+		return
+	}
+	if spectralmark.IsAnimation(data) && (format == "png" || format == "gif") {
+		// Every frame is marked and the animation keeps its timing. Without
+		// an explicit format it stays in the upload's container.
+		animFormat := format
+		if strings.TrimSpace(r.FormValue("format")) == "" {
+			animFormat = ""
+		}
+		rep, err := spectralmark.EmbedAnimation(r.Context(), bytes.NewReader(data), &out, spectralmark.AnimationOptions{
+			EmbedOptions: opts,
+			Format:       animFormat,
+		})
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("embed failed: %v", err), http.StatusBadRequest)
+			return
+		}
+		writeImage(w, rep.Format, out.Bytes())
+		return
+	}
+
+	img, meta, err := decodeUploadImage(bytes.NewReader(data))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if format == "jpeg" {
+		// JPEG has no alpha, so the mark must not skip transparent blocks.
+		img = spectralmark.WithoutAlpha(img)
+	}
 
-	wmImg, err := spectralwm.EmbedImage(img, key, msg, alpha)
+	opts.Orientation = meta.Orientation()
+	res, err := spectralmark.Embed(r.Context(), img, opts)
+	if r.Context().Err() != nil {
+		// The client is gone; there is nobody to send a response to.
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf("embed failed: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	var out bytes.Buffer
-	if err := png.Encode(&out, spectralimage.ToNRGBA(wmImg)); err != nil {
+	// BMP, TGA and GIF have nowhere to keep the metadata.
+	switch format {
+	case "jpeg":
+		err = spectralmark.EncodeJPEGMetadata(&out, res.Native, spectralmark.JPEGOptions{Quality: quality}, meta)
+	case "bmp":
+		err = spectralmark.EncodeBMP(&out, res.Native, spectralmark.BMPOptions{RLE: rle})
+	case "tga":
+		err = spectralmark.EncodeTGA(&out, res.Native, spectralmark.TGAOptions{RLE: rle})
+	case "gif":
+		if _, ok := res.Native.(*stdimage.Paletted); !ok {
+			http.Error(w, "format=gif needs paletted input and no bit_exact", http.StatusBadRequest)
+			return
+		}
+		err = spectralmark.EncodeGIF(&out, res.Native)
+	default:
+		err = spectralmark.EncodePNGMetadata(&out, res.Native, meta)
+	}
+	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to encode output image: %v", err), http.StatusInternalServerError)
 		return
 	}
+	writeImage(w, format, out.Bytes())
+}
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Disposition", `attachment; filename="watermarked.png"`)
+// writeImage sends an encoded /embed result as a download.
+func writeImage(w http.ResponseWriter, format string, data []byte) {
+	ext, contentType := format, "image/"+format
+	switch format {
+	case "jpeg":
+		ext = "jpg"
+	case "tga":
+		contentType = "image/x-tga"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="watermarked.%s"`, ext))
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(out.Bytes())
+	_, _ = w.Write(data)
 }
 
 func handleDetect(w http.ResponseWriter, r *http.Request) {
@@ -140,30 +270,88 @@ func handleDetect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, fileHeader, err := r.FormFile("file")
+	file, _, err := r.FormFile("file")
 	if err != nil {
 		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("failed to read uploaded file: %v", err))
 		return
 	}
 	defer file.Close()
 
-	img, err := decodeUploadImage(file, fileHeader.Filename)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("failed to read uploaded file: %v", err))
+		return
+	}
+	region, err := formRegion(r)
 	if err != nil {
 		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	opts := spectralmark.DetectOptions{
+		Key:     key,
+		Profile: strings.TrimSpace(r.FormValue("profile")),
+		Region:  region,
+	}
 
-	score, present, msg, ok, err := spectralwm.DetectImage(img, key)
-	if err != nil {
-		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("detect failed: %v", err))
+	var resp detectResponse
+	if spectralmark.IsAnimation(data) {
+		det, err := spectralmark.DetectAnimation(r.Context(), bytes.NewReader(data), opts)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("detect failed: %v", err))
+			return
+		}
+		resp = detectResponse{
+			Score:      det.Score,
+			Present:    det.Present,
+			Msg:        det.Message,
+			OK:         det.OK,
+			Frames:     det.Frames,
+			FramesUsed: det.FramesUsed,
+		}
+	} else {
+		img, meta, err := decodeUploadImage(bytes.NewReader(data))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Orientation = meta.Orientation()
+		det, err := spectralmark.Detect(r.Context(), img, opts)
+		if r.Context().Err() != nil {
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("detect failed: %v", err))
+			return
+		}
+		resp = detectResponse{
+			Score:   det.Score,
+			Present: det.Present,
+			Msg:     det.Message,
+			OK:      det.OK,
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, detectResponse{
-		Score:   score,
-		Present: present,
-		Msg:     msg,
-		OK:      ok,
+	st := spectralmark.SlotCacheStats()
+	writeJSON(w, http.StatusOK, statsResponse{
+		SlotCache: slotCacheStatsResponse{
+			Hits:      st.Hits,
+			Misses:    st.Misses,
+			Evictions: st.Evictions,
+			Entries:   st.Entries,
+			Slots:     st.Slots,
+			HitRate:   st.HitRate(),
+		},
 	})
 }
 
@@ -183,35 +371,60 @@ func parseAlpha(raw string) (float32, error) {
 	return float32(v), nil
 }
 
-func decodeUploadImage(file io.Reader, filename string) (*spectralimage.Image, error) {
-	data, err := io.ReadAll(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read uploaded file body: %w", err)
+// parseOutputFormat accepts "png" (the default), "jpeg" ("jpg"), "gif",
+// "bmp" and "tga".
+func parseOutputFormat(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "png":
+		return "png", nil
+	case "jpeg", "jpg":
+		return "jpeg", nil
+	case "gif", "bmp", "tga":
+		return strings.ToLower(strings.TrimSpace(raw)), nil
 	}
-	if len(data) == 0 {
-		return nil, fmt.Errorf("uploaded file is empty")
+	return "", fmt.Errorf("invalid format: %q (expected png, jpeg, gif, bmp or tga)", raw)
+}
+
+func parseQuality(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return spectralmark.DefaultJPEGQuality, nil
 	}
 
-	ext := strings.ToLower(filepath.Ext(filename))
-	if ext == ".ppm" {
-		img, err := spectralimage.ReadPPMReader(bytes.NewReader(data))
-		if err != nil {
-			return nil, fmt.Errorf("failed to read PPM: %w", err)
-		}
-		return img, nil
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quality: %q", raw)
+	}
+	if v < 1 || v > 100 {
+		return 0, fmt.Errorf("quality must be between 1 and 100")
 	}
+	return v, nil
+}
 
-	stdImg, _, err := stdimage.Decode(bytes.NewReader(data))
-	if err == nil {
-		return spectralimage.FromStdImage(stdImg), nil
+func parsePyramid(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, nil
 	}
 
-	ppmImg, ppmErr := spectralimage.ReadPPMReader(bytes.NewReader(data))
-	if ppmErr == nil {
-		return ppmImg, nil
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pyramid: %q", raw)
+	}
+	if v < 0 || v > spectralmark.MaxPyramidLevels {
+		return 0, fmt.Errorf("pyramid must be between 0 and %d", spectralmark.MaxPyramidLevels)
 	}
+	return v, nil
+}
 
-	return nil, fmt.Errorf("unsupported image format; use .ppm, .png, .jpg, or .jpeg")
+// decodeUploadImage also returns the upload's EXIF, ICC profile and PNG text,
+// which /embed copies into its output.
+func decodeUploadImage(file io.Reader) (stdimage.Image, *spectralmark.Metadata, error) {
+	img, meta, err := spectralmark.DecodeMetadata(file)
+	if errors.Is(err, spectralmark.ErrUnsupportedFormat) {
+		return nil, nil, fmt.Errorf("unsupported image format; use .ppm, .pgm, .png, .jpg, .jpeg, .gif, .bmp, or .tga")
+	}
+	return img, meta, err
 }
 
 func writeJSONError(w http.ResponseWriter, status int, msg string) {
@@ -223,3 +436,41 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(v)
 }
+
+// formRegion reads the optional region fields: a "mask" image upload and
+// "include" and "exclude" rectangle lists (see spectralmark.ParseRectangles).
+func formRegion(r *http.Request) (*spectralmark.Region, error) {
+	region := &spectralmark.Region{}
+	var err error
+	if region.Include, err = spectralmark.ParseRectangles(r.FormValue("include")); err != nil {
+		return nil, err
+	}
+	if region.Exclude, err = spectralmark.ParseRectangles(r.FormValue("exclude")); err != nil {
+		return nil, err
+	}
+
+	file, _, err := r.FormFile("mask")
+	if errors.Is(err, http.ErrMissingFile) {
+		if region.Include == nil && region.Exclude == nil {
+			return nil, nil
+		}
+		return region, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mask: %v", err)
+	}
+	defer file.Close()
+	if region.Mask, _, err = decodeUploadImage(file); err != nil {
+		return nil, fmt.Errorf("mask: %v", err)
+	}
+	return region, nil
+}
+
+// formBool treats "1", "true" and "on" (what an HTML checkbox sends) as true.
+func formBool(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "on":
+		return true
+	}
+	return false
+}
@@ -8,7 +8,7 @@ import (
 	spectralwm "spectralmark/internal/wm"
 )
 
-func AttackNoise(img *spectralimage.Image, key string, sigma float32) *spectralimage.Image {
+func AttackNoise(img *spectralimage.Planar, key string, sigma float32) *spectralimage.Planar {
 	if img == nil {
 		return nil
 	}
@@ -16,25 +16,22 @@ func AttackNoise(img *spectralimage.Image, key string, sigma float32) *spectrali
 		sigma = 6
 	}
 
-	out := cloneImage(img)
+	out := img.Clone()
 	rng := spectralwm.NewPRNG(spectralwm.SeedFromKey("bench-noise:" + key))
 
-	for i := range out.Pix {
+	for i := range out.Y {
 		nr := gaussianish(rng, sigma)
 		ng := gaussianish(rng, sigma)
 		nb := gaussianish(rng, sigma)
 
-		out.Pix[i] = spectralimage.Rgb{
-			R: clampToByte(float32(out.Pix[i].R) + nr),
-			G: clampToByte(float32(out.Pix[i].G) + ng),
-			B: clampToByte(float32(out.Pix[i].B) + nb),
-		}
+		r, g, b := out.RGB(i)
+		out.SetRGB(i, clampToRange(r+nr), clampToRange(g+ng), clampToRange(b+nb))
 	}
 
 	return out
 }
 
-func AttackBrightnessContrast(img *spectralimage.Image, brightness, contrast float32) *spectralimage.Image {
+func AttackBrightnessContrast(img *spectralimage.Planar, brightness, contrast float32) *spectralimage.Planar {
 	if img == nil {
 		return nil
 	}
@@ -42,19 +39,19 @@ func AttackBrightnessContrast(img *spectralimage.Image, brightness, contrast flo
 		contrast = 1
 	}
 
-	out := cloneImage(img)
-	for i, p := range out.Pix {
-		out.Pix[i] = spectralimage.Rgb{
-			R: clampToByte((float32(p.R)-128)*contrast + 128 + brightness),
-			G: clampToByte((float32(p.G)-128)*contrast + 128 + brightness),
-			B: clampToByte((float32(p.B)-128)*contrast + 128 + brightness),
-		}
+	out := img.Clone()
+	adjust := func(v float32) float32 {
+		return clampToRange((v-128)*contrast + 128 + brightness)
+	}
+	for i := range out.Y {
+		r, g, b := out.RGB(i)
+		out.SetRGB(i, adjust(r), adjust(g), adjust(b))
 	}
 
 	return out
 }
 
-func AttackCropCenter(img *spectralimage.Image, keepFraction float32) *spectralimage.Image {
+func AttackCropCenter(img *spectralimage.Planar, keepFraction float32) *spectralimage.Planar {
 	if img == nil {
 		return nil
 	}
@@ -71,26 +68,14 @@ func AttackCropCenter(img *spectralimage.Image, keepFraction float32) *spectrali
 		ch = 1
 	}
 
-	x0 := (img.W - cw) / 2
-	y0 := (img.H - ch) / 2
-
-	crop := &spectralimage.Image{
-		W:   cw,
-		H:   ch,
-		Pix: make([]spectralimage.Rgb, cw*ch),
-	}
-	for y := 0; y < ch; y++ {
-		for x := 0; x < cw; x++ {
-			srcIdx := (y0+y)*img.W + (x0 + x)
-			dstIdx := y*cw + x
-			crop.Pix[dstIdx] = img.Pix[srcIdx]
-		}
+	crop, err := img.Sub((img.W-cw)/2, (img.H-ch)/2, cw, ch)
+	if err != nil {
+		return &spectralimage.Planar{}
 	}
-
 	return ResizeNN(crop, img.W, img.H)
 }
 
-func AttackResizeNN(img *spectralimage.Image, scale float32) *spectralimage.Image {
+func AttackResizeNN(img *spectralimage.Planar, scale float32) *spectralimage.Planar {
 	if img == nil {
 		return nil
 	}
@@ -111,7 +96,7 @@ func AttackResizeNN(img *spectralimage.Image, scale float32) *spectralimage.Imag
 	return ResizeNN(down, img.W, img.H)
 }
 
-func AttackDCTQuantize(img *spectralimage.Image, step float32) *spectralimage.Image {
+func AttackDCTQuantize(img *spectralimage.Planar, step float32) *spectralimage.Planar {
 	if img == nil {
 		return nil
 	}
@@ -119,26 +104,28 @@ func AttackDCTQuantize(img *spectralimage.Image, step float32) *spectralimage.Im
 		step = 12
 	}
 
-	y, cb, cr := spectralimage.RGBToYCbCr(img)
-	yQ := quantizeChannelDCT(y, img.W, img.H, step)
-	cbQ := quantizeChannelDCT(cb, img.W, img.H, step*1.25)
-	crQ := quantizeChannelDCT(cr, img.W, img.H, step*1.25)
-
-	return spectralimage.YCbCrToRGB(img.W, img.H, yQ, cbQ, crQ)
+	src := img.Packed()
+	return &spectralimage.Planar{
+		W:      src.W,
+		H:      src.H,
+		Stride: src.W,
+		Y:      quantizeChannelDCT(src.Y, src.W, src.H, step),
+		Cb:     quantizeChannelDCT(src.Cb, src.W, src.H, step*1.25),
+		Cr:     quantizeChannelDCT(src.Cr, src.W, src.H, step*1.25),
+		Alpha:  append([]uint8(nil), src.Alpha...),
+	}
 }
 
-func ResizeNN(img *spectralimage.Image, w, h int) *spectralimage.Image {
+func ResizeNN(img *spectralimage.Planar, w, h int) *spectralimage.Planar {
 	if img == nil {
 		return nil
 	}
-	if w <= 0 || h <= 0 {
-		return &spectralimage.Image{}
+	out, err := spectralimage.NewPlanar(w, h)
+	if err != nil {
+		return &spectralimage.Planar{}
 	}
-
-	out := &spectralimage.Image{
-		W:   w,
-		H:   h,
-		Pix: make([]spectralimage.Rgb, w*h),
+	if img.Alpha != nil {
+		out.Alpha = make([]uint8, w*h)
 	}
 
 	scaleX := float64(img.W) / float64(w)
@@ -155,7 +142,11 @@ func ResizeNN(img *spectralimage.Image, w, h int) *spectralimage.Image {
 				srcX = img.W - 1
 			}
 
-			out.Pix[y*w+x] = img.Pix[srcY*img.W+srcX]
+			i, j := y*w+x, srcY*img.Stride+srcX
+			out.Y[i], out.Cb[i], out.Cr[i] = img.Y[j], img.Cb[j], img.Cr[j]
+			if out.Alpha != nil {
+				out.Alpha[i] = img.Alpha[j]
+			}
 		}
 	}
 
@@ -175,31 +166,17 @@ func quantizeChannelDCT(ch []float32, w, h int, step float32) []float32 {
 		return nil
 	}
 
-	blocksX := w2 / 8
-	blocksY := h2 / 8
-	for by := 0; by < blocksY; by++ {
-		for bx := 0; bx < blocksX; bx++ {
-			b := spectralmath.GetBlock8(pad, w2, bx, by)
-			c := spectralmath.DCT8(b)
-
-			for v := 0; v < 8; v++ {
-				for u := 0; u < 8; u++ {
-					c[v][u] = float32(stdmath.Round(float64(c[v][u]/step))) * step
-				}
-			}
-
-			r := spectralmath.IDCT8(c)
-			for y := 0; y < 8; y++ {
-				for x := 0; x < 8; x++ {
-					if r[y][x] < 0 {
-						r[y][x] = 0
-					} else if r[y][x] > 255 {
-						r[y][x] = 255
-					}
-				}
-			}
+	coeff := spectralmath.DCT8Plane(pad, w2, h2)
+	for i, c := range coeff {
+		coeff[i] = float32(stdmath.Round(float64(c/step))) * step
+	}
 
-			spectralmath.SetBlock8(pad, w2, bx, by, r)
+	pad = spectralmath.IDCT8Plane(coeff, w2, h2)
+	for i, v := range pad {
+		if v < 0 {
+			pad[i] = 0
+		} else if v > 255 {
+			pad[i] = 255
 		}
 	}
 
@@ -215,25 +192,14 @@ func gaussianish(rng *spectralwm.PRNG, sigma float32) float32 {
 	return (sum - 3.0) * sigma * 1.41421356
 }
 
-func cloneImage(img *spectralimage.Image) *spectralimage.Image {
-	if img == nil {
-		return nil
-	}
-	out := &spectralimage.Image{
-		W:   img.W,
-		H:   img.H,
-		Pix: make([]spectralimage.Rgb, len(img.Pix)),
-	}
-	copy(out.Pix, img.Pix)
-	return out
-}
-
-func clampToByte(v float32) uint8 {
+// clampToRange keeps a sample within what 8-bit storage can hold, without
+// rounding it.
+func clampToRange(v float32) float32 {
 	if v <= 0 {
 		return 0
 	}
 	if v >= 255 {
 		return 255
 	}
-	return uint8(v + 0.5)
+	return v
 }
@@ -1,10 +1,9 @@
 package bench
 
 import (
+	"context"
 	"fmt"
 	stdmath "math"
-	"os"
-	"path/filepath"
 	"strings"
 
 	spectralimage "spectralmark/internal/image"
@@ -25,10 +24,16 @@ type Result struct {
 
 type attackCase struct {
 	name  string
-	apply func(img *spectralimage.Image) *spectralimage.Image
+	apply func(img *spectralimage.Planar) *spectralimage.Planar
 }
 
 func RunBench(inPath, key, msg string, alpha float32) ([]Result, error) {
+	return RunBenchContext(context.Background(), inPath, key, msg, alpha)
+}
+
+// RunBenchContext is RunBench with cancellation; ctx is checked before each
+// attack and passed through to embedding and detection.
+func RunBenchContext(ctx context.Context, inPath, key, msg string, alpha float32) ([]Result, error) {
 	if inPath == "" {
 		return nil, fmt.Errorf("input path is required")
 	}
@@ -42,58 +47,52 @@ func RunBench(inPath, key, msg string, alpha float32) ([]Result, error) {
 		return nil, fmt.Errorf("alpha must be > 0")
 	}
 
-	tmpDir, err := os.MkdirTemp("", "spectralmark-bench-*")
+	img, _, err := readInput(inPath)
 	if err != nil {
-		return nil, fmt.Errorf("create temp dir: %w", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	wmPath := filepath.Join(tmpDir, "watermarked.ppm")
-	if err := spectralwm.EmbedPPM(inPath, wmPath, key, msg, alpha); err != nil {
-		return nil, fmt.Errorf("embed input image: %w", err)
+		return nil, err
 	}
-
-	wmImg, err := spectralimage.ReadPPM(wmPath)
+	marked, _, err := spectralwm.EmbedPlanar(ctx, img, key, msg, spectralwm.EmbedOptions{Alpha: alpha})
 	if err != nil {
-		return nil, fmt.Errorf("read watermarked image: %w", err)
+		return nil, fmt.Errorf("embed input image: %w", err)
 	}
-	yWM, _, _ := spectralimage.RGBToYCbCr(wmImg)
+	// The marked image is rounded to 8-bit RGB once, as saving it would;
+	// the attacks and detection then work on it without further rounding.
+	wmImg := spectralimage.PlanarFromImage(marked.Image())
 
 	attacks := []attackCase{
-		{name: "none", apply: func(img *spectralimage.Image) *spectralimage.Image { return cloneImage(img) }},
-		{name: "noise", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackNoise(img, key, 1.5) }},
-		{name: "bright-contrast", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackBrightnessContrast(img, 2, 1.01) }},
-		{name: "crop-center", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackCropCenter(img, 0.99) }},
-		{name: "resize-nn", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackResizeNN(img, 0.99) }},
-		{name: "dct-quantize", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackDCTQuantize(img, 6) }},
+		{name: "none", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return img.Clone() }},
+		{name: "noise", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackNoise(img, key, 1.5) }},
+		{name: "bright-contrast", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackBrightnessContrast(img, 2, 1.01) }},
+		{name: "crop-center", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackCropCenter(img, 0.99) }},
+		{name: "resize-nn", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackResizeNN(img, 0.99) }},
+		{name: "dct-quantize", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackDCTQuantize(img, 6) }},
 	}
 
 	results := make([]Result, 0, len(attacks))
-	for i, a := range attacks {
+	for _, a := range attacks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		out := a.apply(wmImg)
 		row := Result{
 			Attack: a.name,
 			PSNR:   float32(stdmath.NaN()),
 		}
-		if out == nil || len(out.Pix) == 0 {
+		if out == nil || len(out.Y) == 0 {
 			row.Error = "attack returned empty image"
 			results = append(results, row)
 			continue
 		}
 
 		if out.W == wmImg.W && out.H == wmImg.H {
-			yOut, _, _ := spectralimage.RGBToYCbCr(out)
-			row.PSNR = spectralutil.PSNR(yWM, yOut)
+			row.PSNR = spectralutil.PlanarPSNR(wmImg, out)
 		}
 
-		outPath := filepath.Join(tmpDir, fmt.Sprintf("%02d_%s.ppm", i, sanitizeName(a.name)))
-		if err := spectralimage.WritePPM(outPath, out); err != nil {
-			row.Error = fmt.Sprintf("write attacked image: %v", err)
-			results = append(results, row)
-			continue
+		score, present, detMsg, ok, err := spectralwm.DetectPlanar(ctx, out, key, spectralwm.DetectOptions{})
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
 		}
-
-		score, present, detMsg, ok, err := spectralwm.DetectPPM(outPath, key)
 		row.Score = score
 		row.Present = present
 		row.Decode = ok
@@ -109,6 +108,18 @@ func RunBench(inPath, key, msg string, alpha float32) ([]Result, error) {
 	return results, nil
 }
 
+// readInput decodes path in any format the codec layer reads, as the opaque
+// planar image the attacks work on.
+func readInput(path string) (*spectralimage.Planar, spectralimage.Format, error) {
+	src, format, err := spectralimage.ReadImageFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read input image: %w", err)
+	}
+	img := spectralimage.PlanarFromStd(src)
+	img.Alpha = nil
+	return img, format, nil
+}
+
 func FormatResultsTable(results []Result) string {
 	if len(results) == 0 {
 		return "no results\n"
@@ -149,19 +160,3 @@ func FormatResultsTable(results []Result) string {
 
 	return b.String()
 }
-
-func sanitizeName(s string) string {
-	if s == "" {
-		return "attack"
-	}
-	var b strings.Builder
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
-			b.WriteByte(c)
-		} else {
-			b.WriteByte('_')
-		}
-	}
-	return b.String()
-}
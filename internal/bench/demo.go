@@ -1,6 +1,8 @@
 package bench
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	stdmath "math"
 
@@ -10,6 +12,12 @@ import (
 )
 
 func RunDemo(inPath, outPath, key, msg string, alpha float32) ([]Result, error) {
+	return RunDemoContext(context.Background(), inPath, outPath, key, msg, alpha)
+}
+
+// RunDemoContext is RunDemo with cancellation; ctx is checked before each
+// attack and passed through to embedding and detection.
+func RunDemoContext(ctx context.Context, inPath, outPath, key, msg string, alpha float32) ([]Result, error) {
 	if inPath == "" {
 		return nil, fmt.Errorf("input path is required")
 	}
@@ -26,41 +34,62 @@ func RunDemo(inPath, outPath, key, msg string, alpha float32) ([]Result, error)
 		return nil, fmt.Errorf("alpha must be > 0")
 	}
 
-	if err := spectralwm.EmbedPPM(inPath, outPath, key, msg, alpha); err != nil {
+	img, inFormat, err := readInput(inPath)
+	if err != nil {
+		return nil, err
+	}
+	marked, _, err := spectralwm.EmbedPlanar(ctx, img, key, msg, spectralwm.EmbedOptions{Alpha: alpha})
+	if err != nil {
 		return nil, fmt.Errorf("embed input image: %w", err)
 	}
 
-	wmImg, err := spectralimage.ReadPPM(outPath)
+	// The attacks start from the file as written, so a lossy output format
+	// is part of the demo.
+	var buf bytes.Buffer
+	format := spectralimage.OutputFormat(outPath, inFormat)
+	if err := spectralimage.EncodeImage(&buf, spectralimage.ToNRGBA(marked.Image()), format, spectralimage.EncodeOptions{}); err != nil {
+		return nil, fmt.Errorf("encode watermarked image: %w", err)
+	}
+	if err := writeFile(outPath, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("write watermarked image: %w", err)
+	}
+	decoded, _, err := spectralimage.DecodeImage(buf.Bytes())
 	if err != nil {
 		return nil, fmt.Errorf("read watermarked image: %w", err)
 	}
-	yWM, _, _ := spectralimage.RGBToYCbCr(wmImg)
+	wmImg := spectralimage.PlanarFromStd(decoded)
 
 	attacks := []attackCase{
-		{name: "noise", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackNoise(img, key, 1.5) }},
-		{name: "resize-nn", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackResizeNN(img, 0.99) }},
-		{name: "dct-quantize", apply: func(img *spectralimage.Image) *spectralimage.Image { return AttackDCTQuantize(img, 6) }},
+		{name: "noise", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackNoise(img, key, 1.5) }},
+		{name: "resize-nn", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackResizeNN(img, 0.99) }},
+		{name: "dct-quantize", apply: func(img *spectralimage.Planar) *spectralimage.Planar { return AttackDCTQuantize(img, 6) }},
 	}
 
 	results := make([]Result, 0, len(attacks))
 	for _, a := range attacks {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
 		out := a.apply(wmImg)
 		row := Result{
 			Attack: a.name,
 			PSNR:   float32(stdmath.NaN()),
 		}
-		if out == nil || len(out.Pix) == 0 {
+		if out == nil || len(out.Y) == 0 {
 			row.Error = "attack returned empty image"
 			results = append(results, row)
 			continue
 		}
 
 		if out.W == wmImg.W && out.H == wmImg.H {
-			yOut, _, _ := spectralimage.RGBToYCbCr(out)
-			row.PSNR = spectralutil.PSNR(yWM, yOut)
+			row.PSNR = spectralutil.PlanarPSNR(wmImg, out)
 		}
 
-		score, present, detMsg, ok, err := spectralwm.DetectImage(out, key)
+		score, present, detMsg, ok, err := spectralwm.DetectPlanar(ctx, out, key, spectralwm.DetectOptions{})
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		row.Score = score
 		row.Present = present
 		row.Decode = ok
@@ -75,3 +104,18 @@ func RunDemo(inPath, outPath, key, msg string, alpha float32) ([]Result, error)
 
 	return results, nil
 }
+
+// writeFile writes data to path, or to stdout for "-".
+func writeFile(path string, data []byte) (err error) {
+	out, err := spectralimage.CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = closeErr
+		}
+	}()
+	_, err = out.Write(data)
+	return err
+}
@@ -0,0 +1,249 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"image/gif"
+	"io"
+)
+
+// Animation is an animated GIF or APNG as stored: every frame is the
+// rectangle it redraws on the canvas, with its timing and how it is
+// composed, so that a round trip keeps the animation's structure.
+type Animation struct {
+	Width, Height int
+	Frames        []AnimationFrame
+	// Plays is how many times the animation runs; 0 loops for ever.
+	Plays int
+	// Default is an APNG's static image when it is not also the first
+	// frame. Decoders without APNG support show it instead.
+	Default stdimage.Image
+	// Palette and Background are a GIF's global colour table, nil if it has
+	// none, and background colour index.
+	Palette    color.Palette
+	Background uint8
+}
+
+type AnimationFrame struct {
+	// Image is the frame; its bounds place it on the canvas.
+	Image stdimage.Image
+	// The frame shows for DelayNum/DelayDen seconds.
+	DelayNum, DelayDen uint16
+	Disposal           Disposal
+	Blend              Blend
+}
+
+// Disposal is what happens to a frame's rectangle before the next frame.
+type Disposal uint8
+
+const (
+	// DisposeUnspecified is GIF's "no disposal specified", which viewers
+	// treat as DisposeNone.
+	DisposeUnspecified Disposal = iota
+	DisposeNone
+	DisposeBackground
+	DisposePrevious
+)
+
+type Blend uint8
+
+const (
+	// BlendSource replaces the frame's rectangle, alpha included.
+	BlendSource Blend = iota
+	// BlendOver composes the frame over the canvas. GIF frames always blend
+	// this way.
+	BlendOver
+)
+
+// IsAnimation reports whether data is a GIF or an APNG with more than one
+// frame. DecodeAnimation also reads single-frame ones.
+func IsAnimation(data []byte) bool {
+	switch {
+	case isGIF(data):
+		return gifFrameCount(data, 2) > 1
+	case isAPNG(data):
+		return apngFrameCount(data) > 1
+	}
+	return false
+}
+
+// DecodeAnimation reads every frame of a GIF or APNG, and reports which of
+// the two it was as FormatGIF or FormatPNG.
+func DecodeAnimation(data []byte) (*Animation, Format, error) {
+	switch {
+	case isGIF(data):
+		a, err := decodeGIFAnimation(data)
+		return a, FormatGIF, err
+	case isAPNG(data):
+		a, err := decodeAPNG(data)
+		return a, FormatPNG, err
+	}
+	return nil, "", fmt.Errorf("%w: not a gif or apng", ErrUnknownFormat)
+}
+
+// EncodeAnimation writes a as a GIF (FormatGIF), whose frames must all be
+// *image.Paletted, or as an APNG (FormatPNG).
+func EncodeAnimation(w io.Writer, a *Animation, f Format) error {
+	if w == nil {
+		return errors.New("writer is nil")
+	}
+	if a == nil || len(a.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+	if a.Width <= 0 || a.Height <= 0 {
+		return fmt.Errorf("invalid canvas size %dx%d", a.Width, a.Height)
+	}
+	canvas := stdimage.Rect(0, 0, a.Width, a.Height)
+	for i, fr := range a.Frames {
+		if fr.Image == nil {
+			return fmt.Errorf("frame %d is nil", i)
+		}
+		if b := fr.Image.Bounds(); b.Empty() || !b.In(canvas) {
+			return fmt.Errorf("frame %d at %v is outside the %dx%d canvas", i, b, a.Width, a.Height)
+		}
+	}
+
+	switch f {
+	case FormatGIF:
+		return encodeGIFAnimation(w, a)
+	case FormatPNG:
+		return encodeAPNG(w, a)
+	}
+	return fmt.Errorf("%w: cannot write an animation as %q", ErrUnknownFormat, f)
+}
+
+func isGIF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))
+}
+
+// gifFrameCount counts the images in a GIF, stopping at limit, by walking
+// its blocks without decompressing them. A truncated file counts the images
+// seen so far.
+func gifFrameCount(data []byte, limit int) int {
+	if len(data) < 13 {
+		return 0
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&7 + 1)
+	}
+	// skipBlocks steps over a run of data sub-blocks and its terminator.
+	skipBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos += n + 1
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	n := 0
+	for pos < len(data) && n < limit {
+		switch data[pos] {
+		case 0x21: // extension: label, then sub-blocks
+			pos += 2
+			if !skipBlocks() {
+				return n
+			}
+		case 0x2c: // image: descriptor, local colour table, LZW code size
+			if pos+10 > len(data) {
+				return n
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&7 + 1)
+			}
+			pos++
+			if !skipBlocks() {
+				return n
+			}
+			n++
+		default: // trailer or garbage
+			return n
+		}
+	}
+	return n
+}
+
+func decodeGIFAnimation(data []byte) (*Animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	a := &Animation{
+		Width:      g.Config.Width,
+		Height:     g.Config.Height,
+		Frames:     make([]AnimationFrame, len(g.Image)),
+		Background: g.BackgroundIndex,
+	}
+	if p, ok := g.Config.ColorModel.(color.Palette); ok && len(p) > 0 {
+		a.Palette = p
+	}
+
+	// GIF counts repeats after the first run, and -1 means no NETSCAPE
+	// block, which plays once.
+	switch {
+	case g.LoopCount < 0:
+		a.Plays = 1
+	case g.LoopCount > 0:
+		a.Plays = g.LoopCount + 1
+	}
+
+	for i, img := range g.Image {
+		a.Frames[i] = AnimationFrame{Image: img, DelayDen: 100, Blend: BlendOver}
+		if i < len(g.Delay) {
+			a.Frames[i].DelayNum = uint16(g.Delay[i])
+		}
+		if i < len(g.Disposal) {
+			a.Frames[i].Disposal = Disposal(g.Disposal[i])
+		}
+	}
+	return a, nil
+}
+
+func encodeGIFAnimation(w io.Writer, a *Animation) error {
+	g := &gif.GIF{
+		Image:           make([]*stdimage.Paletted, len(a.Frames)),
+		Delay:           make([]int, len(a.Frames)),
+		Disposal:        make([]byte, len(a.Frames)),
+		BackgroundIndex: a.Background,
+		Config:          stdimage.Config{Width: a.Width, Height: a.Height},
+	}
+	if a.Palette != nil {
+		g.Config.ColorModel = a.Palette
+	}
+	switch a.Plays {
+	case 0:
+		g.LoopCount = 0
+	case 1:
+		g.LoopCount = -1
+	default:
+		g.LoopCount = a.Plays - 1
+	}
+
+	for i, fr := range a.Frames {
+		p, ok := fr.Image.(*stdimage.Paletted)
+		if !ok {
+			return fmt.Errorf("gif: frame %d is not paletted", i)
+		}
+		g.Image[i] = p
+		g.Delay[i] = gifDelay(fr.DelayNum, fr.DelayDen)
+		g.Disposal[i] = byte(fr.Disposal)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// gifDelay converts a delay of num/den seconds to GIF's hundredths. A zero
+// den means hundredths, as in APNG.
+func gifDelay(num, den uint16) int {
+	if den == 0 || den == 100 {
+		return int(num)
+	}
+	return (int(num)*100 + int(den)/2) / int(den)
+}
@@ -0,0 +1,103 @@
+package image
+
+import (
+	"bytes"
+	stdimage "image"
+	"image/color"
+	"testing"
+)
+
+var testPalette = color.Palette{
+	color.NRGBA{A: 0xff},
+	color.NRGBA{R: 0xff, A: 0xff},
+	color.NRGBA{G: 0xff, A: 0xff},
+	color.NRGBA{B: 0xff, A: 0xff},
+}
+
+func testPaletted(r stdimage.Rectangle, seed int) *stdimage.Paletted {
+	img := stdimage.NewPaletted(r, testPalette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8((i*7 + seed) % len(testPalette))
+	}
+	return img
+}
+
+func TestAnimationRoundTrip(t *testing.T) {
+	cases := []struct {
+		name   string
+		format Format
+		anim   *Animation
+	}{
+		{"gif", FormatGIF, &Animation{
+			Width: 24, Height: 16, Plays: 3, Palette: testPalette, Background: 2,
+			Frames: []AnimationFrame{
+				{Image: testPaletted(stdimage.Rect(0, 0, 24, 16), 0), DelayNum: 10, DelayDen: 100, Disposal: DisposeNone, Blend: BlendOver},
+				{Image: testPaletted(stdimage.Rect(5, 3, 17, 11), 1), DelayNum: 25, DelayDen: 100, Disposal: DisposeBackground, Blend: BlendOver},
+			},
+		}},
+		{"apng-paletted", FormatPNG, &Animation{
+			Width: 24, Height: 16, Plays: 0,
+			Frames: []AnimationFrame{
+				{Image: testPaletted(stdimage.Rect(0, 0, 24, 16), 0), DelayNum: 1, DelayDen: 30, Disposal: DisposeNone, Blend: BlendSource},
+				{Image: testPaletted(stdimage.Rect(5, 3, 17, 11), 1), DelayNum: 2, DelayDen: 30, Disposal: DisposePrevious, Blend: BlendOver},
+			},
+		}},
+		{"apng-rgba", FormatPNG, &Animation{
+			Width: 24, Height: 16, Plays: 2, Default: testNRGBA(stdimage.Rect(0, 0, 24, 16), 9),
+			Frames: []AnimationFrame{
+				{Image: testNRGBA(stdimage.Rect(2, 2, 20, 14), 0), DelayNum: 1, DelayDen: 10, Disposal: DisposeBackground, Blend: BlendSource},
+				{Image: testNRGBA(stdimage.Rect(0, 0, 24, 16), 1), DelayNum: 3, DelayDen: 10, Disposal: DisposeNone, Blend: BlendOver},
+			},
+		}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := EncodeAnimation(&buf, c.anim, c.format); err != nil {
+			t.Fatalf("%s: encode: %v", c.name, err)
+		}
+		got, format, err := DecodeAnimation(buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: decode: %v", c.name, err)
+		}
+		if format != c.format {
+			t.Errorf("%s: format %q, want %q", c.name, format, c.format)
+		}
+		if got.Width != c.anim.Width || got.Height != c.anim.Height || got.Plays != c.anim.Plays {
+			t.Errorf("%s: canvas %dx%d plays %d, want %dx%d plays %d", c.name, got.Width, got.Height, got.Plays, c.anim.Width, c.anim.Height, c.anim.Plays)
+		}
+		if (got.Default == nil) != (c.anim.Default == nil) || got.Default != nil && !sameImage(got.Default, c.anim.Default) {
+			t.Errorf("%s: default image differs", c.name)
+		}
+		if len(got.Frames) != len(c.anim.Frames) {
+			t.Fatalf("%s: %d frames, want %d", c.name, len(got.Frames), len(c.anim.Frames))
+		}
+		for i, want := range c.anim.Frames {
+			fr := got.Frames[i]
+			if fr.DelayNum != want.DelayNum || fr.DelayDen != want.DelayDen || fr.Disposal != want.Disposal || fr.Blend != want.Blend {
+				t.Errorf("%s: frame %d timing %+v, want %+v", c.name, i, fr, want)
+			}
+			if !sameImage(fr.Image, want.Image) {
+				t.Errorf("%s: frame %d pixels differ", c.name, i)
+			}
+		}
+	}
+}
+
+func TestAnimationTruncated(t *testing.T) {
+	frames := []AnimationFrame{
+		{Image: testPaletted(stdimage.Rect(0, 0, 24, 16), 0), DelayNum: 10, DelayDen: 100},
+		{Image: testPaletted(stdimage.Rect(5, 3, 17, 11), 1), DelayNum: 10, DelayDen: 100},
+	}
+	for _, format := range []Format{FormatGIF, FormatPNG} {
+		var buf bytes.Buffer
+		if err := EncodeAnimation(&buf, &Animation{Width: 24, Height: 16, Frames: frames}, format); err != nil {
+			t.Fatalf("%s: encode: %v", format, err)
+		}
+		data := buf.Bytes()
+		for n := 0; n < len(data); n++ {
+			if _, _, err := DecodeAnimation(data[:n]); err == nil {
+				t.Fatalf("%s: decoding %d of %d bytes succeeded", format, n, len(data))
+			}
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package image
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// PNG colour types the APNG writer uses.
+const (
+	pngColorPaletted = 3
+	pngColorRGBA     = 6
+)
+
+var errStopChunks = errors.New("stop")
+
+// isAPNG reports whether data is a PNG with an acTL chunk, which must come
+// before the image data.
+func isAPNG(data []byte) bool {
+	return apngFrameCount(data) > 0
+}
+
+// apngFrameCount is the frame count in data's acTL chunk, 0 if it has none.
+func apngFrameCount(data []byte) int {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return 0
+	}
+	n := 0
+	_ = forEachPNGChunk(data, func(typ string, body []byte) error {
+		switch typ {
+		case "acTL":
+			if len(body) == 8 {
+				n = int(min(binary.BigEndian.Uint32(body), 1<<30))
+			}
+			return errStopChunks
+		case "IDAT":
+			return errStopChunks
+		}
+		return nil
+	})
+	return n
+}
+
+type apngFrame struct {
+	fctl []byte
+	data bytes.Buffer
+}
+
+// decodeAPNG splits an APNG into one plain PNG per frame, sharing the
+// header and the chunks before the image data, and decodes each with
+// image/png.
+func decodeAPNG(data []byte) (*Animation, error) {
+	var ihdr []byte
+	var shared bytes.Buffer
+	var frames []*apngFrame
+	var defaultData *bytes.Buffer
+	var plays uint32
+	seenIDAT := false
+
+	err := forEachPNGChunk(data, func(typ string, body []byte) error {
+		switch typ {
+		case "IHDR":
+			if len(body) != 13 {
+				return errors.New("apng: invalid IHDR")
+			}
+			ihdr = body
+		case "acTL":
+			if len(body) != 8 {
+				return errors.New("apng: invalid acTL")
+			}
+			plays = binary.BigEndian.Uint32(body[4:])
+		case "fcTL":
+			if len(body) != 26 {
+				return errors.New("apng: invalid fcTL")
+			}
+			frames = append(frames, &apngFrame{fctl: body})
+		case "IDAT":
+			seenIDAT = true
+			if len(frames) == 0 {
+				if defaultData == nil {
+					defaultData = &bytes.Buffer{}
+				}
+				defaultData.Write(body)
+			} else {
+				frames[0].data.Write(body)
+			}
+		case "fdAT":
+			if len(frames) == 0 || len(body) < 4 {
+				return errors.New("apng: invalid fdAT")
+			}
+			frames[len(frames)-1].data.Write(body[4:])
+		case "IEND":
+		default:
+			if !seenIDAT {
+				writePNGChunk(&shared, typ, body)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ihdr == nil {
+		return nil, errors.New("apng: missing IHDR")
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("apng: no frames")
+	}
+
+	a := &Animation{
+		Width:  int(binary.BigEndian.Uint32(ihdr[0:])),
+		Height: int(binary.BigEndian.Uint32(ihdr[4:])),
+		Frames: make([]AnimationFrame, len(frames)),
+		Plays:  int(plays),
+	}
+	if defaultData != nil {
+		img, err := decodeAPNGPart(ihdr, a.Width, a.Height, shared.Bytes(), defaultData.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("apng: default image: %w", err)
+		}
+		a.Default = img
+	}
+	for i, f := range frames {
+		w := int(binary.BigEndian.Uint32(f.fctl[4:]))
+		h := int(binary.BigEndian.Uint32(f.fctl[8:]))
+		x := int(binary.BigEndian.Uint32(f.fctl[12:]))
+		y := int(binary.BigEndian.Uint32(f.fctl[16:]))
+		if w <= 0 || h <= 0 || x+w > a.Width || y+h > a.Height {
+			return nil, fmt.Errorf("apng: frame %d at (%d, %d) size %dx%d is outside the canvas", i, x, y, w, h)
+		}
+		img, err := decodeAPNGPart(ihdr, w, h, shared.Bytes(), f.data.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("apng: frame %d: %w", i, err)
+		}
+		a.Frames[i] = AnimationFrame{
+			Image:    Translate(img, stdimage.Pt(x, y)),
+			DelayNum: binary.BigEndian.Uint16(f.fctl[20:]),
+			DelayDen: binary.BigEndian.Uint16(f.fctl[22:]),
+			Disposal: Disposal(f.fctl[24]) + DisposeNone,
+			Blend:    Blend(f.fctl[25]),
+		}
+		if a.Frames[i].Disposal > DisposePrevious || a.Frames[i].Blend > BlendOver {
+			return nil, fmt.Errorf("apng: frame %d has an invalid dispose or blend op", i)
+		}
+	}
+	return a, nil
+}
+
+func decodeAPNGPart(ihdr []byte, w, h int, shared, idat []byte) (stdimage.Image, error) {
+	hdr := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(hdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(hdr[4:], uint32(h))
+
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	writePNGChunk(&buf, "IHDR", hdr)
+	buf.Write(shared)
+	writePNGChunk(&buf, "IDAT", idat)
+	writePNGChunk(&buf, "IEND", nil)
+	return png.Decode(&buf)
+}
+
+// Translate returns img with its bounds moved by pt, sharing pixels
+// where the type allows.
+func Translate(img stdimage.Image, pt stdimage.Point) stdimage.Image {
+	if pt == (stdimage.Point{}) {
+		return img
+	}
+	switch s := img.(type) {
+	case *stdimage.Paletted:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	case *stdimage.Gray:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	case *stdimage.Gray16:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	case *stdimage.RGBA:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	case *stdimage.RGBA64:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	case *stdimage.NRGBA:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	case *stdimage.NRGBA64:
+		out := *s
+		out.Rect = s.Rect.Add(pt)
+		return &out
+	}
+
+	b := img.Bounds()
+	out := stdimage.NewNRGBA64(b.Add(pt))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			out.Set(x+pt.X, y+pt.Y, img.At(x, y))
+		}
+	}
+	return out
+}
+
+// encodeAPNG writes every frame, and the default image if any, in one
+// colour type: indexed if they are all *image.Paletted with the same
+// palette, else RGBA, at 16 bits if any of them has 16-bit samples.
+func encodeAPNG(w io.Writer, a *Animation) error {
+	images := make([]stdimage.Image, 0, len(a.Frames)+1)
+	if a.Default != nil {
+		if a.Default.Bounds() != stdimage.Rect(0, 0, a.Width, a.Height) {
+			return errors.New("apng: default image must cover the canvas")
+		}
+		images = append(images, a.Default)
+	} else if a.Frames[0].Image.Bounds() != stdimage.Rect(0, 0, a.Width, a.Height) {
+		return errors.New("apng: first frame must cover the canvas")
+	}
+	for _, fr := range a.Frames {
+		images = append(images, fr.Image)
+	}
+
+	colorType, depth := apngColorType(images)
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(a.Width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(a.Height))
+	ihdr[8] = byte(depth)
+	ihdr[9] = byte(colorType)
+	writePNGChunk(&out, "IHDR", ihdr)
+
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(len(a.Frames)))
+	binary.BigEndian.PutUint32(actl[4:], uint32(a.Plays))
+	writePNGChunk(&out, "acTL", actl)
+
+	if colorType == pngColorPaletted {
+		pal := images[0].(*stdimage.Paletted).Palette
+		plte := make([]byte, 0, 3*len(pal))
+		trns := make([]byte, 0, len(pal))
+		for _, c := range pal {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			plte = append(plte, n.R, n.G, n.B)
+			trns = append(trns, n.A)
+		}
+		for len(trns) > 0 && trns[len(trns)-1] == 255 {
+			trns = trns[:len(trns)-1]
+		}
+		writePNGChunk(&out, "PLTE", plte)
+		if len(trns) > 0 {
+			writePNGChunk(&out, "tRNS", trns)
+		}
+	}
+
+	seq := uint32(0)
+	if a.Default != nil {
+		data, err := encodePNGPixels(a.Default, colorType, depth)
+		if err != nil {
+			return err
+		}
+		writePNGChunk(&out, "IDAT", data)
+	}
+	for i, fr := range a.Frames {
+		b := fr.Image.Bounds()
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(b.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(b.Min.Y))
+		binary.BigEndian.PutUint16(fctl[20:], fr.DelayNum)
+		binary.BigEndian.PutUint16(fctl[22:], fr.DelayDen)
+		if fr.Disposal > DisposeNone {
+			fctl[24] = byte(fr.Disposal - DisposeNone)
+		}
+		fctl[25] = byte(fr.Blend)
+		writePNGChunk(&out, "fcTL", fctl)
+		seq++
+
+		data, err := encodePNGPixels(fr.Image, colorType, depth)
+		if err != nil {
+			return fmt.Errorf("apng: frame %d: %w", i, err)
+		}
+		if i == 0 && a.Default == nil {
+			writePNGChunk(&out, "IDAT", data)
+			continue
+		}
+		body := make([]byte, 4, 4+len(data))
+		binary.BigEndian.PutUint32(body, seq)
+		writePNGChunk(&out, "fdAT", append(body, data...))
+		seq++
+	}
+	writePNGChunk(&out, "IEND", nil)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func apngColorType(images []stdimage.Image) (colorType, depth int) {
+	paletted := true
+	var pal color.Palette
+	for i, img := range images {
+		p, ok := img.(*stdimage.Paletted)
+		if !ok || len(p.Palette) == 0 || len(p.Palette) > 256 {
+			paletted = false
+			break
+		}
+		if i == 0 {
+			pal = p.Palette
+			continue
+		}
+		if !samePalette(pal, p.Palette) {
+			paletted = false
+			break
+		}
+	}
+	if paletted {
+		return pngColorPaletted, 8
+	}
+
+	for _, img := range images {
+		switch img.(type) {
+		case *stdimage.Gray16, *stdimage.RGBA64, *stdimage.NRGBA64:
+			return pngColorRGBA, 16
+		}
+	}
+	return pngColorRGBA, 8
+}
+
+func samePalette(a, b color.Palette) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		r1, g1, b1, a1 := a[i].RGBA()
+		r2, g2, b2, a2 := b[i].RGBA()
+		if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePNGPixels returns the zlib stream of img's filtered scanlines.
+// Each row takes the filter whose output has the smallest sum of absolute
+// values, the heuristic image/png uses.
+func encodePNGPixels(img stdimage.Image, colorType, depth int) ([]byte, error) {
+	b := img.Bounds()
+	bpp := 4 * depth / 8
+	if colorType == pngColorPaletted {
+		bpp = 1
+	}
+	rowLen := b.Dx() * bpp
+
+	var z bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&z, zlib.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	prev := make([]byte, rowLen)
+	cur := make([]byte, rowLen)
+	filtered := make([][]byte, 5)
+	for i := range filtered {
+		filtered[i] = make([]byte, 1+rowLen)
+		filtered[i][0] = byte(i)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		pngRow(cur, img, y, colorType, depth)
+		best, bestSum := 0, -1
+		for ft := range filtered {
+			f := filtered[ft][1:]
+			sum := 0
+			for i := range cur {
+				var left, upLeft byte
+				if i >= bpp {
+					left, upLeft = cur[i-bpp], prev[i-bpp]
+				}
+				up := prev[i]
+				var pred byte
+				switch ft {
+				case 1:
+					pred = left
+				case 2:
+					pred = up
+				case 3:
+					pred = byte((int(left) + int(up)) / 2)
+				case 4:
+					pred = paeth(left, up, upLeft)
+				}
+				f[i] = cur[i] - pred
+				if v := int(int8(f[i])); v < 0 {
+					sum -= v
+				} else {
+					sum += v
+				}
+			}
+			if bestSum < 0 || sum < bestSum {
+				best, bestSum = ft, sum
+			}
+		}
+		if _, err := zw.Write(filtered[best]); err != nil {
+			return nil, err
+		}
+		prev, cur = cur, prev
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return z.Bytes(), nil
+}
+
+func pngRow(dst []byte, img stdimage.Image, y, colorType, depth int) {
+	b := img.Bounds()
+	if colorType == pngColorPaletted {
+		p := img.(*stdimage.Paletted)
+		off := p.PixOffset(b.Min.X, y)
+		copy(dst, p.Pix[off:off+b.Dx()])
+		return
+	}
+	for x := b.Min.X; x < b.Max.X; x++ {
+		i := (x - b.Min.X) * 4 * depth / 8
+		if depth == 16 {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			binary.BigEndian.PutUint16(dst[i:], c.R)
+			binary.BigEndian.PutUint16(dst[i+2:], c.G)
+			binary.BigEndian.PutUint16(dst[i+4:], c.B)
+			binary.BigEndian.PutUint16(dst[i+6:], c.A)
+			continue
+		}
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		dst[i], dst[i+1], dst[i+2], dst[i+3] = c.R, c.G, c.B, c.A
+	}
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
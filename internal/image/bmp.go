@@ -0,0 +1,535 @@
+package image
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"io"
+	"math/bits"
+)
+
+func init() {
+	stdimage.RegisterFormat("bmp", "BM", DecodeBMP, DecodeBMPConfig)
+}
+
+const (
+	bmpFileHeaderLen = 14
+	bmpInfoHeaderLen = 40
+	bmpV4HeaderLen   = 108
+
+	bmpRGB       = 0
+	bmpRLE8      = 1
+	bmpRLE4      = 2
+	bmpBitfields = 3
+	bmpAlphaBits = 6
+)
+
+type bmpHeader struct {
+	w, h        int
+	topDown     bool
+	bpp         int
+	compression uint32
+	masks       [4]uint32 // R, G, B, A
+	palette     color.Palette
+	dataOffset  int64
+}
+
+// DecodeBMP reads an uncompressed, RLE4/RLE8 or bitfields BMP at 1, 4, 8,
+// 16, 24 or 32 bits per pixel. A palette that is the 256-level gray ramp
+// decodes to *image.Gray, other palettes to *image.Paletted and true colour
+// to *image.NRGBA; alpha is only read from an explicit alpha mask.
+func DecodeBMP(r io.Reader) (stdimage.Image, error) {
+	br := &countingReader{r: bufio.NewReader(r)}
+	h, err := readBMPHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if h.dataOffset < br.n {
+		return nil, errors.New("bmp: pixel data overlaps header")
+	}
+	if _, err := io.CopyN(io.Discard, br, h.dataOffset-br.n); err != nil {
+		return nil, fmt.Errorf("bmp: %w", err)
+	}
+
+	switch h.compression {
+	case bmpRLE8, bmpRLE4:
+		idx, err := decodeBMPRLE(br, h)
+		if err != nil {
+			return nil, err
+		}
+		return h.indexedImage(idx), nil
+	}
+	if h.bpp <= 8 {
+		idx, err := readBMPIndexed(br, h)
+		if err != nil {
+			return nil, err
+		}
+		return h.indexedImage(idx), nil
+	}
+	return readBMPTrueColor(br, h)
+}
+
+func DecodeBMPConfig(r io.Reader) (stdimage.Config, error) {
+	h, err := readBMPHeader(&countingReader{r: r})
+	if err != nil {
+		return stdimage.Config{}, err
+	}
+	cfg := stdimage.Config{Width: h.w, Height: h.h, ColorModel: color.NRGBAModel}
+	if h.bpp <= 8 {
+		cfg.ColorModel = h.palette
+		if isGrayRamp(h.palette) {
+			cfg.ColorModel = color.GrayModel
+		}
+	}
+	return cfg, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func readBMPHeader(r io.Reader) (*bmpHeader, error) {
+	var file [bmpFileHeaderLen + 4]byte
+	if _, err := io.ReadFull(r, file[:]); err != nil {
+		return nil, fmt.Errorf("bmp: read header: %w", err)
+	}
+	if string(file[:2]) != "BM" {
+		return nil, errors.New("bmp: invalid signature")
+	}
+	h := &bmpHeader{dataOffset: int64(binary.LittleEndian.Uint32(file[10:]))}
+	infoLen := int(binary.LittleEndian.Uint32(file[14:]))
+	if infoLen < 12 || infoLen > 1<<10 {
+		return nil, fmt.Errorf("bmp: unsupported header size %d", infoLen)
+	}
+	info := make([]byte, infoLen)
+	copy(info, file[14:])
+	if _, err := io.ReadFull(r, info[4:]); err != nil {
+		return nil, fmt.Errorf("bmp: read header: %w", err)
+	}
+
+	var height int
+	if infoLen == 12 {
+		// OS/2 BITMAPCOREHEADER.
+		h.w = int(binary.LittleEndian.Uint16(info[4:]))
+		height = int(binary.LittleEndian.Uint16(info[6:]))
+		h.bpp = int(binary.LittleEndian.Uint16(info[10:]))
+	} else {
+		if infoLen < bmpInfoHeaderLen {
+			return nil, fmt.Errorf("bmp: unsupported header size %d", infoLen)
+		}
+		h.w = int(int32(binary.LittleEndian.Uint32(info[4:])))
+		height = int(int32(binary.LittleEndian.Uint32(info[8:])))
+		h.bpp = int(binary.LittleEndian.Uint16(info[14:]))
+		h.compression = binary.LittleEndian.Uint32(info[16:])
+	}
+	if height < 0 {
+		h.topDown = true
+		height = -height
+	}
+	h.h = height
+	if err := checkPixels(h.w, h.h); err != nil {
+		return nil, fmt.Errorf("bmp: %w", err)
+	}
+
+	switch h.compression {
+	case bmpRGB:
+		if h.bpp != 1 && h.bpp != 4 && h.bpp != 8 && h.bpp != 16 && h.bpp != 24 && h.bpp != 32 {
+			return nil, fmt.Errorf("bmp: unsupported bit depth %d", h.bpp)
+		}
+	case bmpRLE8, bmpRLE4:
+		if (h.compression == bmpRLE8 && h.bpp != 8) || (h.compression == bmpRLE4 && h.bpp != 4) || h.topDown {
+			return nil, errors.New("bmp: invalid RLE header")
+		}
+	case bmpBitfields, bmpAlphaBits:
+		if h.bpp != 16 && h.bpp != 32 {
+			return nil, errors.New("bmp: bitfields need 16 or 32 bits per pixel")
+		}
+	default:
+		return nil, fmt.Errorf("bmp: unsupported compression %d", h.compression)
+	}
+
+	// Masks: the default layouts, replaced by the header's (or by the
+	// three or four masks that follow a plain info header).
+	switch h.bpp {
+	case 16:
+		h.masks = [4]uint32{0x7c00, 0x03e0, 0x001f, 0}
+	case 24, 32:
+		h.masks = [4]uint32{0xff0000, 0x00ff00, 0x0000ff, 0}
+	}
+	paletteEntry := 4
+	if infoLen == 12 {
+		paletteEntry = 3
+	}
+	if h.compression == bmpBitfields || h.compression == bmpAlphaBits {
+		n := 3
+		if h.compression == bmpAlphaBits {
+			n = 4
+		}
+		if infoLen >= bmpInfoHeaderLen+4*n {
+			for i := 0; i < n; i++ {
+				h.masks[i] = binary.LittleEndian.Uint32(info[bmpInfoHeaderLen+4*i:])
+			}
+		} else {
+			var extra [16]byte
+			if _, err := io.ReadFull(r, extra[:4*n]); err != nil {
+				return nil, fmt.Errorf("bmp: read bitfields: %w", err)
+			}
+			for i := 0; i < n; i++ {
+				h.masks[i] = binary.LittleEndian.Uint32(extra[4*i:])
+			}
+		}
+	}
+	if infoLen >= bmpInfoHeaderLen+16 && h.compression != bmpRGB {
+		h.masks[3] = binary.LittleEndian.Uint32(info[bmpInfoHeaderLen+12:])
+	}
+
+	if h.bpp <= 8 {
+		count := 1 << h.bpp
+		if infoLen >= bmpInfoHeaderLen {
+			if used := int(binary.LittleEndian.Uint32(info[32:])); used > 0 && used < count {
+				count = used
+			}
+		}
+		pal := make([]byte, count*paletteEntry)
+		if _, err := io.ReadFull(r, pal); err != nil {
+			return nil, fmt.Errorf("bmp: read palette: %w", err)
+		}
+		h.palette = make(color.Palette, count)
+		for i := range h.palette {
+			e := pal[i*paletteEntry:]
+			h.palette[i] = color.RGBA{R: e[2], G: e[1], B: e[0], A: 0xff}
+		}
+	}
+	return h, nil
+}
+
+// stride is the length of one row of pixel data, padded to 4 bytes.
+func (h *bmpHeader) stride() int {
+	return (h.w*h.bpp + 31) / 32 * 4
+}
+
+// row returns the image row that the i-th stored row holds.
+func (h *bmpHeader) row(i int) int {
+	if h.topDown {
+		return i
+	}
+	return h.h - 1 - i
+}
+
+func readBMPIndexed(r io.Reader, h *bmpHeader) ([]uint8, error) {
+	data, err := readSized(r, h.stride()*h.h)
+	if err != nil {
+		return nil, fmt.Errorf("bmp: pixel data is truncated")
+	}
+	idx := make([]uint8, h.w*h.h)
+	mask := byte(1<<h.bpp - 1)
+	for i := 0; i < h.h; i++ {
+		buf := data[i*h.stride():]
+		dst := idx[h.row(i)*h.w:]
+		for x := 0; x < h.w; x++ {
+			bit := x * h.bpp
+			shift := 8 - h.bpp - bit%8
+			dst[x] = buf[bit/8] >> shift & mask
+		}
+	}
+	return idx, nil
+}
+
+// decodeBMPRLE expands RLE8 or RLE4 data. Pixels the stream skips keep
+// index 0.
+func decodeBMPRLE(r io.Reader, h *bmpHeader) ([]uint8, error) {
+	br := bufio.NewReader(r)
+	idx := make([]uint8, h.w*h.h)
+	x, y := 0, 0
+	put := func(v uint8) {
+		if x < h.w && y < h.h {
+			idx[h.row(y)*h.w+x] = v
+		}
+		x++
+	}
+	nibbles := h.compression == bmpRLE4
+
+	for {
+		var pair [2]byte
+		// The data ends with an end-of-bitmap marker or the last row;
+		// running out before either is truncation.
+		if _, err := io.ReadFull(br, pair[:]); err != nil {
+			return nil, errors.New("bmp: RLE data is truncated")
+		}
+		n, v := int(pair[0]), pair[1]
+		if n > 0 {
+			for i := 0; i < n; i++ {
+				if nibbles {
+					put(v >> (4 * uint(1-i%2)) & 0x0f)
+				} else {
+					put(v)
+				}
+			}
+			continue
+		}
+
+		switch v {
+		case 0:
+			x, y = 0, y+1
+		case 1:
+			return idx, nil
+		case 2:
+			var d [2]byte
+			if _, err := io.ReadFull(br, d[:]); err != nil {
+				return nil, errors.New("bmp: RLE data is truncated")
+			}
+			x, y = x+int(d[0]), y+int(d[1])
+		default:
+			// Absolute run of v pixels, padded to a 16-bit boundary.
+			count := int(v)
+			size := count
+			if nibbles {
+				size = (count + 1) / 2
+			}
+			lit := make([]byte, (size+1)&^1)
+			if _, err := io.ReadFull(br, lit); err != nil {
+				return nil, errors.New("bmp: RLE data is truncated")
+			}
+			for i := 0; i < count; i++ {
+				if nibbles {
+					put(lit[i/2] >> (4 * uint(1-i%2)) & 0x0f)
+				} else {
+					put(lit[i])
+				}
+			}
+		}
+		if y >= h.h {
+			return idx, nil
+		}
+	}
+}
+
+func (h *bmpHeader) indexedImage(idx []uint8) stdimage.Image {
+	for i, v := range idx {
+		if int(v) >= len(h.palette) {
+			idx[i] = 0
+		}
+	}
+	if isGrayRamp(h.palette) {
+		return &stdimage.Gray{Pix: idx, Stride: h.w, Rect: stdimage.Rect(0, 0, h.w, h.h)}
+	}
+	return &stdimage.Paletted{Pix: idx, Stride: h.w, Rect: stdimage.Rect(0, 0, h.w, h.h), Palette: h.palette}
+}
+
+// isGrayRamp reports whether p maps every index i to gray level i.
+func isGrayRamp(p color.Palette) bool {
+	if len(p) != 256 {
+		return false
+	}
+	for i, c := range p {
+		r, g, b, _ := c.RGBA()
+		if r>>8 != uint32(i) || g>>8 != uint32(i) || b>>8 != uint32(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func readBMPTrueColor(r io.Reader, h *bmpHeader) (stdimage.Image, error) {
+	data, err := readSized(r, h.stride()*h.h)
+	if err != nil {
+		return nil, fmt.Errorf("bmp: pixel data is truncated")
+	}
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, h.w, h.h))
+	bytesPP := h.bpp / 8
+	hasAlpha := h.masks[3] != 0
+
+	for i := 0; i < h.h; i++ {
+		buf := data[i*h.stride():]
+		dst := img.Pix[h.row(i)*img.Stride:]
+		for x := 0; x < h.w; x++ {
+			var px uint32
+			switch bytesPP {
+			case 2:
+				px = uint32(binary.LittleEndian.Uint16(buf[2*x:]))
+			case 3:
+				px = uint32(buf[3*x]) | uint32(buf[3*x+1])<<8 | uint32(buf[3*x+2])<<16
+			default:
+				px = binary.LittleEndian.Uint32(buf[4*x:])
+			}
+			d := dst[4*x : 4*x+4]
+			d[0] = maskedSample(px, h.masks[0])
+			d[1] = maskedSample(px, h.masks[1])
+			d[2] = maskedSample(px, h.masks[2])
+			d[3] = 0xff
+			if hasAlpha {
+				d[3] = maskedSample(px, h.masks[3])
+			}
+		}
+	}
+	return img, nil
+}
+
+// maskedSample extracts the field selected by mask and scales it to 8 bits.
+func maskedSample(px, mask uint32) uint8 {
+	if mask == 0 {
+		return 0
+	}
+	shift := bits.TrailingZeros32(mask)
+	width := bits.OnesCount32(mask)
+	v := (px & mask) >> shift
+	if width >= 8 {
+		return uint8(v >> (width - 8))
+	}
+	maxV := uint32(1)<<width - 1
+	return uint8((v*255 + maxV/2) / maxV)
+}
+
+// WriteBMP writes img as a bottom-up BMP: *image.Gray as 8 bits with a gray
+// palette (RLE8 when rle is set), opaque images as 24-bit and images with
+// transparency as 32-bit with an alpha mask. True colour BMP has no RLE
+// form, so rle only affects gray images. 16-bit samples are rounded to 8
+// bits.
+func WriteBMP(dst io.Writer, img stdimage.Image, rle bool) error {
+	if dst == nil {
+		return errors.New("writer is nil")
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if _, _, err := checkedImageSizes(w, h); err != nil {
+		return err
+	}
+
+	var info []byte
+	var palette []byte
+	var data []byte
+	switch src := img.(type) {
+	case *stdimage.Gray:
+		info = bmpInfoHeader(w, h, 8, bmpRGB, 256)
+		palette = make([]byte, 256*4)
+		for i := 0; i < 256; i++ {
+			palette[4*i], palette[4*i+1], palette[4*i+2] = byte(i), byte(i), byte(i)
+		}
+		if rle {
+			binary.LittleEndian.PutUint32(info[16:], bmpRLE8)
+			data = encodeBMPRLE8(src)
+		} else {
+			stride := (w + 3) &^ 3
+			data = make([]byte, stride*h)
+			for y := 0; y < h; y++ {
+				off := src.PixOffset(b.Min.X, b.Min.Y+y)
+				copy(data[(h-1-y)*stride:], src.Pix[off:off+w])
+			}
+		}
+	default:
+		converted := FromStdImage(img)
+		nrgba := ToNRGBA(converted)
+		if converted.Alpha == nil {
+			info = bmpInfoHeader(w, h, 24, bmpRGB, 0)
+			stride := (w*3 + 3) &^ 3
+			data = make([]byte, stride*h)
+			for y := 0; y < h; y++ {
+				row := data[(h-1-y)*stride:]
+				for x := 0; x < w; x++ {
+					p := nrgba.Pix[y*nrgba.Stride+4*x:]
+					row[3*x], row[3*x+1], row[3*x+2] = p[2], p[1], p[0]
+				}
+			}
+			break
+		}
+		// A V4 header carries the alpha mask that readers look for.
+		info = make([]byte, bmpV4HeaderLen)
+		copy(info, bmpInfoHeader(w, h, 32, bmpBitfields, 0))
+		binary.LittleEndian.PutUint32(info[0:], bmpV4HeaderLen)
+		binary.LittleEndian.PutUint32(info[40:], 0x00ff0000)
+		binary.LittleEndian.PutUint32(info[44:], 0x0000ff00)
+		binary.LittleEndian.PutUint32(info[48:], 0x000000ff)
+		binary.LittleEndian.PutUint32(info[52:], 0xff000000)
+		copy(info[56:], "BGRs")
+		data = make([]byte, 4*w*h)
+		for y := 0; y < h; y++ {
+			row := data[(h-1-y)*4*w:]
+			for x := 0; x < w; x++ {
+				p := nrgba.Pix[y*nrgba.Stride+4*x:]
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = p[2], p[1], p[0], p[3]
+			}
+		}
+	}
+	binary.LittleEndian.PutUint32(info[20:], uint32(len(data)))
+
+	offset := bmpFileHeaderLen + len(info) + len(palette)
+	var file [bmpFileHeaderLen]byte
+	copy(file[:], "BM")
+	binary.LittleEndian.PutUint32(file[2:], uint32(offset+len(data)))
+	binary.LittleEndian.PutUint32(file[10:], uint32(offset))
+
+	bw := bufio.NewWriter(dst)
+	for _, part := range [][]byte{file[:], info, palette, data} {
+		if _, err := bw.Write(part); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func bmpInfoHeader(w, h, bpp int, compression uint32, colors int) []byte {
+	info := make([]byte, bmpInfoHeaderLen)
+	binary.LittleEndian.PutUint32(info[0:], bmpInfoHeaderLen)
+	binary.LittleEndian.PutUint32(info[4:], uint32(w))
+	binary.LittleEndian.PutUint32(info[8:], uint32(h))
+	binary.LittleEndian.PutUint16(info[12:], 1)
+	binary.LittleEndian.PutUint16(info[14:], uint16(bpp))
+	binary.LittleEndian.PutUint32(info[16:], compression)
+	// 72 dpi.
+	binary.LittleEndian.PutUint32(info[24:], 2835)
+	binary.LittleEndian.PutUint32(info[28:], 2835)
+	binary.LittleEndian.PutUint32(info[32:], uint32(colors))
+	return info
+}
+
+// encodeBMPRLE8 codes each row bottom-up as runs of equal pixels, with
+// absolute mode for stretches that do not repeat.
+func encodeBMPRLE8(src *stdimage.Gray) []byte {
+	b := src.Bounds()
+	var out []byte
+	for y := b.Max.Y - 1; y >= b.Min.Y; y-- {
+		off := src.PixOffset(b.Min.X, y)
+		row := src.Pix[off : off+b.Dx()]
+		for x := 0; x < len(row); {
+			run := 1
+			for x+run < len(row) && run < 255 && row[x+run] == row[x] {
+				run++
+			}
+			if run >= 2 {
+				out = append(out, byte(run), row[x])
+				x += run
+				continue
+			}
+			// Gather literals up to the next run of 2 or more.
+			lit := 1
+			for x+lit < len(row) && lit < 255 && (x+lit+1 >= len(row) || row[x+lit] != row[x+lit+1]) {
+				lit++
+			}
+			if lit < 3 {
+				// Absolute mode needs at least 3 pixels.
+				for i := 0; i < lit; i++ {
+					out = append(out, 1, row[x+i])
+				}
+			} else {
+				out = append(out, 0, byte(lit))
+				out = append(out, row[x:x+lit]...)
+				if lit%2 == 1 {
+					out = append(out, 0)
+				}
+			}
+			x += lit
+		}
+		out = append(out, 0, 0)
+	}
+	return append(out, 0, 1)
+}
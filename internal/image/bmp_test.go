@@ -0,0 +1,125 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	stdimage "image"
+	"image/color"
+	"testing"
+)
+
+// bmpHeaderBytes is a file and info header for an uncompressed BMP with the
+// pixel data straight after them.
+func bmpHeaderBytes(w, h, bpp int) []byte {
+	info := bmpInfoHeader(w, h, bpp, bmpRGB, 0)
+	var file [bmpFileHeaderLen]byte
+	copy(file[:], "BM")
+	binary.LittleEndian.PutUint32(file[10:], uint32(bmpFileHeaderLen+len(info)))
+	return append(file[:], info...)
+}
+
+func TestDecodeBMPRejectsHugeHeader(t *testing.T) {
+	for _, bpp := range []int{8, 24} {
+		data := bmpHeaderBytes(60000, 60000, bpp)
+		if bpp == 8 {
+			data = append(data, make([]byte, 256*4)...)
+		}
+		data = append(data, make([]byte, 64)...)
+
+		if _, err := DecodeBMP(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%d bpp: DecodeBMP error = %v, want ErrTooLarge", bpp, err)
+		}
+		if _, err := DecodeBMPConfig(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%d bpp: DecodeBMPConfig error = %v, want ErrTooLarge", bpp, err)
+		}
+		if _, _, err := DecodeImage(data); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%d bpp: DecodeImage error = %v, want ErrTooLarge", bpp, err)
+		}
+	}
+}
+
+func TestDecodeBMPTruncatedDoesNotAllocateDeclaredSize(t *testing.T) {
+	// 8000x8000 is within MaxPixels and declares 192 MB at 24 bits.
+	data := append(bmpHeaderBytes(8000, 8000, 24), make([]byte, 64)...)
+	var err error
+	n := allocated(func() { _, err = DecodeBMP(bytes.NewReader(data)) })
+	if err == nil {
+		t.Fatal("truncated BMP decoded")
+	}
+	if n > 16<<20 {
+		t.Fatalf("truncated BMP allocated %d bytes", n)
+	}
+}
+
+func testNRGBA(r stdimage.Rectangle, seed int) *stdimage.NRGBA {
+	img := stdimage.NewNRGBA(r)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*13 + seed)
+	}
+	return img
+}
+
+// sameImage reports whether a and b have the same bounds and colours.
+func sameImage(a, b stdimage.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.NRGBAModel.Convert(a.At(x, y)) != color.NRGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writerTestImages covers each kind of image the BMP and TGA writers store
+// differently: gray, opaque colour and colour with alpha.
+func writerTestImages() map[string]stdimage.Image {
+	gray := stdimage.NewGray(stdimage.Rect(0, 0, 19, 7))
+	for i := range gray.Pix {
+		// Runs of repeats as well as changing pixels, for both RLE packets.
+		gray.Pix[i] = uint8(i / 5 * 11)
+	}
+	opaque := testNRGBA(stdimage.Rect(0, 0, 19, 7), 3)
+	translucent := testNRGBA(stdimage.Rect(0, 0, 19, 7), 5)
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xff
+		translucent.Pix[i] |= 0x80
+	}
+	return map[string]stdimage.Image{"gray": gray, "opaque": opaque, "translucent": translucent}
+}
+
+func TestWriteBMPRoundTrip(t *testing.T) {
+	for name, img := range writerTestImages() {
+		for _, rle := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := WriteBMP(&buf, img, rle); err != nil {
+				t.Fatalf("%s rle %v: %v", name, rle, err)
+			}
+			got, err := DecodeBMP(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%s rle %v: %v", name, rle, err)
+			}
+			if !sameImage(got, img) {
+				t.Errorf("%s rle %v: decoded pixels differ", name, rle)
+			}
+
+			// The RLE end-of-bitmap marker follows the last row, so losing
+			// only that loses no pixels.
+			data := buf.Bytes()
+			end := len(data)
+			if rle && name == "gray" {
+				end -= 2
+			}
+			for n := 0; n < end; n++ {
+				if _, err := DecodeBMP(bytes.NewReader(data[:n])); err == nil {
+					t.Fatalf("%s rle %v: decoding %d of %d bytes succeeded", name, rle, n, len(data))
+				}
+			}
+		}
+	}
+}
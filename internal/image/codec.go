@@ -0,0 +1,280 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format names a file format DecodeImage reads and EncodeImage writes.
+type Format string
+
+const (
+	FormatPNG    Format = "png"
+	FormatJPEG   Format = "jpeg"
+	FormatBMP    Format = "bmp"
+	FormatTGA    Format = "tga"
+	FormatNetpbm Format = "netpbm"
+	// FormatGIF decodes to its first frame (see DecodeAnimation for all of
+	// them) and only encodes *image.Paletted.
+	FormatGIF Format = "gif"
+)
+
+var ErrUnknownFormat = errors.New("unknown image format")
+
+// ErrTooLarge reports an image header that declares more than MaxPixels.
+var ErrTooLarge = errors.New("image is too large")
+
+// MaxPixels is the largest width times height the decoders accept, 8192x8192
+// or the same area in any shape. Headers are checked against it before any
+// pixel buffer is allocated, so a few bytes declaring a huge image are an
+// error rather than an out-of-memory crash.
+const MaxPixels = 1 << 26
+
+// StdioPath is the path ReadFile and CreateFile take for stdin and stdout.
+const StdioPath = "-"
+
+// ParseFormat accepts a format name or a common alias: jpg for JPEG, and
+// ppm, pgm or pnm for netpbm.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "png":
+		return FormatPNG, nil
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "gif":
+		return FormatGIF, nil
+	case "bmp":
+		return FormatBMP, nil
+	case "tga":
+		return FormatTGA, nil
+	case "netpbm", "ppm", "pgm", "pnm":
+		return FormatNetpbm, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+}
+
+// FormatFromPath picks the format from the extension of path. ok is false
+// for an extension it does not know, including none.
+func FormatFromPath(path string) (f Format, ok bool) {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	if ext == "" {
+		return "", false
+	}
+	f, err := ParseFormat(ext)
+	return f, err == nil
+}
+
+// DecodeImage reads any format registered with the standard image package,
+// which includes PNG, JPEG, GIF, BMP and TGA, or netpbm. The format is reported
+// under the name the decoder was registered with, and FormatNetpbm for
+// netpbm. A header declaring more than MaxPixels gives ErrTooLarge.
+func DecodeImage(data []byte) (stdimage.Image, Format, error) {
+	cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		err = checkPixels(cfg.Width, cfg.Height)
+	}
+	if errors.Is(err, ErrTooLarge) {
+		return nil, "", err
+	}
+	if img, name, err := stdimage.Decode(bytes.NewReader(data)); err == nil {
+		return img, Format(name), nil
+	}
+	p, err := ReadNetpbm(bytes.NewReader(data))
+	if err == nil {
+		return p.StdImage(), FormatNetpbm, nil
+	}
+	if errors.Is(err, ErrTooLarge) {
+		return nil, "", err
+	}
+	return nil, "", ErrUnknownFormat
+}
+
+// checkPixels rejects a declared w x h that is empty or above MaxPixels.
+func checkPixels(w, h int) error {
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("invalid image size %dx%d", w, h)
+	}
+	if w > MaxPixels/h {
+		return fmt.Errorf("%w: %dx%d is over %d pixels", ErrTooLarge, w, h, MaxPixels)
+	}
+	return nil
+}
+
+// readSized reads exactly n bytes of pixel data. The buffer grows as the
+// data arrives instead of being sized from the header up front, so input
+// that declares more than it holds fails on the missing bytes.
+func readSized(r io.Reader, n int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+type EncodeOptions struct {
+	// ASCII writes plain netpbm (P2, P3).
+	ASCII bool
+	// Quality is the JPEG quality, 1..100; 0 selects 90.
+	Quality int
+	// RLE run-length encodes BMP (grayscale only) and TGA.
+	RLE bool
+}
+
+// EncodeImage writes img in format f. Formats without alpha (JPEG, netpbm)
+// drop it, and GIF takes only *image.Paletted, since quantizing to a
+// palette here would be lossy.
+func EncodeImage(w io.Writer, img stdimage.Image, f Format, opts EncodeOptions) error {
+	if w == nil {
+		return errors.New("writer is nil")
+	}
+	if img == nil {
+		return errors.New("image is nil")
+	}
+
+	switch f {
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatJPEG:
+		quality := opts.Quality
+		if quality == 0 {
+			quality = 90
+		}
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("jpeg quality %d is outside 1..100", quality)
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatGIF:
+		p, ok := img.(*stdimage.Paletted)
+		if !ok {
+			return errors.New("gif: image is not paletted")
+		}
+		return gif.Encode(w, p, nil)
+	case FormatBMP:
+		return WriteBMP(w, img, opts.RLE)
+	case FormatTGA:
+		return WriteTGA(w, img, opts.RLE)
+	case FormatNetpbm:
+		p := NetpbmFromStd(img)
+		p.ASCII = opts.ASCII
+		return WriteNetpbm(w, p)
+	}
+	return fmt.Errorf("%w: cannot write %q", ErrUnknownFormat, f)
+}
+
+// KeepsMetadata reports whether format f stores the metadata ReadMetadata
+// reads: PNG and JPEG.
+func (f Format) KeepsMetadata() bool {
+	return f == FormatPNG || f == FormatJPEG
+}
+
+// HasAlpha reports whether format f stores transparency.
+func (f Format) HasAlpha() bool {
+	return f == FormatPNG || f == FormatGIF || f == FormatBMP || f == FormatTGA
+}
+
+// ReadFile reads path whole, or stdin if path is StdioPath.
+func ReadFile(path string) ([]byte, error) {
+	if path == StdioPath {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+		return data, nil
+	}
+	return os.ReadFile(path)
+}
+
+// CreateFile creates path, or returns stdout if path is StdioPath. Closing
+// stdout is a no-op so that callers can close whatever they got.
+func CreateFile(path string) (io.WriteCloser, error) {
+	if path == StdioPath {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// ReadImageFile reads and decodes path (see ReadFile and DecodeImage).
+func ReadImageFile(path string) (stdimage.Image, Format, error) {
+	data, err := ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	img, f, err := DecodeImage(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("read %s: %w", path, err)
+	}
+	return img, f, nil
+}
+
+// WriteImageFile encodes img into path (see CreateFile and EncodeImage).
+func WriteImageFile(path string, img stdimage.Image, f Format, opts EncodeOptions) error {
+	return WriteImageFileMetadata(path, img, f, opts, nil)
+}
+
+// WriteImageFileMetadata is WriteImageFile that also writes m if f keeps
+// metadata; other formats drop it.
+func WriteImageFileMetadata(path string, img stdimage.Image, f Format, opts EncodeOptions, m *Metadata) (err error) {
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, img, f, opts); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	data := buf.Bytes()
+	switch {
+	case m.Empty():
+	case f == FormatPNG:
+		data, err = AttachPNGMetadata(data, m)
+	case f == FormatJPEG:
+		data, err = AttachJPEGMetadata(data, m)
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: metadata: %w", path, err)
+	}
+
+	out, err := CreateFile(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("close %s: %w", path, closeErr)
+		}
+	}()
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// OutputFormat is the format to write path in: the one its extension names,
+// else fallback for stdout, else netpbm. A GIF fallback becomes PNG, which
+// keeps any palette but also takes truecolour.
+func OutputFormat(path string, fallback Format) Format {
+	if f, ok := FormatFromPath(path); ok {
+		return f
+	}
+	if path == StdioPath && fallback == FormatGIF {
+		return FormatPNG
+	}
+	if path == StdioPath && fallback != "" {
+		return fallback
+	}
+	return FormatNetpbm
+}
@@ -0,0 +1,185 @@
+package image
+
+import (
+	stdimage "image"
+	"image/color"
+)
+
+type Rgb16 struct {
+	R uint16
+	G uint16
+	B uint16
+}
+
+// Image16 is Image at 16 bits per channel, for masters that must keep their
+// full dynamic range.
+type Image16 struct {
+	W     int
+	H     int
+	Pix   []Rgb16
+	Alpha []uint16
+}
+
+// AlphaMask reduces Alpha to 8 bits for the transparency checks of the
+// embedder, keeping every non-zero alpha non-zero. It is nil for an opaque
+// image.
+func (img *Image16) AlphaMask() []uint8 {
+	if img.Alpha == nil {
+		return nil
+	}
+	out := make([]uint8, len(img.Alpha))
+	for i, a := range img.Alpha {
+		switch {
+		case a == 0:
+			out[i] = 0
+		case a < 256:
+			out[i] = 1
+		default:
+			out[i] = uint8(a >> 8)
+		}
+	}
+	return out
+}
+
+// Image rounds img to 8 bits per channel.
+func (img *Image16) Image() *Image {
+	out := &Image{W: img.W, H: img.H, Pix: make([]Rgb, len(img.Pix))}
+	for i, p := range img.Pix {
+		out.Pix[i] = Rgb{R: to8(p.R), G: to8(p.G), B: to8(p.B)}
+	}
+	if img.Alpha != nil {
+		out.Alpha = make([]uint8, len(img.Alpha))
+		for i, a := range img.Alpha {
+			out.Alpha[i] = to8(a)
+		}
+	}
+	return out
+}
+
+func to8(v uint16) uint8 {
+	return uint8(ScaleSample(v, netpbmMaxVal16, 255))
+}
+
+func FromStdImage16(src stdimage.Image) *Image16 {
+	if src == nil {
+		return &Image16{}
+	}
+
+	b := src.Bounds()
+	w := b.Dx()
+	h := b.Dy()
+	if w <= 0 || h <= 0 {
+		return &Image16{}
+	}
+
+	pix := make([]Rgb16, w*h)
+	var alpha []uint16
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA64Model.Convert(src.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64)
+			pix[y*w+x] = Rgb16{R: c.R, G: c.G, B: c.B}
+			if c.A != 0xffff && alpha == nil {
+				alpha = make([]uint16, w*h)
+				for i := range alpha {
+					alpha[i] = 0xffff
+				}
+			}
+			if alpha != nil {
+				alpha[y*w+x] = c.A
+			}
+		}
+	}
+
+	return &Image16{
+		W:     w,
+		H:     h,
+		Pix:   pix,
+		Alpha: alpha,
+	}
+}
+
+func ToNRGBA64(src *Image16) *stdimage.NRGBA64 {
+	if src == nil || src.W <= 0 || src.H <= 0 {
+		return stdimage.NewNRGBA64(stdimage.Rect(0, 0, 0, 0))
+	}
+
+	out := stdimage.NewNRGBA64(stdimage.Rect(0, 0, src.W, src.H))
+	for i := 0; i < src.W*src.H; i++ {
+		var p Rgb16
+		if i < len(src.Pix) {
+			p = src.Pix[i]
+		}
+		a := uint16(0xffff)
+		if i < len(src.Alpha) {
+			a = src.Alpha[i]
+		}
+		out.SetNRGBA64(i%src.W, i/src.W, color.NRGBA64{R: p.R, G: p.G, B: p.B, A: a})
+	}
+	return out
+}
+
+// RGB16ToYCbCr is RGBToYCbCr for 16-bit pixels. The planes are on the 8-bit
+// scale (0..255, fractional), so embedding strength means the same at both
+// depths: a margin of alpha in 8-bit units is 257·alpha in 16-bit ones.
+func RGB16ToYCbCr(img *Image16) (y, cb, cr []float32) {
+	if img == nil || len(img.Pix) == 0 {
+		return nil, nil, nil
+	}
+
+	n := len(img.Pix)
+	y = make([]float32, n)
+	cb = make([]float32, n)
+	cr = make([]float32, n)
+
+	for i, p := range img.Pix {
+		r := float32(p.R) / 257
+		g := float32(p.G) / 257
+		b := float32(p.B) / 257
+
+		y[i] = lumaFromRGB(r, g, b)
+		cb[i] = 128 - 0.168736*r - 0.331264*g + 0.5*b
+		cr[i] = 128 + 0.5*r - 0.418688*g - 0.081312*b
+	}
+
+	return y, cb, cr
+}
+
+func YCbCrToRGB16(w, h int, y, cb, cr []float32) *Image16 {
+	pixelCount, _, err := checkedImageSizes(w, h)
+	if err != nil {
+		return &Image16{W: w, H: h}
+	}
+
+	pix := make([]Rgb16, pixelCount)
+	for i := 0; i < pixelCount; i++ {
+		pix[i] = ycbcrToRgb16(
+			sampleChannel(y, i, 0),
+			sampleChannel(cb, i, 128),
+			sampleChannel(cr, i, 128),
+		)
+	}
+
+	return &Image16{
+		W:   w,
+		H:   h,
+		Pix: pix,
+	}
+}
+
+// QuantizeLuma16 is QuantizeLuma for 16-bit RGB output.
+func QuantizeLuma16(y, cb, cr float32) float32 {
+	p := ycbcrToRgb16(y, cb, cr)
+	return lumaFromRGB(float32(p.R)/257, float32(p.G)/257, float32(p.B)/257)
+}
+
+func ycbcrToRgb16(yv, cbv, crv float32) Rgb16 {
+	r := yv + 1.402*(crv-128)
+	g := yv - 0.344136*(cbv-128) - 0.714136*(crv-128)
+	b := yv + 1.772*(cbv-128)
+
+	return Rgb16{
+		R: clampFloatToUint16(r * 257),
+		G: clampFloatToUint16(g * 257),
+		B: clampFloatToUint16(b * 257),
+	}
+}
@@ -0,0 +1,76 @@
+package image
+
+import (
+	"fmt"
+	stdimage "image"
+	"image/color"
+)
+
+// Indexed is a palette image: one palette index per pixel. Alpha is the
+// straight alpha of each palette entry, or nil if every entry is opaque.
+type Indexed struct {
+	W, H    int
+	Pix     []uint8
+	Palette []Rgb
+	Alpha   []uint8
+}
+
+// IndexedFromStd converts src. Its palette may have at most 256 entries, as
+// in PNG and GIF.
+func IndexedFromStd(src *stdimage.Paletted) (*Indexed, error) {
+	if len(src.Palette) == 0 || len(src.Palette) > 256 {
+		return nil, fmt.Errorf("palette has %d entries, expected 1 to 256", len(src.Palette))
+	}
+
+	b := src.Bounds()
+	img := &Indexed{W: b.Dx(), H: b.Dy(), Pix: make([]uint8, b.Dx()*b.Dy())}
+	for y := 0; y < img.H; y++ {
+		off := src.PixOffset(b.Min.X, b.Min.Y+y)
+		copy(img.Pix[y*img.W:(y+1)*img.W], src.Pix[off:off+img.W])
+	}
+	for _, i := range img.Pix {
+		if int(i) >= len(src.Palette) {
+			return nil, fmt.Errorf("pixel index %d is outside the %d-entry palette", i, len(src.Palette))
+		}
+	}
+
+	img.Palette = make([]Rgb, len(src.Palette))
+	for i, c := range src.Palette {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		img.Palette[i] = Rgb{R: n.R, G: n.G, B: n.B}
+		if n.A != 255 && img.Alpha == nil {
+			img.Alpha = opaqueAlpha(len(src.Palette))
+		}
+		if img.Alpha != nil {
+			img.Alpha[i] = n.A
+		}
+	}
+	return img, nil
+}
+
+// Std returns img as an *image.Paletted at the origin.
+func (img *Indexed) Std() *stdimage.Paletted {
+	palette := make(color.Palette, len(img.Palette))
+	for i, c := range img.Palette {
+		a := uint8(255)
+		if img.Alpha != nil {
+			a = img.Alpha[i]
+		}
+		palette[i] = color.NRGBA{R: c.R, G: c.G, B: c.B, A: a}
+	}
+	out := stdimage.NewPaletted(stdimage.Rect(0, 0, img.W, img.H), palette)
+	copy(out.Pix, img.Pix)
+	return out
+}
+
+// PixelAlpha returns the alpha of every pixel, or nil if img is opaque.
+func (img *Indexed) PixelAlpha() []uint8 {
+	if img.Alpha == nil {
+		return nil
+	}
+	alpha := make([]uint8, len(img.Pix))
+	for i, p := range img.Pix {
+		alpha[i] = img.Alpha[p]
+	}
+	return alpha
+}
@@ -0,0 +1,669 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JPEGCoefficients is a sequential Huffman JPEG (baseline or extended, 8-bit)
+// held as its quantized DCT coefficients. The coefficients can be changed and
+// the file written back with WriteJPEGCoefficients; every other segment is
+// copied verbatim, so nothing but the changed coefficients is re-encoded.
+type JPEGCoefficients struct {
+	W          int
+	H          int
+	Components []JPEGComponent
+
+	// items is the file between SOI and EOI in order: raw segments, with a
+	// nil body standing for a scan (in scans, in the same order).
+	items []jpegItem
+	scans []jpegScan
+}
+
+type JPEGComponent struct {
+	ID byte
+	H  int
+	V  int
+	// Quant is the component's quantization table in natural order.
+	Quant [64]uint16
+	// Blocks holds BlocksW x BlocksH blocks in raster order, padded to whole
+	// MCUs, each with its quantized coefficients in natural (row-major)
+	// order.
+	BlocksW int
+	BlocksH int
+	Blocks  [][64]int32
+}
+
+type jpegItem struct {
+	marker byte
+	body   []byte
+	scan   int
+}
+
+type jpegScan struct {
+	header  []byte
+	comps   []int
+	dc      []int
+	ac      []int
+	restart int
+}
+
+const (
+	jpegSOF0 = 0xc0
+	jpegSOF1 = 0xc1
+	jpegDHT  = 0xc4
+	jpegSOI  = 0xd8
+	jpegEOI  = 0xd9
+	jpegSOS  = 0xda
+	jpegDQT  = 0xdb
+	jpegDRI  = 0xdd
+	jpegRST0 = 0xd0
+	jpegAPP0 = 0xe0
+
+	// JPEGMaxAC bounds an 8-bit baseline AC coefficient (magnitude category
+	// 10).
+	JPEGMaxAC = 1023
+)
+
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// ReadJPEGCoefficients parses a JPEG and decodes its entropy-coded data to
+// quantized coefficients. Progressive and arithmetic-coded files are
+// rejected.
+func ReadJPEGCoefficients(data []byte) (*JPEGCoefficients, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, errors.New("not a jpeg")
+	}
+
+	j := &JPEGCoefficients{}
+	var quant [4]*[64]uint16
+	var dc, ac [4]*huffDecoder
+	restart := 0
+	haveFrame := false
+
+	pos := 2
+	for {
+		marker, body, next, err := nextJPEGSegment(data, pos)
+		if err != nil {
+			return nil, err
+		}
+		pos = next
+
+		switch {
+		case marker == jpegEOI:
+			if len(j.scans) == 0 {
+				return nil, errors.New("jpeg has no scan")
+			}
+			return j, nil
+		case marker == jpegSOF0 || marker == jpegSOF1:
+			if haveFrame {
+				return nil, errors.New("jpeg has more than one frame")
+			}
+			if err := j.parseFrame(body); err != nil {
+				return nil, err
+			}
+			haveFrame = true
+		case marker >= 0xc2 && marker <= 0xcf && marker != jpegDHT && marker != 0xc8 && marker != 0xcc:
+			return nil, fmt.Errorf("unsupported jpeg process (SOF%d); only sequential Huffman JPEGs can be edited in place", marker-0xc0)
+		case marker == jpegDQT:
+			if err := parseDQT(body, &quant); err != nil {
+				return nil, err
+			}
+		case marker == jpegDHT:
+			if err := parseDHT(body, &dc, &ac); err != nil {
+				return nil, err
+			}
+			// Tables are rewritten per scan, so the originals are dropped.
+			continue
+		case marker == jpegDRI:
+			if len(body) != 2 {
+				return nil, errors.New("invalid jpeg DRI segment")
+			}
+			restart = int(binary.BigEndian.Uint16(body))
+		case marker == jpegSOS:
+			if !haveFrame {
+				return nil, errors.New("jpeg scan before frame header")
+			}
+			scan, err := j.parseScanHeader(body, restart)
+			if err != nil {
+				return nil, err
+			}
+			end := entropyDataEnd(data, pos)
+			if 4*(end-pos) < j.scanBlocks(&scan) {
+				// Every block takes at least a DC and an end-of-block
+				// code, one bit each at best.
+				return nil, errors.New("jpeg scan data is truncated")
+			}
+			for _, c := range scan.comps {
+				comp := &j.Components[c]
+				if comp.Blocks == nil {
+					q := quant[j.quantSelector(c)]
+					if q == nil {
+						return nil, fmt.Errorf("jpeg component %d has no quantization table", comp.ID)
+					}
+					comp.Quant = *q
+					comp.Blocks = make([][64]int32, comp.BlocksW*comp.BlocksH)
+				}
+			}
+			if err := j.decodeScan(&scan, data[pos:end], &dc, &ac); err != nil {
+				return nil, err
+			}
+			pos = end
+			j.items = append(j.items, jpegItem{marker: marker, scan: len(j.scans)})
+			j.scans = append(j.scans, scan)
+			continue
+		}
+		j.items = append(j.items, jpegItem{marker: marker, body: body})
+	}
+}
+
+// Luma returns the luma component of a grayscale or YCbCr JPEG.
+func (j *JPEGCoefficients) Luma() (*JPEGComponent, error) {
+	switch len(j.Components) {
+	case 1:
+		return &j.Components[0], nil
+	case 3:
+		if !j.isRGB() {
+			return &j.Components[0], nil
+		}
+	}
+	return nil, errors.New("jpeg is not grayscale or YCbCr")
+}
+
+// isRGB reports whether a three-component JPEG stores RGB rather than YCbCr,
+// as flagged by an Adobe APP14 transform of 0 or by component IDs R, G, B.
+func (j *JPEGCoefficients) isRGB() bool {
+	for _, it := range j.items {
+		if it.marker == jpegAPP0+14 && len(it.body) >= 12 && bytes.HasPrefix(it.body, []byte("Adobe")) {
+			return it.body[11] == 0
+		}
+	}
+	return j.Components[0].ID == 'R' && j.Components[1].ID == 'G' && j.Components[2].ID == 'B'
+}
+
+// nextJPEGSegment reads the marker segment at pos, skipping fill bytes.
+// Markers without a body (EOI) return a nil body.
+func nextJPEGSegment(data []byte, pos int) (marker byte, body []byte, next int, err error) {
+	for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+		pos++
+	}
+	if pos+2 > len(data) {
+		return 0, nil, 0, errors.New("jpeg is truncated")
+	}
+	if data[pos] != 0xff {
+		return 0, nil, 0, fmt.Errorf("invalid jpeg marker at offset %d", pos)
+	}
+	marker = data[pos+1]
+	if marker == jpegEOI {
+		return marker, nil, pos + 2, nil
+	}
+	if pos+4 > len(data) {
+		return 0, nil, 0, errors.New("jpeg is truncated")
+	}
+	length := int(binary.BigEndian.Uint16(data[pos+2:]))
+	if length < 2 || pos+2+length > len(data) {
+		return 0, nil, 0, fmt.Errorf("jpeg segment %#x is truncated", marker)
+	}
+	return marker, data[pos+4 : pos+2+length], pos + 2 + length, nil
+}
+
+// entropyDataEnd returns where the entropy-coded data starting at pos ends:
+// the first marker other than a stuffed zero or a restart marker.
+func entropyDataEnd(data []byte, pos int) int {
+	for pos+1 < len(data) {
+		if data[pos] == 0xff {
+			m := data[pos+1]
+			if m != 0 && (m < jpegRST0 || m > jpegRST0+7) && m != 0xff {
+				return pos
+			}
+		}
+		pos++
+	}
+	return len(data)
+}
+
+func (j *JPEGCoefficients) parseFrame(body []byte) error {
+	if len(body) < 6 {
+		return errors.New("invalid jpeg frame header")
+	}
+	if body[0] != 8 {
+		return fmt.Errorf("unsupported jpeg sample precision %d", body[0])
+	}
+	j.H = int(binary.BigEndian.Uint16(body[1:]))
+	j.W = int(binary.BigEndian.Uint16(body[3:]))
+	n := int(body[5])
+	if j.W == 0 || j.H == 0 {
+		return errors.New("jpeg has no size (DNL is not supported)")
+	}
+	if err := checkPixels(j.W, j.H); err != nil {
+		return fmt.Errorf("jpeg: %w", err)
+	}
+	if n < 1 || n > 4 || len(body) != 6+3*n {
+		return errors.New("invalid jpeg frame header")
+	}
+
+	j.Components = make([]JPEGComponent, n)
+	hmax, vmax := 1, 1
+	for i := range j.Components {
+		c := body[6+3*i:]
+		h, v := int(c[1]>>4), int(c[1]&15)
+		if h < 1 || h > 4 || v < 1 || v > 4 || c[2] > 3 {
+			return errors.New("invalid jpeg frame header")
+		}
+		j.Components[i] = JPEGComponent{ID: c[0], H: h, V: v}
+		hmax, vmax = max(hmax, h), max(vmax, v)
+	}
+	mcusX := (j.W + 8*hmax - 1) / (8 * hmax)
+	mcusY := (j.H + 8*vmax - 1) / (8 * vmax)
+	for i := range j.Components {
+		c := &j.Components[i]
+		c.BlocksW = mcusX * c.H
+		c.BlocksH = mcusY * c.V
+	}
+	return nil
+}
+
+// frameBody returns the SOF segment, which quantSelector reads the table
+// selectors from.
+func (j *JPEGCoefficients) frameBody() []byte {
+	for _, it := range j.items {
+		if it.marker == jpegSOF0 || it.marker == jpegSOF1 {
+			return it.body
+		}
+	}
+	return nil
+}
+
+func (j *JPEGCoefficients) quantSelector(c int) int {
+	return int(j.frameBody()[6+3*c+2])
+}
+
+func (j *JPEGCoefficients) maxSampling() (hmax, vmax int) {
+	hmax, vmax = 1, 1
+	for _, c := range j.Components {
+		hmax, vmax = max(hmax, c.H), max(vmax, c.V)
+	}
+	return hmax, vmax
+}
+
+func parseDQT(body []byte, quant *[4]*[64]uint16) error {
+	for len(body) > 0 {
+		pq, tq := body[0]>>4, int(body[0]&15)
+		size := 64
+		if pq == 1 {
+			size = 128
+		}
+		if pq > 1 || tq > 3 || len(body) < 1+size {
+			return errors.New("invalid jpeg DQT segment")
+		}
+		var q [64]uint16
+		for k := 0; k < 64; k++ {
+			v := uint16(body[1+k])
+			if pq == 1 {
+				v = binary.BigEndian.Uint16(body[1+2*k:])
+			}
+			q[jpegZigzag[k]] = v
+		}
+		quant[tq] = &q
+		body = body[1+size:]
+	}
+	return nil
+}
+
+func parseDHT(body []byte, dc, ac *[4]*huffDecoder) error {
+	for len(body) > 0 {
+		if len(body) < 17 {
+			return errors.New("invalid jpeg DHT segment")
+		}
+		tc, th := body[0]>>4, int(body[0]&15)
+		if tc > 1 || th > 3 {
+			return errors.New("invalid jpeg DHT segment")
+		}
+		var counts [17]int
+		total := 0
+		for l := 1; l <= 16; l++ {
+			counts[l] = int(body[l])
+			total += counts[l]
+		}
+		if total > 256 || len(body) < 17+total {
+			return errors.New("invalid jpeg DHT segment")
+		}
+		t := newHuffDecoder(counts, body[17:17+total])
+		if tc == 0 {
+			dc[th] = t
+		} else {
+			ac[th] = t
+		}
+		body = body[17+total:]
+	}
+	return nil
+}
+
+func (j *JPEGCoefficients) parseScanHeader(body []byte, restart int) (jpegScan, error) {
+	if len(body) < 1 {
+		return jpegScan{}, errors.New("invalid jpeg scan header")
+	}
+	ns := int(body[0])
+	if ns < 1 || ns > 4 || len(body) != 4+2*ns {
+		return jpegScan{}, errors.New("invalid jpeg scan header")
+	}
+	tail := body[1+2*ns:]
+	if tail[0] != 0 || tail[1] != 63 || tail[2] != 0 {
+		return jpegScan{}, errors.New("invalid sequential jpeg scan header")
+	}
+
+	s := jpegScan{header: body, restart: restart}
+	for i := 0; i < ns; i++ {
+		id := body[1+2*i]
+		c := -1
+		for k, comp := range j.Components {
+			if comp.ID == id {
+				c = k
+			}
+		}
+		if c < 0 {
+			return jpegScan{}, fmt.Errorf("jpeg scan references unknown component %d", id)
+		}
+		td, ta := int(body[2+2*i]>>4), int(body[2+2*i]&15)
+		if td > 3 || ta > 3 {
+			return jpegScan{}, errors.New("invalid jpeg scan header")
+		}
+		s.comps = append(s.comps, c)
+		s.dc = append(s.dc, td)
+		s.ac = append(s.ac, ta)
+	}
+	return s, nil
+}
+
+// forEachScanBlock calls fn for every block of a scan in coding order, with
+// i the index of the scan component. restart is called before every MCU
+// that starts a new restart interval.
+// scanBlocks is the number of blocks forEachScanBlock visits for s.
+func (j *JPEGCoefficients) scanBlocks(s *jpegScan) int {
+	hmax, vmax := j.maxSampling()
+	if len(s.comps) == 1 {
+		c := &j.Components[s.comps[0]]
+		return ((j.W*c.H+hmax-1)/hmax + 7) / 8 * (((j.H*c.V+vmax-1)/vmax + 7) / 8)
+	}
+	n := 0
+	for _, ci := range s.comps {
+		n += j.Components[ci].H * j.Components[ci].V
+	}
+	return n * ((j.W + 8*hmax - 1) / (8 * hmax)) * ((j.H + 8*vmax - 1) / (8 * vmax))
+}
+
+func (j *JPEGCoefficients) forEachScanBlock(s *jpegScan, restart func(n int) error, fn func(i int, block *[64]int32) error) error {
+	hmax, vmax := j.maxSampling()
+	mcu := 0
+	next := func() error {
+		if s.restart > 0 && mcu > 0 && mcu%s.restart == 0 {
+			if err := restart(mcu/s.restart - 1); err != nil {
+				return err
+			}
+		}
+		mcu++
+		return nil
+	}
+
+	if len(s.comps) == 1 {
+		// A non-interleaved scan covers only the blocks inside the
+		// component, not the MCU padding.
+		c := &j.Components[s.comps[0]]
+		bw := ((j.W*c.H+hmax-1)/hmax + 7) / 8
+		bh := ((j.H*c.V+vmax-1)/vmax + 7) / 8
+		for by := 0; by < bh; by++ {
+			for bx := 0; bx < bw; bx++ {
+				if err := next(); err != nil {
+					return err
+				}
+				if err := fn(0, &c.Blocks[by*c.BlocksW+bx]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	mcusX := (j.W + 8*hmax - 1) / (8 * hmax)
+	mcusY := (j.H + 8*vmax - 1) / (8 * vmax)
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if err := next(); err != nil {
+				return err
+			}
+			for i, ci := range s.comps {
+				c := &j.Components[ci]
+				for v := 0; v < c.V; v++ {
+					for h := 0; h < c.H; h++ {
+						idx := (my*c.V+v)*c.BlocksW + mx*c.H + h
+						if err := fn(i, &c.Blocks[idx]); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (j *JPEGCoefficients) decodeScan(s *jpegScan, data []byte, dc, ac *[4]*huffDecoder) error {
+	for i := range s.comps {
+		if dc[s.dc[i]] == nil || ac[s.ac[i]] == nil {
+			return errors.New("jpeg scan uses an undefined Huffman table")
+		}
+	}
+
+	br := &jpegBitReader{data: data}
+	preds := make([]int32, len(s.comps))
+	restart := func(n int) error {
+		if err := br.restart(n); err != nil {
+			return err
+		}
+		clear(preds)
+		return nil
+	}
+	return j.forEachScanBlock(s, restart, func(i int, block *[64]int32) error {
+		return decodeJPEGBlock(br, block, &preds[i], dc[s.dc[i]], ac[s.ac[i]])
+	})
+}
+
+func decodeJPEGBlock(br *jpegBitReader, block *[64]int32, pred *int32, dc, ac *huffDecoder) error {
+	t, err := dc.decode(br)
+	if err != nil {
+		return err
+	}
+	if t > 11 {
+		return errors.New("invalid jpeg DC coefficient")
+	}
+	diff, err := br.receiveExtend(int(t))
+	if err != nil {
+		return err
+	}
+	*pred += diff
+	block[0] = *pred
+
+	for k := 1; k < 64; k++ {
+		rs, err := ac.decode(br)
+		if err != nil {
+			return err
+		}
+		r, s := int(rs>>4), int(rs&15)
+		if s == 0 {
+			if r != 15 {
+				return nil
+			}
+			k += 15
+			continue
+		}
+		k += r
+		if k > 63 {
+			return errors.New("invalid jpeg AC run")
+		}
+		v, err := br.receiveExtend(s)
+		if err != nil {
+			return err
+		}
+		block[jpegZigzag[k]] = v
+	}
+	return nil
+}
+
+// WriteJPEGCoefficients writes j with fresh Huffman tables computed for the
+// current coefficients; every other segment is copied as read.
+func WriteJPEGCoefficients(w io.Writer, j *JPEGCoefficients) error {
+	if w == nil {
+		return errors.New("writer is nil")
+	}
+	if j == nil {
+		return errors.New("jpeg is nil")
+	}
+
+	var out bytes.Buffer
+	out.Write([]byte{0xff, jpegSOI})
+	for _, it := range j.items {
+		if it.body == nil && it.marker == jpegSOS {
+			if err := j.writeScan(&out, &j.scans[it.scan]); err != nil {
+				return err
+			}
+			continue
+		}
+		writeJPEGSegment(&out, it.marker, it.body)
+	}
+	out.Write([]byte{0xff, jpegEOI})
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func writeJPEGSegment(out *bytes.Buffer, marker byte, body []byte) {
+	var hdr [4]byte
+	hdr[0], hdr[1] = 0xff, marker
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(body)+2))
+	out.Write(hdr[:])
+	out.Write(body)
+}
+
+func (j *JPEGCoefficients) writeScan(out *bytes.Buffer, s *jpegScan) error {
+	// First pass: symbol statistics for optimal tables.
+	var dcFreq, acFreq [4]*[257]int
+	for i := range s.comps {
+		if dcFreq[s.dc[i]] == nil {
+			dcFreq[s.dc[i]] = new([257]int)
+		}
+		if acFreq[s.ac[i]] == nil {
+			acFreq[s.ac[i]] = new([257]int)
+		}
+	}
+	preds := make([]int32, len(s.comps))
+	resetPreds := func(int) error {
+		clear(preds)
+		return nil
+	}
+	err := j.forEachScanBlock(s, resetPreds, func(i int, block *[64]int32) error {
+		return encodeJPEGBlock(block, &preds[i], func(sym byte) { dcFreq[s.dc[i]][sym]++ }, func(sym byte) { acFreq[s.ac[i]][sym]++ }, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	var dcCodes, acCodes [4]*huffEncoder
+	var dht bytes.Buffer
+	for id := 0; id < 4; id++ {
+		if dcFreq[id] != nil {
+			dcCodes[id] = optimalHuffEncoder(dcFreq[id])
+			dcCodes[id].writeDHT(&dht, 0, id)
+		}
+	}
+	for id := 0; id < 4; id++ {
+		if acFreq[id] != nil {
+			acCodes[id] = optimalHuffEncoder(acFreq[id])
+			acCodes[id].writeDHT(&dht, 1, id)
+		}
+	}
+	writeJPEGSegment(out, jpegDHT, dht.Bytes())
+	writeJPEGSegment(out, jpegSOS, s.header)
+
+	bw := &jpegBitWriter{out: out}
+	restart := func(n int) error {
+		bw.flush()
+		out.Write([]byte{0xff, jpegRST0 + byte(n&7)})
+		clear(preds)
+		return nil
+	}
+	clear(preds)
+	err = j.forEachScanBlock(s, restart, func(i int, block *[64]int32) error {
+		dc, ac := dcCodes[s.dc[i]], acCodes[s.ac[i]]
+		return encodeJPEGBlock(block, &preds[i], func(sym byte) { dc.put(bw, sym) }, func(sym byte) { ac.put(bw, sym) }, bw)
+	})
+	if err != nil {
+		return err
+	}
+	bw.flush()
+	return nil
+}
+
+// encodeJPEGBlock emits the DC and AC symbols of block and, when bw is not
+// nil, their extra bits.
+func encodeJPEGBlock(block *[64]int32, pred *int32, dcSym, acSym func(byte), bw *jpegBitWriter) error {
+	diff := block[0] - *pred
+	*pred = block[0]
+	s := magnitudeCategory(diff)
+	if s > 11 {
+		return fmt.Errorf("jpeg DC difference %d is out of range", diff)
+	}
+	dcSym(byte(s))
+	if bw != nil {
+		bw.putValue(diff, s)
+	}
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		v := block[jpegZigzag[k]]
+		if v == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			acSym(0xf0)
+			run -= 16
+		}
+		s := magnitudeCategory(v)
+		if s > 10 {
+			return fmt.Errorf("jpeg AC coefficient %d is out of range", v)
+		}
+		acSym(byte(run<<4 | s))
+		if bw != nil {
+			bw.putValue(v, s)
+		}
+		run = 0
+	}
+	if run > 0 {
+		acSym(0x00)
+	}
+	return nil
+}
+
+func magnitudeCategory(v int32) int {
+	if v < 0 {
+		v = -v
+	}
+	s := 0
+	for v > 0 {
+		s++
+		v >>= 1
+	}
+	return s
+}
@@ -0,0 +1,117 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	stdimage "image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// testJPEG encodes a w x h gradient as a baseline JPEG.
+func testJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := stdimage.NewRGBA(stdimage.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 7), G: uint8(y * 5), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withFrameSize rewrites the size in the SOF0 segment of data.
+func withFrameSize(t *testing.T, data []byte, w, h int) []byte {
+	t.Helper()
+	out := append([]byte(nil), data...)
+	i := bytes.Index(out, []byte{0xff, jpegSOF0})
+	if i < 0 {
+		t.Fatal("no SOF0 segment")
+	}
+	binary.BigEndian.PutUint16(out[i+5:], uint16(h))
+	binary.BigEndian.PutUint16(out[i+7:], uint16(w))
+	return out
+}
+
+func TestReadJPEGCoefficientsRejectsHugeFrame(t *testing.T) {
+	data := withFrameSize(t, testJPEG(t, 16, 16), 0xffff, 0xffff)
+	if _, err := ReadJPEGCoefficients(data); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("ReadJPEGCoefficients error = %v, want ErrTooLarge", err)
+	}
+	if _, _, err := DecodeImage(data); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("DecodeImage error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestReadJPEGCoefficientsShortScanDoesNotAllocateFrame(t *testing.T) {
+	// 8000x8000 is within MaxPixels; its coefficient blocks would take
+	// hundreds of MB, but the scan is only a few hundred bytes.
+	data := withFrameSize(t, testJPEG(t, 16, 16), 8000, 8000)
+	var err error
+	n := allocated(func() { _, err = ReadJPEGCoefficients(data) })
+	if err == nil {
+		t.Fatal("short scan decoded")
+	}
+	if n > 16<<20 {
+		t.Fatalf("short scan allocated %d bytes", n)
+	}
+}
+
+func TestJPEGCoefficientsRoundTrip(t *testing.T) {
+	j, err := ReadJPEGCoefficients(testJPEG(t, 40, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Change coefficients so the rewrite needs new Huffman codes, including
+	// magnitudes the original tables never saw.
+	luma, err := j.Luma()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range luma.Blocks {
+		luma.Blocks[i][0] += int32(i%5) - 2
+		luma.Blocks[i][9] = int32(i*37%201) - 100
+		luma.Blocks[i][63] = int32(i%3) - 1
+	}
+
+	var buf bytes.Buffer
+	if err := WriteJPEGCoefficients(&buf, j); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadJPEGCoefficients(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.W != j.W || got.H != j.H || len(got.Components) != len(j.Components) {
+		t.Fatalf("rewritten jpeg is %dx%d with %d components, want %dx%d with %d", got.W, got.H, len(got.Components), j.W, j.H, len(j.Components))
+	}
+	for c := range j.Components {
+		want, have := j.Components[c].Blocks, got.Components[c].Blocks
+		if len(have) != len(want) {
+			t.Fatalf("component %d has %d blocks, want %d", c, len(have), len(want))
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Fatalf("component %d block %d = %v, want %v", c, i, have[i], want[i])
+			}
+		}
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("image/jpeg cannot read the rewrite: %v", err)
+	}
+}
+
+func TestReadJPEGCoefficientsTruncated(t *testing.T) {
+	data := testJPEG(t, 40, 24)
+	for n := 0; n < len(data); n++ {
+		if _, err := ReadJPEGCoefficients(data[:n]); err == nil {
+			t.Fatalf("decoding %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var errJPEGHuffman = errors.New("invalid jpeg Huffman code")
+
+// huffDecoder decodes one Huffman table the canonical way (JPEG Annex F.2):
+// for each code length the smallest and largest code and where its values
+// start.
+type huffDecoder struct {
+	values  []byte
+	mincode [17]int32
+	maxcode [17]int32
+	valptr  [17]int
+}
+
+func newHuffDecoder(counts [17]int, values []byte) *huffDecoder {
+	d := &huffDecoder{values: append([]byte(nil), values...)}
+	code := int32(0)
+	k := 0
+	for l := 1; l <= 16; l++ {
+		d.valptr[l] = k
+		d.mincode[l] = code
+		code += int32(counts[l])
+		k += counts[l]
+		d.maxcode[l] = code - 1
+		if counts[l] == 0 {
+			d.maxcode[l] = -1
+		}
+		code <<= 1
+	}
+	return d
+}
+
+func (d *huffDecoder) decode(br *jpegBitReader) (byte, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		b, err := br.bit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | int32(b)
+		if code <= d.maxcode[l] {
+			i := d.valptr[l] + int(code-d.mincode[l])
+			if i >= len(d.values) {
+				return 0, errJPEGHuffman
+			}
+			return d.values[i], nil
+		}
+	}
+	return 0, errJPEGHuffman
+}
+
+// jpegBitReader reads entropy-coded data, removing stuffed zero bytes. Past
+// a marker it reads zero bits, as decoders do for a short final segment.
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  byte
+	n    int
+}
+
+func (r *jpegBitReader) bit() (int, error) {
+	if r.n == 0 {
+		switch {
+		case r.pos >= len(r.data):
+			r.acc = 0
+		case r.data[r.pos] == 0xff && r.pos+1 < len(r.data) && r.data[r.pos+1] == 0:
+			r.acc = 0xff
+			r.pos += 2
+		case r.data[r.pos] == 0xff:
+			// A marker: leave it for restart().
+			r.acc = 0
+		default:
+			r.acc = r.data[r.pos]
+			r.pos++
+		}
+		r.n = 8
+	}
+	r.n--
+	return int(r.acc>>r.n) & 1, nil
+}
+
+func (r *jpegBitReader) receiveExtend(s int) (int32, error) {
+	if s == 0 {
+		return 0, nil
+	}
+	v := int32(0)
+	for i := 0; i < s; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | int32(b)
+	}
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, nil
+}
+
+// restart discards the remaining bits of the byte and consumes restart
+// marker n (mod 8).
+func (r *jpegBitReader) restart(n int) error {
+	r.n = 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xff || r.data[r.pos+1] != jpegRST0+byte(n&7) {
+		return fmt.Errorf("jpeg restart marker %d is missing", n&7)
+	}
+	r.pos += 2
+	return nil
+}
+
+type jpegBitWriter struct {
+	out *bytes.Buffer
+	acc uint32
+	n   int
+}
+
+func (w *jpegBitWriter) putBits(v uint32, n int) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.out.WriteByte(b)
+		if b == 0xff {
+			w.out.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+// putValue writes the s low bits JPEG uses for a coefficient of category s:
+// v itself when positive, v-1 in two's complement when negative.
+func (w *jpegBitWriter) putValue(v int32, s int) {
+	if s == 0 {
+		return
+	}
+	if v < 0 {
+		v--
+	}
+	w.putBits(uint32(v), s)
+}
+
+// flush pads the last byte with one bits.
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.putBits(1<<(8-w.n)-1, 8-w.n)
+	}
+	w.acc = 0
+}
+
+type huffEncoder struct {
+	counts [17]int
+	values []byte
+	code   [256]uint16
+	size   [256]int
+}
+
+func (e *huffEncoder) put(w *jpegBitWriter, sym byte) {
+	w.putBits(uint32(e.code[sym]), e.size[sym])
+}
+
+func (e *huffEncoder) writeDHT(out *bytes.Buffer, class, id int) {
+	out.WriteByte(byte(class<<4 | id))
+	for l := 1; l <= 16; l++ {
+		out.WriteByte(byte(e.counts[l]))
+	}
+	out.Write(e.values)
+}
+
+// optimalHuffEncoder builds the code lengths for freq as in JPEG Annex K.2,
+// limited to 16 bits and never assigning the all-ones code. freq is used as
+// scratch space.
+func optimalHuffEncoder(freq *[257]int) *huffEncoder {
+	used := false
+	for _, f := range freq[:256] {
+		used = used || f > 0
+	}
+	if !used {
+		freq[0] = 1
+	}
+	// A reserved symbol keeps every real code from being all ones.
+	freq[256] = 1
+
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		c1, c2 := -1, -1
+		for i, f := range freq {
+			if f > 0 && (c1 < 0 || f <= freq[c1]) {
+				c1 = i
+			}
+		}
+		for i, f := range freq {
+			if f > 0 && i != c1 && (c2 < 0 || f <= freq[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		freq[c1] += freq[c2]
+		freq[c2] = 0
+		codesize[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			codesize[c1]++
+		}
+		others[c1] = c2
+		codesize[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			codesize[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, s := range codesize {
+		if s > 0 {
+			bits[s]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	e := &huffEncoder{}
+	copy(e.counts[1:], bits[1:17])
+	for l := 1; l <= 32; l++ {
+		for sym := 0; sym < 256; sym++ {
+			if codesize[sym] == l {
+				e.values = append(e.values, byte(sym))
+			}
+		}
+	}
+
+	code := uint16(0)
+	k := 0
+	for l := 1; l <= 16; l++ {
+		for n := 0; n < e.counts[l]; n++ {
+			sym := e.values[k]
+			e.code[sym] = code
+			e.size[sym] = l
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return e
+}
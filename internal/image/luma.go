@@ -0,0 +1,79 @@
+package image
+
+// LumaToFloat widens an 8-bit Y plane for the float embedder.
+func LumaToFloat(y []uint8) []float32 {
+	out := make([]float32, len(y))
+	for i, v := range y {
+		out[i] = float32(v)
+	}
+	return out
+}
+
+// LumaFromFloat rounds and clamps a float Y plane back to 8 bits.
+func LumaFromFloat(y []float32) []uint8 {
+	out := make([]uint8, len(y))
+	for i, v := range y {
+		out[i] = clampFloatToUint8(v)
+	}
+	return out
+}
+
+// LumaToFixed widens an 8-bit Y plane to the Q4 fixed-point scale.
+func LumaToFixed(y []uint8) []int32 {
+	out := make([]int32, len(y))
+	for i, v := range y {
+		out[i] = int32(v) << FixedFracBits
+	}
+	return out
+}
+
+func LumaFromFixed(y []int32) []uint8 {
+	out := make([]uint8, len(y))
+	for i, v := range y {
+		out[i] = clampFixedToUint8(int64(v))
+	}
+	return out
+}
+
+// QuantizeSample is the value an 8-bit Y plane stores for v.
+func QuantizeSample(v float32) float32 {
+	return float32(clampFloatToUint8(v))
+}
+
+// QuantizeSampleFixed is QuantizeSample for Q4 values.
+func QuantizeSampleFixed(v int32) int32 {
+	return int32(clampFixedToUint8(int64(v))) << FixedFracBits
+}
+
+// Luma16ToFloat maps a 16-bit luma plane onto the 8-bit scale the embedder
+// works in, so strength and margins mean the same as for 8-bit input.
+func Luma16ToFloat(y []uint16) []float32 {
+	out := make([]float32, len(y))
+	for i, v := range y {
+		out[i] = float32(v) / 257
+	}
+	return out
+}
+
+func Luma16FromFloat(y []float32) []uint16 {
+	out := make([]uint16, len(y))
+	for i, v := range y {
+		out[i] = clampFloatToUint16(v * 257)
+	}
+	return out
+}
+
+// QuantizeSample16 is QuantizeSample for a 16-bit plane, on the 8-bit scale.
+func QuantizeSample16(v float32) float32 {
+	return float32(clampFloatToUint16(v*257)) / 257
+}
+
+func clampFloatToUint16(v float32) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 65535 {
+		return 65535
+	}
+	return uint16(v + 0.5)
+}
@@ -0,0 +1,325 @@
+package image
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Metadata is the non-pixel data of a JPEG or PNG file that survives
+// embedding: the EXIF block, the ICC colour profile and PNG text chunks.
+type Metadata struct {
+	// EXIF is the TIFF-structured EXIF data, without the "Exif\0\0" prefix
+	// JPEG puts in front of it.
+	EXIF []byte
+	// ICC is the uncompressed ICC profile.
+	ICC []byte
+	// Text holds PNG tEXt, zTXt and iTXt chunks verbatim.
+	Text []PNGChunk
+}
+
+type PNGChunk struct {
+	Type string
+	Data []byte
+}
+
+const (
+	pngSignature    = "\x89PNG\r\n\x1a\n"
+	jpegExifPrefix  = "Exif\x00\x00"
+	jpegICCPrefix   = "ICC_PROFILE\x00"
+	maxICCProfile   = 16 << 20
+	iccProfileName  = "ICC Profile"
+	exifOrientation = 0x0112
+	// maxJPEGSegment is the largest body a JPEG marker segment can hold.
+	maxJPEGSegment = 65533
+)
+
+func (m *Metadata) Empty() bool {
+	return m == nil || (len(m.EXIF) == 0 && len(m.ICC) == 0 && len(m.Text) == 0)
+}
+
+// ReadMetadata extracts metadata from an encoded JPEG or PNG. Other formats
+// have none and return an empty Metadata.
+func ReadMetadata(data []byte) (*Metadata, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return readPNGMetadata(data)
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return readJPEGMetadata(data)
+	}
+	return &Metadata{}, nil
+}
+
+func readPNGMetadata(data []byte) (*Metadata, error) {
+	m := &Metadata{}
+	err := forEachPNGChunk(data, func(typ string, body []byte) error {
+		switch typ {
+		case "eXIf":
+			m.EXIF = append([]byte(nil), body...)
+		case "iCCP":
+			icc, err := decodeICCPChunk(body)
+			if err != nil {
+				return err
+			}
+			m.ICC = icc
+		case "tEXt", "zTXt", "iTXt":
+			m.Text = append(m.Text, PNGChunk{Type: typ, Data: append([]byte(nil), body...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// forEachPNGChunk calls fn for every chunk after the signature, up to IEND.
+func forEachPNGChunk(data []byte, fn func(typ string, body []byte) error) error {
+	pos := len(pngSignature)
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		end := pos + 8 + length + 4
+		if length < 0 || end > len(data) || end < pos {
+			return fmt.Errorf("png chunk %q is truncated", typ)
+		}
+		if err := fn(typ, data[pos+8:pos+8+length]); err != nil {
+			return err
+		}
+		if typ == "IEND" {
+			return nil
+		}
+		pos = end
+	}
+	return errors.New("png has no IEND chunk")
+}
+
+func decodeICCPChunk(body []byte) ([]byte, error) {
+	nul := bytes.IndexByte(body, 0)
+	if nul < 0 || nul+2 > len(body) || body[nul+1] != 0 {
+		return nil, errors.New("invalid png iCCP chunk")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(body[nul+2:]))
+	if err != nil {
+		return nil, fmt.Errorf("png iCCP chunk: %w", err)
+	}
+	defer zr.Close()
+
+	icc, err := io.ReadAll(io.LimitReader(zr, maxICCProfile+1))
+	if err != nil {
+		return nil, fmt.Errorf("png iCCP chunk: %w", err)
+	}
+	if len(icc) > maxICCProfile {
+		return nil, errors.New("png ICC profile is too large")
+	}
+	return icc, nil
+}
+
+func readJPEGMetadata(data []byte) (*Metadata, error) {
+	m := &Metadata{}
+	type iccPart struct {
+		seq  int
+		data []byte
+	}
+	var iccParts []iccPart
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return nil, fmt.Errorf("invalid jpeg marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// Fill byte.
+			pos++
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			// Start of scan: all metadata segments come before it.
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("jpeg segment %#x is truncated", marker)
+		}
+		body := data[pos+4 : pos+2+length]
+
+		switch {
+		case marker == 0xe1 && bytes.HasPrefix(body, []byte(jpegExifPrefix)) && m.EXIF == nil:
+			m.EXIF = append([]byte(nil), body[len(jpegExifPrefix):]...)
+		case marker == 0xe2 && bytes.HasPrefix(body, []byte(jpegICCPrefix)) && len(body) >= len(jpegICCPrefix)+2:
+			iccParts = append(iccParts, iccPart{
+				seq:  int(body[len(jpegICCPrefix)]),
+				data: body[len(jpegICCPrefix)+2:],
+			})
+		}
+		pos += 2 + length
+	}
+
+	// ICC profiles larger than one segment are split across APP2 segments
+	// numbered from 1.
+	sort.SliceStable(iccParts, func(i, j int) bool { return iccParts[i].seq < iccParts[j].seq })
+	for _, p := range iccParts {
+		m.ICC = append(m.ICC, p.data...)
+	}
+	return m, nil
+}
+
+// Orientation returns the EXIF orientation (1..8), or 1 when there is none.
+func (m *Metadata) Orientation() int {
+	if m == nil {
+		return 1
+	}
+	return ExifOrientation(m.EXIF)
+}
+
+// ExifOrientation reads the orientation tag from IFD0 of a TIFF-structured
+// EXIF block. Missing or invalid values read as 1 (as stored).
+func ExifOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 1
+	}
+	count := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(exif) {
+			return 1
+		}
+		if order.Uint16(exif[entry:]) != exifOrientation {
+			continue
+		}
+		// SHORT, count 1, value left-justified in the offset field.
+		if order.Uint16(exif[entry+2:]) != 3 || order.Uint32(exif[entry+4:]) != 1 {
+			return 1
+		}
+		if o := int(order.Uint16(exif[entry+8:])); o >= 1 && o <= 8 {
+			return o
+		}
+		return 1
+	}
+	return 1
+}
+
+// AttachPNGMetadata inserts m into an encoded PNG that has none: the ICC
+// profile and EXIF right after IHDR, where they must precede the image
+// data, and the text chunks after them.
+func AttachPNGMetadata(data []byte, m *Metadata) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, errors.New("not a png")
+	}
+	if m.Empty() {
+		return data, nil
+	}
+
+	sig := len(pngSignature)
+	if len(data) < sig+8 || string(data[sig+4:sig+8]) != "IHDR" {
+		return nil, errors.New("png does not start with IHDR")
+	}
+	ihdrEnd := sig + 8 + int(binary.BigEndian.Uint32(data[sig:])) + 4
+	if ihdrEnd > len(data) {
+		return nil, errors.New("png IHDR chunk is truncated")
+	}
+
+	var chunks bytes.Buffer
+	if len(m.ICC) > 0 {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		if _, err := zw.Write(m.ICC); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		body := append([]byte(iccProfileName+"\x00\x00"), z.Bytes()...)
+		writePNGChunk(&chunks, "iCCP", body)
+	}
+	if len(m.EXIF) > 0 {
+		writePNGChunk(&chunks, "eXIf", m.EXIF)
+	}
+	for _, c := range m.Text {
+		writePNGChunk(&chunks, c.Type, c.Data)
+	}
+
+	out := make([]byte, 0, len(data)+chunks.Len())
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunks.Bytes()...)
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, body []byte) {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(body)))
+	copy(hdr[4:], typ)
+	w.Write(hdr[:])
+	w.Write(body)
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(body)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+// AttachJPEGMetadata inserts the EXIF and ICC profile of m into an encoded
+// JPEG that has none, after SOI and any JFIF header. JPEG has no place for
+// PNG text chunks, so they are dropped.
+func AttachJPEGMetadata(data []byte, m *Metadata) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != jpegSOI {
+		return nil, errors.New("not a jpeg")
+	}
+	if m == nil || (len(m.EXIF) == 0 && len(m.ICC) == 0) {
+		return data, nil
+	}
+
+	pos := 2
+	if marker, _, next, err := nextJPEGSegment(data, pos); err == nil && marker == jpegAPP0 {
+		pos = next
+	}
+
+	var segs bytes.Buffer
+	if len(m.EXIF) > 0 {
+		body := append([]byte(jpegExifPrefix), m.EXIF...)
+		if len(body) > maxJPEGSegment {
+			return nil, errors.New("exif data is too large for a jpeg segment")
+		}
+		writeJPEGSegment(&segs, jpegAPP0+1, body)
+	}
+	if len(m.ICC) > 0 {
+		// Profiles are split over numbered APP2 segments.
+		chunk := maxJPEGSegment - len(jpegICCPrefix) - 2
+		count := (len(m.ICC) + chunk - 1) / chunk
+		if count > 255 {
+			return nil, errors.New("icc profile is too large for a jpeg")
+		}
+		for i := 0; i < count; i++ {
+			part := m.ICC[i*chunk : min((i+1)*chunk, len(m.ICC))]
+			body := append([]byte(jpegICCPrefix), byte(i+1), byte(count))
+			writeJPEGSegment(&segs, jpegAPP0+2, append(body, part...))
+		}
+	}
+
+	out := make([]byte, 0, len(data)+segs.Len())
+	out = append(out, data[:pos]...)
+	out = append(out, segs.Bytes()...)
+	return append(out, data[pos:]...), nil
+}
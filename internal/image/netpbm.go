@@ -0,0 +1,302 @@
+package image
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const netpbmMaxVal16 = 65535
+
+// Netpbm is a PGM or PPM image at its native sample depth. Samples are
+// row-major with Channels values per pixel (1 for PGM, 3 for PPM); a MaxVal
+// above 255 means two bytes per sample in the binary formats.
+type Netpbm struct {
+	W        int
+	H        int
+	Channels int
+	MaxVal   int
+	// ASCII selects the plain formats (P2, P3) instead of binary (P5, P6).
+	ASCII   bool
+	Samples []uint16
+}
+
+// ReadNetpbm reads a P2, P3, P5 or P6 image with any maxval up to 65535.
+func ReadNetpbm(src io.Reader) (*Netpbm, error) {
+	if src == nil {
+		return nil, errors.New("reader is nil")
+	}
+	r := bufio.NewReader(src)
+
+	magic, err := readRequiredToken(r, "magic")
+	if err != nil {
+		return nil, err
+	}
+	p := &Netpbm{}
+	switch magic {
+	case "P2":
+		p.Channels, p.ASCII = 1, true
+	case "P3":
+		p.Channels, p.ASCII = 3, true
+	case "P5":
+		p.Channels = 1
+	case "P6":
+		p.Channels = 3
+	default:
+		return nil, fmt.Errorf("unsupported magic %q (expected P2, P3, P5 or P6)", magic)
+	}
+
+	widthToken, err := readRequiredToken(r, "width")
+	if err != nil {
+		return nil, err
+	}
+	heightToken, err := readRequiredToken(r, "height")
+	if err != nil {
+		return nil, err
+	}
+	maxValToken, err := readRequiredToken(r, "maxval")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.W, err = parsePositiveInt("width", widthToken); err != nil {
+		return nil, err
+	}
+	if p.H, err = parsePositiveInt("height", heightToken); err != nil {
+		return nil, err
+	}
+	p.MaxVal, err = strconv.Atoi(maxValToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid maxval %q", maxValToken)
+	}
+	if p.MaxVal < 1 || p.MaxVal > netpbmMaxVal16 {
+		return nil, fmt.Errorf("unsupported maxval %d (expected 1..65535)", p.MaxVal)
+	}
+
+	if err := checkPixels(p.W, p.H); err != nil {
+		return nil, err
+	}
+	sampleCount, byteCount, err := checkedSampleSizes(p.W, p.H, p.Channels, p.bytesPerSample())
+	if err != nil {
+		return nil, err
+	}
+
+	// Samples are only allocated as the data turns up, so a header that
+	// declares more than the file holds fails without the full buffer.
+	if p.ASCII {
+		err = p.readPlainSamples(r, sampleCount)
+	} else {
+		err = p.readRawSamples(r, sampleCount, byteCount)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Netpbm) bytesPerSample() int {
+	if p.MaxVal > 255 {
+		return 2
+	}
+	return 1
+}
+
+func (p *Netpbm) readRawSamples(r io.Reader, sampleCount, byteCount int) error {
+	raw, err := readSized(r, byteCount)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("pixel data is truncated")
+		}
+		return fmt.Errorf("read pixel data: %w", err)
+	}
+
+	p.Samples = make([]uint16, sampleCount)
+	wide := p.bytesPerSample() == 2
+	for i := range p.Samples {
+		v := uint16(raw[i])
+		if wide {
+			v = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+		}
+		if int(v) > p.MaxVal {
+			return fmt.Errorf("sample %d exceeds maxval %d", v, p.MaxVal)
+		}
+		p.Samples[i] = v
+	}
+	return nil
+}
+
+func (p *Netpbm) readPlainSamples(r *bufio.Reader, sampleCount int) error {
+	for len(p.Samples) < sampleCount {
+		tok, err := readHeaderToken(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("pixel data is truncated")
+			}
+			return fmt.Errorf("read pixel data: %w", err)
+		}
+		v, err := strconv.Atoi(tok)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid sample %q", tok)
+		}
+		if v > p.MaxVal {
+			return fmt.Errorf("sample %d exceeds maxval %d", v, p.MaxVal)
+		}
+		p.Samples = append(p.Samples, uint16(v))
+	}
+	return nil
+}
+
+func WriteNetpbm(dst io.Writer, p *Netpbm) error {
+	if dst == nil {
+		return errors.New("writer is nil")
+	}
+	if err := p.check(); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(dst)
+	if _, err := fmt.Fprintf(w, "%s\n%d %d\n%d\n", p.magic(), p.W, p.H, p.MaxVal); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	var err error
+	if p.ASCII {
+		err = p.writePlainSamples(w)
+	} else {
+		err = p.writeRawSamples(w)
+	}
+	if err != nil {
+		return fmt.Errorf("write pixel data: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+func (p *Netpbm) magic() string {
+	switch {
+	case p.Channels == 1 && p.ASCII:
+		return "P2"
+	case p.Channels == 1:
+		return "P5"
+	case p.ASCII:
+		return "P3"
+	}
+	return "P6"
+}
+
+func (p *Netpbm) check() error {
+	if p == nil {
+		return errors.New("image is nil")
+	}
+	if p.Channels != 1 && p.Channels != 3 {
+		return fmt.Errorf("unsupported channel count %d", p.Channels)
+	}
+	if p.MaxVal < 1 || p.MaxVal > netpbmMaxVal16 {
+		return fmt.Errorf("unsupported maxval %d", p.MaxVal)
+	}
+	sampleCount, _, err := checkedSampleSizes(p.W, p.H, p.Channels, p.bytesPerSample())
+	if err != nil {
+		return err
+	}
+	if len(p.Samples) != sampleCount {
+		return fmt.Errorf("sample buffer length %d does not match dimensions %dx%dx%d", len(p.Samples), p.W, p.H, p.Channels)
+	}
+	return nil
+}
+
+func (p *Netpbm) writeRawSamples(w *bufio.Writer) error {
+	raw := make([]byte, len(p.Samples)*p.bytesPerSample())
+	if p.bytesPerSample() == 2 {
+		for i, v := range p.Samples {
+			raw[2*i] = byte(v >> 8)
+			raw[2*i+1] = byte(v)
+		}
+	} else {
+		for i, v := range p.Samples {
+			raw[i] = byte(v)
+		}
+	}
+	_, err := w.Write(raw)
+	return err
+}
+
+// writePlainSamples writes one image row per line, wrapped so no line is
+// longer than the 70 characters the plain formats allow.
+func (p *Netpbm) writePlainSamples(w *bufio.Writer) error {
+	const maxLine = 70
+	rowLen := p.W * p.Channels
+	var buf []byte
+	for row := 0; row < p.H; row++ {
+		lineLen := 0
+		for _, v := range p.Samples[row*rowLen : (row+1)*rowLen] {
+			buf = strconv.AppendUint(buf[:0], uint64(v), 10)
+			if lineLen > 0 && lineLen+1+len(buf) > maxLine {
+				if err := w.WriteByte('\n'); err != nil {
+					return err
+				}
+				lineLen = 0
+			}
+			if lineLen > 0 {
+				if err := w.WriteByte(' '); err != nil {
+					return err
+				}
+				lineLen++
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			lineLen += len(buf)
+		}
+		if err := w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Image converts p to 8-bit RGB, rescaling samples from MaxVal to 255 and
+// replicating gray into all three channels.
+func (p *Netpbm) Image() *Image {
+	pix := make([]Rgb, p.W*p.H)
+	for i := range pix {
+		if p.Channels == 1 {
+			g := ScaleSample(p.Samples[i], p.MaxVal, 255)
+			pix[i] = Rgb{R: uint8(g), G: uint8(g), B: uint8(g)}
+			continue
+		}
+		pix[i] = Rgb{
+			R: uint8(ScaleSample(p.Samples[3*i], p.MaxVal, 255)),
+			G: uint8(ScaleSample(p.Samples[3*i+1], p.MaxVal, 255)),
+			B: uint8(ScaleSample(p.Samples[3*i+2], p.MaxVal, 255)),
+		}
+	}
+	return &Image{W: p.W, H: p.H, Pix: pix}
+}
+
+// ScaleSample rescales v from [0, from] to [0, to], rounding to nearest.
+func ScaleSample(v uint16, from, to int) uint16 {
+	if from == to {
+		return v
+	}
+	return uint16((uint32(v)*uint32(to) + uint32(from)/2) / uint32(from))
+}
+
+// checkedSampleSizes is checkedImageSizes for any channel count and sample
+// width.
+func checkedSampleSizes(w, h, channels, bytesPerSample int) (sampleCount int, byteCount int, err error) {
+	pixelCount, _, err := checkedImageSizes(w, h)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	maxInt := int(^uint(0) >> 1)
+	if pixelCount > maxInt/(channels*bytesPerSample) {
+		return 0, 0, fmt.Errorf("pixel data size overflows for %dx%d", w, h)
+	}
+	sampleCount = pixelCount * channels
+	return sampleCount, sampleCount * bytesPerSample, nil
+}
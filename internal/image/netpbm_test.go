@@ -0,0 +1,59 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadNetpbmRejectsHugeHeader(t *testing.T) {
+	for _, magic := range []string{"P2", "P3", "P5", "P6"} {
+		data := []byte(magic + "\n65535 65535\n255\n\x00\x00\x00")
+		if _, err := ReadNetpbm(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%s: ReadNetpbm error = %v, want ErrTooLarge", magic, err)
+		}
+		if _, _, err := DecodeImage(data); !errors.Is(err, ErrTooLarge) {
+			t.Fatalf("%s: DecodeImage error = %v, want ErrTooLarge", magic, err)
+		}
+	}
+}
+
+func TestReadNetpbmTruncatedDoesNotAllocateDeclaredSize(t *testing.T) {
+	// 8000x8000 16-bit RGB is within MaxPixels and declares 384 MB.
+	for _, magic := range []string{"P3", "P6"} {
+		data := []byte(magic + "\n8000 8000\n65535\n1 2 3 4")
+		var err error
+		n := allocated(func() { _, err = ReadNetpbm(bytes.NewReader(data)) })
+		if err == nil {
+			t.Fatalf("%s: truncated image decoded", magic)
+		}
+		if n > 16<<20 {
+			t.Fatalf("%s: truncated image allocated %d bytes", magic, n)
+		}
+	}
+}
+
+func TestNetpbmRoundTrip(t *testing.T) {
+	for _, want := range []*Netpbm{
+		{W: 3, H: 2, Channels: 1, MaxVal: 255, Samples: []uint16{0, 1, 2, 253, 254, 255}},
+		{W: 2, H: 1, Channels: 3, MaxVal: 65535, Samples: []uint16{0, 1, 256, 65534, 65535, 4096}},
+		{W: 2, H: 1, Channels: 3, MaxVal: 1000, ASCII: true, Samples: []uint16{0, 1, 999, 1000, 500, 7}},
+	} {
+		var buf bytes.Buffer
+		if err := WriteNetpbm(&buf, want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadNetpbm(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.W != want.W || got.H != want.H || got.Channels != want.Channels || got.MaxVal != want.MaxVal || got.ASCII != want.ASCII {
+			t.Fatalf("header %+v, want %+v", got, want)
+		}
+		for i := range want.Samples {
+			if got.Samples[i] != want.Samples[i] {
+				t.Fatalf("sample %d = %d, want %d", i, got.Samples[i], want.Samples[i])
+			}
+		}
+	}
+}
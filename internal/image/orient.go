@@ -0,0 +1,126 @@
+package image
+
+import (
+	stdimage "image"
+	"image/draw"
+)
+
+// Orient returns src as it is meant to be displayed under EXIF orientation o:
+// 2-4 mirror or rotate by 180 degrees, 5-8 also swap width and height. The
+// result has the same pixel type as src where that type can be written, and
+// *YCbCr stays *YCbCr; orientation 1 and invalid values return src
+// unchanged.
+func Orient(src stdimage.Image, o int) stdimage.Image {
+	if o < 2 || o > 8 {
+		return src
+	}
+	if s, ok := src.(*stdimage.YCbCr); ok {
+		return orientYCbCr(s, o)
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := newImageLike(src, stdimage.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := OrientedSource(o, x, y, w, h)
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// Unorient undoes Orient(src, o), returning the image as it is stored.
+func Unorient(src stdimage.Image, o int) stdimage.Image {
+	switch o {
+	case 6:
+		return Orient(src, 8)
+	case 8:
+		return Orient(src, 6)
+	}
+	// Every other orientation is its own inverse.
+	return Orient(src, o)
+}
+
+// OrientedSource maps display pixel (x, y) to the stored pixel of a w x h
+// image under orientation o.
+func OrientedSource(o, x, y, w, h int) (sx, sy int) {
+	switch o {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, h - 1 - x
+	case 7:
+		return w - 1 - y, h - 1 - x
+	case 8:
+		return w - 1 - y, x
+	}
+	return x, y
+}
+
+// orientYCbCr is Orient for *YCbCr, which has no Set: it moves the Y, Cb
+// and Cr samples themselves, so a JPEG's pixels are not converted through
+// RGB. A quarter turn swaps the subsampling axes, so 4:2:2 becomes 4:4:0;
+// 4:1:1 and 4:1:0 become 4:4:4.
+// Each chroma sample is taken from under a luma pixel it covers, which is
+// exact when the dimensions are multiples of the subsampling factors.
+func orientYCbCr(src *stdimage.YCbCr, o int) *stdimage.YCbCr {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh, ratio := w, h, src.SubsampleRatio
+	if o >= 5 {
+		dw, dh = h, w
+		switch ratio {
+		case stdimage.YCbCrSubsampleRatio420, stdimage.YCbCrSubsampleRatio444:
+		case stdimage.YCbCrSubsampleRatio422:
+			ratio = stdimage.YCbCrSubsampleRatio440
+		case stdimage.YCbCrSubsampleRatio440:
+			ratio = stdimage.YCbCrSubsampleRatio422
+		default:
+			// 4:1:1 and 4:1:0 have no transposed ratio.
+			ratio = stdimage.YCbCrSubsampleRatio444
+		}
+	}
+
+	dst := stdimage.NewYCbCr(stdimage.Rect(0, 0, dw, dh), ratio)
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := OrientedSource(o, x, y, w, h)
+			sx, sy = b.Min.X+sx, b.Min.Y+sy
+			dst.Y[dst.YOffset(x, y)] = src.Y[src.YOffset(sx, sy)]
+			ci, si := dst.COffset(x, y), src.COffset(sx, sy)
+			dst.Cb[ci] = src.Cb[si]
+			dst.Cr[ci] = src.Cr[si]
+		}
+	}
+	return dst
+}
+
+func newImageLike(src stdimage.Image, r stdimage.Rectangle) draw.Image {
+	switch s := src.(type) {
+	case *stdimage.Gray:
+		return stdimage.NewGray(r)
+	case *stdimage.Gray16:
+		return stdimage.NewGray16(r)
+	case *stdimage.RGBA:
+		return stdimage.NewRGBA(r)
+	case *stdimage.RGBA64:
+		return stdimage.NewRGBA64(r)
+	case *stdimage.NRGBA64:
+		return stdimage.NewNRGBA64(r)
+	case *stdimage.Paletted:
+		return stdimage.NewPaletted(r, s.Palette)
+	}
+	return stdimage.NewNRGBA(r)
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	stdimage "image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func testYCbCr(r stdimage.Rectangle, ratio stdimage.YCbCrSubsampleRatio) *stdimage.YCbCr {
+	img := stdimage.NewYCbCr(r, ratio)
+	for i := range img.Y {
+		img.Y[i] = uint8(i * 13)
+	}
+	for i := range img.Cb {
+		img.Cb[i] = uint8(i * 7)
+		img.Cr[i] = uint8(255 - i*11)
+	}
+	return img
+}
+
+// TestOrientYCbCr checks that *YCbCr is oriented plane by plane to the same
+// colours Orient gives through RGB, and that Unorient restores the planes.
+func TestOrientYCbCr(t *testing.T) {
+	ratios := []stdimage.YCbCrSubsampleRatio{
+		stdimage.YCbCrSubsampleRatio444,
+		stdimage.YCbCrSubsampleRatio422,
+		stdimage.YCbCrSubsampleRatio420,
+		stdimage.YCbCrSubsampleRatio440,
+		stdimage.YCbCrSubsampleRatio411,
+		stdimage.YCbCrSubsampleRatio410,
+	}
+	for _, ratio := range ratios {
+		src := testYCbCr(stdimage.Rect(0, 0, 16, 12), ratio)
+		for o := 1; o <= 8; o++ {
+			got, ok := Orient(src, o).(*stdimage.YCbCr)
+			if !ok {
+				t.Fatalf("%v orientation %d: result is %T", ratio, o, Orient(src, o))
+			}
+			want := Orient(genericImage{src}, o)
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("%v orientation %d: bounds %v, want %v", ratio, o, got.Bounds(), want.Bounds())
+			}
+			b := got.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if g, w := color.NRGBAModel.Convert(got.At(x, y)), color.NRGBAModel.Convert(want.At(x, y)); g != w {
+						t.Fatalf("%v orientation %d: (%d,%d) = %v, want %v", ratio, o, x, y, g, w)
+					}
+				}
+			}
+
+			back := Unorient(got, o).(*stdimage.YCbCr)
+			if back.SubsampleRatio != ratio {
+				// Widened to 4:4:4 by a quarter turn.
+				continue
+			}
+			if !reflect.DeepEqual(back.Y, src.Y) || !reflect.DeepEqual(back.Cb, src.Cb) || !reflect.DeepEqual(back.Cr, src.Cr) {
+				t.Errorf("%v orientation %d: Unorient does not restore the planes", ratio, o)
+			}
+		}
+	}
+
+	// Odd sizes and a non-zero origin must stay in bounds.
+	odd := testYCbCr(stdimage.Rect(0, 0, 15, 11), stdimage.YCbCrSubsampleRatio420).SubImage(stdimage.Rect(2, 1, 15, 11))
+	for o := 1; o <= 8; o++ {
+		if got := Unorient(Orient(odd, o), o); got.Bounds().Size() != odd.Bounds().Size() {
+			t.Errorf("odd orientation %d: size %v", o, got.Bounds().Size())
+		}
+	}
+}
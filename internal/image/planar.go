@@ -0,0 +1,152 @@
+package image
+
+import (
+	"fmt"
+	stdimage "image"
+)
+
+// Planar is an image as full-resolution Y, Cb and Cr float planes on the
+// 8-bit scale, the form the watermark works in. Samples are not rounded, so
+// stages can pass a Planar along and quantize to RGB only when the image is
+// stored. Pixel (x, y) is at index y*Stride+x of every plane, Alpha included.
+type Planar struct {
+	W, H   int
+	Stride int
+	Y      []float32
+	Cb     []float32
+	Cr     []float32
+	// Alpha is straight alpha as in Image, or nil for an opaque image.
+	Alpha []uint8
+}
+
+func NewPlanar(w, h int) (*Planar, error) {
+	pixelCount, _, err := checkedImageSizes(w, h)
+	if err != nil {
+		return nil, err
+	}
+	return &Planar{
+		W:      w,
+		H:      h,
+		Stride: w,
+		Y:      make([]float32, pixelCount),
+		Cb:     make([]float32, pixelCount),
+		Cr:     make([]float32, pixelCount),
+	}, nil
+}
+
+// PlanarFromImage converts img to Y/Cb/Cr as RGBToYCbCr does.
+func PlanarFromImage(img *Image) *Planar {
+	if img == nil {
+		return &Planar{}
+	}
+	y, cb, cr := RGBToYCbCr(img)
+	return &Planar{W: img.W, H: img.H, Stride: img.W, Y: y, Cb: cb, Cr: cr, Alpha: cloneAlpha8(img.Alpha)}
+}
+
+// PlanarFromStd converts src. *image.YCbCr is read from its planes, with
+// chroma repeated over the pixels each sample covers.
+func PlanarFromStd(src stdimage.Image) *Planar {
+	s, ok := src.(*stdimage.YCbCr)
+	if !ok {
+		return PlanarFromImage(FromStdImage(src))
+	}
+
+	b := s.Bounds()
+	p, err := NewPlanar(b.Dx(), b.Dy())
+	if err != nil {
+		return &Planar{}
+	}
+	for y := 0; y < p.H; y++ {
+		for x := 0; x < p.W; x++ {
+			i := y*p.Stride + x
+			ci := s.COffset(b.Min.X+x, b.Min.Y+y)
+			p.Y[i] = float32(s.Y[s.YOffset(b.Min.X+x, b.Min.Y+y)])
+			p.Cb[i] = float32(s.Cb[ci])
+			p.Cr[i] = float32(s.Cr[ci])
+		}
+	}
+	return p
+}
+
+// Image quantizes p to 8-bit RGB, as YCbCrToRGB does.
+func (p *Planar) Image() *Image {
+	p = p.Packed()
+	img := YCbCrToRGB(p.W, p.H, p.Y, p.Cb, p.Cr)
+	img.Alpha = cloneAlpha8(p.Alpha)
+	return img
+}
+
+// Clone returns a tightly packed copy of p.
+func (p *Planar) Clone() *Planar {
+	if p.Stride != p.W {
+		return p.Packed()
+	}
+	out := *p
+	out.Y = append([]float32(nil), p.Y...)
+	out.Cb = append([]float32(nil), p.Cb...)
+	out.Cr = append([]float32(nil), p.Cr...)
+	out.Alpha = cloneAlpha8(p.Alpha)
+	return &out
+}
+
+// Sub returns the w x h region at (x0, y0) as a Planar that shares p's
+// planes.
+func (p *Planar) Sub(x0, y0, w, h int) (*Planar, error) {
+	if x0 < 0 || y0 < 0 || w <= 0 || h <= 0 || x0+w > p.W || y0+h > p.H {
+		return nil, fmt.Errorf("region %dx%d at (%d, %d) is outside the %dx%d image", w, h, x0, y0, p.W, p.H)
+	}
+	off := y0*p.Stride + x0
+	end := (y0+h-1)*p.Stride + x0 + w
+	sub := &Planar{W: w, H: h, Stride: p.Stride, Y: p.Y[off:end], Cb: p.Cb[off:end], Cr: p.Cr[off:end]}
+	if p.Alpha != nil {
+		sub.Alpha = p.Alpha[off:end]
+	}
+	return sub, nil
+}
+
+// RGB returns pixel i (an index into the planes) as unrounded RGB.
+func (p *Planar) RGB(i int) (r, g, b float32) {
+	yv, cb, cr := p.Y[i], p.Cb[i]-128, p.Cr[i]-128
+	return yv + 1.402*cr, yv - 0.344136*cb - 0.714136*cr, yv + 1.772*cb
+}
+
+// SetRGB sets pixel i from unrounded RGB.
+func (p *Planar) SetRGB(i int, r, g, b float32) {
+	p.Y[i] = lumaFromRGB(r, g, b)
+	p.Cb[i] = 128 - 0.168736*r - 0.331264*g + 0.5*b
+	p.Cr[i] = 128 + 0.5*r - 0.418688*g - 0.081312*b
+}
+
+// Packed returns p, or a copy of it without padding between rows.
+func (p *Planar) Packed() *Planar {
+	if p.Stride == p.W {
+		return p
+	}
+
+	out := &Planar{W: p.W, H: p.H, Stride: p.W}
+	out.Y = packPlane(p.Y, p.W, p.H, p.Stride)
+	out.Cb = packPlane(p.Cb, p.W, p.H, p.Stride)
+	out.Cr = packPlane(p.Cr, p.W, p.H, p.Stride)
+	if p.Alpha != nil {
+		out.Alpha = make([]uint8, p.W*p.H)
+		for y := 0; y < p.H; y++ {
+			copy(out.Alpha[y*p.W:(y+1)*p.W], p.Alpha[y*p.Stride:])
+		}
+	}
+	return out
+}
+
+func packPlane(src []float32, w, h, stride int) []float32 {
+	out := make([]float32, w*h)
+	for y := 0; y < h; y++ {
+		copy(out[y*w:(y+1)*w], src[y*stride:])
+	}
+	return out
+}
+
+func cloneAlpha8(alpha []uint8) []uint8 {
+	if alpha == nil {
+		return nil
+	}
+	return append([]uint8(nil), alpha...)
+}
@@ -26,107 +26,70 @@ func ReadPPMReader(r io.Reader) (*Image, error) {
 	return readPPM(r)
 }
 
+// readPPM reads any netpbm variant (see ReadNetpbm) and converts it to 8-bit
+// RGB.
 func readPPM(src io.Reader) (*Image, error) {
-	r := bufio.NewReader(src)
-
-	magic, err := readRequiredToken(r, "magic")
-	if err != nil {
-		return nil, err
-	}
-	if magic != "P6" {
-		return nil, fmt.Errorf("unsupported magic %q (expected P6)", magic)
-	}
-
-	widthToken, err := readRequiredToken(r, "width")
-	if err != nil {
-		return nil, err
-	}
-	heightToken, err := readRequiredToken(r, "height")
-	if err != nil {
-		return nil, err
-	}
-	maxValToken, err := readRequiredToken(r, "maxval")
+	p, err := ReadNetpbm(src)
 	if err != nil {
 		return nil, err
 	}
+	return p.Image(), nil
+}
 
-	w, err := parsePositiveInt("width", widthToken)
-	if err != nil {
-		return nil, err
-	}
-	h, err := parsePositiveInt("height", heightToken)
-	if err != nil {
-		return nil, err
+func WritePPM(path string, img *Image) (err error) {
+	if err := checkWritableImage(img); err != nil {
+		return err
 	}
 
-	maxVal, err := strconv.Atoi(maxValToken)
+	f, err := os.Create(path)
 	if err != nil {
-		return nil, fmt.Errorf("invalid maxval %q", maxValToken)
-	}
-	if maxVal != 255 {
-		return nil, fmt.Errorf("unsupported maxval %d (expected 255)", maxVal)
+		return fmt.Errorf("create %s: %w", path, err)
 	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil && closeErr != nil {
+			err = fmt.Errorf("close %s: %w", path, closeErr)
+		}
+	}()
 
-	pixelCount, byteCount, err := checkedImageSizes(w, h)
-	if err != nil {
-		return nil, err
+	if err := writePPM(f, img); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
 	}
+	return nil
+}
 
-	raw := make([]byte, byteCount)
-	if _, err := io.ReadFull(r, raw); err != nil {
-		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-			return nil, fmt.Errorf("pixel data is truncated")
-		}
-		return nil, fmt.Errorf("read pixel data: %w", err)
+func WritePPMWriter(w io.Writer, img *Image) error {
+	if w == nil {
+		return errors.New("writer is nil")
 	}
-
-	pix := make([]Rgb, pixelCount)
-	for i := 0; i < pixelCount; i++ {
-		base := i * 3
-		pix[i] = Rgb{
-			R: raw[base],
-			G: raw[base+1],
-			B: raw[base+2],
-		}
+	if err := checkWritableImage(img); err != nil {
+		return err
 	}
-
-	return &Image{
-		W:   w,
-		H:   h,
-		Pix: pix,
-	}, nil
+	return writePPM(w, img)
 }
 
-func WritePPM(path string, img *Image) (err error) {
+func checkWritableImage(img *Image) error {
 	if img == nil {
 		return errors.New("image is nil")
 	}
 
-	pixelCount, byteCount, err := checkedImageSizes(img.W, img.H)
+	pixelCount, _, err := checkedImageSizes(img.W, img.H)
 	if err != nil {
 		return err
 	}
 	if len(img.Pix) != pixelCount {
 		return fmt.Errorf("pixel buffer length %d does not match dimensions %dx%d", len(img.Pix), img.W, img.H)
 	}
+	return nil
+}
 
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("create %s: %w", path, err)
-	}
-	defer func() {
-		closeErr := f.Close()
-		if err == nil && closeErr != nil {
-			err = fmt.Errorf("close %s: %w", path, closeErr)
-		}
-	}()
-
-	w := bufio.NewWriter(f)
+func writePPM(dst io.Writer, img *Image) error {
+	w := bufio.NewWriter(dst)
 	if _, err := fmt.Fprintf(w, "P6\n%d %d\n255\n", img.W, img.H); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
 
-	raw := make([]byte, byteCount)
+	raw := make([]byte, len(img.Pix)*3)
 	for i, p := range img.Pix {
 		base := i * 3
 		raw[base] = p.R
@@ -138,7 +101,7 @@ func WritePPM(path string, img *Image) (err error) {
 		return fmt.Errorf("write pixel data: %w", err)
 	}
 	if err := w.Flush(); err != nil {
-		return fmt.Errorf("flush %s: %w", path, err)
+		return fmt.Errorf("flush: %w", err)
 	}
 
 	return nil
@@ -1,6 +1,9 @@
 package image
 
-import stdimage "image"
+import (
+	stdimage "image"
+	"image/color"
+)
 
 func FromStdImage(src stdimage.Image) *Image {
 	if src == nil {
@@ -14,23 +17,154 @@ func FromStdImage(src stdimage.Image) *Image {
 		return &Image{}
 	}
 
+	switch s := src.(type) {
+	case *stdimage.NRGBA:
+		return fromNRGBA(s)
+	case *stdimage.RGBA:
+		return fromRGBA(s)
+	case *stdimage.YCbCr:
+		return fromYCbCr(s)
+	case *stdimage.Gray:
+		pix := make([]Rgb, w*h)
+		for i, v := range GrayPlane(s) {
+			pix[i] = Rgb{R: v, G: v, B: v}
+		}
+		return &Image{W: w, H: h, Pix: pix}
+	}
+
 	pix := make([]Rgb, w*h)
+	var alpha []uint8
 	for y := 0; y < h; y++ {
 		for x := 0; x < w; x++ {
-			r, g, bl, _ := src.At(b.Min.X+x, b.Min.Y+y).RGBA()
-			pix[y*w+x] = Rgb{
-				R: uint8(r >> 8),
-				G: uint8(g >> 8),
-				B: uint8(bl >> 8),
+			c := color.NRGBAModel.Convert(src.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			pix[y*w+x] = Rgb{R: c.R, G: c.G, B: c.B}
+			if c.A != 255 && alpha == nil {
+				alpha = make([]uint8, w*h)
+				for i := range alpha {
+					alpha[i] = 255
+				}
+			}
+			if alpha != nil {
+				alpha[y*w+x] = c.A
 			}
 		}
 	}
 
 	return &Image{
-		W:   w,
-		H:   h,
-		Pix: pix,
+		W:     w,
+		H:     h,
+		Pix:   pix,
+		Alpha: alpha,
+	}
+}
+
+// The fast paths below read the pixel buffers directly and give exactly what
+// the color.NRGBAModel conversion above gives.
+
+func fromNRGBA(src *stdimage.NRGBA) *Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	img := &Image{W: w, H: h, Pix: make([]Rgb, w*h)}
+	for y := 0; y < h; y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			p := row[4*x : 4*x+4]
+			img.Pix[y*w+x] = Rgb{R: p[0], G: p[1], B: p[2]}
+			if p[3] != 255 && img.Alpha == nil {
+				img.Alpha = opaqueAlpha(w * h)
+			}
+			if img.Alpha != nil {
+				img.Alpha[y*w+x] = p[3]
+			}
+		}
+	}
+	return img
+}
+
+func fromRGBA(src *stdimage.RGBA) *Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	img := &Image{W: w, H: h, Pix: make([]Rgb, w*h)}
+	for y := 0; y < h; y++ {
+		row := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			p := row[4*x : 4*x+4]
+			a := p[3]
+			if a != 255 && img.Alpha == nil {
+				img.Alpha = opaqueAlpha(w * h)
+			}
+			if img.Alpha != nil {
+				img.Alpha[y*w+x] = a
+			}
+			switch a {
+			case 255:
+				img.Pix[y*w+x] = Rgb{R: p[0], G: p[1], B: p[2]}
+			case 0:
+			default:
+				img.Pix[y*w+x] = Rgb{R: unpremultiply(p[0], a), G: unpremultiply(p[1], a), B: unpremultiply(p[2], a)}
+			}
+		}
+	}
+	return img
+}
+
+// unpremultiply divides as color.NRGBAModel does, at 16 bits.
+func unpremultiply(v, a uint8) uint8 {
+	return uint8((uint32(v) * 0x101 * 0xffff / (uint32(a) * 0x101)) >> 8)
+}
+
+func fromYCbCr(src *stdimage.YCbCr) *Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	img := &Image{W: w, H: h, Pix: make([]Rgb, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			yi := src.YOffset(b.Min.X+x, b.Min.Y+y)
+			ci := src.COffset(b.Min.X+x, b.Min.Y+y)
+			r, g, bl := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+			img.Pix[y*w+x] = Rgb{R: r, G: g, B: bl}
+		}
 	}
+	return img
+}
+
+func opaqueAlpha(n int) []uint8 {
+	alpha := make([]uint8, n)
+	for i := range alpha {
+		alpha[i] = 255
+	}
+	return alpha
+}
+
+// YCbCrLuma returns the Y plane of src, tightly packed. It is the luma the
+// watermark works on, so a JPEG's pixels need no trip through RGB.
+func YCbCrLuma(src *stdimage.YCbCr) []uint8 {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		off := src.YOffset(b.Min.X, b.Min.Y+y)
+		copy(out[y*w:(y+1)*w], src.Y[off:off+w])
+	}
+	return out
+}
+
+// YCbCrWithLuma returns a copy of src with its Y plane replaced by y, a
+// tightly packed plane of src's size. Chroma, subsampling and bounds are
+// src's.
+func YCbCrWithLuma(src *stdimage.YCbCr, y []uint8) *stdimage.YCbCr {
+	out := *src
+	out.Y = append([]uint8(nil), src.Y...)
+	out.Cb = append([]uint8(nil), src.Cb...)
+	out.Cr = append([]uint8(nil), src.Cr...)
+
+	b := src.Bounds()
+	w := b.Dx()
+	for row := 0; row < b.Dy(); row++ {
+		off := out.YOffset(b.Min.X, b.Min.Y+row)
+		copy(out.Y[off:off+w], y[row*w:(row+1)*w])
+	}
+	return &out
 }
 
 func ToNRGBA(src *Image) *stdimage.NRGBA {
@@ -49,11 +183,134 @@ func ToNRGBA(src *Image) *stdimage.NRGBA {
 		if i < len(src.Pix) {
 			p = src.Pix[i]
 		}
+		a := uint8(255)
+		if i < len(src.Alpha) {
+			a = src.Alpha[i]
+		}
 		out.Pix[offset] = p.R
 		out.Pix[offset+1] = p.G
 		out.Pix[offset+2] = p.B
-		out.Pix[offset+3] = 255
+		out.Pix[offset+3] = a
+	}
+
+	return out
+}
+
+// StdImage converts p to the closest standard image type: *Gray or *NRGBA
+// for maxval up to 255 and *Gray16 or *NRGBA64 above, with samples rescaled
+// to the full range of the type.
+func (p *Netpbm) StdImage() stdimage.Image {
+	rect := stdimage.Rect(0, 0, p.W, p.H)
+	wide := p.MaxVal > 255
+	switch {
+	case p.Channels == 1 && wide:
+		out := stdimage.NewGray16(rect)
+		for i, v := range p.Samples {
+			v = ScaleSample(v, p.MaxVal, netpbmMaxVal16)
+			out.Pix[2*i] = uint8(v >> 8)
+			out.Pix[2*i+1] = uint8(v)
+		}
+		return out
+	case p.Channels == 1:
+		out := stdimage.NewGray(rect)
+		for i, v := range p.Samples {
+			out.Pix[i] = uint8(ScaleSample(v, p.MaxVal, 255))
+		}
+		return out
+	case wide:
+		out := stdimage.NewNRGBA64(rect)
+		for i := 0; i < p.W*p.H; i++ {
+			for c := 0; c < 3; c++ {
+				v := ScaleSample(p.Samples[3*i+c], p.MaxVal, netpbmMaxVal16)
+				out.Pix[8*i+2*c] = uint8(v >> 8)
+				out.Pix[8*i+2*c+1] = uint8(v)
+			}
+			out.Pix[8*i+6] = 0xff
+			out.Pix[8*i+7] = 0xff
+		}
+		return out
 	}
+	out := stdimage.NewNRGBA(rect)
+	for i := 0; i < p.W*p.H; i++ {
+		for c := 0; c < 3; c++ {
+			out.Pix[4*i+c] = uint8(ScaleSample(p.Samples[3*i+c], p.MaxVal, 255))
+		}
+		out.Pix[4*i+3] = 0xff
+	}
+	return out
+}
 
+// NetpbmFromStd converts src to a binary PGM for *Gray and *Gray16 and a
+// PPM otherwise. *Gray16, *RGBA64 and *NRGBA64 keep 16 bits per sample.
+// Samples are the straight colour, as FromStdImage reads it, and alpha is
+// dropped, so a translucent pixel keeps its colour rather than darkening.
+// Pixels are read through FromStdImage and the plane helpers, so the common
+// types skip the per-pixel At call.
+func NetpbmFromStd(src stdimage.Image) *Netpbm {
+	b := src.Bounds()
+	p := &Netpbm{W: b.Dx(), H: b.Dy(), Channels: 3, MaxVal: 255}
+	switch s := src.(type) {
+	case *stdimage.Gray:
+		p.Channels = 1
+		p.Samples = make([]uint16, p.W*p.H)
+		for i, v := range GrayPlane(s) {
+			p.Samples[i] = uint16(v)
+		}
+	case *stdimage.Gray16:
+		p.Channels, p.MaxVal = 1, netpbmMaxVal16
+		p.Samples = Gray16Plane(s)
+	case *stdimage.RGBA64, *stdimage.NRGBA64:
+		p.MaxVal = netpbmMaxVal16
+		p.Samples = make([]uint16, 3*p.W*p.H)
+		for i, c := range FromStdImage16(src).Pix {
+			p.Samples[3*i], p.Samples[3*i+1], p.Samples[3*i+2] = c.R, c.G, c.B
+		}
+	default:
+		p.Samples = make([]uint16, 3*p.W*p.H)
+		for i, c := range FromStdImage(src).Pix {
+			p.Samples[3*i], p.Samples[3*i+1], p.Samples[3*i+2] = uint16(c.R), uint16(c.G), uint16(c.B)
+		}
+	}
+	return p
+}
+
+// GrayPlane returns the pixels of src as a tightly packed row-major plane.
+func GrayPlane(src *stdimage.Gray) []uint8 {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		off := src.PixOffset(b.Min.X, b.Min.Y+y)
+		copy(out[y*w:(y+1)*w], src.Pix[off:off+w])
+	}
+	return out
+}
+
+func GrayFromPlane(y []uint8, w, h int) *stdimage.Gray {
+	out := stdimage.NewGray(stdimage.Rect(0, 0, w, h))
+	copy(out.Pix, y)
+	return out
+}
+
+// Gray16Plane is GrayPlane for *Gray16.
+func Gray16Plane(src *stdimage.Gray16) []uint16 {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := make([]uint16, w*h)
+	for y := 0; y < h; y++ {
+		off := src.PixOffset(b.Min.X, b.Min.Y+y)
+		for x := 0; x < w; x++ {
+			out[y*w+x] = uint16(src.Pix[off+2*x])<<8 | uint16(src.Pix[off+2*x+1])
+		}
+	}
+	return out
+}
+
+func Gray16FromPlane(y []uint16, w, h int) *stdimage.Gray16 {
+	out := stdimage.NewGray16(stdimage.Rect(0, 0, w, h))
+	for i, v := range y {
+		out.Pix[2*i] = uint8(v >> 8)
+		out.Pix[2*i+1] = uint8(v)
+	}
 	return out
 }
@@ -0,0 +1,185 @@
+package image
+
+import (
+	stdimage "image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// genericImage hides the concrete type of an image so FromStdImage takes
+// the At() path.
+type genericImage struct{ stdimage.Image }
+
+// subImage is a window with a non-zero origin, so the fast paths' offset
+// arithmetic is exercised too.
+func subImage(img stdimage.Image) stdimage.Image {
+	r := img.Bounds()
+	return img.(interface {
+		SubImage(stdimage.Rectangle) stdimage.Image
+	}).SubImage(stdimage.Rect(r.Min.X+3, r.Min.Y+5, r.Max.X-2, r.Max.Y-1))
+}
+
+func TestFromStdImageFastPathsMatchGeneric(t *testing.T) {
+	r := stdimage.Rect(0, 0, 37, 23)
+	nrgba := testNRGBA(r, 1)
+	opaque := testNRGBA(r, 2)
+	rgba := stdimage.NewRGBA(r)
+	gray := stdimage.NewGray(r)
+	for i := 0; i < len(nrgba.Pix); i += 4 {
+		opaque.Pix[i+3] = 0xff
+		// Valid premultiplied colour: no channel above alpha.
+		a := nrgba.Pix[i+3]
+		for c := 0; c < 3; c++ {
+			rgba.Pix[i+c] = uint8(int(nrgba.Pix[i+c]) * int(a) / 255)
+		}
+		rgba.Pix[i+3] = a
+		gray.Pix[i/4] = nrgba.Pix[i]
+	}
+
+	images := map[string]stdimage.Image{
+		"nrgba":  nrgba,
+		"opaque": opaque,
+		"rgba":   rgba,
+		"gray":   gray,
+	}
+	for _, ratio := range []stdimage.YCbCrSubsampleRatio{stdimage.YCbCrSubsampleRatio420, stdimage.YCbCrSubsampleRatio422, stdimage.YCbCrSubsampleRatio444} {
+		ycc := stdimage.NewYCbCr(r, ratio)
+		for i := range ycc.Y {
+			ycc.Y[i] = uint8(i * 13)
+		}
+		for i := range ycc.Cb {
+			ycc.Cb[i] = uint8(i * 7)
+			ycc.Cr[i] = uint8(255 - i*11)
+		}
+		images["ycbcr"+ratio.String()] = ycc
+	}
+	images["cmyk"] = stdimage.NewCMYK(r)
+
+	for name, img := range images {
+		for _, src := range []stdimage.Image{img, subImage(img)} {
+			got := FromStdImage(src)
+			want := FromStdImage(genericImage{src})
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %v: fast path differs from At()", name, src.Bounds())
+			}
+		}
+	}
+}
+
+func TestLumaPlanesMatchAt(t *testing.T) {
+	r := stdimage.Rect(0, 0, 37, 23)
+	ycc := stdimage.NewYCbCr(r, stdimage.YCbCrSubsampleRatio420)
+	gray := stdimage.NewGray(r)
+	for i := range ycc.Y {
+		ycc.Y[i] = uint8(i * 13)
+		gray.Pix[i] = uint8(i * 7)
+	}
+
+	for _, src := range []stdimage.Image{ycc, subImage(ycc), gray, subImage(gray)} {
+		var plane []uint8
+		switch s := src.(type) {
+		case *stdimage.YCbCr:
+			plane = YCbCrLuma(s)
+		case *stdimage.Gray:
+			plane = GrayPlane(s)
+		}
+		b := src.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var want uint8
+				switch c := src.At(x, y).(type) {
+				case color.YCbCr:
+					want = c.Y
+				case color.Gray:
+					want = c.Y
+				}
+				if got := plane[(y-b.Min.Y)*b.Dx()+x-b.Min.X]; got != want {
+					t.Fatalf("%T %v: luma at (%d,%d) = %d, want %d", src, b, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestNetpbmFromStdKeepsStraightColour(t *testing.T) {
+	r := stdimage.Rect(0, 0, 3, 1)
+	nrgba := stdimage.NewNRGBA(r)
+	deep := stdimage.NewNRGBA64(r)
+	for x, a := range []uint8{0xff, 0x80, 0x10} {
+		nrgba.SetNRGBA(x, 0, color.NRGBA{R: 200, G: 100, B: 50, A: a})
+		deep.SetNRGBA64(x, 0, color.NRGBA64{R: 51234, G: 25617, B: 12808, A: uint16(a) * 257})
+	}
+
+	p := NetpbmFromStd(nrgba)
+	for x := 0; x < 3; x++ {
+		if got := p.Samples[3*x : 3*x+3]; got[0] != 200 || got[1] != 100 || got[2] != 50 {
+			t.Errorf("8-bit pixel %d = %v, want [200 100 50]", x, got)
+		}
+	}
+	p = NetpbmFromStd(deep)
+	for x := 0; x < 3; x++ {
+		if got := p.Samples[3*x : 3*x+3]; got[0] != 51234 || got[1] != 25617 || got[2] != 12808 {
+			t.Errorf("16-bit pixel %d = %v, want [51234 25617 12808]", x, got)
+		}
+	}
+}
+
+// netpbmSamplesAt is NetpbmFromStd done one At() call per pixel.
+func netpbmSamplesAt(src stdimage.Image, channels, maxVal int) []uint16 {
+	b := src.Bounds()
+	var out []uint16
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var rgb [3]uint16
+			if maxVal == 255 {
+				c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+				rgb = [3]uint16{uint16(c.R), uint16(c.G), uint16(c.B)}
+			} else {
+				c := color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64)
+				rgb = [3]uint16{c.R, c.G, c.B}
+			}
+			out = append(out, rgb[:channels]...)
+		}
+	}
+	return out
+}
+
+func TestNetpbmFromStdFastPathsMatchAt(t *testing.T) {
+	r := stdimage.Rect(0, 0, 37, 23)
+	rgba := stdimage.NewRGBA(r)
+	gray := stdimage.NewGray(r)
+	gray16 := stdimage.NewGray16(r)
+	nrgba64 := stdimage.NewNRGBA64(r)
+	for i := range rgba.Pix {
+		rgba.Pix[i] = uint8(i * 29)
+		nrgba64.Pix[2*i] = uint8(i * 31)
+		nrgba64.Pix[2*i+1] = uint8(i * 7)
+	}
+	for i := 3; i < len(rgba.Pix); i += 4 {
+		// Premultiplied colour must not exceed alpha.
+		rgba.Pix[i] = max(rgba.Pix[i-3], rgba.Pix[i-2], rgba.Pix[i-1])
+	}
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 13)
+		gray16.Pix[2*i] = uint8(i * 13)
+		gray16.Pix[2*i+1] = uint8(i * 5)
+	}
+
+	for name, img := range map[string]stdimage.Image{
+		"nrgba":   testNRGBA(r, 1),
+		"rgba":    rgba,
+		"gray":    gray,
+		"gray16":  gray16,
+		"nrgba64": nrgba64,
+		"ycbcr":   stdimage.NewYCbCr(r, stdimage.YCbCrSubsampleRatio420),
+		"cmyk":    stdimage.NewCMYK(r),
+	} {
+		for _, src := range []stdimage.Image{img, subImage(img)} {
+			p := NetpbmFromStd(src)
+			if want := netpbmSamplesAt(src, p.Channels, p.MaxVal); !reflect.DeepEqual(p.Samples, want) {
+				t.Errorf("%s %v: samples differ from At()", name, src.Bounds())
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package image
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	// TGA has no signature; these match the colour map flag and image type
+	// of every type DecodeTGA reads.
+	for _, magic := range []string{"?\x00\x02", "?\x00\x03", "?\x00\x0a", "?\x00\x0b", "?\x01\x01", "?\x01\x09"} {
+		stdimage.RegisterFormat("tga", magic, DecodeTGA, DecodeTGAConfig)
+	}
+}
+
+const tgaHeaderLen = 18
+
+const (
+	tgaColorMapped = 1
+	tgaTrueColor   = 2
+	tgaGray        = 3
+	tgaRLE         = 8
+)
+
+type tgaHeader struct {
+	idLen      int
+	mapType    int
+	imageType  int
+	mapFirst   int
+	mapLen     int
+	mapDepth   int
+	w, h       int
+	depth      int
+	alphaBits  int
+	rightLeft  bool
+	topDown    bool
+	baseType   int
+	compressed bool
+}
+
+// DecodeTGA reads an uncompressed or RLE TGA: colour-mapped (8-bit indices),
+// true colour at 15, 16, 24 or 32 bits, or 8-bit grayscale. Grayscale
+// decodes to *image.Gray, colour-mapped to *image.Paletted and true colour
+// to *image.NRGBA, with alpha when the descriptor declares alpha bits.
+func DecodeTGA(r io.Reader) (stdimage.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readTGAHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, br, int64(h.idLen)); err != nil {
+		return nil, errors.New("tga: image ID is truncated")
+	}
+
+	var palette color.Palette
+	if h.mapType == 1 {
+		entry := (h.mapDepth + 7) / 8
+		raw := make([]byte, h.mapLen*entry)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return nil, errors.New("tga: colour map is truncated")
+		}
+		if h.baseType == tgaColorMapped {
+			palette = make(color.Palette, h.mapFirst+h.mapLen)
+			for i := range palette[:h.mapFirst] {
+				palette[i] = color.NRGBA{A: 0xff}
+			}
+			for i := 0; i < h.mapLen; i++ {
+				palette[h.mapFirst+i] = tgaColor(raw[i*entry:], h.mapDepth, h.mapDepth == 32 || h.mapDepth == 16 && h.alphaBits > 0)
+			}
+		}
+	}
+
+	bytesPP := (h.depth + 7) / 8
+	var pix []byte
+	if h.compressed {
+		pix, err = readTGARLE(br, h.w*h.h*bytesPP, bytesPP)
+	} else if pix, err = readSized(br, h.w*h.h*bytesPP); err != nil {
+		err = errors.New("tga: pixel data is truncated")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Pixels are stored bottom-up and left to right unless the descriptor
+	// says otherwise.
+	dst := func(i int) int {
+		x, y := i%h.w, i/h.w
+		if h.rightLeft {
+			x = h.w - 1 - x
+		}
+		if !h.topDown {
+			y = h.h - 1 - y
+		}
+		return y*h.w + x
+	}
+
+	rect := stdimage.Rect(0, 0, h.w, h.h)
+	switch h.baseType {
+	case tgaGray:
+		img := stdimage.NewGray(rect)
+		for i := 0; i < h.w*h.h; i++ {
+			img.Pix[dst(i)] = pix[i*bytesPP]
+		}
+		return img, nil
+	case tgaColorMapped:
+		img := stdimage.NewPaletted(rect, palette)
+		for i := 0; i < h.w*h.h; i++ {
+			v := pix[i*bytesPP]
+			if int(v) >= len(palette) {
+				return nil, fmt.Errorf("tga: colour index %d is outside the colour map", v)
+			}
+			img.Pix[dst(i)] = v
+		}
+		return img, nil
+	}
+
+	img := stdimage.NewNRGBA(rect)
+	alpha := h.alphaBits > 0 && (h.depth == 32 || h.depth == 16)
+	for i := 0; i < h.w*h.h; i++ {
+		c := tgaColor(pix[i*bytesPP:], h.depth, alpha)
+		j := 4 * dst(i)
+		img.Pix[j], img.Pix[j+1], img.Pix[j+2], img.Pix[j+3] = c.R, c.G, c.B, c.A
+	}
+	return img, nil
+}
+
+func DecodeTGAConfig(r io.Reader) (stdimage.Config, error) {
+	h, err := readTGAHeader(r)
+	if err != nil {
+		return stdimage.Config{}, err
+	}
+	cfg := stdimage.Config{Width: h.w, Height: h.h, ColorModel: color.NRGBAModel}
+	if h.baseType == tgaGray {
+		cfg.ColorModel = color.GrayModel
+	}
+	return cfg, nil
+}
+
+func readTGAHeader(r io.Reader) (*tgaHeader, error) {
+	var b [tgaHeaderLen]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, fmt.Errorf("tga: read header: %w", err)
+	}
+	h := &tgaHeader{
+		idLen:     int(b[0]),
+		mapType:   int(b[1]),
+		imageType: int(b[2]),
+		mapFirst:  int(binary.LittleEndian.Uint16(b[3:])),
+		mapLen:    int(binary.LittleEndian.Uint16(b[5:])),
+		mapDepth:  int(b[7]),
+		w:         int(binary.LittleEndian.Uint16(b[12:])),
+		h:         int(binary.LittleEndian.Uint16(b[14:])),
+		depth:     int(b[16]),
+		alphaBits: int(b[17] & 0x0f),
+		rightLeft: b[17]&0x10 != 0,
+		topDown:   b[17]&0x20 != 0,
+	}
+	h.baseType = h.imageType &^ tgaRLE
+	h.compressed = h.imageType&tgaRLE != 0
+
+	if h.mapType > 1 {
+		return nil, fmt.Errorf("tga: unsupported colour map type %d", h.mapType)
+	}
+	if h.mapType == 1 && h.mapDepth != 15 && h.mapDepth != 16 && h.mapDepth != 24 && h.mapDepth != 32 {
+		return nil, fmt.Errorf("tga: unsupported colour map depth %d", h.mapDepth)
+	}
+	switch h.baseType {
+	case tgaColorMapped:
+		if h.mapType != 1 || h.depth != 8 || h.mapFirst+h.mapLen > 256 {
+			return nil, errors.New("tga: colour-mapped images need an 8-bit index and a colour map of up to 256 entries")
+		}
+	case tgaTrueColor:
+		if h.depth != 15 && h.depth != 16 && h.depth != 24 && h.depth != 32 {
+			return nil, fmt.Errorf("tga: unsupported true colour depth %d", h.depth)
+		}
+	case tgaGray:
+		if h.depth != 8 {
+			return nil, fmt.Errorf("tga: unsupported grayscale depth %d", h.depth)
+		}
+	default:
+		return nil, fmt.Errorf("tga: unsupported image type %d", h.imageType)
+	}
+	if err := checkPixels(h.w, h.h); err != nil {
+		return nil, fmt.Errorf("tga: %w", err)
+	}
+	return h, nil
+}
+
+// readTGARLE expands run-length packets into size bytes of pixels. Packets
+// may run across scanlines. The output grows with the packets read, so a
+// truncated stream does not cost the full size.
+func readTGARLE(r *bufio.Reader, size, bytesPP int) ([]byte, error) {
+	var pix []byte
+	var px [4]byte
+	for len(pix) < size {
+		hdr, err := r.ReadByte()
+		if err != nil {
+			return nil, errors.New("tga: RLE data is truncated")
+		}
+		n := int(hdr&0x7f) + 1
+		if len(pix)+n*bytesPP > size {
+			return nil, errors.New("tga: RLE packet overruns the image")
+		}
+		if hdr&0x80 == 0 {
+			start := len(pix)
+			pix = append(pix, make([]byte, n*bytesPP)...)
+			if _, err := io.ReadFull(r, pix[start:]); err != nil {
+				return nil, errors.New("tga: RLE data is truncated")
+			}
+			continue
+		}
+		if _, err := io.ReadFull(r, px[:bytesPP]); err != nil {
+			return nil, errors.New("tga: RLE data is truncated")
+		}
+		for k := 0; k < n; k++ {
+			pix = append(pix, px[:bytesPP]...)
+		}
+	}
+	return pix, nil
+}
+
+// tgaColor decodes one BGR(A) or 1-5-5-5 pixel.
+func tgaColor(p []byte, depth int, alpha bool) color.NRGBA {
+	switch depth {
+	case 15, 16:
+		v := binary.LittleEndian.Uint16(p)
+		c := color.NRGBA{
+			R: scale5(v >> 10),
+			G: scale5(v >> 5),
+			B: scale5(v),
+			A: 0xff,
+		}
+		if alpha && v&0x8000 == 0 {
+			c.A = 0
+		}
+		return c
+	case 24:
+		return color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+	}
+	c := color.NRGBA{R: p[2], G: p[1], B: p[0], A: 0xff}
+	if alpha {
+		c.A = p[3]
+	}
+	return c
+}
+
+func scale5(v uint16) uint8 {
+	v &= 0x1f
+	return uint8(v<<3 | v>>2)
+}
+
+// WriteTGA writes img top-down: *image.Gray as 8-bit grayscale, opaque
+// images as 24-bit and images with transparency as 32-bit with 8 alpha
+// bits. rle selects the run-length encoded image types. 16-bit samples are
+// rounded to 8 bits.
+func WriteTGA(dst io.Writer, img stdimage.Image, rle bool) error {
+	if dst == nil {
+		return errors.New("writer is nil")
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= 0 || h <= 0 || w > 0xffff || h > 0xffff {
+		return fmt.Errorf("tga: cannot store a %dx%d image", w, h)
+	}
+
+	var hdr [tgaHeaderLen]byte
+	binary.LittleEndian.PutUint16(hdr[12:], uint16(w))
+	binary.LittleEndian.PutUint16(hdr[14:], uint16(h))
+	hdr[17] = 0x20
+
+	var pix []byte
+	var bytesPP int
+	if g, ok := img.(*stdimage.Gray); ok {
+		hdr[2], hdr[16], bytesPP = tgaGray, 8, 1
+		pix = make([]byte, w*h)
+		for y := 0; y < h; y++ {
+			off := g.PixOffset(b.Min.X, b.Min.Y+y)
+			copy(pix[y*w:], g.Pix[off:off+w])
+		}
+	} else {
+		converted := FromStdImage(img)
+		hdr[2], hdr[16], bytesPP = tgaTrueColor, 24, 3
+		if converted.Alpha != nil {
+			hdr[16], bytesPP = 32, 4
+			hdr[17] |= 8
+		}
+		pix = make([]byte, w*h*bytesPP)
+		for i, p := range converted.Pix {
+			px := pix[i*bytesPP:]
+			px[0], px[1], px[2] = p.B, p.G, p.R
+			if bytesPP == 4 {
+				px[3] = converted.Alpha[i]
+			}
+		}
+	}
+	if rle {
+		hdr[2] |= tgaRLE
+		pix = encodeTGARLE(pix, w, bytesPP)
+	}
+
+	bw := bufio.NewWriter(dst)
+	if _, err := bw.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := bw.Write(pix); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// encodeTGARLE packs each scanline separately, as the TGA 2.0 spec asks,
+// into run packets for repeats and raw packets for the rest.
+func encodeTGARLE(pix []byte, w, bytesPP int) []byte {
+	var out []byte
+	same := func(a, b int) bool {
+		for k := 0; k < bytesPP; k++ {
+			if pix[a*bytesPP+k] != pix[b*bytesPP+k] {
+				return false
+			}
+		}
+		return true
+	}
+	rows := len(pix) / (w * bytesPP)
+	for y := 0; y < rows; y++ {
+		start, end := y*w, (y+1)*w
+		for i := start; i < end; {
+			run := 1
+			for i+run < end && run < 128 && same(i, i+run) {
+				run++
+			}
+			if run > 1 {
+				out = append(out, byte(0x80|(run-1)))
+				out = append(out, pix[i*bytesPP:(i+1)*bytesPP]...)
+				i += run
+				continue
+			}
+			raw := 1
+			for i+raw < end && raw < 128 && (i+raw+1 >= end || !same(i+raw, i+raw+1)) {
+				raw++
+			}
+			out = append(out, byte(raw-1))
+			out = append(out, pix[i*bytesPP:(i+raw)*bytesPP]...)
+			i += raw
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+// tgaHeaderBytes is an 18-byte header for a true colour or grayscale TGA.
+func tgaHeaderBytes(imageType, w, h, depth int) []byte {
+	var b [tgaHeaderLen]byte
+	b[2] = byte(imageType)
+	binary.LittleEndian.PutUint16(b[12:], uint16(w))
+	binary.LittleEndian.PutUint16(b[14:], uint16(h))
+	b[16] = byte(depth)
+	b[17] = 0x20
+	return b[:]
+}
+
+// allocated is the number of bytes fn allocates.
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestDecodeTGARejectsHugeHeader(t *testing.T) {
+	data := append(tgaHeaderBytes(tgaTrueColor, 0xffff, 0xffff, 32), make([]byte, 16)...)
+
+	if _, err := DecodeTGA(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("DecodeTGA error = %v, want ErrTooLarge", err)
+	}
+	if _, err := DecodeTGAConfig(bytes.NewReader(data)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("DecodeTGAConfig error = %v, want ErrTooLarge", err)
+	}
+	if _, _, err := DecodeImage(data); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("DecodeImage error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestDecodeTGATruncatedDoesNotAllocateDeclaredSize(t *testing.T) {
+	// 8000x8000 at 32 bits is within MaxPixels and declares 256 MB.
+	for _, imageType := range []int{tgaTrueColor, tgaTrueColor | tgaRLE} {
+		data := append(tgaHeaderBytes(imageType, 8000, 8000, 32), 0x7f, 1, 2, 3, 4)
+		var err error
+		n := allocated(func() { _, err = DecodeTGA(bytes.NewReader(data)) })
+		if err == nil {
+			t.Fatalf("type %d: truncated TGA decoded", imageType)
+		}
+		if n > 16<<20 {
+			t.Fatalf("type %d: truncated TGA allocated %d bytes", imageType, n)
+		}
+	}
+}
+
+func TestWriteTGARoundTrip(t *testing.T) {
+	for name, img := range writerTestImages() {
+		for _, rle := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := WriteTGA(&buf, img, rle); err != nil {
+				t.Fatalf("%s rle %v: %v", name, rle, err)
+			}
+			got, err := DecodeTGA(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("%s rle %v: %v", name, rle, err)
+			}
+			if !sameImage(got, img) {
+				t.Errorf("%s rle %v: decoded pixels differ", name, rle)
+			}
+
+			data := buf.Bytes()
+			for n := 0; n < len(data); n++ {
+				if _, err := DecodeTGA(bytes.NewReader(data[:n])); err == nil {
+					t.Fatalf("%s rle %v: decoding %d of %d bytes succeeded", name, rle, n, len(data))
+				}
+			}
+		}
+	}
+}
@@ -10,4 +10,7 @@ type Image struct {
 	W   int
 	H   int
 	Pix []Rgb
+	// Alpha is the straight (not premultiplied) alpha of each pixel, or nil
+	// for an opaque image.
+	Alpha []uint8
 }
@@ -0,0 +1,222 @@
+package image
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	y4mMagic        = "YUV4MPEG2"
+	y4mFrameMagic   = "FRAME"
+	y4mMaxHeaderLen = 4096
+)
+
+// Y4MHeader is a parsed YUV4MPEG2 stream header. Params keeps every tag
+// other than W, H and C verbatim (frame rate, interlacing, aspect, X...) so
+// a stream can be rewritten with the same header.
+type Y4MHeader struct {
+	W           int
+	H           int
+	Subsampling Subsampling
+	Colorspace  string
+	Params      []string
+}
+
+type Y4MReader struct {
+	r   *bufio.Reader
+	hdr Y4MHeader
+}
+
+type Y4MWriter struct {
+	w   *bufio.Writer
+	hdr Y4MHeader
+}
+
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	if r == nil {
+		return nil, errors.New("reader is nil")
+	}
+	br := bufio.NewReader(r)
+
+	line, err := readY4MLine(br)
+	if err != nil {
+		return nil, fmt.Errorf("read y4m header: %w", err)
+	}
+	hdr, err := parseY4MHeader(line)
+	if err != nil {
+		return nil, err
+	}
+	return &Y4MReader{r: br, hdr: hdr}, nil
+}
+
+func (r *Y4MReader) Header() Y4MHeader {
+	return r.hdr
+}
+
+// ReadFrame returns the next frame, or io.EOF after the last one. Per-frame
+// parameters are ignored.
+func (r *Y4MReader) ReadFrame() (*YUVFrame, error) {
+	line, err := readY4MLine(r.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) && line == "" {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read y4m frame header: %w", err)
+	}
+	if line != y4mFrameMagic && !strings.HasPrefix(line, y4mFrameMagic+" ") {
+		return nil, fmt.Errorf("invalid y4m frame header %q", line)
+	}
+
+	f, err := NewYUVFrame(r.hdr.W, r.hdr.H, r.hdr.Subsampling)
+	if err != nil {
+		return nil, err
+	}
+	if err := readFullFrame(r.r, f.Y, f.Cb, f.Cr); err != nil {
+		return nil, fmt.Errorf("read y4m frame: %w", err)
+	}
+	return f, nil
+}
+
+func NewY4MWriter(w io.Writer, hdr Y4MHeader) (*Y4MWriter, error) {
+	if w == nil {
+		return nil, errors.New("writer is nil")
+	}
+	if _, _, err := checkedImageSizes(hdr.W, hdr.H); err != nil {
+		return nil, err
+	}
+	if hdr.Colorspace == "" {
+		cs, err := y4mColorspaceFor(hdr.Subsampling)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Colorspace = cs
+	}
+
+	bw := bufio.NewWriter(w)
+	// Tags go out in the conventional order: W H, the standard tags, C,
+	// then X extensions.
+	fields := []string{y4mMagic, "W" + strconv.Itoa(hdr.W), "H" + strconv.Itoa(hdr.H)}
+	var ext []string
+	for _, p := range hdr.Params {
+		if strings.HasPrefix(p, "X") {
+			ext = append(ext, p)
+		} else {
+			fields = append(fields, p)
+		}
+	}
+	fields = append(fields, "C"+hdr.Colorspace)
+	fields = append(fields, ext...)
+	if _, err := fmt.Fprintf(bw, "%s\n", strings.Join(fields, " ")); err != nil {
+		return nil, fmt.Errorf("write y4m header: %w", err)
+	}
+	return &Y4MWriter{w: bw, hdr: hdr}, nil
+}
+
+func (w *Y4MWriter) WriteFrame(f *YUVFrame) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+	if f.W != w.hdr.W || f.H != w.hdr.H || f.Subsampling != w.hdr.Subsampling {
+		return fmt.Errorf("frame %dx%d does not match stream %dx%d", f.W, f.H, w.hdr.W, w.hdr.H)
+	}
+
+	if _, err := w.w.WriteString(y4mFrameMagic + "\n"); err != nil {
+		return err
+	}
+	for _, p := range [][]uint8{f.Y, f.Cb, f.Cr} {
+		if _, err := w.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Y4MWriter) Flush() error {
+	return w.w.Flush()
+}
+
+func parseY4MHeader(line string) (Y4MHeader, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return Y4MHeader{}, errors.New("not a YUV4MPEG2 stream")
+	}
+
+	hdr := Y4MHeader{Colorspace: "420jpeg"}
+	var err error
+	for _, f := range fields[1:] {
+		switch f[0] {
+		case 'W':
+			hdr.W, err = parsePositiveInt("width", f[1:])
+		case 'H':
+			hdr.H, err = parsePositiveInt("height", f[1:])
+		case 'C':
+			hdr.Colorspace = f[1:]
+		default:
+			hdr.Params = append(hdr.Params, f)
+		}
+		if err != nil {
+			return Y4MHeader{}, err
+		}
+	}
+	if hdr.W == 0 || hdr.H == 0 {
+		return Y4MHeader{}, errors.New("y4m header is missing W or H")
+	}
+	if _, _, err := checkedImageSizes(hdr.W, hdr.H); err != nil {
+		return Y4MHeader{}, err
+	}
+
+	hdr.Subsampling, err = y4mSubsampling(hdr.Colorspace)
+	return hdr, err
+}
+
+// y4mSubsampling maps a C tag to a chroma layout. The 4:2:0 variants only
+// differ in chroma siting, which embedding leaves untouched.
+func y4mSubsampling(cs string) (Subsampling, error) {
+	switch cs {
+	case "420jpeg", "420paldv", "420mpeg2", "420":
+		return Subsampling420, nil
+	case "422":
+		return Subsampling422, nil
+	case "444":
+		return Subsampling444, nil
+	case "mono":
+		return SubsamplingMono, nil
+	}
+	return 0, fmt.Errorf("unsupported y4m colorspace %q (8-bit 420, 422, 444 or mono only)", cs)
+}
+
+func y4mColorspaceFor(s Subsampling) (string, error) {
+	switch s {
+	case Subsampling420:
+		return "420jpeg", nil
+	case Subsampling422:
+		return "422", nil
+	case Subsampling444:
+		return "444", nil
+	case SubsamplingMono:
+		return "mono", nil
+	}
+	return "", fmt.Errorf("unknown subsampling %d", s)
+}
+
+// readY4MLine reads up to the next newline, which is not returned.
+func readY4MLine(r *bufio.Reader) (string, error) {
+	var sb strings.Builder
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return sb.String(), err
+		}
+		if b == '\n' {
+			return sb.String(), nil
+		}
+		if sb.Len() >= y4mMaxHeaderLen {
+			return "", errors.New("y4m header line too long")
+		}
+		sb.WriteByte(b)
+	}
+}
@@ -15,7 +15,7 @@ func RGBToYCbCr(img *Image) (y, cb, cr []float32) {
 		g := float32(p.G)
 		b := float32(p.B)
 
-		y[i] = 0.299*r + 0.587*g + 0.114*b
+		y[i] = lumaFromRGB(r, g, b)
 		cb[i] = 128 - 0.168736*r - 0.331264*g + 0.5*b
 		cr[i] = 128 + 0.5*r - 0.418688*g - 0.081312*b
 	}
@@ -23,6 +23,19 @@ func RGBToYCbCr(img *Image) (y, cb, cr []float32) {
 	return y, cb, cr
 }
 
+// RGBToLuma is the y plane of RGBToYCbCr on its own.
+func RGBToLuma(img *Image) []float32 {
+	if img == nil || len(img.Pix) == 0 {
+		return nil
+	}
+
+	y := make([]float32, len(img.Pix))
+	for i, p := range img.Pix {
+		y[i] = lumaFromRGB(float32(p.R), float32(p.G), float32(p.B))
+	}
+	return y
+}
+
 func YCbCrToRGB(w, h int, y, cb, cr []float32) *Image {
 	pixelCount, _, err := checkedImageSizes(w, h)
 	if err != nil {
@@ -32,19 +45,11 @@ func YCbCrToRGB(w, h int, y, cb, cr []float32) *Image {
 	pix := make([]Rgb, pixelCount)
 
 	for i := 0; i < pixelCount; i++ {
-		yv := sampleChannel(y, i, 0)
-		cbv := sampleChannel(cb, i, 128)
-		crv := sampleChannel(cr, i, 128)
-
-		r := yv + 1.402*(crv-128)
-		g := yv - 0.344136*(cbv-128) - 0.714136*(crv-128)
-		b := yv + 1.772*(cbv-128)
-
-		pix[i] = Rgb{
-			R: clampFloatToUint8(r),
-			G: clampFloatToUint8(g),
-			B: clampFloatToUint8(b),
-		}
+		pix[i] = ycbcrToRgb(
+			sampleChannel(y, i, 0),
+			sampleChannel(cb, i, 128),
+			sampleChannel(cr, i, 128),
+		)
 	}
 
 	return &Image{
@@ -54,6 +59,29 @@ func YCbCrToRGB(w, h int, y, cb, cr []float32) *Image {
 	}
 }
 
+// QuantizeLuma returns the luma a detector will measure for a pixel with the
+// given Y/Cb/Cr after it has been written out as 8-bit RGB and read back.
+func QuantizeLuma(y, cb, cr float32) float32 {
+	p := ycbcrToRgb(y, cb, cr)
+	return lumaFromRGB(float32(p.R), float32(p.G), float32(p.B))
+}
+
+func lumaFromRGB(r, g, b float32) float32 {
+	return 0.299*r + 0.587*g + 0.114*b
+}
+
+func ycbcrToRgb(yv, cbv, crv float32) Rgb {
+	r := yv + 1.402*(crv-128)
+	g := yv - 0.344136*(cbv-128) - 0.714136*(crv-128)
+	b := yv + 1.772*(cbv-128)
+
+	return Rgb{
+		R: clampFloatToUint8(r),
+		G: clampFloatToUint8(g),
+		B: clampFloatToUint8(b),
+	}
+}
+
 func sampleChannel(ch []float32, i int, fallback float32) float32 {
 	if i < 0 || i >= len(ch) {
 		return fallback
@@ -0,0 +1,118 @@
+package image
+
+// Fixed-point colour conversion for the bit-exact pipeline. Planes are int32
+// in Q4 (value * 16) and the BT.601 constants are the float ones rounded to
+// Q16, as in libjpeg's integer converters. Only integer arithmetic is used,
+// so results do not depend on FMA contraction or other float differences
+// between architectures.
+const FixedFracBits = 4
+
+const (
+	fixedOne       = 1 << FixedFracBits
+	fixedCoefShift = 16 - FixedFracBits
+
+	fixYR   = 19595 // 0.299
+	fixYG   = 38470 // 0.587
+	fixYB   = 7471  // 0.114
+	fixCbR  = 11059 // 0.168736
+	fixCbG  = 21709 // 0.331264
+	fixCrG  = 27439 // 0.418688
+	fixCrB  = 5329  // 0.081312
+	fixHalf = 32768 // 0.5
+
+	fixRCr = 91881  // 1.402
+	fixGCb = 22554  // 0.344136
+	fixGCr = 46802  // 0.714136
+	fixBCb = 116130 // 1.772
+)
+
+func RGBToYCbCrFixed(img *Image) (y, cb, cr []int32) {
+	if img == nil || len(img.Pix) == 0 {
+		return nil, nil, nil
+	}
+
+	n := len(img.Pix)
+	y = make([]int32, n)
+	cb = make([]int32, n)
+	cr = make([]int32, n)
+
+	const round = 1 << (fixedCoefShift - 1)
+	const offset = 128 << 16
+	for i, p := range img.Pix {
+		r := int32(p.R)
+		g := int32(p.G)
+		b := int32(p.B)
+
+		y[i] = lumaFixedFromRGB(r, g, b)
+		cb[i] = (offset - fixCbR*r - fixCbG*g + fixHalf*b + round) >> fixedCoefShift
+		cr[i] = (offset + fixHalf*r - fixCrG*g - fixCrB*b + round) >> fixedCoefShift
+	}
+
+	return y, cb, cr
+}
+
+func YCbCrFixedToRGB(w, h int, y, cb, cr []int32) *Image {
+	pixelCount, _, err := checkedImageSizes(w, h)
+	if err != nil {
+		return &Image{W: w, H: h}
+	}
+
+	pix := make([]Rgb, pixelCount)
+	for i := 0; i < pixelCount; i++ {
+		pix[i] = ycbcrFixedToRgb(
+			sampleFixed(y, i, 0),
+			sampleFixed(cb, i, 128*fixedOne),
+			sampleFixed(cr, i, 128*fixedOne),
+		)
+	}
+
+	return &Image{
+		W:   w,
+		H:   h,
+		Pix: pix,
+	}
+}
+
+// QuantizeLumaFixed is QuantizeLuma for Q4 fixed-point planes.
+func QuantizeLumaFixed(y, cb, cr int32) int32 {
+	p := ycbcrFixedToRgb(y, cb, cr)
+	return lumaFixedFromRGB(int32(p.R), int32(p.G), int32(p.B))
+}
+
+func lumaFixedFromRGB(r, g, b int32) int32 {
+	return (fixYR*r + fixYG*g + fixYB*b + 1<<(fixedCoefShift-1)) >> fixedCoefShift
+}
+
+func ycbcrFixedToRgb(yv, cbv, crv int32) Rgb {
+	const round = 1 << 15
+	cbd := int64(cbv - 128*fixedOne)
+	crd := int64(crv - 128*fixedOne)
+
+	r := int64(yv) + (fixRCr*crd+round)>>16
+	g := int64(yv) - (fixGCb*cbd+fixGCr*crd+round)>>16
+	b := int64(yv) + (fixBCb*cbd+round)>>16
+
+	return Rgb{
+		R: clampFixedToUint8(r),
+		G: clampFixedToUint8(g),
+		B: clampFixedToUint8(b),
+	}
+}
+
+func sampleFixed(ch []int32, i int, fallback int32) int32 {
+	if i < 0 || i >= len(ch) {
+		return fallback
+	}
+	return ch[i]
+}
+
+func clampFixedToUint8(v int64) uint8 {
+	v = (v + fixedOne/2) >> FixedFracBits
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,203 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Subsampling is the chroma layout of a YUV frame.
+type Subsampling int
+
+const (
+	Subsampling420 Subsampling = iota
+	Subsampling422
+	Subsampling444
+	SubsamplingMono
+)
+
+// YUVFrame is one 8-bit planar Y'CbCr frame. Cb and Cr are stored at chroma
+// resolution (see ChromaSize) and are empty for SubsamplingMono.
+type YUVFrame struct {
+	W           int
+	H           int
+	Subsampling Subsampling
+	Y           []uint8
+	Cb          []uint8
+	Cr          []uint8
+}
+
+// RawYUVLayout names a headerless planar YUV file layout.
+type RawYUVLayout string
+
+const (
+	// RawI420 is Y, then Cb, then Cr, chroma at half resolution both ways.
+	RawI420 RawYUVLayout = "i420"
+	// RawNV12 is Y, then one half-resolution plane of interleaved Cb, Cr.
+	RawNV12 RawYUVLayout = "nv12"
+)
+
+func ParseRawYUVLayout(s string) (RawYUVLayout, error) {
+	switch l := RawYUVLayout(strings.ToLower(s)); l {
+	case RawI420, RawNV12:
+		return l, nil
+	}
+	return "", fmt.Errorf("unknown raw YUV layout %q (expected i420 or nv12)", s)
+}
+
+func ChromaSize(w, h int, s Subsampling) (cw, ch int) {
+	switch s {
+	case Subsampling420:
+		return (w + 1) / 2, (h + 1) / 2
+	case Subsampling422:
+		return (w + 1) / 2, h
+	case Subsampling444:
+		return w, h
+	}
+	return 0, 0
+}
+
+func NewYUVFrame(w, h int, s Subsampling) (*YUVFrame, error) {
+	pixelCount, _, err := checkedImageSizes(w, h)
+	if err != nil {
+		return nil, err
+	}
+	cw, ch := ChromaSize(w, h, s)
+	return &YUVFrame{
+		W:           w,
+		H:           h,
+		Subsampling: s,
+		Y:           make([]uint8, pixelCount),
+		Cb:          make([]uint8, cw*ch),
+		Cr:          make([]uint8, cw*ch),
+	}, nil
+}
+
+func (f *YUVFrame) frameBytes() int {
+	cw, ch := ChromaSize(f.W, f.H, f.Subsampling)
+	return f.W*f.H + 2*cw*ch
+}
+
+func (f *YUVFrame) check() error {
+	if f == nil {
+		return errors.New("frame is nil")
+	}
+	cw, ch := ChromaSize(f.W, f.H, f.Subsampling)
+	if len(f.Y) != f.W*f.H || len(f.Cb) != cw*ch || len(f.Cr) != cw*ch {
+		return fmt.Errorf("plane sizes do not match %dx%d frame", f.W, f.H)
+	}
+	return nil
+}
+
+// ReadRawYUVFrame reads one frame of a headerless YUV file. It returns io.EOF
+// when r is exhausted before the frame starts and io.ErrUnexpectedEOF when it
+// ends inside one.
+func ReadRawYUVFrame(r io.Reader, w, h int, layout RawYUVLayout) (*YUVFrame, error) {
+	if _, err := ParseRawYUVLayout(string(layout)); err != nil {
+		return nil, err
+	}
+	f, err := NewYUVFrame(w, h, Subsampling420)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(r, f.Y); err != nil {
+		return nil, err
+	}
+	if layout == RawI420 {
+		if err := readFullFrame(r, f.Cb, f.Cr); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	uv := make([]uint8, 2*len(f.Cb))
+	if err := readFullFrame(r, uv); err != nil {
+		return nil, err
+	}
+	for i := range f.Cb {
+		f.Cb[i] = uv[2*i]
+		f.Cr[i] = uv[2*i+1]
+	}
+	return f, nil
+}
+
+func WriteRawYUVFrame(w io.Writer, f *YUVFrame, layout RawYUVLayout) error {
+	if _, err := ParseRawYUVLayout(string(layout)); err != nil {
+		return err
+	}
+	if err := f.check(); err != nil {
+		return err
+	}
+	if f.Subsampling != Subsampling420 {
+		return fmt.Errorf("raw %s needs 4:2:0 chroma", layout)
+	}
+
+	if _, err := w.Write(f.Y); err != nil {
+		return err
+	}
+	if layout == RawI420 {
+		if _, err := w.Write(f.Cb); err != nil {
+			return err
+		}
+		_, err := w.Write(f.Cr)
+		return err
+	}
+
+	uv := make([]uint8, 2*len(f.Cb))
+	for i := range f.Cb {
+		uv[2*i] = f.Cb[i]
+		uv[2*i+1] = f.Cr[i]
+	}
+	_, err := w.Write(uv)
+	return err
+}
+
+// YUVFrameFromImage converts img to a 4:2:0 frame with the full-range
+// matrix of RGBToYCbCr, each chroma sample the mean of its 2x2 block.
+func YUVFrameFromImage(img *Image) (*YUVFrame, error) {
+	if img == nil {
+		return nil, errors.New("image is nil")
+	}
+	f, err := NewYUVFrame(img.W, img.H, Subsampling420)
+	if err != nil {
+		return nil, err
+	}
+
+	y, cb, cr := RGBToYCbCr(img)
+	for i, v := range y {
+		f.Y[i] = clampFloatToUint8(v)
+	}
+	cw, ch := ChromaSize(img.W, img.H, Subsampling420)
+	for cy := 0; cy < ch; cy++ {
+		for cx := 0; cx < cw; cx++ {
+			var sumCb, sumCr float32
+			n := 0
+			for py := 2 * cy; py < min(2*cy+2, img.H); py++ {
+				for px := 2 * cx; px < min(2*cx+2, img.W); px++ {
+					sumCb += cb[py*img.W+px]
+					sumCr += cr[py*img.W+px]
+					n++
+				}
+			}
+			f.Cb[cy*cw+cx] = clampFloatToUint8(sumCb / float32(n))
+			f.Cr[cy*cw+cx] = clampFloatToUint8(sumCr / float32(n))
+		}
+	}
+	return f, nil
+}
+
+// readFullFrame reads the remaining planes of a frame whose first plane has
+// already been read, so any EOF here is a truncated frame.
+func readFullFrame(r io.Reader, planes ...[]uint8) error {
+	for _, p := range planes {
+		if _, err := io.ReadFull(r, p); err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -1,39 +1,21 @@
 package math
 
-func PadTo8(y []float32, w, h int) (y2 []float32, w2, h2 int) {
-	if w <= 0 || h <= 0 {
-		return nil, 0, 0
-	}
-
-	w2 = roundUp8(w)
-	h2 = roundUp8(h)
-	y2 = make([]float32, w2*h2)
-
-	for py := 0; py < h2; py++ {
-		srcY := py
-		if srcY >= h {
-			srcY = h - 1
-		}
-
-		for px := 0; px < w2; px++ {
-			srcX := px
-			if srcX >= w {
-				srcX = w - 1
-			}
-
-			y2[py*w2+px] = sampleAt(y, w, srcX, srcY)
-		}
-	}
+// Sample is the element type of a plane: float32 for the regular pipeline and
+// int32 for the fixed-point (bit-exact) one.
+type Sample interface {
+	~float32 | ~int32
+}
 
-	return y2, w2, h2
+func PadTo8(y []float32, w, h int) (y2 []float32, w2, h2 int) {
+	return PadToN(y, w, h, 8)
 }
 
-func Unpad(y2 []float32, w2, h2 int, w, h int) []float32 {
+func Unpad[T Sample](y2 []T, w2, h2 int, w, h int) []T {
 	if w <= 0 || h <= 0 {
 		return nil
 	}
 
-	out := make([]float32, w*h)
+	out := make([]T, w*h)
 	for py := 0; py < h; py++ {
 		for px := 0; px < w; px++ {
 			out[py*w+px] = sampleAtWH(y2, w2, h2, px, py)
@@ -76,11 +58,7 @@ func SetBlock8(y []float32, w, bx, by int, b [8][8]float32) {
 	}
 }
 
-func roundUp8(n int) int {
-	return ((n + 7) / 8) * 8
-}
-
-func sampleAt(y []float32, w, x, yy int) float32 {
+func sampleAt[T Sample](y []T, w, x, yy int) T {
 	if w <= 0 || x < 0 || yy < 0 {
 		return 0
 	}
@@ -93,7 +71,7 @@ func sampleAt(y []float32, w, x, yy int) float32 {
 	return y[idx]
 }
 
-func sampleAtWH(y []float32, w, h, x, yy int) float32 {
+func sampleAtWH[T Sample](y []T, w, h, x, yy int) T {
 	if w <= 0 || h <= 0 || x < 0 || yy < 0 || x >= w || yy >= h {
 		return 0
 	}
@@ -106,7 +84,7 @@ func sampleAtWH(y []float32, w, h, x, yy int) float32 {
 	return y[idx]
 }
 
-func writeAt(y []float32, w, x, yy int, v float32) {
+func writeAt[T Sample](y []T, w, x, yy int, v T) {
 	if w <= 0 || x < 0 || yy < 0 {
 		return
 	}
@@ -0,0 +1,70 @@
+package math
+
+// PadToN edge-replicates a plane up to the next multiple of n in both
+// directions. PadTo8 is PadToN with n = 8.
+func PadToN[T Sample](y []T, w, h, n int) (y2 []T, w2, h2 int) {
+	if w <= 0 || h <= 0 || n <= 0 {
+		return nil, 0, 0
+	}
+
+	w2 = roundUpN(w, n)
+	h2 = roundUpN(h, n)
+	y2 = make([]T, w2*h2)
+
+	for py := 0; py < h2; py++ {
+		srcY := py
+		if srcY >= h {
+			srcY = h - 1
+		}
+
+		for px := 0; px < w2; px++ {
+			srcX := px
+			if srcX >= w {
+				srcX = w - 1
+			}
+
+			y2[py*w2+px] = sampleAt(y, w, srcX, srcY)
+		}
+	}
+
+	return y2, w2, h2
+}
+
+// GetBlockN returns block (bx, by) of an n x n grid as a row-major slice of
+// n*n samples.
+func GetBlockN[T Sample](y []T, w, n, bx, by int) []T {
+	b := make([]T, n*n)
+	if w <= 0 || n <= 0 {
+		return b
+	}
+
+	x0 := bx * n
+	y0 := by * n
+
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			b[j*n+i] = sampleAt(y, w, x0+i, y0+j)
+		}
+	}
+
+	return b
+}
+
+func SetBlockN[T Sample](y []T, w, n, bx, by int, b []T) {
+	if w <= 0 || n <= 0 || len(b) < n*n {
+		return
+	}
+
+	x0 := bx * n
+	y0 := by * n
+
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			writeAt(y, w, x0+i, y0+j, b[j*n+i])
+		}
+	}
+}
+
+func roundUpN(v, n int) int {
+	return ((v + n - 1) / n) * n
+}
@@ -12,7 +12,9 @@ var (
 	dctCos = buildDCTCosTable()
 )
 
-func DCT8(block [8][8]float32) [8][8]float32 {
+// DCT8Ref is the direct O(n^4) definition of the 2-D DCT-II. It is kept as the
+// reference that the fast transform is checked against.
+func DCT8Ref(block [8][8]float32) [8][8]float32 {
 	var coeff [8][8]float32
 
 	for v := 0; v < dctSize; v++ {
@@ -30,7 +32,8 @@ func DCT8(block [8][8]float32) [8][8]float32 {
 	return coeff
 }
 
-func IDCT8(coeff [8][8]float32) [8][8]float32 {
+// IDCT8Ref is the direct O(n^4) inverse of DCT8Ref.
+func IDCT8Ref(coeff [8][8]float32) [8][8]float32 {
 	var block [8][8]float32
 
 	for y := 0; y < dctSize; y++ {
@@ -48,6 +51,22 @@ func IDCT8(coeff [8][8]float32) [8][8]float32 {
 	return block
 }
 
+// DCT8Coeff computes the single DCT8 coefficient (u, v) of a block, for
+// callers that only need a handful of coefficients and not the full transform.
+func DCT8Coeff(block [8][8]float32, u, v int) float32 {
+	if u < 0 || u >= dctSize || v < 0 || v >= dctSize {
+		return 0
+	}
+
+	sum := float32(0)
+	for y := 0; y < dctSize; y++ {
+		for x := 0; x < dctSize; x++ {
+			sum += block[y][x] * dctCos[u][x] * dctCos[v][y]
+		}
+	}
+	return 0.25 * dctAlpha[u] * dctAlpha[v] * sum
+}
+
 func buildDCTCosTable() [dctSize][dctSize]float32 {
 	var table [dctSize][dctSize]float32
 	for u := 0; u < dctSize; u++ {
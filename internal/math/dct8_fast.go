@@ -0,0 +1,232 @@
+package math
+
+import stdmath "math"
+
+// Fast 8x8 DCT using the Arai-Agui-Nakajima factorisation: each 1-D pass is
+// 5 multiplies and 29 adds, applied separably to rows and then columns. The
+// AAN butterflies produce outputs scaled by 2*cos(k*pi/16) (k > 0), so the
+// forward transform divides that back out together with the DCT8Ref
+// normalisation, and the inverse pre-scales its input the same way.
+var (
+	aanPostScale = buildAANPostScale()
+	aanPreScale  = buildAANPreScale()
+)
+
+func DCT8(block [8][8]float32) [8][8]float32 {
+	var tmp [8][8]float32
+	for y := 0; y < dctSize; y++ {
+		tmp[y] = aanForward1D(block[y])
+	}
+
+	var coeff [8][8]float32
+	for u := 0; u < dctSize; u++ {
+		var col [8]float32
+		for y := 0; y < dctSize; y++ {
+			col[y] = tmp[y][u]
+		}
+		col = aanForward1D(col)
+		for v := 0; v < dctSize; v++ {
+			coeff[v][u] = col[v] * aanPostScale[v][u]
+		}
+	}
+
+	return coeff
+}
+
+func IDCT8(coeff [8][8]float32) [8][8]float32 {
+	var tmp [8][8]float32
+	for u := 0; u < dctSize; u++ {
+		var col [8]float32
+		for v := 0; v < dctSize; v++ {
+			col[v] = coeff[v][u] * aanPreScale[v][u]
+		}
+		col = aanInverse1D(col)
+		for y := 0; y < dctSize; y++ {
+			tmp[y][u] = col[y]
+		}
+	}
+
+	var block [8][8]float32
+	for y := 0; y < dctSize; y++ {
+		block[y] = aanInverse1D(tmp[y])
+	}
+
+	return block
+}
+
+func aanForward1D(d [8]float32) [8]float32 {
+	tmp0 := d[0] + d[7]
+	tmp7 := d[0] - d[7]
+	tmp1 := d[1] + d[6]
+	tmp6 := d[1] - d[6]
+	tmp2 := d[2] + d[5]
+	tmp5 := d[2] - d[5]
+	tmp3 := d[3] + d[4]
+	tmp4 := d[3] - d[4]
+
+	var out [8]float32
+
+	// Even part.
+	tmp10 := tmp0 + tmp3
+	tmp13 := tmp0 - tmp3
+	tmp11 := tmp1 + tmp2
+	tmp12 := tmp1 - tmp2
+
+	out[0] = tmp10 + tmp11
+	out[4] = tmp10 - tmp11
+
+	z1 := (tmp12 + tmp13) * 0.707106781
+	out[2] = tmp13 + z1
+	out[6] = tmp13 - z1
+
+	// Odd part.
+	tmp10 = tmp4 + tmp5
+	tmp11 = tmp5 + tmp6
+	tmp12 = tmp6 + tmp7
+
+	z5 := (tmp10 - tmp12) * 0.382683433
+	z2 := 0.541196100*tmp10 + z5
+	z4 := 1.306562965*tmp12 + z5
+	z3 := tmp11 * 0.707106781
+
+	z11 := tmp7 + z3
+	z13 := tmp7 - z3
+
+	out[5] = z13 + z2
+	out[3] = z13 - z2
+	out[1] = z11 + z4
+	out[7] = z11 - z4
+
+	return out
+}
+
+func aanInverse1D(in [8]float32) [8]float32 {
+	// Even part.
+	tmp0 := in[0]
+	tmp1 := in[2]
+	tmp2 := in[4]
+	tmp3 := in[6]
+
+	tmp10 := tmp0 + tmp2
+	tmp11 := tmp0 - tmp2
+	tmp13 := tmp1 + tmp3
+	tmp12 := (tmp1-tmp3)*1.414213562 - tmp13
+
+	tmp0 = tmp10 + tmp13
+	tmp3 = tmp10 - tmp13
+	tmp1 = tmp11 + tmp12
+	tmp2 = tmp11 - tmp12
+
+	// Odd part.
+	tmp4 := in[1]
+	tmp5 := in[3]
+	tmp6 := in[5]
+	tmp7 := in[7]
+
+	z13 := tmp6 + tmp5
+	z10 := tmp6 - tmp5
+	z11 := tmp4 + tmp7
+	z12 := tmp4 - tmp7
+
+	tmp7 = z11 + z13
+	tmp11 = (z11 - z13) * 1.414213562
+
+	z5 := (z10 + z12) * 1.847759065
+	tmp10 = z5 - z12*1.082392200
+	tmp12 = z5 - z10*2.613125930
+
+	tmp6 = tmp12 - tmp7
+	tmp5 = tmp11 - tmp6
+	tmp4 = tmp10 - tmp5
+
+	return [8]float32{
+		tmp0 + tmp7,
+		tmp1 + tmp6,
+		tmp2 + tmp5,
+		tmp3 + tmp4,
+		tmp3 - tmp4,
+		tmp2 - tmp5,
+		tmp1 - tmp6,
+		tmp0 - tmp7,
+	}
+}
+
+func aanScale(k int) float64 {
+	if k == 0 {
+		return 1
+	}
+	return stdmath.Cos(float64(k) * stdmath.Pi / 16)
+}
+
+func buildAANPostScale() [dctSize][dctSize]float32 {
+	var table [dctSize][dctSize]float32
+	for v := 0; v < dctSize; v++ {
+		for u := 0; u < dctSize; u++ {
+			su := aanScale(u)
+			sv := aanScale(v)
+			if u > 0 {
+				su *= 2
+			}
+			if v > 0 {
+				sv *= 2
+			}
+			table[v][u] = float32(0.25 * float64(dctAlpha[u]) * float64(dctAlpha[v]) / (su * sv))
+		}
+	}
+	return table
+}
+
+func buildAANPreScale() [dctSize][dctSize]float32 {
+	var table [dctSize][dctSize]float32
+	for v := 0; v < dctSize; v++ {
+		for u := 0; u < dctSize; u++ {
+			table[v][u] = float32(0.25 * float64(dctAlpha[u]) * float64(dctAlpha[v]) * aanScale(u) * aanScale(v))
+		}
+	}
+	return table
+}
+
+// DCT8Plane transforms every 8x8 block of a plane whose width and height are
+// multiples of 8 (see PadTo8). Coefficient (u, v) of block (bx, by) is stored
+// at (bx*8+u, by*8+v), so the result has the same layout as the input.
+func DCT8Plane(src []float32, w, h int) []float32 {
+	if w <= 0 || h <= 0 || w%8 != 0 || h%8 != 0 || len(src) < w*h {
+		return nil
+	}
+
+	dst := make([]float32, w*h)
+	DCT8PlaneRows(dst, src, w, 0, h/8)
+	return dst
+}
+
+// IDCT8Plane is the inverse of DCT8Plane.
+func IDCT8Plane(coeff []float32, w, h int) []float32 {
+	if w <= 0 || h <= 0 || w%8 != 0 || h%8 != 0 || len(coeff) < w*h {
+		return nil
+	}
+
+	dst := make([]float32, w*h)
+	IDCT8PlaneRows(dst, coeff, w, 0, h/8)
+	return dst
+}
+
+// DCT8PlaneRows transforms block rows [by0, by1) of src into dst. Disjoint
+// row ranges touch disjoint memory, so callers may run them concurrently.
+func DCT8PlaneRows(dst, src []float32, w, by0, by1 int) {
+	blockCols := w / 8
+	for by := by0; by < by1; by++ {
+		for bx := 0; bx < blockCols; bx++ {
+			SetBlock8(dst, w, bx, by, DCT8(GetBlock8(src, w, bx, by)))
+		}
+	}
+}
+
+// IDCT8PlaneRows is the inverse of DCT8PlaneRows.
+func IDCT8PlaneRows(dst, coeff []float32, w, by0, by1 int) {
+	blockCols := w / 8
+	for by := by0; by < by1; by++ {
+		for bx := 0; bx < blockCols; bx++ {
+			SetBlock8(dst, w, bx, by, IDCT8(GetBlock8(coeff, w, bx, by)))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package math
+
+import (
+	stdmath "math"
+	"math/rand"
+	"testing"
+)
+
+// dctTolerance matches the dct-check command.
+const dctTolerance = 1e-3
+
+func maxDiff(a, b []float32) float32 {
+	d := float32(0)
+	for i := range a {
+		d = max(d, float32(stdmath.Abs(float64(a[i]-b[i]))))
+	}
+	return d
+}
+
+func maxBlockDiff(a, b [8][8]float32) float32 {
+	d := float32(0)
+	for y := range a {
+		d = max(d, maxDiff(a[y][:], b[y][:]))
+	}
+	return d
+}
+
+func randomBlock(rng *rand.Rand) [8][8]float32 {
+	var b [8][8]float32
+	for y := range b {
+		for x := range b[y] {
+			b[y][x] = rng.Float32() * 255
+		}
+	}
+	return b
+}
+
+func TestFastDCTMatchesReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 1000; n++ {
+		block := randomBlock(rng)
+		ref := DCT8Ref(block)
+		fast := DCT8(block)
+		if d := maxBlockDiff(fast, ref); d > dctTolerance {
+			t.Fatalf("block %d: forward differs from reference by %g", n, d)
+		}
+		if d := maxBlockDiff(IDCT8(ref), IDCT8Ref(ref)); d > dctTolerance {
+			t.Fatalf("block %d: inverse differs from reference by %g", n, d)
+		}
+		if d := maxBlockDiff(IDCT8(fast), block); d > dctTolerance {
+			t.Fatalf("block %d: fast round trip is off by %g", n, d)
+		}
+	}
+}
+
+func TestDCT8PlaneMatchesBlocks(t *testing.T) {
+	const w, h = 40, 24
+	rng := rand.New(rand.NewSource(1))
+	src := make([]float32, w*h)
+	for i := range src {
+		src[i] = rng.Float32() * 255
+	}
+
+	coeff := DCT8Plane(src, w, h)
+	for by := 0; by < h/8; by++ {
+		for bx := 0; bx < w/8; bx++ {
+			want := DCT8Ref(GetBlock8(src, w, bx, by))
+			if d := maxBlockDiff(GetBlock8(coeff, w, bx, by), want); d > dctTolerance {
+				t.Fatalf("block (%d, %d) differs from reference by %g", bx, by, d)
+			}
+		}
+	}
+	if d := maxDiff(IDCT8Plane(coeff, w, h), src); d > dctTolerance {
+		t.Errorf("plane round trip is off by %g", d)
+	}
+	if DCT8Plane(src, w-1, h) != nil {
+		t.Error("DCT8Plane accepted a width that is not a multiple of 8")
+	}
+}
@@ -0,0 +1,111 @@
+package math
+
+import (
+	stdmath "math"
+	"sync"
+)
+
+// Fixed-point DCT used by the bit-exact pipeline. Samples and coefficients
+// are int32 in the same fixed-point scale (callers pick it; the image package
+// uses Q4), the orthonormal basis is rounded once to Q14, and each separable
+// pass accumulates in int64 and rounds half up with an arithmetic shift.
+// Nothing here touches floating point after the basis is built, so results
+// are identical on every architecture.
+const dctIntBasisBits = 14
+
+type dctIntTable struct {
+	basis []int64 // basis[k*n+x]
+}
+
+var dctIntTables sync.Map // int -> *dctIntTable
+
+func dctIntTableN(n int) *dctIntTable {
+	if t, ok := dctIntTables.Load(n); ok {
+		return t.(*dctIntTable)
+	}
+
+	// Built in float64 from integer inputs and rounded to integers, so the
+	// table itself is the same everywhere.
+	t := &dctIntTable{basis: make([]int64, n*n)}
+	for k := 0; k < n; k++ {
+		s := stdmath.Sqrt(2 / float64(n))
+		if k == 0 {
+			s = stdmath.Sqrt(1 / float64(n))
+		}
+		for x := 0; x < n; x++ {
+			angle := float64((2*x+1)*k) * stdmath.Pi / float64(2*n)
+			t.basis[k*n+x] = int64(stdmath.Round(s * stdmath.Cos(angle) * (1 << dctIntBasisBits)))
+		}
+	}
+
+	actual, _ := dctIntTables.LoadOrStore(n, t)
+	return actual.(*dctIntTable)
+}
+
+func roundShiftBasis(v int64) int32 {
+	return int32((v + 1<<(dctIntBasisBits-1)) >> dctIntBasisBits)
+}
+
+// DCTNInt is the fixed-point counterpart of DCTN.
+func DCTNInt(block []int32, n int) []int32 {
+	if n <= 0 || len(block) < n*n {
+		return nil
+	}
+
+	t := dctIntTableN(n)
+	tmp := make([]int32, n*n)
+	for y := 0; y < n; y++ {
+		for u := 0; u < n; u++ {
+			acc := int64(0)
+			for x := 0; x < n; x++ {
+				acc += int64(block[y*n+x]) * t.basis[u*n+x]
+			}
+			tmp[y*n+u] = roundShiftBasis(acc)
+		}
+	}
+
+	coeff := make([]int32, n*n)
+	for v := 0; v < n; v++ {
+		for u := 0; u < n; u++ {
+			acc := int64(0)
+			for y := 0; y < n; y++ {
+				acc += int64(tmp[y*n+u]) * t.basis[v*n+y]
+			}
+			coeff[v*n+u] = roundShiftBasis(acc)
+		}
+	}
+
+	return coeff
+}
+
+// IDCTNInt is the fixed-point counterpart of IDCTN.
+func IDCTNInt(coeff []int32, n int) []int32 {
+	if n <= 0 || len(coeff) < n*n {
+		return nil
+	}
+
+	t := dctIntTableN(n)
+	tmp := make([]int32, n*n)
+	for v := 0; v < n; v++ {
+		for x := 0; x < n; x++ {
+			acc := int64(0)
+			for u := 0; u < n; u++ {
+				acc += int64(coeff[v*n+u]) * t.basis[u*n+x]
+			}
+			tmp[v*n+x] = roundShiftBasis(acc)
+		}
+	}
+
+	block := make([]int32, n*n)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			acc := int64(0)
+			for v := 0; v < n; v++ {
+				acc += int64(tmp[v*n+x]) * t.basis[v*n+y]
+			}
+			block[y*n+x] = roundShiftBasis(acc)
+		}
+	}
+
+	return block
+}
@@ -0,0 +1,170 @@
+package math
+
+import (
+	stdmath "math"
+	"sync"
+)
+
+// dctNTable holds the scaled basis c(k) * cos((2x+1)k*pi/2n) for one block
+// size, so the separable passes below need no normalisation of their own.
+type dctNTable struct {
+	n     int
+	basis []float32 // basis[k*n+x]
+}
+
+var dctNTables sync.Map // int -> *dctNTable
+
+func dctTableN(n int) *dctNTable {
+	if t, ok := dctNTables.Load(n); ok {
+		return t.(*dctNTable)
+	}
+
+	t := &dctNTable{n: n, basis: make([]float32, n*n)}
+	scale0 := stdmath.Sqrt(1 / float64(n))
+	scale := stdmath.Sqrt(2 / float64(n))
+	for k := 0; k < n; k++ {
+		s := scale
+		if k == 0 {
+			s = scale0
+		}
+		for x := 0; x < n; x++ {
+			angle := (2*float64(x) + 1) * float64(k) * stdmath.Pi / (2 * float64(n))
+			t.basis[k*n+x] = float32(s * stdmath.Cos(angle))
+		}
+	}
+
+	actual, _ := dctNTables.LoadOrStore(n, t)
+	return actual.(*dctNTable)
+}
+
+// DCTN is the orthonormal n x n DCT-II of a row-major block. For n = 8 it
+// matches DCT8 exactly (and uses the fast transform); other sizes use a
+// separable O(n^3) pass over a cached basis.
+func DCTN(block []float32, n int) []float32 {
+	if n <= 0 || len(block) < n*n {
+		return nil
+	}
+	if n == dctSize {
+		return fromBlock8(DCT8(toBlock8(block)))
+	}
+
+	t := dctTableN(n)
+	tmp := make([]float32, n*n)
+	for y := 0; y < n; y++ {
+		row := block[y*n : y*n+n]
+		for u := 0; u < n; u++ {
+			basis := t.basis[u*n : u*n+n]
+			sum := float32(0)
+			for x := 0; x < n; x++ {
+				sum += row[x] * basis[x]
+			}
+			tmp[y*n+u] = sum
+		}
+	}
+
+	coeff := make([]float32, n*n)
+	for v := 0; v < n; v++ {
+		basis := t.basis[v*n : v*n+n]
+		for u := 0; u < n; u++ {
+			sum := float32(0)
+			for y := 0; y < n; y++ {
+				sum += tmp[y*n+u] * basis[y]
+			}
+			coeff[v*n+u] = sum
+		}
+	}
+
+	return coeff
+}
+
+// IDCTN is the inverse of DCTN.
+func IDCTN(coeff []float32, n int) []float32 {
+	if n <= 0 || len(coeff) < n*n {
+		return nil
+	}
+	if n == dctSize {
+		return fromBlock8(IDCT8(toBlock8(coeff)))
+	}
+
+	t := dctTableN(n)
+	tmp := make([]float32, n*n)
+	for v := 0; v < n; v++ {
+		row := coeff[v*n : v*n+n]
+		for x := 0; x < n; x++ {
+			sum := float32(0)
+			for u := 0; u < n; u++ {
+				sum += row[u] * t.basis[u*n+x]
+			}
+			tmp[v*n+x] = sum
+		}
+	}
+
+	block := make([]float32, n*n)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			sum := float32(0)
+			for v := 0; v < n; v++ {
+				sum += tmp[v*n+x] * t.basis[v*n+y]
+			}
+			block[y*n+x] = sum
+		}
+	}
+
+	return block
+}
+
+// DCTNCoeff computes the single coefficient (u, v) of an n x n block.
+func DCTNCoeff(block []float32, n, u, v int) float32 {
+	if n <= 0 || len(block) < n*n || u < 0 || u >= n || v < 0 || v >= n {
+		return 0
+	}
+	if n == dctSize {
+		return DCT8Coeff(toBlock8(block), u, v)
+	}
+
+	t := dctTableN(n)
+	bu := t.basis[u*n : u*n+n]
+	bv := t.basis[v*n : v*n+n]
+	sum := float32(0)
+	for y := 0; y < n; y++ {
+		rowSum := float32(0)
+		for x := 0; x < n; x++ {
+			rowSum += block[y*n+x] * bu[x]
+		}
+		sum += rowSum * bv[y]
+	}
+	return sum
+}
+
+// DCTNPlaneRows transforms block rows [by0, by1) of an n x n grid, storing
+// coefficient (u, v) of block (bx, by) at (bx*n+u, by*n+v). Disjoint row
+// ranges touch disjoint memory, so callers may run them concurrently.
+func DCTNPlaneRows(dst, src []float32, w, n, by0, by1 int) {
+	if n == dctSize {
+		DCT8PlaneRows(dst, src, w, by0, by1)
+		return
+	}
+
+	blockCols := w / n
+	for by := by0; by < by1; by++ {
+		for bx := 0; bx < blockCols; bx++ {
+			SetBlockN(dst, w, n, bx, by, DCTN(GetBlockN(src, w, n, bx, by), n))
+		}
+	}
+}
+
+func toBlock8(b []float32) [8][8]float32 {
+	var out [8][8]float32
+	for y := 0; y < 8; y++ {
+		copy(out[y][:], b[y*8:y*8+8])
+	}
+	return out
+}
+
+func fromBlock8(b [8][8]float32) []float32 {
+	out := make([]float32, 64)
+	for y := 0; y < 8; y++ {
+		copy(out[y*8:y*8+8], b[y][:])
+	}
+	return out
+}
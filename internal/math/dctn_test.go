@@ -0,0 +1,42 @@
+package math
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestDCTNMatchesDCT8(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	block := randomBlock(rng)
+	var flat []float32
+	for y := range block {
+		flat = append(flat, block[y][:]...)
+	}
+	ref := DCT8Ref(block)
+	got := DCTN(flat, 8)
+	for v := 0; v < 8; v++ {
+		if d := maxDiff(got[v*8:(v+1)*8], ref[v][:]); d > dctTolerance {
+			t.Fatalf("row %d differs from DCT8Ref by %g", v, d)
+		}
+	}
+}
+
+func TestDCTNRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{8, 16, 32} {
+		src := make([]float32, n*n)
+		for i := range src {
+			src[i] = rng.Float32() * 255
+		}
+		coeff := DCTN(src, n)
+		if d := maxDiff(IDCTN(coeff, n), src); d > dctTolerance {
+			t.Errorf("DCT%d round trip is off by %g", n, d)
+		}
+		for _, uv := range [][2]int{{0, 0}, {1, 2}, {n - 1, n - 3}} {
+			u, v := uv[0], uv[1]
+			if d := DCTNCoeff(src, n, u, v) - coeff[v*n+u]; d > dctTolerance || d < -dctTolerance {
+				t.Errorf("DCT%d coefficient (%d, %d) differs from DCTN by %g", n, u, v, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package math
+
+// Gaussian pyramid steps with the binomial kernel 1 3 3 1 / 8, applied
+// separably. The even kernel centres each reduced sample between the two it
+// replaces, as box and bilinear thumbnailers do, rather than on one of them.
+// Edges replicate the outermost sample.
+
+// Reduce blurs a w x h plane and keeps every other sample, giving a
+// (w+1)/2 x (h+1)/2 plane.
+func Reduce(y []float32, w, h int) (out []float32, w2, h2 int) {
+	w2, h2 = (w+1)/2, (h+1)/2
+
+	rows := make([]float32, w2*h)
+	for yy := 0; yy < h; yy++ {
+		src := y[yy*w : (yy+1)*w]
+		for x := 0; x < w2; x++ {
+			rows[yy*w2+x] = reduceTaps(src, 2*x, w, 1)
+		}
+	}
+
+	out = make([]float32, w2*h2)
+	for x := 0; x < w2; x++ {
+		col := rows[x:]
+		for yy := 0; yy < h2; yy++ {
+			out[yy*w2+x] = reduceTaps(col, 2*yy, h, w2)
+		}
+	}
+	return out, w2, h2
+}
+
+// reduceTaps is the kernel centred between samples c and c+1 of the n samples
+// s[0], s[stride], s[2*stride], ...
+func reduceTaps(s []float32, c, n, stride int) float32 {
+	at := func(i int) float32 {
+		return s[min(max(i, 0), n-1)*stride]
+	}
+	return (at(c-1) + 3*at(c) + 3*at(c+1) + at(c+2)) / 8
+}
+
+// Expand is the inverse step of Reduce: it interpolates a w x h plane up to
+// W x H, which must be the size the plane was reduced from.
+func Expand(y []float32, w, h, W, H int) []float32 {
+	rows := make([]float32, W*h)
+	for yy := 0; yy < h; yy++ {
+		src := y[yy*w : (yy+1)*w]
+		for x := 0; x < W; x++ {
+			rows[yy*W+x] = expandTaps(src, x, w, 1)
+		}
+	}
+
+	out := make([]float32, W*H)
+	for x := 0; x < W; x++ {
+		col := rows[x:]
+		for yy := 0; yy < H; yy++ {
+			out[yy*W+x] = expandTaps(col, yy, h, W)
+		}
+	}
+	return out
+}
+
+// expandTaps interpolates position c of the upsampled signal: the kernel
+// over zero-stuffed samples leaves 1 3 / 4 towards the nearer neighbour.
+func expandTaps(s []float32, c, n, stride int) float32 {
+	at := func(i int) float32 {
+		return s[min(max(i, 0), n-1)*stride]
+	}
+	i := c / 2
+	if c%2 == 0 {
+		return (at(i-1) + 3*at(i)) / 4
+	}
+	return (3*at(i) + at(i+1)) / 4
+}
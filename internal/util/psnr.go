@@ -1,6 +1,10 @@
 package util
 
-import stdmath "math"
+import (
+	stdmath "math"
+
+	spectralimage "spectralmark/internal/image"
+)
 
 func PSNR(yA, yB []float32) float32 {
 	if len(yA) == 0 || len(yA) != len(yB) {
@@ -21,3 +25,12 @@ func PSNR(yA, yB []float32) float32 {
 	maxI := 255.0
 	return float32(10.0 * stdmath.Log10((maxI*maxI)/mse))
 }
+
+// PlanarPSNR is PSNR over the luma of two planar images of the same size, or
+// 0 if their sizes differ.
+func PlanarPSNR(a, b *spectralimage.Planar) float32 {
+	if a == nil || b == nil || a.W != b.W || a.H != b.H {
+		return 0
+	}
+	return PSNR(a.Packed().Y, b.Packed().Y)
+}
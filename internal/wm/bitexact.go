@@ -0,0 +1,127 @@
+package wm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// BitExactVector is one determinism test case for EmbedOptions.BitExact: a
+// synthetic cover image, embed parameters, and the SHA-256 of the RGB bytes
+// of the output. Every platform must reproduce Digest exactly.
+type BitExactVector struct {
+	Name       string
+	W          int
+	H          int
+	Seed       string
+	Key        string
+	Msg        string
+	Profile    string
+	Alpha      float32
+	ClosedLoop int
+	Digest     string
+}
+
+type BitExactResult struct {
+	Vector BitExactVector
+	Got    string
+	OK     bool
+}
+
+var bitExactVectors = []BitExactVector{
+	{
+		Name: "8x8-basic", W: 128, H: 96, Seed: "cover-a",
+		Key: "k1", Msg: "hello", Profile: "8x8", Alpha: 3,
+		Digest: "74df51a06c5cbee5b775298f17dafa331ae3875a9f7a92132c2d2b9cdff30aea",
+	},
+	{
+		Name: "8x8-odd-size", W: 101, H: 77, Seed: "cover-b",
+		Key: "secret", Msg: "odd", Profile: "8x8", Alpha: 2.5,
+		Digest: "99110d68cb85eb6e59d3671a088a62badc2d0646066ded9311f24267aae73465",
+	},
+	{
+		Name: "8x8-closed-loop", W: 96, H: 96, Seed: "cover-c",
+		Key: "loop", Msg: "closed", Profile: "8x8", Alpha: 4, ClosedLoop: 3,
+		Digest: "f0012d506c48c9911692fed451f25e5be2c506e116c7276b4d20b73cb4510f46",
+	},
+	{
+		Name: "16x16", W: 160, H: 128, Seed: "cover-d",
+		Key: "k16", Msg: "b16", Profile: "16x16", Alpha: 3,
+		Digest: "fd04b7e50716e3ab4a7a70668e642e499018edcbf4abc0c4fad0786fddc5e089",
+	},
+	{
+		Name: "32x32-closed-loop", W: 256, H: 192, Seed: "cover-e",
+		Key: "k32", Msg: "b32", Profile: "32x32", Alpha: 3, ClosedLoop: 2,
+		Digest: "32842144156f582d7d4d8fc49213aeecf84e0100d641b8b659b00e7cb8db829e",
+	},
+}
+
+func BitExactVectors() []BitExactVector {
+	return append([]BitExactVector(nil), bitExactVectors...)
+}
+
+// BitExactCover builds the synthetic cover image for a vector: smooth
+// gradients plus keyed noise, using integer arithmetic only.
+func BitExactCover(w, h int, seed string) *spectralimage.Image {
+	rng := NewPRNG(SeedFromKey(seed))
+	pix := make([]spectralimage.Rgb, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			noise := int(rng.NextU64()>>58) - 32
+			pix[y*w+x] = spectralimage.Rgb{
+				R: clampVectorSample(x*255/max(w-1, 1) + noise),
+				G: clampVectorSample(y*255/max(h-1, 1) - noise/2),
+				B: clampVectorSample((x+y)*255/max(w+h-2, 1) + noise/4),
+			}
+		}
+	}
+	return &spectralimage.Image{W: w, H: h, Pix: pix}
+}
+
+func RunBitExactVector(ctx context.Context, v BitExactVector) (string, error) {
+	out, _, err := EmbedImageOptions(ctx, BitExactCover(v.W, v.H, v.Seed), v.Key, v.Msg, EmbedOptions{
+		Alpha:                v.Alpha,
+		Profile:              v.Profile,
+		ClosedLoopIterations: v.ClosedLoop,
+		BitExact:             true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("vector %s: %w", v.Name, err)
+	}
+	return imageDigest(out), nil
+}
+
+func CheckBitExactVectors(ctx context.Context) ([]BitExactResult, error) {
+	results := make([]BitExactResult, 0, len(bitExactVectors))
+	for _, v := range bitExactVectors {
+		got, err := RunBitExactVector(ctx, v)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, BitExactResult{Vector: v, Got: got, OK: got == v.Digest})
+	}
+	return results, nil
+}
+
+func imageDigest(img *spectralimage.Image) string {
+	h := sha256.New()
+	buf := make([]byte, 0, 3*len(img.Pix))
+	for _, p := range img.Pix {
+		buf = append(buf, p.R, p.G, p.B)
+	}
+	h.Write(buf)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func clampVectorSample(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
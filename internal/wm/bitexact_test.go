@@ -0,0 +1,20 @@
+package wm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBitExactVectors(t *testing.T) {
+	for _, v := range BitExactVectors() {
+		t.Run(v.Name, func(t *testing.T) {
+			got, err := RunBitExactVector(context.Background(), v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != v.Digest {
+				t.Errorf("digest %s, want %s", got, v.Digest)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package wm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCancelledContext(t *testing.T) {
+	src := testCover(256, 192, "cancel")
+	marked, err := EmbedImage(src, "k", "HI", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := EmbedImageContext(ctx, src, "k", "HI", 3); !errors.Is(err, context.Canceled) {
+		t.Errorf("embed error = %v, want context.Canceled", err)
+	}
+	if _, _, _, _, err := DetectImageContext(ctx, marked, "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("detect error = %v, want context.Canceled", err)
+	}
+	// An off-grid copy goes through the grid phase search as well.
+	if _, _, _, _, err := DetectImageContext(ctx, cropFromPage(marked, 3, 5), "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("off-grid detect error = %v, want context.Canceled", err)
+	}
+}
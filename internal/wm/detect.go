@@ -1,6 +1,7 @@
 package wm
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"unicode"
@@ -13,6 +14,10 @@ import (
 const maxSyncStartScan = 64
 
 func DetectPPM(path, key string) (score float32, present bool, msg string, ok bool, err error) {
+	return DetectPPMContext(context.Background(), path, key)
+}
+
+func DetectPPMContext(ctx context.Context, path, key string) (score float32, present bool, msg string, ok bool, err error) {
 	if path == "" {
 		err = fmt.Errorf("input path is required")
 		return
@@ -24,10 +29,30 @@ func DetectPPM(path, key string) (score float32, present bool, msg string, ok bo
 		return
 	}
 
-	return DetectImage(img, key)
+	return DetectImageContext(ctx, img, key)
 }
 
 func DetectImage(img *spectralimage.Image, key string) (score float32, present bool, msg string, ok bool, err error) {
+	return DetectImageContext(context.Background(), img, key)
+}
+
+// DetectImageContext is DetectImage with cancellation: ctx is checked between
+// blocks, grid-phase candidates and bit-flip combinations, and its error is
+// returned as soon as it is noticed.
+func DetectImageContext(ctx context.Context, img *spectralimage.Image, key string) (score float32, present bool, msg string, ok bool, err error) {
+	return DetectImageOptions(ctx, img, key, DetectOptions{})
+}
+
+type DetectOptions struct {
+	// Profile restricts detection to one embedding profile. Empty tries every
+	// profile, default first, and stops at the first that decodes.
+	Profile string
+	// Mask is the region-of-interest mask the image was embedded with; see
+	// EmbedOptions.Mask.
+	Mask []uint8
+}
+
+func DetectImageOptions(ctx context.Context, img *spectralimage.Image, key string, opts DetectOptions) (score float32, present bool, msg string, ok bool, err error) {
 	if img == nil {
 		err = fmt.Errorf("image is nil")
 		return
@@ -37,109 +62,300 @@ func DetectImage(img *spectralimage.Image, key string) (score float32, present b
 		return
 	}
 
-	y, _, _ := spectralimage.RGBToYCbCr(img)
-	score, present, msg, ok = detectFromLuma(y, img.W, img.H, key)
-	if ok {
+	if img.Alpha != nil && len(img.Alpha) != len(img.Pix) {
+		err = fmt.Errorf("alpha length %d does not match %d pixels", len(img.Alpha), len(img.Pix))
 		return
 	}
 
-	maxOffsetX := 7
-	if img.W-1 < maxOffsetX {
-		maxOffsetX = img.W - 1
+	return detectLuma(ctx, spectralimage.RGBToLuma(img), img.Alpha, img.W, img.H, key, opts)
+}
+
+// DetectPlanar is DetectImageOptions for a planar image, read as it is with
+// no rounding.
+func DetectPlanar(ctx context.Context, p *spectralimage.Planar, key string, opts DetectOptions) (score float32, present bool, msg string, ok bool, err error) {
+	if p == nil {
+		err = fmt.Errorf("image is nil")
+		return
 	}
-	maxOffsetY := 7
-	if img.H-1 < maxOffsetY {
-		maxOffsetY = img.H - 1
+	if key == "" {
+		err = fmt.Errorf("key is required")
+		return
 	}
+	p = p.Packed()
+	if p.Alpha != nil && len(p.Alpha) != len(p.Y) {
+		err = fmt.Errorf("alpha length %d does not match %d pixels", len(p.Alpha), len(p.Y))
+		return
+	}
+	return detectLuma(ctx, p.Y, p.Alpha, p.W, p.H, key, opts)
+}
 
-	for oy := 0; oy <= maxOffsetY; oy++ {
-		for ox := 0; ox <= maxOffsetX; ox++ {
-			if ox == 0 && oy == 0 {
-				continue
+// DetectImage16Options is DetectImageOptions for 16-bit images.
+func DetectImage16Options(ctx context.Context, img *spectralimage.Image16, key string, opts DetectOptions) (score float32, present bool, msg string, ok bool, err error) {
+	if img == nil {
+		err = fmt.Errorf("image is nil")
+		return
+	}
+	if key == "" {
+		err = fmt.Errorf("key is required")
+		return
+	}
+	if img.Alpha != nil && len(img.Alpha) != len(img.Pix) {
+		err = fmt.Errorf("alpha length %d does not match %d pixels", len(img.Alpha), len(img.Pix))
+		return
+	}
+
+	y, _, _ := spectralimage.RGB16ToYCbCr(img)
+	return detectLuma(ctx, y, img.AlphaMask(), img.W, img.H, key, opts)
+}
+
+// DetectLuma8 detects on an 8-bit luma plane, such as the Y plane of a YUV
+// frame, without any colour conversion.
+func DetectLuma8(ctx context.Context, y []uint8, w, h int, key string, opts DetectOptions) (score float32, present bool, msg string, ok bool, err error) {
+	if len(y) != w*h || w <= 0 || h <= 0 {
+		err = fmt.Errorf("luma plane size %d does not match %dx%d", len(y), w, h)
+		return
+	}
+	if key == "" {
+		err = fmt.Errorf("key is required")
+		return
+	}
+	return detectLuma(ctx, spectralimage.LumaToFloat(y), nil, w, h, key, opts)
+}
+
+// DetectLuma16 is DetectLuma8 for a 16-bit plane.
+func DetectLuma16(ctx context.Context, y []uint16, w, h int, key string, opts DetectOptions) (score float32, present bool, msg string, ok bool, err error) {
+	if len(y) != w*h || w <= 0 || h <= 0 {
+		err = fmt.Errorf("luma plane size %d does not match %dx%d", len(y), w, h)
+		return
+	}
+	if key == "" {
+		err = fmt.Errorf("key is required")
+		return
+	}
+	return detectLuma(ctx, spectralimage.Luma16ToFloat(y), nil, w, h, key, opts)
+}
+
+func lookupDetectProfiles(name string) ([]Profile, error) {
+	if name == "" {
+		return profiles, nil
+	}
+	p, err := LookupProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return []Profile{p}, nil
+}
+
+// detectLuma decodes a luma plane. alpha and opts.Mask, if not nil, select
+// the usable blocks that carry slots as on the embedding side. If no profile
+// decodes on the native grid, the plane is reduced a pyramid level at a time
+// and tried again (see embedPyramid).
+func detectLuma(ctx context.Context, y []float32, alpha []uint8, w, h int, key string, opts DetectOptions) (score float32, present bool, msg string, ok bool, err error) {
+	candidates, err := lookupDetectProfiles(opts.Profile)
+	if err != nil {
+		return
+	}
+	if err = checkMask(opts.Mask, w, h); err != nil {
+		return
+	}
+	alpha = maskedAlpha(alpha, opts.Mask)
+
+	first := true
+	for level := 0; level <= MaxPyramidLevels; level++ {
+		if level > 0 {
+			alpha = reduceAlpha(alpha, w, h)
+			y, w, h = spectralmath.Reduce(y, w, h)
+			if min(w, h) < minPyramidSize {
+				return
 			}
+		}
 
-			yShift := shiftLuma(y, img.W, img.H, ox, oy)
-			candScore, candPresent, candMsg, candOK := detectFromLuma(yShift, img.W, img.H, key)
-			if betterDetectCandidate(candScore, candOK, score, ok) {
+		for _, p := range candidates {
+			candScore, candPresent, candMsg, candOK, candErr := detectProfile(ctx, y, alpha, w, h, key, p)
+			if candErr != nil {
+				err = candErr
+				return
+			}
+			if first || betterDetectCandidate(candScore, candOK, score, ok) {
 				score = candScore
 				present = candPresent
 				msg = candMsg
 				ok = candOK
 			}
+			first = false
+			if ok {
+				return
+			}
 		}
 	}
 
 	return
 }
 
-func detectFromLuma(y []float32, w, h int, key string) (score float32, present bool, msg string, ok bool) {
-	yPad, w2, h2 := spectralmath.PadTo8(y, w, h)
-	if w2 <= 0 || h2 <= 0 {
-		return 0, false, "", false
+// detectProfile decodes the luma plane with one profile, first on the native
+// block grid and then on the best-ranked shifted grid phases.
+func detectProfile(ctx context.Context, y []float32, alpha []uint8, w, h int, key string, profile Profile) (score float32, present bool, msg string, ok bool, err error) {
+	workers := Workers()
+	n := profile.BlockSize
+	score, present, msg, ok, err = detectFromLuma(ctx, y, w, h, key, profile, visibleBlocks(alpha, w, h, n, 0, 0), workers)
+	if err != nil || ok {
+		return
 	}
 
-	blockCols := w2 / 8
-	blockRows := h2 / 8
-	blockCount := blockCols * blockRows
-	if blockCount <= 0 {
-		return 0, false, "", false
+	maxOffsetX := profile.BlockSize - 1
+	if w-1 < maxOffsetX {
+		maxOffsetX = w - 1
 	}
-	totalSlots := blockCount * len(midFreqPositions)
-	if totalSlots < spreadChipsPerSymbol {
-		return 0, false, "", false
+	maxOffsetY := profile.BlockSize - 1
+	if h-1 < maxOffsetY {
+		maxOffsetY = h - 1
+	}
+
+	// Rank grid phases cheaply, then fully decode only the most likely ones.
+	phases, err := estimateGridPhases(ctx, y, alpha, w, h, key, profile, maxOffsetX, maxOffsetY, workers)
+	if err != nil {
+		return
+	}
+	candidates := make([]gridPhase, 0, gridPhaseCandidates)
+	for _, p := range phases {
+		if p.ox == 0 && p.oy == 0 {
+			continue
+		}
+		candidates = append(candidates, p)
+		if len(candidates) == gridPhaseCandidates {
+			break
+		}
+	}
+
+	type shiftResult struct {
+		score   float32
+		present bool
+		msg     string
+		ok      bool
+		err     error
+	}
+
+	// Candidates are decoded in parallel, each on a single worker, and then
+	// reduced in rank order so the result does not depend on scheduling.
+	results := make([]shiftResult, len(candidates))
+	err = parallelFor(ctx, len(candidates), workers, func(i int) {
+		ox, oy := candidates[i].ox, candidates[i].oy
+		r := &results[i]
+		r.score, r.present, r.msg, r.ok, r.err = detectFromLuma(ctx, shiftLuma(y, w, h, ox, oy), w, h, key, profile, visibleBlocks(alpha, w, h, n, ox, oy), 1)
+	})
+	if err != nil {
+		return
 	}
 
-	coeffVals := make([][]float32, blockCount)
-	for blockIdx := 0; blockIdx < blockCount; blockIdx++ {
-		bx := blockIdx % blockCols
-		by := blockIdx / blockCols
+	for _, cand := range results {
+		if cand.err != nil {
+			err = cand.err
+			return
+		}
+		if betterDetectCandidate(cand.score, cand.ok, score, ok) {
+			score = cand.score
+			present = cand.present
+			msg = cand.msg
+			ok = cand.ok
+		}
+	}
+
+	return
+}
 
-		block := spectralmath.GetBlock8(yPad, w2, bx, by)
-		coeff := spectralmath.DCT8(block)
+func detectFromLuma(ctx context.Context, y []float32, w, h int, key string, profile Profile, blocks []int, workers int) (score float32, present bool, msg string, ok bool, err error) {
+	symbolSoft, err := symbolSoftFromLuma(ctx, y, w, h, key, profile, blocks, workers)
+	if err != nil || len(symbolSoft) == 0 {
+		return 0, false, "", false, err
+	}
 
-		row := make([]float32, len(midFreqPositions))
-		for i, pos := range midFreqPositions {
-			row[i] = coeff[pos.v][pos.u]
+	symbols := make([]int8, len(symbolSoft))
+	for i, soft := range symbolSoft {
+		if soft >= 0 {
+			symbols[i] = 1
+		} else {
+			symbols[i] = -1
 		}
-		coeffVals[blockIdx] = row
+	}
+
+	poll := newCancelPoll(ctx)
+	msg, ok = decodePayloadFromSymbolSoft(symbolSoft, 2, 10, poll)
+	if poll.err != nil {
+		return 0, false, "", false, poll.err
+	}
+	score = estimateDetectScoreSymbols(symbols, msg, ok)
+	present = ok
+	return
+}
+
+// symbolSoftFromLuma transforms the luma plane and returns the chip-weighted
+// correlation for every symbol the image can hold under profile.scheme, in
+// keyed slot order over blocks (nil for all).
+func symbolSoftFromLuma(ctx context.Context, y []float32, w, h int, key string, profile Profile, blocks []int, workers int) ([]float32, error) {
+	plane, err := coeffPlaneFromLuma(ctx, y, w, h, profile.BlockSize, workers)
+	if err != nil || plane == nil {
+		return nil, err
+	}
+	return plane.symbolSoft(key, profile, blocks), nil
+}
+
+// coeffPlane is a padded luma plane with every n x n block replaced by its
+// DCT, laid out as DCTNPlaneRows writes it.
+type coeffPlane struct {
+	coeff []float32
+	w2    int
+	h2    int
+	n     int
+}
+
+func coeffPlaneFromLuma(ctx context.Context, y []float32, w, h, n int, workers int) (*coeffPlane, error) {
+	yPad, w2, h2 := spectralmath.PadToN(y, w, h, n)
+	if w2 <= 0 || h2 <= 0 {
+		return nil, nil
+	}
+
+	coeff := make([]float32, w2*h2)
+	err := parallelFor(ctx, h2/n, workers, func(by int) {
+		spectralmath.DCTNPlaneRows(coeff, yPad, w2, n, by, by+1)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &coeffPlane{coeff: coeff, w2: w2, h2: h2, n: n}, nil
+}
+
+// symbolSoft reads every symbol the plane can hold under key and
+// profile.scheme, laid over blocks (nil for all). profile.BlockSize must
+// match the plane.
+func (p *coeffPlane) symbolSoft(key string, profile Profile, blocks []int) []float32 {
+	blockCols := p.w2 / p.n
+	layout := newSlotLayout(profile, blockCols*(p.h2/p.n), blocks)
+	totalSlots := layout.totalSlots()
+	if totalSlots < spreadChipsPerSymbol {
+		return nil
 	}
 
 	symbolCount := totalSlots / spreadChipsPerSymbol
 	neededSlots := symbolCount * spreadChipsPerSymbol
-	slots, chips := shuffledSlotsAndChips(key, totalSlots, neededSlots)
+	slots, chips := shuffledSlotsAndChips(profile.scheme, key, totalSlots, neededSlots)
 	if len(slots) != neededSlots || len(chips) != neededSlots {
-		return 0, false, "", false
+		return nil
 	}
 
 	symbolSoft := make([]float32, symbolCount)
-	symbols := make([]int8, symbolCount)
 	for symIdx := 0; symIdx < symbolCount; symIdx++ {
 		soft := float32(0)
 		base := symIdx * spreadChipsPerSymbol
 		for j := 0; j < spreadChipsPerSymbol; j++ {
 			slotIdx := base + j
-			slot := slots[slotIdx]
-			blockIdx := slot / len(midFreqPositions)
-			coeffIdx := slot % len(midFreqPositions)
+			blockIdx, coeffIdx := layout.locate(slots[slotIdx])
+			coeffAt := profile.coeffIndex(blockIdx, coeffIdx, blockCols, p.w2)
 
-			soft += coeffVals[blockIdx][coeffIdx] * float32(chips[slotIdx])
+			soft += p.coeff[coeffAt] * float32(chips[slotIdx])
 		}
 		symbolSoft[symIdx] = soft
-		if soft >= 0 {
-			symbols[symIdx] = 1
-		} else {
-			symbols[symIdx] = -1
-		}
 	}
-
-	if len(symbols) == 0 {
-		return 0, false, "", false
-	}
-
-	msg, ok = decodePayloadFromSymbolSoft(symbolSoft, 2, 10)
-	score = estimateDetectScoreSymbols(symbols, msg, ok)
-	present = ok
-	return
+	return symbolSoft
 }
 
 func shiftLuma(y []float32, w, h, ox, oy int) []float32 {
@@ -176,7 +392,7 @@ func betterDetectCandidate(candScore float32, candOK bool, bestScore float32, be
 	return candScore > bestScore
 }
 
-func decodePayloadFromSymbolSoft(symbolSoft []float32, maxSyncErrors int, maxDataCandidates int) (msg string, ok bool) {
+func decodePayloadFromSymbolSoft(symbolSoft []float32, maxSyncErrors int, maxDataCandidates int, poll *cancelPoll) (msg string, ok bool) {
 	rawBits, rawConf := repetitionSoftToRaw(symbolSoft)
 	if len(rawBits) < 48 {
 		return "", false
@@ -188,7 +404,7 @@ func decodePayloadFromSymbolSoft(symbolSoft []float32, maxSyncErrors int, maxDat
 	}
 
 	// Second pass: flip low-confidence data bits (not sync/len/crc), then re-check CRC.
-	return decodeRawBitsWithBitFixes(rawBits, rawConf, maxSyncErrors, maxDataCandidates)
+	return decodeRawBitsWithBitFixes(rawBits, rawConf, maxSyncErrors, maxDataCandidates, poll)
 }
 
 func repetitionSoftToRaw(symbolSoft []float32) (rawBits []uint8, rawConf []float32) {
@@ -263,7 +479,7 @@ func decodeRawBitsDirect(rawBits []uint8, maxSyncErrors int) (msg string, ok boo
 	return "", false
 }
 
-func decodeRawBitsWithBitFixes(rawBits []uint8, rawConf []float32, maxSyncErrors int, maxDataCandidates int) (msg string, ok bool) {
+func decodeRawBitsWithBitFixes(rawBits []uint8, rawConf []float32, maxSyncErrors int, maxDataCandidates int, poll *cancelPoll) (msg string, ok bool) {
 	if len(rawBits) < 48 {
 		return "", false
 	}
@@ -322,9 +538,12 @@ func decodeRawBitsWithBitFixes(rawBits []uint8, rawConf []float32, maxSyncErrors
 				maxFlips = 5
 			}
 
-			if msg, ok := tryDecodeWithBitFlips(rawBits, dataBitStart, msgLen, gotCRC, candidateIdx, maxFlips); ok {
+			if msg, ok := tryDecodeWithBitFlips(rawBits, dataBitStart, msgLen, gotCRC, candidateIdx, maxFlips, poll); ok {
 				return msg, true
 			}
+			if poll.stop() {
+				return "", false
+			}
 
 		}
 	}
@@ -332,7 +551,7 @@ func decodeRawBitsWithBitFixes(rawBits []uint8, rawConf []float32, maxSyncErrors
 	return "", false
 }
 
-func tryDecodeWithBitFlips(rawBits []uint8, dataBitStart, msgLen int, gotCRC uint16, candidateIdx []int, maxFlips int) (string, bool) {
+func tryDecodeWithBitFlips(rawBits []uint8, dataBitStart, msgLen int, gotCRC uint16, candidateIdx []int, maxFlips int, poll *cancelPoll) (string, bool) {
 	if len(candidateIdx) == 0 || maxFlips <= 0 {
 		return "", false
 	}
@@ -344,7 +563,7 @@ func tryDecodeWithBitFlips(rawBits []uint8, dataBitStart, msgLen int, gotCRC uin
 	copy(testBits, rawBits)
 
 	for flips := 1; flips <= maxFlips; flips++ {
-		if msg, ok := searchFlipCombinations(testBits, candidateIdx, 0, flips, dataBitStart, msgLen, gotCRC); ok {
+		if msg, ok := searchFlipCombinations(testBits, candidateIdx, 0, flips, dataBitStart, msgLen, gotCRC, poll); ok {
 			return msg, true
 		}
 	}
@@ -352,8 +571,11 @@ func tryDecodeWithBitFlips(rawBits []uint8, dataBitStart, msgLen int, gotCRC uin
 	return "", false
 }
 
-func searchFlipCombinations(bits []uint8, candidateIdx []int, start, flipsLeft, dataBitStart, msgLen int, gotCRC uint16) (string, bool) {
+func searchFlipCombinations(bits []uint8, candidateIdx []int, start, flipsLeft, dataBitStart, msgLen int, gotCRC uint16, poll *cancelPoll) (string, bool) {
 	if flipsLeft == 0 {
+		if poll.stop() {
+			return "", false
+		}
 		data := make([]byte, msgLen)
 		for i := 0; i < msgLen; i++ {
 			data[i] = readByteAtBit(bits, dataBitStart+i*8)
@@ -371,10 +593,13 @@ func searchFlipCombinations(bits []uint8, candidateIdx []int, start, flipsLeft,
 	for i := start; i <= limit; i++ {
 		idx := candidateIdx[i]
 		bits[idx] ^= 1
-		if msg, ok := searchFlipCombinations(bits, candidateIdx, i+1, flipsLeft-1, dataBitStart, msgLen, gotCRC); ok {
+		if msg, ok := searchFlipCombinations(bits, candidateIdx, i+1, flipsLeft-1, dataBitStart, msgLen, gotCRC, poll); ok {
 			return msg, true
 		}
 		bits[idx] ^= 1
+		if poll.stop() {
+			return "", false
+		}
 	}
 
 	return "", false
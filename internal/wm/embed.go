@@ -1,12 +1,84 @@
 package wm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	spectralimage "spectralmark/internal/image"
 	spectralmath "spectralmark/internal/math"
 )
 
+// ErrNoCapacity means the image has too few usable blocks for the payload.
+var ErrNoCapacity = errors.New("payload too large for image")
+
+type embedOp struct {
+	slotIdx   int
+	coeffIdx  int
+	direction int8
+}
+
+// embedPlan is the keyed slot assignment for one image size and profile:
+// for every block, which of its coefficients carry which payload slot and in
+// which direction.
+type embedPlan struct {
+	blockCols   int
+	blockRows   int
+	blockCount  int
+	neededSlots int
+	blockOps    [][]embedOp
+}
+
+// planEmbed lays the keyed slots over blocks, which lists the usable blocks
+// (see visibleBlocks); nil uses every block.
+func planEmbed(profile Profile, key string, bits []int8, w, h int, blocks []int) (*embedPlan, error) {
+	n := profile.BlockSize
+
+	blockCols := (w + n - 1) / n
+	blockRows := (h + n - 1) / n
+	blockCount := blockCols * blockRows
+	layout := newSlotLayout(profile, blockCount, blocks)
+	totalSlots := layout.totalSlots()
+	neededSlots := len(bits) * spreadChipsPerSymbol
+	if neededSlots > totalSlots {
+		maxSymbols := totalSlots / spreadChipsPerSymbol
+		return nil, fmt.Errorf(
+			"%w: payload symbols=%d capacity=%d (spread=%d, profile=%s%s)",
+			ErrNoCapacity,
+			len(bits),
+			maxSymbols,
+			spreadChipsPerSymbol,
+			profile.Name,
+			layout.describe(),
+		)
+	}
+
+	slots, chips := shuffledSlotsAndChips(profile.scheme, key, totalSlots, neededSlots)
+	if len(slots) != neededSlots || len(chips) != neededSlots {
+		return nil, fmt.Errorf("failed to allocate spread mapping")
+	}
+
+	blockOps := make([][]embedOp, blockCount)
+	for i := 0; i < neededSlots; i++ {
+		symbolIdx := i / spreadChipsPerSymbol
+		blockIdx, coeffIdx := layout.locate(slots[i])
+
+		blockOps[blockIdx] = append(blockOps[blockIdx], embedOp{
+			slotIdx:   i,
+			coeffIdx:  coeffIdx,
+			direction: bits[symbolIdx] * chips[i],
+		})
+	}
+
+	return &embedPlan{
+		blockCols:   blockCols,
+		blockRows:   blockRows,
+		blockCount:  blockCount,
+		neededSlots: neededSlots,
+		blockOps:    blockOps,
+	}, nil
+}
+
 type coeffPos struct {
 	u int
 	v int
@@ -22,120 +94,422 @@ var midFreqPositions = [...]coeffPos{
 }
 
 func EmbedPPM(inPath, outPath, key, msg string, alpha float32) error {
+	return EmbedPPMContext(context.Background(), inPath, outPath, key, msg, alpha)
+}
+
+func EmbedPPMContext(ctx context.Context, inPath, outPath, key, msg string, alpha float32) error {
+	_, err := EmbedPPMOptions(ctx, inPath, outPath, key, msg, EmbedOptions{Alpha: alpha})
+	return err
+}
+
+func EmbedPPMOptions(ctx context.Context, inPath, outPath, key, msg string, opts EmbedOptions) (*EmbedReport, error) {
 	if inPath == "" {
-		return fmt.Errorf("input path is required")
+		return nil, fmt.Errorf("input path is required")
 	}
 	if outPath == "" {
-		return fmt.Errorf("output path is required")
+		return nil, fmt.Errorf("output path is required")
 	}
 
 	img, err := spectralimage.ReadPPM(inPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	outImg, err := EmbedImage(img, key, msg, alpha)
+	outImg, report, err := EmbedImageOptions(ctx, img, key, msg, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return spectralimage.WritePPM(outPath, outImg)
+	return report, spectralimage.WritePPM(outPath, outImg)
 }
 
 func EmbedImage(img *spectralimage.Image, key, msg string, alpha float32) (*spectralimage.Image, error) {
+	return EmbedImageContext(context.Background(), img, key, msg, alpha)
+}
+
+// EmbedImageContext is EmbedImage with cancellation checked between blocks.
+func EmbedImageContext(ctx context.Context, img *spectralimage.Image, key, msg string, alpha float32) (*spectralimage.Image, error) {
+	outImg, _, err := EmbedImageOptions(ctx, img, key, msg, EmbedOptions{Alpha: alpha})
+	return outImg, err
+}
+
+// EmbedImageOptions embeds msg under key and reports the margin every slot
+// actually achieved in the 8-bit output. With opts.ClosedLoopIterations > 0,
+// each modified block is quantized to RGB8 and re-measured, and slots that
+// rounding or clamping pushed below the target are topped up and retried.
+// Alpha is passed through, and fully transparent blocks carry no slots.
+func EmbedImageOptions(ctx context.Context, img *spectralimage.Image, key, msg string, opts EmbedOptions) (*spectralimage.Image, *EmbedReport, error) {
+	profile, err := checkEmbedArgs(img, key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return embedSymbols(ctx, img, key, EncodePayload(msg), opts, profile)
+}
+
+// EmbedLuma8 embeds into an 8-bit luma plane, such as the Y plane of a YUV
+// frame. There is no colour conversion: the closed loop models plain 8-bit
+// rounding, and chroma is the caller's to keep.
+func EmbedLuma8(ctx context.Context, y []uint8, w, h int, key, msg string, opts EmbedOptions) ([]uint8, *EmbedReport, error) {
+	if len(y) != w*h || w <= 0 || h <= 0 {
+		return nil, nil, fmt.Errorf("luma plane size %d does not match %dx%d", len(y), w, h)
+	}
+	profile, err := checkEmbedOptions(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return embedLuma8Symbols(ctx, y, w, h, key, EncodePayload(msg), opts, profile)
+}
+
+func embedLuma8Symbols(ctx context.Context, y []uint8, w, h int, key string, bits []int8, opts EmbedOptions, profile Profile) ([]uint8, *EmbedReport, error) {
+	blocks, err := usableBlocks(nil, opts.Mask, w, h, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planEmbed(profile, key, bits, w, h, blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.BitExact {
+		out, report, err := embedLumaPlanFixed(ctx, spectralimage.LumaToFixed(y), w, h, plan, profile, opts, func(v int32, _ int) int32 {
+			return spectralimage.QuantizeSampleFixed(v)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return spectralimage.LumaFromFixed(out), report, nil
+	}
+
+	yf, err := embedPyramid(ctx, spectralimage.LumaToFloat(y), nil, w, h, key, bits, profile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, report, err := embedLumaPlan(ctx, yf, w, h, plan, profile, opts, func(v float32, _ int) float32 {
+		return spectralimage.QuantizeSample(v)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return spectralimage.LumaFromFloat(out), report, nil
+}
+
+// EmbedLuma16 is EmbedLuma8 for a 16-bit plane. Embedding works on the
+// 8-bit scale, so alpha means the same as for 8-bit input; the closed loop
+// models 16-bit rounding. Bit-exact mode is not available for 16-bit samples.
+func EmbedLuma16(ctx context.Context, y []uint16, w, h int, key, msg string, opts EmbedOptions) ([]uint16, *EmbedReport, error) {
+	if len(y) != w*h || w <= 0 || h <= 0 {
+		return nil, nil, fmt.Errorf("luma plane size %d does not match %dx%d", len(y), w, h)
+	}
+	profile, err := checkEmbedOptions(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.BitExact {
+		return nil, nil, fmt.Errorf("bit-exact mode needs 8-bit samples")
+	}
+
+	blocks, err := usableBlocks(nil, opts.Mask, w, h, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	bits := EncodePayload(msg)
+	plan, err := planEmbed(profile, key, bits, w, h, blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+	yf, err := embedPyramid(ctx, spectralimage.Luma16ToFloat(y), nil, w, h, key, bits, profile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, report, err := embedLumaPlan(ctx, yf, w, h, plan, profile, opts, func(v float32, _ int) float32 {
+		return spectralimage.QuantizeSample16(v)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return spectralimage.Luma16FromFloat(out), report, nil
+}
+
+func checkEmbedArgs(img *spectralimage.Image, key string, opts EmbedOptions) (Profile, error) {
 	if img == nil {
-		return nil, fmt.Errorf("image is nil")
+		return Profile{}, fmt.Errorf("image is nil")
+	}
+	if img.Alpha != nil && len(img.Alpha) != len(img.Pix) {
+		return Profile{}, fmt.Errorf("alpha length %d does not match %d pixels", len(img.Alpha), len(img.Pix))
 	}
+	return checkEmbedOptions(key, opts)
+}
+
+func checkEmbedOptions(key string, opts EmbedOptions) (Profile, error) {
 	if key == "" {
-		return nil, fmt.Errorf("key is required")
+		return Profile{}, fmt.Errorf("key is required")
+	}
+	if opts.Alpha <= 0 {
+		return Profile{}, fmt.Errorf("alpha must be > 0")
+	}
+	if opts.ClosedLoopIterations < 0 {
+		return Profile{}, fmt.Errorf("closed-loop iterations must be >= 0")
+	}
+	if opts.PyramidLevels < 0 || opts.PyramidLevels > MaxPyramidLevels {
+		return Profile{}, fmt.Errorf("pyramid levels must be between 0 and %d", MaxPyramidLevels)
 	}
-	if alpha <= 0 {
-		return nil, fmt.Errorf("alpha must be > 0")
+	if opts.PyramidLevels > 0 && opts.BitExact {
+		return Profile{}, fmt.Errorf("pyramid levels are not available in bit-exact mode")
+	}
+	return LookupProfile(opts.Profile)
+}
+
+// embedSymbols marks one ±1 symbol per keyed slot of profile.scheme. Options
+// must already be validated.
+func embedSymbols(ctx context.Context, img *spectralimage.Image, key string, bits []int8, opts EmbedOptions, profile Profile) (*spectralimage.Image, *EmbedReport, error) {
+	if opts.BitExact {
+		return embedImageFixed(ctx, img, key, bits, opts, profile)
 	}
 
-	y, cb, cr := spectralimage.RGBToYCbCr(img)
-	yPad, w2, h2 := spectralmath.PadTo8(y, img.W, img.H)
+	blocks, err := usableBlocks(img.Alpha, opts.Mask, img.W, img.H, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planEmbed(profile, key, bits, img.W, img.H, blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, err := embedPlanarPyramid(ctx, spectralimage.PlanarFromImage(img), key, bits, profile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	out, report, err := embedPlanar(ctx, p, plan, profile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Image(), report, nil
+}
 
+// EmbedPlanar is EmbedImageOptions for a planar image. The result is not
+// rounded, so it can go on to further stages as it is; the closed loop still
+// models the 8-bit RGB it will be stored as. Bit-exact mode goes through
+// 8-bit RGB, since its arithmetic is defined on it.
+func EmbedPlanar(ctx context.Context, p *spectralimage.Planar, key, msg string, opts EmbedOptions) (*spectralimage.Planar, *EmbedReport, error) {
+	if p == nil {
+		return nil, nil, fmt.Errorf("image is nil")
+	}
+	if p.Alpha != nil && len(p.Alpha) != len(p.Y) {
+		return nil, nil, fmt.Errorf("alpha length %d does not match %d pixels", len(p.Alpha), len(p.Y))
+	}
+	profile, err := checkEmbedOptions(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
 	bits := EncodePayload(msg)
-	blockCols := w2 / 8
-	blockRows := h2 / 8
-	blockCount := blockCols * blockRows
-	totalSlots := blockCount * len(midFreqPositions)
-	neededSlots := len(bits) * spreadChipsPerSymbol
-	if neededSlots > totalSlots {
-		maxSymbols := totalSlots / spreadChipsPerSymbol
-		return nil, fmt.Errorf(
-			"payload too large for image: payload symbols=%d capacity=%d (spread=%d)",
-			len(bits),
-			maxSymbols,
-			spreadChipsPerSymbol,
-		)
+	if opts.BitExact {
+		out, report, err := embedImageFixed(ctx, p.Image(), key, bits, opts, profile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return spectralimage.PlanarFromImage(out), report, nil
 	}
 
-	slots, chips := shuffledSlotsAndChips(key, totalSlots, neededSlots)
-	if len(slots) != neededSlots || len(chips) != neededSlots {
-		return nil, fmt.Errorf("failed to allocate spread mapping")
+	p = p.Packed()
+	blocks, err := usableBlocks(p.Alpha, opts.Mask, p.W, p.H, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planEmbed(profile, key, bits, p.W, p.H, blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p, err = embedPlanarPyramid(ctx, p, key, bits, profile, opts); err != nil {
+		return nil, nil, err
 	}
+	return embedPlanar(ctx, p, plan, profile, opts)
+}
 
-	type embedOp struct {
-		coeffIdx  int
-		direction float32
+// embedPlanarPyramid is embedPyramid on the luma of a packed p. It returns
+// p itself when there are no levels to mark.
+func embedPlanarPyramid(ctx context.Context, p *spectralimage.Planar, key string, bits []int8, profile Profile, opts EmbedOptions) (*spectralimage.Planar, error) {
+	if opts.PyramidLevels == 0 {
+		return p, nil
 	}
-	blockOps := make([][]embedOp, blockCount)
+	y, err := embedPyramid(ctx, p.Y, p.Alpha, p.W, p.H, key, bits, profile, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := *p
+	out.Y = y
+	return &out, nil
+}
 
-	for i := 0; i < neededSlots; i++ {
-		symbolIdx := i / spreadChipsPerSymbol
-		slot := slots[i]
-		blockIdx := slot / len(midFreqPositions)
-		coeffIdx := slot % len(midFreqPositions)
+// embedPlanar marks the luma of a packed p, leaving p itself unchanged.
+func embedPlanar(ctx context.Context, p *spectralimage.Planar, plan *embedPlan, profile Profile, opts EmbedOptions) (*spectralimage.Planar, *EmbedReport, error) {
+	cb, cr := p.Cb, p.Cr
+	y, report, err := embedLumaPlan(ctx, p.Y, p.W, p.H, plan, profile, opts, func(v float32, idx int) float32 {
+		return spectralimage.QuantizeLuma(v, cb[idx], cr[idx])
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	out := p.Clone()
+	out.Y = y
+	return out, report, nil
+}
 
-		direction := float32(bits[symbolIdx] * chips[i])
-		blockOps[blockIdx] = append(blockOps[blockIdx], embedOp{
-			coeffIdx:  coeffIdx,
-			direction: direction,
-		})
+// EmbedImage16Options is EmbedImageOptions for 16-bit images. The luma plane
+// is worked on at the 8-bit scale, so opts.Alpha gives the same margin
+// relative to the signal as for 8-bit input, and the closed loop models
+// 16-bit rounding. Bit-exact mode needs 8-bit samples.
+func EmbedImage16Options(ctx context.Context, img *spectralimage.Image16, key, msg string, opts EmbedOptions) (*spectralimage.Image16, *EmbedReport, error) {
+	if img == nil {
+		return nil, nil, fmt.Errorf("image is nil")
+	}
+	if img.Alpha != nil && len(img.Alpha) != len(img.Pix) {
+		return nil, nil, fmt.Errorf("alpha length %d does not match %d pixels", len(img.Alpha), len(img.Pix))
+	}
+	profile, err := checkEmbedOptions(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.BitExact {
+		return nil, nil, fmt.Errorf("bit-exact mode needs 8-bit samples")
+	}
+
+	blocks, err := usableBlocks(img.AlphaMask(), opts.Mask, img.W, img.H, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	bits := EncodePayload(msg)
+	plan, err := planEmbed(profile, key, bits, img.W, img.H, blocks)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	for blockIdx, ops := range blockOps {
+	y, cb, cr := spectralimage.RGB16ToYCbCr(img)
+	if y, err = embedPyramid(ctx, y, img.AlphaMask(), img.W, img.H, key, bits, profile, opts); err != nil {
+		return nil, nil, err
+	}
+	yOut, report, err := embedLumaPlan(ctx, y, img.W, img.H, plan, profile, opts, func(v float32, idx int) float32 {
+		return spectralimage.QuantizeLuma16(v, cb[idx], cr[idx])
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	out := spectralimage.YCbCrToRGB16(img.W, img.H, yOut, cb, cr)
+	if img.Alpha != nil {
+		out.Alpha = append([]uint16(nil), img.Alpha...)
+	}
+	return out, report, nil
+}
+
+func cloneAlpha(alpha []uint8) []uint8 {
+	if alpha == nil {
+		return nil
+	}
+	return append([]uint8(nil), alpha...)
+}
+
+// lumaQuantizer returns the luma a detector will read back for a sample of
+// value v at plane index idx once the output has been stored.
+type lumaQuantizer func(v float32, idx int) float32
+
+// embedLumaPlan applies plan to a luma plane and returns the marked plane.
+// quantize models how the output is stored, so the closed loop measures what
+// the detector will see.
+func embedLumaPlan(ctx context.Context, y []float32, w, h int, plan *embedPlan, profile Profile, opts EmbedOptions, quantize lumaQuantizer) ([]float32, *EmbedReport, error) {
+	n := profile.BlockSize
+	yPad, w2, h2 := spectralmath.PadToN(y, w, h, n)
+
+	target := opts.Alpha * spreadTargetScale * profile.targetScale()
+	margins := make([]float32, plan.neededSlots)
+	iterations := make([]int, plan.blockCount)
+
+	// Blocks do not overlap, so each one can be transformed independently.
+	err := parallelFor(ctx, plan.blockCount, Workers(), func(blockIdx int) {
+		ops := plan.blockOps[blockIdx]
 		if len(ops) == 0 {
-			continue
+			return
 		}
 
-		bx := blockIdx % blockCols
-		by := blockIdx / blockCols
+		bx := blockIdx % plan.blockCols
+		by := blockIdx / plan.blockCols
 
-		block := spectralmath.GetBlock8(yPad, w2, bx, by)
-		coeff := spectralmath.DCT8(block)
+		block := spectralmath.GetBlockN(yPad, w2, n, bx, by)
+		coeff := spectralmath.DCTN(block, n)
 
 		for _, op := range ops {
-			pos := midFreqPositions[op.coeffIdx]
-			projected := coeff[pos.v][pos.u] * op.direction
-			target := alpha * spreadTargetScale
+			pos := profile.coeffs[op.coeffIdx]
+			projected := coeff[pos.v*n+pos.u] * float32(op.direction)
 			if projected < target {
-				coeff[pos.v][pos.u] += (target - projected) * op.direction
+				coeff[pos.v*n+pos.u] += (target - projected) * float32(op.direction)
 			}
 		}
 
-		recon := spectralmath.IDCT8(coeff)
-		clampBlockToByteRange(&recon)
-		spectralmath.SetBlock8(yPad, w2, bx, by, recon)
+		var recon []float32
+		for iter := 0; ; iter++ {
+			recon = spectralmath.IDCTN(coeff, n)
+			clampBlockToByteRange(recon)
+
+			measured := spectralmath.DCTN(quantizedLumaBlock(recon, w, h, n, bx, by, quantize), n)
+			deficient := false
+			for _, op := range ops {
+				pos := profile.coeffs[op.coeffIdx]
+				margins[op.slotIdx] = measured[pos.v*n+pos.u] * float32(op.direction)
+				if margins[op.slotIdx] < target {
+					deficient = true
+				}
+			}
+			if !deficient || iter >= opts.ClosedLoopIterations {
+				iterations[blockIdx] = iter
+				break
+			}
+
+			for _, op := range ops {
+				pos := profile.coeffs[op.coeffIdx]
+				if deficit := target - margins[op.slotIdx]; deficit > 0 {
+					coeff[pos.v*n+pos.u] += deficit * float32(op.direction)
+				}
+			}
+		}
+
+		spectralmath.SetBlockN(yPad, w2, n, bx, by, recon)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	yOut := spectralmath.Unpad(yPad, w2, h2, img.W, img.H)
-	outImg := spectralimage.YCbCrToRGB(img.W, img.H, yOut, cb, cr)
-	return outImg, nil
+	return spectralmath.Unpad(yPad, w2, h2, w, h), newEmbedReport(target, margins, iterations), nil
 }
 
-func clampBlockToByteRange(b *[8][8]float32) {
-	for y := 0; y < 8; y++ {
-		for x := 0; x < 8; x++ {
-			if b[y][x] < 0 {
-				b[y][x] = 0
-				continue
-			}
-			if b[y][x] > 255 {
-				b[y][x] = 255
+// quantizedLumaBlock returns block (bx, by) of the luma plane as a detector
+// will read it back: every pixel goes through quantize, and pixels in the
+// padding replicate the nearest in-image pixel exactly as PadToN does.
+func quantizedLumaBlock(recon []float32, w, h, n, bx, by int, quantize lumaQuantizer) []float32 {
+	out := make([]float32, n*n)
+	x0 := bx * n
+	y0 := by * n
+	for j := 0; j < n; j++ {
+		srcY := y0 + j
+		if srcY >= h {
+			srcY = h - 1
+		}
+		for i := 0; i < n; i++ {
+			srcX := x0 + i
+			if srcX >= w {
+				srcX = w - 1
 			}
+			out[j*n+i] = quantize(recon[(srcY-y0)*n+srcX-x0], srcY*w+srcX)
+		}
+	}
+	return out
+}
+
+func clampBlockToByteRange(b []float32) {
+	for i, v := range b {
+		if v < 0 {
+			b[i] = 0
+			continue
+		}
+		if v > 255 {
+			b[i] = 255
 		}
 	}
 }
@@ -0,0 +1,141 @@
+package wm
+
+import (
+	"context"
+	"math"
+
+	spectralimage "spectralmark/internal/image"
+	spectralmath "spectralmark/internal/math"
+)
+
+const fixedOne = 1 << spectralimage.FixedFracBits
+
+// embedImageFixed is the bit-exact variant of embedSymbols. It follows
+// the same plan and closed loop, but luma, coefficients and the target are
+// Q4 integers, so no step depends on floating-point rounding.
+func embedImageFixed(ctx context.Context, img *spectralimage.Image, key string, bits []int8, opts EmbedOptions, profile Profile) (*spectralimage.Image, *EmbedReport, error) {
+	blocks, err := usableBlocks(img.Alpha, opts.Mask, img.W, img.H, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planEmbed(profile, key, bits, img.W, img.H, blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	y, cb, cr := spectralimage.RGBToYCbCrFixed(img)
+	yOut, report, err := embedLumaPlanFixed(ctx, y, img.W, img.H, plan, profile, opts, func(v int32, idx int) int32 {
+		return spectralimage.QuantizeLumaFixed(v, cb[idx], cr[idx])
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	out := spectralimage.YCbCrFixedToRGB(img.W, img.H, yOut, cb, cr)
+	out.Alpha = cloneAlpha(img.Alpha)
+	return out, report, nil
+}
+
+type lumaQuantizerFixed func(v int32, idx int) int32
+
+func embedLumaPlanFixed(ctx context.Context, y []int32, w, h int, plan *embedPlan, profile Profile, opts EmbedOptions, quantize lumaQuantizerFixed) ([]int32, *EmbedReport, error) {
+	n := profile.BlockSize
+	yPad, w2, h2 := spectralmath.PadToN(y, w, h, n)
+
+	target := int32(math.Round(float64(opts.Alpha*profile.targetScale()) * spreadTargetScale * fixedOne))
+	margins := make([]int32, plan.neededSlots)
+	iterations := make([]int, plan.blockCount)
+
+	err := parallelFor(ctx, plan.blockCount, Workers(), func(blockIdx int) {
+		ops := plan.blockOps[blockIdx]
+		if len(ops) == 0 {
+			return
+		}
+
+		bx := blockIdx % plan.blockCols
+		by := blockIdx / plan.blockCols
+
+		block := spectralmath.GetBlockN(yPad, w2, n, bx, by)
+		coeff := spectralmath.DCTNInt(block, n)
+
+		for _, op := range ops {
+			pos := profile.coeffs[op.coeffIdx]
+			dir := int32(op.direction)
+			projected := coeff[pos.v*n+pos.u] * dir
+			if projected < target {
+				coeff[pos.v*n+pos.u] += (target - projected) * dir
+			}
+		}
+
+		var recon []int32
+		for iter := 0; ; iter++ {
+			recon = spectralmath.IDCTNInt(coeff, n)
+			clampFixedBlockToByteRange(recon)
+
+			measured := spectralmath.DCTNInt(quantizedLumaBlockFixed(recon, w, h, n, bx, by, quantize), n)
+			deficient := false
+			for _, op := range ops {
+				pos := profile.coeffs[op.coeffIdx]
+				margins[op.slotIdx] = measured[pos.v*n+pos.u] * int32(op.direction)
+				if margins[op.slotIdx] < target {
+					deficient = true
+				}
+			}
+			if !deficient || iter >= opts.ClosedLoopIterations {
+				iterations[blockIdx] = iter
+				break
+			}
+
+			for _, op := range ops {
+				pos := profile.coeffs[op.coeffIdx]
+				if deficit := target - margins[op.slotIdx]; deficit > 0 {
+					coeff[pos.v*n+pos.u] += deficit * int32(op.direction)
+				}
+			}
+		}
+
+		spectralmath.SetBlockN(yPad, w2, n, bx, by, recon)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	floatMargins := make([]float32, len(margins))
+	for i, m := range margins {
+		floatMargins[i] = float32(m) / fixedOne
+	}
+	yOut := spectralmath.Unpad(yPad, w2, h2, w, h)
+	return yOut, newEmbedReport(float32(target)/fixedOne, floatMargins, iterations), nil
+}
+
+// quantizedLumaBlockFixed is quantizedLumaBlock for Q4 planes.
+func quantizedLumaBlockFixed(recon []int32, w, h, n, bx, by int, quantize lumaQuantizerFixed) []int32 {
+	out := make([]int32, n*n)
+	x0 := bx * n
+	y0 := by * n
+	for j := 0; j < n; j++ {
+		srcY := y0 + j
+		if srcY >= h {
+			srcY = h - 1
+		}
+		for i := 0; i < n; i++ {
+			srcX := x0 + i
+			if srcX >= w {
+				srcX = w - 1
+			}
+			out[j*n+i] = quantize(recon[(srcY-y0)*n+srcX-x0], srcY*w+srcX)
+		}
+	}
+	return out
+}
+
+func clampFixedBlockToByteRange(b []int32) {
+	for i, v := range b {
+		if v < 0 {
+			b[i] = 0
+			continue
+		}
+		if v > 255*fixedOne {
+			b[i] = 255 * fixedOne
+		}
+	}
+}
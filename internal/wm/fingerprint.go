@@ -0,0 +1,275 @@
+package wm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// Tardos fingerprinting. Every recipient gets a binary codeword whose bits
+// are drawn with keyed per-position biases; the codeword is embedded as raw
+// ±1 symbols after the sync word, under its own slot permutation so it never
+// collides with a message payload. Colluders who mix their copies can only
+// produce bits that at least one of them holds, and the accusation score
+// below then singles out at least one of them while an innocent recipient
+// stays under the threshold except with probability Epsilon/MaxRecipients.
+//
+// The constants are the ones from Tardos' original construction
+// (length 100c²k, threshold 20ck, cutoff 1/300c), for which the
+// false-accusation bound is proven.
+const (
+	fingerprintSchemeSuffix = "-fp"
+	tardosLengthFactor      = 100
+	tardosThresholdFactor   = 20
+	tardosCutoffFactor      = 300
+)
+
+// FingerprintSyncSymbols is the length of the sync word that precedes the
+// code symbols.
+const FingerprintSyncSymbols = 16 * repetitionFactor
+
+type FingerprintParams struct {
+	// Colluders is the largest coalition the code is designed to resist.
+	Colluders int
+	// MaxRecipients is the largest number of recipients the code will serve.
+	MaxRecipients int
+	// Epsilon bounds the probability that any innocent recipient is
+	// accused.
+	Epsilon float64
+}
+
+type FingerprintCode struct {
+	Params    FingerprintParams
+	Length    int
+	Threshold float64
+	key       string
+	biases    []float64
+}
+
+type Accusation struct {
+	ID      string
+	Score   float64
+	Accused bool
+}
+
+// FingerprintReading is what ReadFingerprintImage recovered: one hard
+// decision per code position (+1 or -1) and how well the sync word matched.
+type FingerprintReading struct {
+	Symbols   []int8
+	SyncScore float32
+	Profile   string
+}
+
+func (p FingerprintParams) validate() error {
+	if p.Colluders < 1 {
+		return fmt.Errorf("colluders must be >= 1")
+	}
+	if p.MaxRecipients < 1 {
+		return fmt.Errorf("max recipients must be >= 1")
+	}
+	if !(p.Epsilon > 0 && p.Epsilon < 1) {
+		return fmt.Errorf("epsilon must be in (0, 1)")
+	}
+	return nil
+}
+
+// NewFingerprintCode derives the code for key. The per-position biases are
+// drawn from the key, so the key and params are all that is needed to
+// regenerate every codeword.
+func NewFingerprintCode(key string, params FingerprintParams) (*FingerprintCode, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	// Union bound over recipients: each innocent one may be accused with
+	// probability at most Epsilon/MaxRecipients.
+	k := math.Ceil(math.Log(float64(params.MaxRecipients) / params.Epsilon))
+	c := float64(params.Colluders)
+	length := int(tardosLengthFactor * c * c * k)
+
+	cutoff := 1 / (tardosCutoffFactor * c)
+	tp := math.Asin(math.Sqrt(cutoff))
+	rng := NewPRNG(SeedFromKey("fp-bias:" + key))
+	biases := make([]float64, length)
+	for i := range biases {
+		r := tp + (math.Pi/2-2*tp)*unitFloat64(rng)
+		s := math.Sin(r)
+		biases[i] = s * s
+	}
+
+	return &FingerprintCode{
+		Params:    params,
+		Length:    length,
+		Threshold: tardosThresholdFactor * c * k,
+		key:       key,
+		biases:    biases,
+	}, nil
+}
+
+// Codeword returns the ±1 symbols for recipientID.
+func (c *FingerprintCode) Codeword(recipientID string) []int8 {
+	rng := NewPRNG(SeedFromKey("fp-word:" + c.key + ":" + recipientID))
+	out := make([]int8, c.Length)
+	for i, p := range c.biases {
+		if unitFloat64(rng) < p {
+			out[i] = 1
+		} else {
+			out[i] = -1
+		}
+	}
+	return out
+}
+
+// Score is Tardos' accusation score of recipientID against the symbols read
+// from a suspect copy. Only positions that read as +1 contribute.
+func (c *FingerprintCode) Score(symbols []int8, recipientID string) float64 {
+	word := c.Codeword(recipientID)
+	score := 0.0
+	for i := 0; i < len(word) && i < len(symbols); i++ {
+		if symbols[i] <= 0 {
+			continue
+		}
+		p := c.biases[i]
+		if word[i] > 0 {
+			score += math.Sqrt((1 - p) / p)
+		} else {
+			score -= math.Sqrt(p / (1 - p))
+		}
+	}
+	return score
+}
+
+// Trace scores every recipient and returns them best first; those above the
+// threshold are marked accused.
+func (c *FingerprintCode) Trace(symbols []int8, recipients []string) []Accusation {
+	out := make([]Accusation, len(recipients))
+	for i, id := range recipients {
+		s := c.Score(symbols, id)
+		out[i] = Accusation{ID: id, Score: s, Accused: s > c.Threshold}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Score > out[j].Score
+	})
+	return out
+}
+
+func fingerprintProfile(p Profile) Profile {
+	p.scheme += fingerprintSchemeSuffix
+	return p
+}
+
+// EmbedFingerprintImage embeds recipientID's codeword. It needs
+// FingerprintSyncSymbols + code.Length slots, which is far more than a text payload; the error
+// reports the shortfall when the image is too small.
+func EmbedFingerprintImage(ctx context.Context, img *spectralimage.Image, key string, code *FingerprintCode, recipientID string, opts EmbedOptions) (*spectralimage.Image, *EmbedReport, error) {
+	if code == nil {
+		return nil, nil, fmt.Errorf("fingerprint code is nil")
+	}
+	if recipientID == "" {
+		return nil, nil, fmt.Errorf("recipient id is required")
+	}
+	profile, err := checkEmbedArgs(img, key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bits := append(syncSymbolPattern(), code.Codeword(recipientID)...)
+	return embedSymbols(ctx, img, key, bits, opts, fingerprintProfile(profile))
+}
+
+// ReadFingerprintImage reads the code symbols back. The block grid phase is
+// taken from the sync word, so content shifts are tolerated as in detection.
+// With no profile given, every profile is tried and the one whose sync word
+// matches best wins.
+func ReadFingerprintImage(ctx context.Context, img *spectralimage.Image, key string, code *FingerprintCode, opts DetectOptions) (*FingerprintReading, error) {
+	if img == nil {
+		return nil, fmt.Errorf("image is nil")
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	if code == nil {
+		return nil, fmt.Errorf("fingerprint code is nil")
+	}
+
+	candidates, err := lookupDetectProfiles(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkMask(opts.Mask, img.W, img.H); err != nil {
+		return nil, err
+	}
+
+	y, _, _ := spectralimage.RGBToYCbCr(img)
+	alpha := maskedAlpha(img.Alpha, opts.Mask)
+	var best *FingerprintReading
+	for _, p := range candidates {
+		r, err := readFingerprintProfile(ctx, y, alpha, img.W, img.H, key, code, p)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil && (best == nil || r.SyncScore > best.SyncScore) {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("image too small for a %d-symbol fingerprint", len(syncSymbolPattern())+code.Length)
+	}
+	return best, nil
+}
+
+func readFingerprintProfile(ctx context.Context, y []float32, alpha []uint8, w, h int, key string, code *FingerprintCode, profile Profile) (*FingerprintReading, error) {
+	fp := fingerprintProfile(profile)
+	syncSymbols := syncSymbolPattern()
+	needed := len(syncSymbols) + code.Length
+	n := fp.BlockSize
+	if ((w+n-1)/n)*((h+n-1)/n)*fp.slotsPerBlock()/spreadChipsPerSymbol < needed {
+		return nil, nil
+	}
+
+	workers := Workers()
+	phases, err := estimateGridPhases(ctx, y, alpha, w, h, key, fp, min(n-1, w-1), min(n-1, h-1), workers)
+	if err != nil || len(phases) == 0 {
+		return nil, err
+	}
+
+	ox, oy := phases[0].ox, phases[0].oy
+	soft, err := symbolSoftFromLuma(ctx, shiftLuma(y, w, h, ox, oy), w, h, key, fp, visibleBlocks(alpha, w, h, n, ox, oy), workers)
+	if err != nil || len(soft) < needed {
+		return nil, err
+	}
+
+	matched := 0
+	for i, want := range syncSymbols {
+		if (soft[i] >= 0) == (want > 0) {
+			matched++
+		}
+	}
+
+	symbols := make([]int8, code.Length)
+	for i := range symbols {
+		if soft[len(syncSymbols)+i] >= 0 {
+			symbols[i] = 1
+		} else {
+			symbols[i] = -1
+		}
+	}
+
+	return &FingerprintReading{
+		Symbols:   symbols,
+		SyncScore: float32(matched) / float32(len(syncSymbols)),
+		Profile:   profile.Name,
+	}, nil
+}
+
+// unitFloat64 draws a uniform value in [0, 1) with 53 bits of precision.
+func unitFloat64(rng *PRNG) float64 {
+	return float64(rng.NextU64()>>11) / (1 << 53)
+}
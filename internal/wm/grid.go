@@ -0,0 +1,141 @@
+package wm
+
+import (
+	"context"
+	"sort"
+
+	spectralmath "spectralmark/internal/math"
+)
+
+// gridPhaseCandidates is how many of the best-ranked block-grid phases
+// DetectImage fully decodes after estimation.
+const gridPhaseCandidates = 4
+
+type gridPhase struct {
+	ox    int
+	oy    int
+	score float32
+}
+
+// estimateGridPhases ranks every grid phase (ox, oy) in 0..maxOX x 0..maxOY
+// by how well the keyed sync-word slots correlate with the known sync symbols
+// when the image is read at that phase. Only the coefficients behind the
+// sync symbols are computed, so this costs a few hundred single-coefficient
+// DCTs per phase instead of a full decode. Phases are returned best first;
+// ties keep (oy, ox) scan order.
+func estimateGridPhases(ctx context.Context, y []float32, alpha []uint8, w, h int, key string, profile Profile, maxOX, maxOY, workers int) ([]gridPhase, error) {
+	if w <= 0 || h <= 0 || len(y) < w*h || maxOX < 0 || maxOY < 0 {
+		return nil, nil
+	}
+
+	n := profile.BlockSize
+	blockCols := (w + n - 1) / n
+	blockRows := (h + n - 1) / n
+	syncSymbols := syncSymbolPattern()
+	// Chips are drawn after the shuffle, so the prefix used by the sync word
+	// is the same one detectFromLuma sees for the full payload.
+	neededSlots := len(syncSymbols) * spreadChipsPerSymbol
+
+	// Without alpha every phase shares one layout; with it, the visible
+	// blocks and so the layout depend on the phase.
+	var shared slotLayout
+	var sharedSlots []int
+	var sharedChips []int8
+	if alpha == nil {
+		shared = newSlotLayout(profile, blockCols*blockRows, nil)
+		if shared.totalSlots()/spreadChipsPerSymbol < len(syncSymbols) {
+			return nil, nil
+		}
+		sharedSlots, sharedChips = shuffledSlotsAndChips(profile.scheme, key, shared.totalSlots(), neededSlots)
+		if len(sharedSlots) != neededSlots || len(sharedChips) != neededSlots {
+			return nil, nil
+		}
+	}
+
+	cols := maxOX + 1
+	phases := make([]gridPhase, (maxOY+1)*cols)
+	err := parallelFor(ctx, len(phases), workers, func(i int) {
+		ox := i % cols
+		oy := i / cols
+
+		layout, slots, chips := shared, sharedSlots, sharedChips
+		if alpha != nil {
+			layout = newSlotLayout(profile, blockCols*blockRows, visibleBlocks(alpha, w, h, n, ox, oy))
+			slots, chips = shuffledSlotsAndChips(profile.scheme, key, layout.totalSlots(), neededSlots)
+			if len(slots) != neededSlots || len(chips) != neededSlots {
+				phases[i] = gridPhase{ox: ox, oy: oy}
+				return
+			}
+		}
+
+		corr := float32(0)
+		energy := float32(0)
+		for symIdx, want := range syncSymbols {
+			soft := float32(0)
+			base := symIdx * spreadChipsPerSymbol
+			for j := 0; j < spreadChipsPerSymbol; j++ {
+				blockIdx, coeffIdx := layout.locate(slots[base+j])
+				pos := profile.coeffs[coeffIdx]
+
+				block := shiftedBlock(y, w, h, n, blockIdx%blockCols, blockIdx/blockCols, ox, oy)
+				soft += spectralmath.DCTNCoeff(block, n, pos.u, pos.v) * float32(chips[base+j])
+			}
+			corr += soft * float32(want)
+			if soft < 0 {
+				energy -= soft
+			} else {
+				energy += soft
+			}
+		}
+
+		score := float32(0)
+		if energy > 0 {
+			score = corr / energy
+		}
+		phases[i] = gridPhase{ox: ox, oy: oy, score: score}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(phases, func(i, j int) bool {
+		return phases[i].score > phases[j].score
+	})
+	return phases, nil
+}
+
+// shiftedBlock reads n x n block (bx, by) of the luma plane as seen after
+// shiftLuma(ox, oy) and PadToN, without materialising the shifted plane.
+func shiftedBlock(y []float32, w, h, n, bx, by, ox, oy int) []float32 {
+	b := make([]float32, n*n)
+	for j := 0; j < n; j++ {
+		srcY := by*n + j + oy
+		if srcY >= h {
+			srcY = h - 1
+		}
+		row := srcY * w
+		for i := 0; i < n; i++ {
+			srcX := bx*n + i + ox
+			if srcX >= w {
+				srcX = w - 1
+			}
+			b[j*n+i] = y[row+srcX]
+		}
+	}
+	return b
+}
+
+func syncSymbolPattern() []int8 {
+	syncBits := appendWordBits(nil, payloadSyncWord)
+	out := make([]int8, 0, len(syncBits)*repetitionFactor)
+	for _, bit := range syncBits {
+		symbol := int8(-1)
+		if bit == 1 {
+			symbol = 1
+		}
+		for i := 0; i < repetitionFactor; i++ {
+			out = append(out, symbol)
+		}
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package wm
+
+import (
+	"context"
+	"testing"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// cropFromPage pastes img onto a flat page at (dx, dy) and crops an
+// img-sized window from the page's top left, so the mark sits off the 8x8
+// grid by (dx, dy) and loses that much at the right and bottom.
+func cropFromPage(img *spectralimage.Image, dx, dy int) *spectralimage.Image {
+	out := &spectralimage.Image{W: img.W, H: img.H, Pix: make([]spectralimage.Rgb, img.W*img.H)}
+	for y := 0; y < img.H; y++ {
+		for x := 0; x < img.W; x++ {
+			p := spectralimage.Rgb{R: 128, G: 128, B: 128}
+			if x >= dx && y >= dy {
+				p = img.Pix[(y-dy)*img.W+x-dx]
+			}
+			out.Pix[y*img.W+x] = p
+		}
+	}
+	return out
+}
+
+// TestGridPhaseAfterCrop crops the marked image out of a page with a margin,
+// which moves the block grid; the estimator must rank the phase that
+// realigns it first.
+func TestGridPhaseAfterCrop(t *testing.T) {
+	marked, err := EmbedImage(testCover(512, 384, "grid"), "k", "HELLO", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cropped := cropFromPage(marked, 3, 5)
+
+	y, _, _ := spectralimage.RGBToYCbCr(cropped)
+	phases, err := estimateGridPhases(context.Background(), y, nil, cropped.W, cropped.H, "k", DefaultProfile(), 7, 7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) == 0 {
+		t.Fatal("no grid phases")
+	}
+	if p := phases[0]; p.ox != 3 || p.oy != 5 {
+		t.Errorf("best phase (%d, %d), want (3, 5)", p.ox, p.oy)
+	}
+
+	_, _, msg, ok, err := DetectImage(cropped, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || msg != "HELLO" {
+		t.Errorf("detect on crop = %q ok %v", msg, ok)
+	}
+}
@@ -0,0 +1,256 @@
+package wm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// paletteSteps is how finely a paletteQuantizer tabulates target luma, in
+// steps per 8-bit level.
+const paletteSteps = 4
+
+// paletteShift is the luma offset of the entries extendPalette adds.
+const paletteShift = 2
+
+// paletteChromaWeight prices a change of chroma against the same change of
+// luma when choosing an entry. Below 1 it lets sparse palettes trade some
+// colour accuracy for the luma changes the mark needs.
+const paletteChromaWeight = 0.25
+
+// paletteIterations is the fewest closed-loop iterations EmbedIndexed runs.
+// Rounding to a palette is much coarser than to 8-bit RGB, and a block
+// usually needs many top-ups before enough pixels change entry.
+const paletteIterations = 32
+
+// EmbedIndexed embeds msg into a palette image and returns an image in the
+// same palette, so it can be stored as indexed colour without being
+// re-quantized. Each pixel of a marked block takes the entry closest to the
+// luma it should have among those with its alpha, weighing how far the
+// entry's chroma is from its original colour. The closed loop measures the
+// entries actually chosen and always allows at least paletteIterations
+// top-ups. With extend, free palette slots are first filled with slightly
+// lighter and darker copies of the most used colours; the original entries
+// keep their indices and only added entries the mark uses are kept.
+// Bit-exact mode needs truecolour output.
+func EmbedIndexed(ctx context.Context, img *spectralimage.Indexed, key, msg string, opts EmbedOptions, extend bool) (*spectralimage.Indexed, *EmbedReport, error) {
+	if img == nil {
+		return nil, nil, fmt.Errorf("image is nil")
+	}
+	if len(img.Pix) != img.W*img.H || img.W <= 0 || img.H <= 0 {
+		return nil, nil, fmt.Errorf("pixel count %d does not match %dx%d", len(img.Pix), img.W, img.H)
+	}
+	if len(img.Palette) == 0 || len(img.Palette) > 256 {
+		return nil, nil, fmt.Errorf("palette has %d entries, expected 1 to 256", len(img.Palette))
+	}
+	if img.Alpha != nil && len(img.Alpha) != len(img.Palette) {
+		return nil, nil, fmt.Errorf("alpha length %d does not match %d palette entries", len(img.Alpha), len(img.Palette))
+	}
+	for _, p := range img.Pix {
+		if int(p) >= len(img.Palette) {
+			return nil, nil, fmt.Errorf("pixel index %d is outside the %d-entry palette", p, len(img.Palette))
+		}
+	}
+	profile, err := checkEmbedOptions(key, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.BitExact {
+		return nil, nil, fmt.Errorf("bit-exact mode needs truecolour output")
+	}
+	if opts.PyramidLevels > 0 {
+		return nil, nil, fmt.Errorf("pyramid levels need truecolour output")
+	}
+	if opts.ClosedLoopIterations < paletteIterations {
+		opts.ClosedLoopIterations = paletteIterations
+	}
+
+	palette, palAlpha := append([]spectralimage.Rgb(nil), img.Palette...), cloneAlpha(img.Alpha)
+	if extend {
+		palette, palAlpha = extendPalette(palette, palAlpha, img.Pix)
+	}
+	q := newPaletteQuantizer(palette, palAlpha, img.Pix)
+
+	y := make([]float32, len(img.Pix))
+	for i, p := range img.Pix {
+		y[i] = q.luma[p]
+	}
+	blocks, err := usableBlocks(img.PixelAlpha(), opts.Mask, img.W, img.H, profile.BlockSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := planEmbed(profile, key, EncodePayload(msg), img.W, img.H, blocks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pix := img.Pix
+	yOut, report, err := embedLumaPlan(ctx, y, img.W, img.H, plan, profile, opts, func(v float32, idx int) float32 {
+		return q.luma[q.nearest(pix[idx], v)]
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := &spectralimage.Indexed{W: img.W, H: img.H, Pix: make([]uint8, len(pix)), Palette: palette, Alpha: palAlpha}
+	for i, p := range pix {
+		if yOut[i] == y[i] {
+			out.Pix[i] = p
+		} else {
+			out.Pix[i] = q.nearest(p, yOut[i])
+		}
+	}
+	return dropUnusedEntries(out, len(img.Palette)), report, nil
+}
+
+// paletteQuantizer maps a pixel's original entry and the luma it should
+// have to the entry that best gives that luma, tabulated at paletteSteps
+// per level for every entry in use.
+type paletteQuantizer struct {
+	luma  []float32
+	table [256][]uint8
+}
+
+func newPaletteQuantizer(palette []spectralimage.Rgb, alpha []uint8, pix []uint8) *paletteQuantizer {
+	luma, cb, cr := spectralimage.RGBToYCbCr(&spectralimage.Image{W: len(palette), H: 1, Pix: palette})
+	q := &paletteQuantizer{luma: luma}
+
+	for _, o := range pix {
+		if q.table[o] != nil {
+			continue
+		}
+
+		// Candidates share the original entry's alpha, and pay for any
+		// change of chroma.
+		var cands []int
+		var chromaCost []float32
+		for e := range palette {
+			if alpha != nil && alpha[e] != alpha[o] {
+				continue
+			}
+			dcb, dcr := cb[e]-cb[o], cr[e]-cr[o]
+			cands = append(cands, e)
+			chromaCost = append(chromaCost, paletteChromaWeight*(dcb*dcb+dcr*dcr))
+		}
+
+		table := make([]uint8, 255*paletteSteps+1)
+		for l := range table {
+			v := float32(l) / paletteSteps
+			best := int(o)
+			bestCost := (v - q.luma[o]) * (v - q.luma[o])
+			for k, e := range cands {
+				d := v - q.luma[e]
+				if cost := d*d + chromaCost[k]; cost < bestCost {
+					best, bestCost = e, cost
+				}
+			}
+			table[l] = uint8(best)
+		}
+		q.table[o] = table
+	}
+	return q
+}
+
+func (q *paletteQuantizer) nearest(o uint8, v float32) uint8 {
+	l := int(v*paletteSteps + 0.5)
+	if l < 0 {
+		l = 0
+	}
+	if l >= len(q.table[o]) {
+		l = len(q.table[o]) - 1
+	}
+	return q.table[o][l]
+}
+
+// extendPalette fills the free slots of palette with copies of the visible
+// colours pix uses most, shifted paletteShift lighter and darker. The
+// shift is the same on R, G and B, so chroma is unchanged. Colours that
+// would clip or are already present are skipped.
+func extendPalette(palette []spectralimage.Rgb, alpha []uint8, pix []uint8) ([]spectralimage.Rgb, []uint8) {
+	entryAlpha := func(i int) uint8 {
+		if alpha == nil {
+			return 255
+		}
+		return alpha[i]
+	}
+
+	var counts [256]int
+	for _, p := range pix {
+		counts[p]++
+	}
+	order := make([]int, 0, len(palette))
+	for i := range palette {
+		if counts[i] > 0 && entryAlpha(i) > 0 {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return counts[order[a]] > counts[order[b]] })
+
+	type entry struct {
+		c spectralimage.Rgb
+		a uint8
+	}
+	present := make(map[entry]bool, 256)
+	for i, c := range palette {
+		present[entry{c, entryAlpha(i)}] = true
+	}
+
+	for _, i := range order {
+		for _, d := range [...]int{paletteShift, -paletteShift} {
+			if len(palette) == 256 {
+				return palette, alpha
+			}
+			c := palette[i]
+			r, g, b := int(c.R)+d, int(c.G)+d, int(c.B)+d
+			if r < 0 || g < 0 || b < 0 || r > 255 || g > 255 || b > 255 {
+				continue
+			}
+			e := entry{spectralimage.Rgb{R: uint8(r), G: uint8(g), B: uint8(b)}, entryAlpha(i)}
+			if present[e] {
+				continue
+			}
+			present[e] = true
+			palette = append(palette, e.c)
+			if alpha != nil {
+				alpha = append(alpha, e.a)
+			}
+		}
+	}
+	return palette, alpha
+}
+
+// dropUnusedEntries removes the entries from index keep on that no pixel of
+// img uses, renumbering those that remain.
+func dropUnusedEntries(img *spectralimage.Indexed, keep int) *spectralimage.Indexed {
+	var used [256]bool
+	for _, p := range img.Pix {
+		used[p] = true
+	}
+
+	var remap [256]uint8
+	palette := img.Palette[:keep:keep]
+	var alpha []uint8
+	if img.Alpha != nil {
+		alpha = img.Alpha[:keep:keep]
+	}
+	for i := 0; i < keep; i++ {
+		remap[i] = uint8(i)
+	}
+	for i := keep; i < len(img.Palette); i++ {
+		if !used[i] {
+			continue
+		}
+		remap[i] = uint8(len(palette))
+		palette = append(palette, img.Palette[i])
+		if alpha != nil {
+			alpha = append(alpha, img.Alpha[i])
+		}
+	}
+	for i, p := range img.Pix {
+		img.Pix[i] = remap[p]
+	}
+	img.Palette, img.Alpha = palette, alpha
+	return img
+}
@@ -0,0 +1,174 @@
+package wm
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	spectralimage "spectralmark/internal/image"
+	spectralmath "spectralmark/internal/math"
+)
+
+// EmbedJPEGCoefficients marks the quantized luma coefficients of j in place,
+// so the file is never decoded to pixels and re-encoded. JPEG's 8x8 blocks
+// are the blocks of the 8x8 profile, which is the only one accepted. Slots
+// are raised to at least the target margin in whole quantization steps, and
+// the closed loop measures the decoded pixels as the detector reads them.
+//
+// o is the EXIF orientation. The mark is laid on the grid of the image as
+// displayed by mapping every displayed block and coefficient to the stored
+// one, which needs both dimensions to be multiples of 8 unless o is 1.
+// BitExact has no effect: the output is integer coefficients either way.
+func EmbedJPEGCoefficients(ctx context.Context, j *spectralimage.JPEGCoefficients, o int, key, msg string, opts EmbedOptions) (*EmbedReport, error) {
+	if j == nil {
+		return nil, fmt.Errorf("jpeg is nil")
+	}
+	profile, err := checkEmbedOptions(key, opts)
+	if err != nil {
+		return nil, err
+	}
+	if profile.BlockSize != 8 {
+		return nil, fmt.Errorf("coefficient embedding needs the 8x8 profile, got %s", profile.Name)
+	}
+	if opts.PyramidLevels > 0 {
+		return nil, fmt.Errorf("coefficient embedding cannot mark pyramid levels")
+	}
+	luma, err := j.Luma()
+	if err != nil {
+		return nil, err
+	}
+	if o < 1 || o > 8 {
+		o = 1
+	}
+	if o != 1 && (j.W%8 != 0 || j.H%8 != 0) {
+		return nil, fmt.Errorf("coefficient embedding under EXIF orientation %d needs dimensions that are multiples of 8, got %dx%d", o, j.W, j.H)
+	}
+
+	dw, dh := j.W, j.H
+	if o >= 5 {
+		dw, dh = dh, dw
+	}
+	blocks, err := usableBlocks(nil, opts.Mask, dw, dh, profile.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planEmbed(profile, key, EncodePayload(msg), dw, dh, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	coeffMap := orientedCoeffMap(o)
+	target := opts.Alpha * spreadTargetScale * profile.targetScale()
+	margins := make([]float32, plan.neededSlots)
+	iterations := make([]int, plan.blockCount)
+	quantize := func(v float32, _ int) float32 {
+		return spectralimage.QuantizeSample(v)
+	}
+
+	err = parallelFor(ctx, plan.blockCount, Workers(), func(blockIdx int) {
+		ops := plan.blockOps[blockIdx]
+		if len(ops) == 0 {
+			return
+		}
+
+		bx := blockIdx % plan.blockCols
+		by := blockIdx / plan.blockCols
+		sx, sy := spectralimage.OrientedSource(o, bx*8, by*8, j.W, j.H)
+		block := &luma.Blocks[sy/8*luma.BlocksW+sx/8]
+
+		for _, op := range ops {
+			pos := profile.coeffs[op.coeffIdx]
+			m := coeffMap[pos.v*8+pos.u]
+			dir := m.sign * int32(op.direction)
+			need := int32(math.Ceil(float64(target) / float64(luma.Quant[m.idx])))
+			if block[m.idx]*dir < need {
+				block[m.idx] = clampJPEGAC(need * dir)
+			}
+		}
+
+		for iter := 0; ; iter++ {
+			coeff := make([]float32, 64)
+			for k, m := range coeffMap {
+				coeff[k] = float32(m.sign*block[m.idx]) * float32(luma.Quant[m.idx])
+			}
+			recon := spectralmath.IDCTN(coeff, 8)
+			for i := range recon {
+				recon[i] += 128
+			}
+			clampBlockToByteRange(recon)
+
+			measured := spectralmath.DCTN(quantizedLumaBlock(recon, dw, dh, 8, bx, by, quantize), 8)
+			deficient := false
+			for _, op := range ops {
+				pos := profile.coeffs[op.coeffIdx]
+				margins[op.slotIdx] = measured[pos.v*8+pos.u] * float32(op.direction)
+				if margins[op.slotIdx] < target {
+					deficient = true
+				}
+			}
+			if !deficient || iter >= opts.ClosedLoopIterations {
+				iterations[blockIdx] = iter
+				break
+			}
+
+			for _, op := range ops {
+				if margins[op.slotIdx] < target {
+					pos := profile.coeffs[op.coeffIdx]
+					m := coeffMap[pos.v*8+pos.u]
+					block[m.idx] = clampJPEGAC(block[m.idx] + m.sign*int32(op.direction))
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newEmbedReport(target, margins, iterations), nil
+}
+
+type storedCoeff struct {
+	idx  int
+	sign int32
+}
+
+// orientedCoeffMap maps each coefficient of a displayed 8x8 block to the
+// stored block's coefficient and sign under orientation o. Mirroring a block
+// negates its odd frequencies along that axis; transposing swaps u and v.
+func orientedCoeffMap(o int) [64]storedCoeff {
+	x0, y0 := spectralimage.OrientedSource(o, 0, 0, 8, 8)
+	x1, y1 := spectralimage.OrientedSource(o, 1, 0, 8, 8)
+	x2, y2 := spectralimage.OrientedSource(o, 0, 1, 8, 8)
+	stepX := [2]int{x1 - x0, y1 - y0}
+	stepY := [2]int{x2 - x0, y2 - y0}
+
+	var m [64]storedCoeff
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var su, sv int
+			sign := int32(1)
+			if stepX[0] != 0 {
+				su, sv = u, v
+				if stepX[0] < 0 && u%2 == 1 {
+					sign = -sign
+				}
+				if stepY[1] < 0 && v%2 == 1 {
+					sign = -sign
+				}
+			} else {
+				su, sv = v, u
+				if stepX[1] < 0 && u%2 == 1 {
+					sign = -sign
+				}
+				if stepY[0] < 0 && v%2 == 1 {
+					sign = -sign
+				}
+			}
+			m[v*8+u] = storedCoeff{idx: sv*8 + su, sign: sign}
+		}
+	}
+	return m
+}
+
+func clampJPEGAC(v int32) int32 {
+	return max(-spectralimage.JPEGMaxAC, min(spectralimage.JPEGMaxAC, v))
+}
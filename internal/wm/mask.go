@@ -0,0 +1,122 @@
+package wm
+
+import "fmt"
+
+// Slots are normally spread over every block of the grid. Images with
+// transparency only use the blocks without fully transparent pixels: a mark
+// under zero alpha is invisible anyway, and it is lost as soon as an encoder
+// discards the colour there, which would also damage the mark in a block
+// that is only partly transparent. The detector derives the same block list
+// from the alpha it reads back, so the keyed slot sequence is laid over the
+// usable blocks only.
+//
+// A region-of-interest mask (EmbedOptions.Mask) works the same way: it is a
+// plane like alpha, zero where the image must stay untouched, and a block
+// is only usable if both planes are non-zero over all of it. Unlike alpha
+// the mask is not stored in the image, so the detector must be given the
+// same one.
+
+// slotLayout maps the keyed slot sequence onto the blocks that carry it.
+type slotLayout struct {
+	perBlock   int
+	blockCount int
+	// blocks lists the usable block indices in raster order; nil means
+	// every block.
+	blocks []int
+}
+
+func newSlotLayout(profile Profile, blockCount int, blocks []int) slotLayout {
+	return slotLayout{perBlock: profile.slotsPerBlock(), blockCount: blockCount, blocks: blocks}
+}
+
+func (l slotLayout) totalSlots() int {
+	if l.blocks == nil {
+		return l.blockCount * l.perBlock
+	}
+	return len(l.blocks) * l.perBlock
+}
+
+// locate returns the block and per-block coefficient of a keyed slot.
+func (l slotLayout) locate(slot int) (blockIdx, coeffIdx int) {
+	blockIdx = slot / l.perBlock
+	if l.blocks != nil {
+		blockIdx = l.blocks[blockIdx]
+	}
+	return blockIdx, slot % l.perBlock
+}
+
+func (l slotLayout) describe() string {
+	if l.blocks == nil {
+		return ""
+	}
+	return fmt.Sprintf(", usable blocks=%d/%d", len(l.blocks), l.blockCount)
+}
+
+// visibleBlocks returns the blocks of the n x n grid over a w x h alpha plane,
+// read at grid phase (ox, oy) as shiftedBlock does, that have no fully
+// transparent pixel. It returns nil when alpha is nil or every block
+// qualifies, so opaque images keep the plain layout.
+func visibleBlocks(alpha []uint8, w, h, n, ox, oy int) []int {
+	if alpha == nil {
+		return nil
+	}
+	blockCols := (w + n - 1) / n
+	blockRows := (h + n - 1) / n
+
+	blocks := make([]int, 0, blockCols*blockRows)
+	for by := 0; by < blockRows; by++ {
+		for bx := 0; bx < blockCols; bx++ {
+			if blockVisible(alpha, w, h, n, bx, by, ox, oy) {
+				blocks = append(blocks, by*blockCols+bx)
+			}
+		}
+	}
+	if len(blocks) == blockCols*blockRows {
+		return nil
+	}
+	return blocks
+}
+
+func blockVisible(alpha []uint8, w, h, n, bx, by, ox, oy int) bool {
+	for j := 0; j < n; j++ {
+		srcY := min(by*n+j+oy, h-1)
+		for i := 0; i < n; i++ {
+			if alpha[srcY*w+min(bx*n+i+ox, w-1)] == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// maskedAlpha is alpha with the pixels mask excludes made transparent, for
+// visibleBlocks. Either may be nil.
+func maskedAlpha(alpha, mask []uint8) []uint8 {
+	if mask == nil {
+		return alpha
+	}
+	if alpha == nil {
+		return mask
+	}
+	out := make([]uint8, len(alpha))
+	for i, a := range alpha {
+		out[i] = min(a, mask[i])
+	}
+	return out
+}
+
+func checkMask(mask []uint8, w, h int) error {
+	if mask != nil && len(mask) != w*h {
+		return fmt.Errorf("mask length %d does not match %dx%d", len(mask), w, h)
+	}
+	return nil
+}
+
+// usableBlocks is visibleBlocks on the native grid for an embedder given
+// alpha (nil if opaque) and a region-of-interest mask.
+func usableBlocks(alpha, mask []uint8, w, h, n int) ([]int, error) {
+	if err := checkMask(mask, w, h); err != nil {
+		return nil, err
+	}
+	return visibleBlocks(maskedAlpha(alpha, mask), w, h, n, 0, 0), nil
+}
@@ -0,0 +1,129 @@
+package wm
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// maxParallelChunk caps how many indices run between cancellation checks.
+const maxParallelChunk = 256
+
+var workerCount atomic.Int32
+
+func SetWorkers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	workerCount.Store(int32(n))
+}
+
+func Workers() int {
+	n := int(workerCount.Load())
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// parallelFor calls fn for every index in [0, n) on up to workers goroutines.
+// Work is handed out in contiguous chunks so each worker touches neighbouring
+// blocks; callers write per-index results only, which keeps output
+// independent of scheduling order. The context is checked between chunks;
+// once it is done no new chunks start and its error is returned.
+func parallelFor(ctx context.Context, n, workers int, fn func(i int)) error {
+	if n <= 0 {
+		return ctx.Err()
+	}
+
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	chunk := n / (workers * 4)
+	if chunk < 1 {
+		chunk = 1
+	}
+	if chunk > maxParallelChunk {
+		chunk = maxParallelChunk
+	}
+
+	if workers == 1 {
+		for lo := 0; lo < n; lo += chunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			hi := lo + chunk
+			if hi > n {
+				hi = n
+			}
+			for i := lo; i < hi; i++ {
+				fn(i)
+			}
+		}
+		return nil
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				if ctx.Err() != nil {
+					return
+				}
+				lo := int(next.Add(int64(chunk))) - chunk
+				if lo >= n {
+					return
+				}
+				hi := lo + chunk
+				if hi > n {
+					hi = n
+				}
+				for i := lo; i < hi; i++ {
+					fn(i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// cancelPoll amortises ctx.Err() over hot loops such as the bit-flip search,
+// checking the context only every cancelPollInterval calls.
+type cancelPoll struct {
+	ctx context.Context
+	n   int
+	err error
+}
+
+const cancelPollInterval = 1024
+
+func newCancelPoll(ctx context.Context) *cancelPoll {
+	return &cancelPoll{ctx: ctx}
+}
+
+func (p *cancelPoll) stop() bool {
+	if p == nil {
+		return false
+	}
+	if p.err != nil {
+		return true
+	}
+	p.n++
+	if p.n%cancelPollInterval != 0 {
+		return false
+	}
+	p.err = p.ctx.Err()
+	return p.err != nil
+}
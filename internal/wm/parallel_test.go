@@ -0,0 +1,111 @@
+package wm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// testCover is a w x h cover of gradients under keyed noise, with enough
+// texture to carry the mark.
+func testCover(w, h int, seed string) *spectralimage.Image {
+	clamp := func(v int) uint8 { return uint8(min(max(v, 0), 255)) }
+	rng := NewPRNG(SeedFromKey(seed))
+	img := &spectralimage.Image{W: w, H: h, Pix: make([]spectralimage.Rgb, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			n := int(rng.NextU64()>>59) - 16
+			img.Pix[y*w+x] = spectralimage.Rgb{
+				R: clamp(48 + x*160/w + n),
+				G: clamp(64 + y*128/h + n),
+				B: clamp(96 + (x+y)*96/(w+h) + n),
+			}
+		}
+	}
+	return img
+}
+
+type detection struct {
+	score   float32
+	present bool
+	msg     string
+	ok      bool
+}
+
+// TestWorkersDeterministic embeds and detects on one worker and on several,
+// which must give the same pixels and the same detection.
+func TestWorkersDeterministic(t *testing.T) {
+	defer SetWorkers(0)
+	src := testCover(320, 256, "workers")
+
+	var outs []*spectralimage.Image
+	var dets []detection
+	for _, workers := range []int{1, 4} {
+		SetWorkers(workers)
+		out, err := EmbedImage(src, "k", "HELLO", 3)
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		var d detection
+		d.score, d.present, d.msg, d.ok, err = DetectImage(out, "k")
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		if !d.ok || d.msg != "HELLO" {
+			t.Fatalf("%d workers: detect = %+v", workers, d)
+		}
+		outs = append(outs, out)
+		dets = append(dets, d)
+	}
+	if !reflect.DeepEqual(outs[0].Pix, outs[1].Pix) {
+		t.Error("embed output differs between 1 and 4 workers")
+	}
+	if dets[0] != dets[1] {
+		t.Errorf("detect differs between 1 and 4 workers: %+v vs %+v", dets[0], dets[1])
+	}
+}
+
+// TestWorkersDeterministicOptions repeats the check for the options that add
+// their own parallel passes: other profiles, the closed loop and pyramid
+// levels.
+func TestWorkersDeterministicOptions(t *testing.T) {
+	defer SetWorkers(0)
+	ctx := context.Background()
+	src := testCover(512, 384, "workers")
+	cases := []EmbedOptions{
+		{Alpha: 3, Profile: "16x16"},
+		{Alpha: 3, ClosedLoopIterations: 3},
+		{Alpha: 3, PyramidLevels: 2},
+	}
+	for _, opts := range cases {
+		var outs []*spectralimage.Image
+		var reports []*EmbedReport
+		var dets []detection
+		for _, workers := range []int{1, 4} {
+			SetWorkers(workers)
+			out, report, err := EmbedImageOptions(ctx, src, "k", "HELLO", opts)
+			if err != nil {
+				t.Fatalf("%+v on %d workers: %v", opts, workers, err)
+			}
+			var d detection
+			d.score, d.present, d.msg, d.ok, err = DetectImageOptions(ctx, out, "k", DetectOptions{Profile: opts.Profile})
+			if err != nil {
+				t.Fatalf("%+v on %d workers: %v", opts, workers, err)
+			}
+			outs = append(outs, out)
+			reports = append(reports, report)
+			dets = append(dets, d)
+		}
+		if !reflect.DeepEqual(outs[0].Pix, outs[1].Pix) {
+			t.Errorf("%+v: embed output differs between 1 and 4 workers", opts)
+		}
+		if !reflect.DeepEqual(reports[0], reports[1]) {
+			t.Errorf("%+v: report differs between 1 and 4 workers", opts)
+		}
+		if dets[0] != dets[1] || !dets[0].ok {
+			t.Errorf("%+v: detect = %+v on 1 worker, %+v on 4", opts, dets[0], dets[1])
+		}
+	}
+}
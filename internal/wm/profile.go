@@ -0,0 +1,83 @@
+package wm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Profile selects the transform block size and the coefficients that carry
+// the mark. Larger blocks put the same coefficient indices at lower absolute
+// spatial frequencies, which survive downscaling better, at the cost of
+// fewer blocks (and so fewer slots) per image.
+type Profile struct {
+	Name      string
+	BlockSize int
+	coeffs    []coeffPos
+	scheme    string
+}
+
+const DefaultProfileName = "8x8"
+
+var largeBlockPositions = []coeffPos{
+	{u: 1, v: 2},
+	{u: 2, v: 1},
+	{u: 2, v: 2},
+	{u: 3, v: 1},
+	{u: 1, v: 3},
+	{u: 3, v: 2},
+	{u: 2, v: 3},
+	{u: 3, v: 3},
+}
+
+// The 8x8 profile keeps the original scheme name so existing marks still
+// decode; other profiles get their own keyed permutation.
+var profiles = []Profile{
+	{Name: "8x8", BlockSize: 8, coeffs: midFreqPositions[:], scheme: spreadScheme},
+	{Name: "16x16", BlockSize: 16, coeffs: largeBlockPositions, scheme: spreadScheme + "-b16"},
+	{Name: "32x32", BlockSize: 32, coeffs: largeBlockPositions, scheme: spreadScheme + "-b32"},
+}
+
+func DefaultProfile() Profile {
+	return profiles[0]
+}
+
+func LookupProfile(name string) (Profile, error) {
+	if name == "" {
+		return DefaultProfile(), nil
+	}
+	for _, p := range profiles {
+		if strings.EqualFold(p.Name, name) {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("unknown profile %q (expected %s)", name, strings.Join(ProfileNames(), ", "))
+}
+
+func ProfileNames() []string {
+	out := make([]string, len(profiles))
+	for i, p := range profiles {
+		out[i] = p.Name
+	}
+	return out
+}
+
+// targetScale multiplies the coefficient target so that alpha means the same
+// change per pixel at every block size. An orthonormal n x n DCT spreads a
+// coefficient over the block at about 2/n per pixel, so a 32x32 block at the
+// 8x8 target moves pixels by a quarter as much, mostly less than the half
+// level that survives 8-bit output.
+func (p Profile) targetScale() float32 {
+	return float32(p.BlockSize) / 8
+}
+
+func (p Profile) slotsPerBlock() int {
+	return len(p.coeffs)
+}
+
+// coeffIndex returns where coefficient c of block blockIdx sits in a plane
+// laid out by DCTNPlaneRows.
+func (p Profile) coeffIndex(blockIdx, c, blockCols, w2 int) int {
+	pos := p.coeffs[c]
+	n := p.BlockSize
+	return (blockIdx/blockCols*n+pos.v)*w2 + blockIdx%blockCols*n + pos.u
+}
@@ -0,0 +1,51 @@
+package wm
+
+import (
+	"context"
+	"testing"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// smoothCover is a w x h cover of gentle gradients, where rounding to 8 bits
+// leaves little of a weak mark.
+func smoothCover(w, h int) *spectralimage.Image {
+	img := &spectralimage.Image{W: w, H: h, Pix: make([]spectralimage.Rgb, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Pix[y*w+x] = spectralimage.Rgb{R: uint8(64 + x*128/w), G: uint8(96 + y*96/h), B: uint8(128 + (x+y)*64/(w+h))}
+		}
+	}
+	return img
+}
+
+// TestProfilesRoundTrip8Bit embeds with every profile, which returns the
+// image rounded to 8 bits, and detects with no attack in between.
+func TestProfilesRoundTrip8Bit(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name   string
+		src    *spectralimage.Image
+		alphas []float32
+	}{
+		{"textured", testCover(512, 384, "profiles"), []float32{3, 5}},
+		{"smooth", smoothCover(512, 384), []float32{5}},
+	}
+	for _, c := range cases {
+		for _, profile := range ProfileNames() {
+			for _, alpha := range c.alphas {
+				out, _, err := EmbedImageOptions(ctx, c.src, "k", "HELLO", EmbedOptions{Alpha: alpha, Profile: profile})
+				if err != nil {
+					t.Fatalf("%s %s alpha %g: %v", c.name, profile, alpha, err)
+				}
+				_, _, msg, ok, err := DetectImageOptions(ctx, out, "k", DetectOptions{Profile: profile})
+				if err != nil {
+					t.Fatalf("%s %s alpha %g: %v", c.name, profile, alpha, err)
+				}
+				if !ok || msg != "HELLO" {
+					t.Errorf("%s %s alpha %g: detect = %q ok %v", c.name, profile, alpha, msg, ok)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package wm
+
+import (
+	"context"
+	"fmt"
+
+	spectralmath "spectralmark/internal/math"
+)
+
+// A thumbnail is roughly the image run down a Gaussian pyramid, so a mark
+// laid on the block grid of pyramid level k (1/2^k of the size) turns up on
+// the native grid of a thumbnail at that scale. The embedder marks each
+// requested level before the native mark: it reduces the luma plane to the
+// level, marks it as usual, and expands the change back up to full size.
+// Reduce after expand loses some of the change, so this repeats until the
+// level measures at its target. The detector reduces the image one level at
+// a time when the native grid does not decode, which finds both a coarse
+// level of a full-size copy and the native level of a thumbnail without any
+// scale search.
+
+// MaxPyramidLevels is the coarsest level the embedder marks and the
+// detector tries: 1/8 of the size.
+const MaxPyramidLevels = 3
+
+const (
+	// pyramidIterations bounds the reduce/mark/expand rounds per level.
+	pyramidIterations = 4
+	// minPyramidSize is the smallest side the detector reduces to.
+	minPyramidSize = 64
+)
+
+// embedPyramid marks levels 1..opts.PyramidLevels of the luma plane y and
+// returns the new plane; y is not modified. alpha is as for visibleBlocks
+// and opts.Mask must already be checked.
+func embedPyramid(ctx context.Context, y []float32, alpha []uint8, w, h int, key string, bits []int8, profile Profile, opts EmbedOptions) ([]float32, error) {
+	if opts.PyramidLevels == 0 {
+		return y, nil
+	}
+	y = append([]float32(nil), y...)
+
+	// Level sizes and the alpha each level's blocks are chosen from.
+	ws, hs := []int{w}, []int{h}
+	alphas := [][]uint8{maskedAlpha(alpha, opts.Mask)}
+	for k := 1; k <= opts.PyramidLevels; k++ {
+		ws = append(ws, (ws[k-1]+1)/2)
+		hs = append(hs, (hs[k-1]+1)/2)
+		alphas = append(alphas, reduceAlpha(alphas[k-1], ws[k-1], hs[k-1]))
+	}
+
+	// The expanded change spreads past the blocks of its level, so it is
+	// cut back to the blocks the native mark may use: pixels kept out by
+	// alpha or the mask stay untouched at every level.
+	keep := usablePixels(alphas[0], w, h, profile.BlockSize)
+
+	// Levels are marked finest first: the expanded change of a coarser level
+	// lies below the frequencies the finer levels are marked in, while a
+	// finer level's change still shows after one reduction.
+	levelOpts := opts
+	levelOpts.ClosedLoopIterations = 0
+	identity := func(v float32, _ int) float32 { return v }
+	for k := 1; k <= opts.PyramidLevels; k++ {
+		plan, err := planEmbed(profile, key, bits, ws[k], hs[k], visibleBlocks(alphas[k], ws[k], hs[k], profile.BlockSize, 0, 0))
+		if err != nil {
+			return nil, fmt.Errorf("pyramid level %d: %w", k, err)
+		}
+
+		for iter := 0; iter < pyramidIterations; iter++ {
+			level := y
+			for j := 1; j <= k; j++ {
+				level, _, _ = spectralmath.Reduce(level, ws[j-1], hs[j-1])
+			}
+			marked, _, err := embedLumaPlan(ctx, level, ws[k], hs[k], plan, profile, levelOpts, identity)
+			if err != nil {
+				return nil, err
+			}
+
+			delta := marked
+			changed := false
+			for i := range delta {
+				delta[i] -= level[i]
+				if delta[i] > 0.01 || delta[i] < -0.01 {
+					changed = true
+				}
+			}
+			if !changed {
+				break
+			}
+			for j := k; j >= 1; j-- {
+				delta = spectralmath.Expand(delta, ws[j], hs[j], ws[j-1], hs[j-1])
+			}
+			for i, d := range delta {
+				if keep == nil || keep[i] {
+					y[i] += d
+				}
+			}
+		}
+	}
+	return y, nil
+}
+
+// reduceAlpha is alpha at the next pyramid level: a pixel is transparent
+// there if any pixel it covers is. nil stays nil.
+func reduceAlpha(alpha []uint8, w, h int) []uint8 {
+	if alpha == nil {
+		return nil
+	}
+	w2, h2 := (w+1)/2, (h+1)/2
+	out := make([]uint8, w2*h2)
+	for y := 0; y < h2; y++ {
+		y0, y1 := 2*y, min(2*y+1, h-1)
+		for x := 0; x < w2; x++ {
+			x0, x1 := 2*x, min(2*x+1, w-1)
+			out[y*w2+x] = min(alpha[y0*w+x0], alpha[y0*w+x1], alpha[y1*w+x0], alpha[y1*w+x1])
+		}
+	}
+	return out
+}
+
+// usablePixels flags the pixels of the blocks visibleBlocks allows on the
+// native grid. nil means every pixel.
+func usablePixels(alpha []uint8, w, h, n int) []bool {
+	blocks := visibleBlocks(alpha, w, h, n, 0, 0)
+	if blocks == nil {
+		return nil
+	}
+	blockCols := (w + n - 1) / n
+	keep := make([]bool, w*h)
+	for _, b := range blocks {
+		x0, y0 := b%blockCols*n, b/blockCols*n
+		for y := y0; y < min(y0+n, h); y++ {
+			for x := x0; x < min(x0+n, w); x++ {
+				keep[y*w+x] = true
+			}
+		}
+	}
+	return keep
+}
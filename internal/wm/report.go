@@ -0,0 +1,67 @@
+package wm
+
+type EmbedOptions struct {
+	Alpha float32
+	// Profile names the block size and coefficient set (see LookupProfile);
+	// empty selects DefaultProfileName.
+	Profile string
+	// ClosedLoopIterations is how many times a block may be re-measured after
+	// 8-bit quantization and topped up. Zero keeps the single-pass embed.
+	ClosedLoopIterations int
+	// BitExact runs colour conversion and the DCT in fixed-point integer
+	// arithmetic, so the same input, key and options produce byte-identical
+	// output on every platform. Detection is unaffected.
+	BitExact bool
+	// Mask is a region-of-interest plane of w*h values, in the image's
+	// display orientation: blocks with a zero anywhere carry no slots and
+	// are left untouched. nil marks every block. Detection needs the same
+	// mask (DetectOptions.Mask).
+	Mask []uint8
+	// PyramidLevels also marks the luma plane's 1/2, 1/4, ... downscales,
+	// up to MaxPyramidLevels, so the mark survives thumbnailing at those
+	// scales (see embedPyramid). Each level must hold the payload. Not
+	// available in bit-exact mode.
+	PyramidLevels int
+}
+
+// EmbedReport describes the margin each payload slot ended up with in the
+// quantized output. Margins are signed projections onto the intended
+// direction, indexed by slot order (symbol*spreadChipsPerSymbol + chip);
+// anything below Target is weaker than requested and negative values decode
+// as the wrong sign.
+type EmbedReport struct {
+	Target        float32
+	Margins       []float32
+	MinMargin     float32
+	MeanMargin    float32
+	Deficient     int
+	MaxIterations int
+}
+
+func newEmbedReport(target float32, margins []float32, iterations []int) *EmbedReport {
+	r := &EmbedReport{
+		Target:  target,
+		Margins: margins,
+	}
+
+	sum := float64(0)
+	for i, m := range margins {
+		if i == 0 || m < r.MinMargin {
+			r.MinMargin = m
+		}
+		if m < target {
+			r.Deficient++
+		}
+		sum += float64(m)
+	}
+	if len(margins) > 0 {
+		r.MeanMargin = float32(sum / float64(len(margins)))
+	}
+
+	for _, it := range iterations {
+		if it > r.MaxIterations {
+			r.MaxIterations = it
+		}
+	}
+	return r
+}
@@ -0,0 +1,70 @@
+package wm
+
+import (
+	"context"
+	stdmath "math"
+	"testing"
+
+	spectralimage "spectralmark/internal/image"
+	spectralmath "spectralmark/internal/math"
+)
+
+// measuredMargins reads every payload slot's margin back from the 8-bit
+// output, independently of the embed loop.
+func measuredMargins(img *spectralimage.Image, key, msg string) []float32 {
+	y, _, _ := spectralimage.RGBToYCbCr(img)
+	yPad, w2, h2 := spectralmath.PadTo8(y, img.W, img.H)
+	bits := EncodePayload(msg)
+	total := (w2 / 8) * (h2 / 8) * len(midFreqPositions)
+	slots, chips := shuffledSlotsAndChips(spreadScheme, key, total, len(bits)*spreadChipsPerSymbol)
+
+	margins := make([]float32, len(slots))
+	for i, slot := range slots {
+		blockIdx := slot / len(midFreqPositions)
+		pos := midFreqPositions[slot%len(midFreqPositions)]
+		coeff := spectralmath.DCT8(spectralmath.GetBlock8(yPad, w2, blockIdx%(w2/8), blockIdx/(w2/8)))
+		margins[i] = coeff[pos.v][pos.u] * float32(bits[i/spreadChipsPerSymbol]*chips[i])
+	}
+	return margins
+}
+
+func TestClosedLoopReportMatchesOutput(t *testing.T) {
+	ctx := context.Background()
+	src := testCover(256, 192, "closed-loop")
+
+	var deficient []int
+	for _, iterations := range []int{0, 3} {
+		out, rep, err := EmbedImageOptions(ctx, src, "k", "HELLO", EmbedOptions{Alpha: 3, ClosedLoopIterations: iterations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := measuredMargins(out, "k", "HELLO")
+		if len(got) != len(rep.Margins) {
+			t.Fatalf("%d iterations: report has %d margins, output %d", iterations, len(rep.Margins), len(got))
+		}
+		short := 0
+		minMargin := float32(stdmath.Inf(1))
+		for i, m := range got {
+			if d := m - rep.Margins[i]; d > 1e-2 || d < -1e-2 {
+				t.Fatalf("%d iterations: slot %d margin %g in the output, %g in the report", iterations, i, m, rep.Margins[i])
+			}
+			if m < rep.Target {
+				short++
+			}
+			minMargin = min(minMargin, m)
+		}
+		if short != rep.Deficient {
+			t.Errorf("%d iterations: %d slots below target in the output, report says %d", iterations, short, rep.Deficient)
+		}
+		if d := minMargin - rep.MinMargin; d > 1e-2 || d < -1e-2 {
+			t.Errorf("%d iterations: min margin %g in the output, %g in the report", iterations, minMargin, rep.MinMargin)
+		}
+		if rep.MaxIterations > iterations {
+			t.Errorf("%d iterations: report says %d", iterations, rep.MaxIterations)
+		}
+		deficient = append(deficient, rep.Deficient)
+	}
+	if deficient[1] >= deficient[0] {
+		t.Errorf("closed loop left %d slots below target, single pass %d", deficient[1], deficient[0])
+	}
+}
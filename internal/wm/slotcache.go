@@ -0,0 +1,143 @@
+package wm
+
+import (
+	"container/list"
+	"sync"
+)
+
+const (
+	slotCacheMaxEntries = 16
+	// slotCacheMaxSlots bounds the summed slot count of all cached mappings
+	// (each slot costs an int and an int8, so this is roughly 150 MB on
+	// 64-bit platforms).
+	slotCacheMaxSlots = 16 << 20
+)
+
+type SlotCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Slots     int
+}
+
+func (s SlotCacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+type slotMappingKey struct {
+	scheme     string
+	key        string
+	totalSlots int
+}
+
+// slotMapping is a full keyed permutation of totalSlots slots plus one chip
+// per slot. Both slices are shared between callers and must not be modified.
+type slotMapping struct {
+	order []int
+	chips []int8
+}
+
+type slotCacheEntry struct {
+	k slotMappingKey
+	m *slotMapping
+}
+
+type slotCache struct {
+	mu         sync.Mutex
+	items      map[slotMappingKey]*list.Element
+	lru        *list.List
+	slots      int
+	maxEntries int
+	maxSlots   int
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+}
+
+var defaultSlotCache = newSlotCache(slotCacheMaxEntries, slotCacheMaxSlots)
+
+func newSlotCache(maxEntries, maxSlots int) *slotCache {
+	return &slotCache{
+		items:      make(map[slotMappingKey]*list.Element),
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		maxSlots:   maxSlots,
+	}
+}
+
+func SlotCacheSnapshot() SlotCacheStats {
+	return defaultSlotCache.stats()
+}
+
+func ResetSlotCache() {
+	defaultSlotCache.reset()
+}
+
+func (c *slotCache) get(k slotMappingKey, build func() *slotMapping) *slotMapping {
+	c.mu.Lock()
+	if el, ok := c.items[k]; ok {
+		c.lru.MoveToFront(el)
+		c.hits++
+		m := el.Value.(*slotCacheEntry).m
+		c.mu.Unlock()
+		return m
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// Build outside the lock; concurrent misses for the same key produce
+	// identical mappings, so whichever lands first is kept.
+	m := build()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*slotCacheEntry).m
+	}
+	if k.totalSlots > c.maxSlots {
+		return m
+	}
+
+	c.items[k] = c.lru.PushFront(&slotCacheEntry{k: k, m: m})
+	c.slots += k.totalSlots
+	for c.lru.Len() > c.maxEntries || c.slots > c.maxSlots {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			break
+		}
+		entry := c.lru.Remove(oldest).(*slotCacheEntry)
+		delete(c.items, entry.k)
+		c.slots -= entry.k.totalSlots
+		c.evictions++
+	}
+	return m
+}
+
+func (c *slotCache) stats() SlotCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return SlotCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Slots:     c.slots,
+	}
+}
+
+func (c *slotCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[slotMappingKey]*list.Element)
+	c.lru.Init()
+	c.slots = 0
+	c.hits = 0
+	c.misses = 0
+	c.evictions = 0
+}
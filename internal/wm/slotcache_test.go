@@ -0,0 +1,53 @@
+package wm
+
+import "testing"
+
+func TestSlotCacheCountsHitsAndMisses(t *testing.T) {
+	ResetSlotCache()
+	defer ResetSlotCache()
+
+	src := testCover(256, 192, "cache")
+	marked, err := EmbedImage(src, "k", "HI", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := SlotCacheSnapshot()
+	if embedded.Misses != 1 || embedded.Hits != 0 || embedded.Entries != 1 {
+		t.Fatalf("after embed: %+v", embedded)
+	}
+
+	// Detecting with the same key and size reuses the mapping.
+	if _, _, _, ok, err := DetectImage(marked, "k"); err != nil || !ok {
+		t.Fatalf("detect: ok %v err %v", ok, err)
+	}
+	detected := SlotCacheSnapshot()
+	if detected.Misses != 1 || detected.Hits == 0 {
+		t.Fatalf("after detect: %+v", detected)
+	}
+
+	if _, err := EmbedImage(src, "other", "HI", 3); err != nil {
+		t.Fatal(err)
+	}
+	if s := SlotCacheSnapshot(); s.Misses != 2 || s.Entries != 2 {
+		t.Fatalf("after a second key: %+v", s)
+	}
+}
+
+func TestSlotCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newSlotCache(2, 1<<20)
+	build := func() *slotMapping { return &slotMapping{} }
+	for _, key := range []string{"a", "b", "a", "c"} {
+		c.get(slotMappingKey{key: key, totalSlots: 10}, build)
+	}
+	s := c.stats()
+	if s.Hits != 1 || s.Misses != 3 || s.Evictions != 1 || s.Entries != 2 || s.Slots != 20 {
+		t.Fatalf("stats %+v", s)
+	}
+
+	// "b" was the least recently used, so it is the one evicted.
+	c.get(slotMappingKey{key: "a", totalSlots: 10}, build)
+	c.get(slotMappingKey{key: "b", totalSlots: 10}, build)
+	if s := c.stats(); s.Hits != 2 || s.Misses != 4 {
+		t.Fatalf("stats %+v", s)
+	}
+}
@@ -3,9 +3,13 @@ package wm
 const (
 	spreadChipsPerSymbol = 1
 	spreadTargetScale    = 0.70
+	spreadScheme         = "spread-v1"
 )
 
-func shuffledSlotsAndChips(key string, totalSlots, neededSlots int) (slots []int, chips []int8) {
+// shuffledSlotsAndChips returns the first neededSlots entries of the keyed
+// slot permutation and chip sequence. The slices come from the shared slot
+// cache and must be treated as read-only.
+func shuffledSlotsAndChips(scheme, key string, totalSlots, neededSlots int) (slots []int, chips []int8) {
 	if totalSlots <= 0 || neededSlots <= 0 {
 		return nil, nil
 	}
@@ -13,7 +17,19 @@ func shuffledSlotsAndChips(key string, totalSlots, neededSlots int) (slots []int
 		neededSlots = totalSlots
 	}
 
-	rng := NewPRNG(SeedFromKey("spread-v1:" + key))
+	k := slotMappingKey{scheme: scheme, key: key, totalSlots: totalSlots}
+	m := defaultSlotCache.get(k, func() *slotMapping {
+		return buildSlotMapping(scheme+":"+key, totalSlots)
+	})
+
+	return m.order[:neededSlots:neededSlots], m.chips[:neededSlots:neededSlots]
+}
+
+// buildSlotMapping shuffles all slots and then draws one chip per slot.
+// Chips follow the shuffle in the PRNG stream, so any prefix of the chip
+// sequence is the same no matter how many symbols a caller needs.
+func buildSlotMapping(seed string, totalSlots int) *slotMapping {
+	rng := NewPRNG(SeedFromKey(seed))
 
 	order := make([]int, totalSlots)
 	for i := 0; i < totalSlots; i++ {
@@ -25,7 +41,7 @@ func shuffledSlotsAndChips(key string, totalSlots, neededSlots int) (slots []int
 		order[i], order[j] = order[j], order[i]
 	}
 
-	chips = make([]int8, neededSlots)
+	chips := make([]int8, totalSlots)
 	for i := range chips {
 		if rng.NextPM1() < 0 {
 			chips[i] = -1
@@ -34,5 +50,5 @@ func shuffledSlotsAndChips(key string, totalSlots, neededSlots int) (slots []int
 		}
 	}
 
-	return order[:neededSlots], chips
+	return &slotMapping{order: order, chips: chips}
 }
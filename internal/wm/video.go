@@ -0,0 +1,288 @@
+package wm
+
+import (
+	"context"
+	"fmt"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// Video marks every frame with the same payload through the still-image
+// embedder. Frames stay independent so the detector can use any subset in
+// any order: each frame's symbol correlations are weighted by how well its
+// sync word matched and summed, and the sum is decoded once. Weak per-frame
+// evidence, for example after heavy compression, adds up across frames while
+// the host content, which differs from frame to frame, averages out.
+
+type VideoEmbedOptions struct {
+	EmbedOptions
+	// KeyPeriod > 1 varies the slot mapping with the frame index modulo
+	// KeyPeriod, so neighbouring frames do not carry the mark in the same
+	// coefficients. 0 or 1 uses one mapping for every frame.
+	KeyPeriod int
+}
+
+type VideoDetectOptions struct {
+	DetectOptions
+	// KeyPeriod must match the embedder. The detector does not need frame
+	// indices; it tries every phase of the period on each frame.
+	KeyPeriod int
+}
+
+type VideoDetectResult struct {
+	Score   float32
+	Present bool
+	Msg     string
+	OK      bool
+	Profile string
+	// FramesUsed counts frames whose sync word correlated positively and so
+	// contributed to the combined symbols.
+	FramesUsed int
+}
+
+// frameKey derives the mapping key of frame idx.
+func frameKey(key string, idx, period int) string {
+	if period <= 1 {
+		return key
+	}
+	return fmt.Sprintf("%s#frame%d", key, idx%period)
+}
+
+func EmbedFrames(ctx context.Context, frames []*spectralimage.Image, key, msg string, opts VideoEmbedOptions) ([]*spectralimage.Image, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames")
+	}
+
+	out := make([]*spectralimage.Image, len(frames))
+	for i, frame := range frames {
+		marked, err := EmbedFrame(ctx, frame, i, key, msg, opts)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		out[i] = marked
+	}
+	return out, nil
+}
+
+// EmbedFrame marks frame idx of a sequence, for callers that stream frames.
+func EmbedFrame(ctx context.Context, frame *spectralimage.Image, idx int, key, msg string, opts VideoEmbedOptions) (*spectralimage.Image, error) {
+	if opts.KeyPeriod < 0 {
+		return nil, fmt.Errorf("key period must be >= 0")
+	}
+	marked, _, err := EmbedImageOptions(ctx, frame, frameKey(key, idx, opts.KeyPeriod), msg, opts.EmbedOptions)
+	return marked, err
+}
+
+// EmbedFrameLuma8 is EmbedFrame on the Y plane of a YUV frame.
+func EmbedFrameLuma8(ctx context.Context, y []uint8, w, h, idx int, key, msg string, opts VideoEmbedOptions) ([]uint8, error) {
+	if opts.KeyPeriod < 0 {
+		return nil, fmt.Errorf("key period must be >= 0")
+	}
+	marked, _, err := EmbedLuma8(ctx, y, w, h, frameKey(key, idx, opts.KeyPeriod), msg, opts.EmbedOptions)
+	return marked, err
+}
+
+// DetectFrames combines the evidence of all frames and decodes the payload.
+// Frames must share one size; they may be any subset of the embedded
+// sequence, in any order.
+func DetectFrames(ctx context.Context, frames []*spectralimage.Image, key string, opts VideoDetectOptions) (*VideoDetectResult, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames")
+	}
+	if frames[0] == nil {
+		return nil, fmt.Errorf("frame 0 is nil")
+	}
+
+	d, err := NewVideoDetector(frames[0].W, frames[0].H, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range frames {
+		if err := d.AddFrame(ctx, f); err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+	}
+	return d.Result(ctx)
+}
+
+// VideoDetector accumulates per-frame evidence for every candidate profile
+// so frames can be fed one at a time and dropped afterwards.
+type VideoDetector struct {
+	w          int
+	h          int
+	key        string
+	period     int
+	profiles   []Profile
+	combined   [][]float32
+	framesUsed []int
+	mask       []uint8
+}
+
+func NewVideoDetector(w, h int, key string, opts VideoDetectOptions) (*VideoDetector, error) {
+	if w <= 0 || h <= 0 {
+		return nil, fmt.Errorf("invalid frame size %dx%d", w, h)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	if opts.KeyPeriod < 0 {
+		return nil, fmt.Errorf("key period must be >= 0")
+	}
+	candidates, err := lookupDetectProfiles(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VideoDetector{
+		w:          w,
+		h:          h,
+		key:        key,
+		period:     opts.KeyPeriod,
+		profiles:   candidates,
+		combined:   make([][]float32, len(candidates)),
+		framesUsed: make([]int, len(candidates)),
+		mask:       opts.Mask,
+	}, nil
+}
+
+// NewFrameDetector is NewVideoDetector for frames that differ in size, such
+// as the stored frames of an animated GIF. Symbols are combined by index,
+// which does not depend on the frame size. AddLuma8 needs a fixed size and
+// is not available.
+func NewFrameDetector(key string, opts VideoDetectOptions) (*VideoDetector, error) {
+	d, err := NewVideoDetector(1, 1, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	d.w, d.h = 0, 0
+	return d, nil
+}
+
+func (d *VideoDetector) AddFrame(ctx context.Context, f *spectralimage.Image) error {
+	if f == nil {
+		return fmt.Errorf("frame is nil")
+	}
+	if d.w != 0 && (f.W != d.w || f.H != d.h) {
+		return fmt.Errorf("frame is %dx%d, expected %dx%d", f.W, f.H, d.w, d.h)
+	}
+	if f.Alpha != nil && len(f.Alpha) != len(f.Pix) {
+		return fmt.Errorf("alpha length %d does not match %d pixels", len(f.Alpha), len(f.Pix))
+	}
+	y, _, _ := spectralimage.RGBToYCbCr(f)
+	return d.addLuma(ctx, y, f.Alpha, f.W, f.H)
+}
+
+// AddLuma8 adds the Y plane of a YUV frame.
+func (d *VideoDetector) AddLuma8(ctx context.Context, y []uint8) error {
+	if d.w == 0 {
+		return fmt.Errorf("frame size is not fixed")
+	}
+	if len(y) != d.w*d.h {
+		return fmt.Errorf("luma plane size %d does not match %dx%d", len(y), d.w, d.h)
+	}
+	return d.addLuma(ctx, spectralimage.LumaToFloat(y), nil, d.w, d.h)
+}
+
+func (d *VideoDetector) addLuma(ctx context.Context, y []float32, alpha []uint8, w, h int) error {
+	if err := checkMask(d.mask, w, h); err != nil {
+		return err
+	}
+	alpha = maskedAlpha(alpha, d.mask)
+	phases := max(d.period, 1)
+	syncSymbols := syncSymbolPattern()
+	workers := Workers()
+
+	for pi, profile := range d.profiles {
+		plane, err := coeffPlaneFromLuma(ctx, y, w, h, profile.BlockSize, workers)
+		if err != nil {
+			return err
+		}
+		if plane == nil {
+			continue
+		}
+
+		blocks := visibleBlocks(alpha, w, h, profile.BlockSize, 0, 0)
+
+		// Pick the period phase whose sync word fits this frame best.
+		var frameSoft []float32
+		frameWeight := float32(0)
+		for k := 0; k < phases; k++ {
+			soft := plane.symbolSoft(frameKey(d.key, k, d.period), profile, blocks)
+			if wgt := syncCorrelation(soft, syncSymbols); wgt > frameWeight {
+				frameSoft = soft
+				frameWeight = wgt
+			}
+		}
+		if frameSoft == nil {
+			continue
+		}
+
+		// Transparency can leave a frame room for fewer symbols than the
+		// others; the payload sits at the front, so only the overlap adds up.
+		if len(frameSoft) > len(d.combined[pi]) {
+			d.combined[pi] = append(d.combined[pi], make([]float32, len(frameSoft)-len(d.combined[pi]))...)
+		}
+		for i, s := range frameSoft {
+			d.combined[pi][i] += s * frameWeight
+		}
+		d.framesUsed[pi]++
+	}
+	return nil
+}
+
+// Result decodes the evidence gathered so far, trying profiles in order and
+// stopping at the first that decodes.
+func (d *VideoDetector) Result(ctx context.Context) (*VideoDetectResult, error) {
+	var best *VideoDetectResult
+	for pi, profile := range d.profiles {
+		r := &VideoDetectResult{Profile: profile.Name, FramesUsed: d.framesUsed[pi]}
+		if combined := d.combined[pi]; combined != nil {
+			symbols := make([]int8, len(combined))
+			for i, soft := range combined {
+				if soft >= 0 {
+					symbols[i] = 1
+				} else {
+					symbols[i] = -1
+				}
+			}
+
+			poll := newCancelPoll(ctx)
+			r.Msg, r.OK = decodePayloadFromSymbolSoft(combined, 2, 10, poll)
+			if poll.err != nil {
+				return nil, poll.err
+			}
+			r.Score = estimateDetectScoreSymbols(symbols, r.Msg, r.OK)
+			r.Present = r.OK
+		}
+
+		if pi == 0 || betterDetectCandidate(r.Score, r.OK, best.Score, best.OK) {
+			best = r
+		}
+		if best.OK {
+			break
+		}
+	}
+	return best, nil
+}
+
+// syncCorrelation is the normalised correlation of the leading symbols with
+// the sync pattern, in [-1, 1]; 0 when there are too few symbols.
+func syncCorrelation(soft []float32, syncSymbols []int8) float32 {
+	if len(soft) < len(syncSymbols) {
+		return 0
+	}
+	corr := float32(0)
+	energy := float32(0)
+	for i, want := range syncSymbols {
+		corr += soft[i] * float32(want)
+		if soft[i] < 0 {
+			energy -= soft[i]
+		} else {
+			energy += soft[i]
+		}
+	}
+	if energy == 0 {
+		return 0
+	}
+	return corr / energy
+}
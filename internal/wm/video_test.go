@@ -0,0 +1,43 @@
+package wm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// TestDetectFramesShuffledSubset drops most frames of a clip marked with a
+// key period and shuffles the rest; the detector must still find every
+// frame's phase and combine them.
+func TestDetectFramesShuffledSubset(t *testing.T) {
+	ctx := context.Background()
+	frames := make([]*spectralimage.Image, 8)
+	for i := range frames {
+		frames[i] = testCover(256, 192, fmt.Sprintf("frame%d", i))
+	}
+	marked, err := EmbedFrames(ctx, frames, "k", "CLIP", VideoEmbedOptions{EmbedOptions: EmbedOptions{Alpha: 3}, KeyPeriod: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subset := []*spectralimage.Image{marked[6], marked[1], marked[3]}
+	res, err := DetectFrames(ctx, subset, "k", VideoDetectOptions{KeyPeriod: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK || res.Msg != "CLIP" || res.FramesUsed != len(subset) {
+		t.Errorf("shuffled subset: %+v", res)
+	}
+
+	// Without the period every frame but those at phase 0 is read under the
+	// wrong mapping, so the period must really vary it.
+	res, err = DetectFrames(ctx, []*spectralimage.Image{marked[1], marked[3]}, "k", VideoDetectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OK {
+		t.Errorf("frames at phases 1 and 3 decoded without the key period: %+v", res)
+	}
+}
@@ -0,0 +1,44 @@
+package spectralmark
+
+import (
+	"context"
+	"testing"
+)
+
+func TestEmbedPreservesAlpha(t *testing.T) {
+	ctx := context.Background()
+	img := texturedImage(512, 384)
+	// A fully transparent band on the left and a translucent one below it.
+	for y := 0; y < img.Rect.Dy(); y++ {
+		for x := 0; x < img.Rect.Dx(); x++ {
+			switch {
+			case x < 100:
+				img.Pix[img.PixOffset(x, y)+3] = 0
+			case y >= 300:
+				img.Pix[img.PixOffset(x, y)+3] = uint8(64 + x%128)
+			}
+		}
+	}
+
+	res, err := Embed(ctx, img, EmbedOptions{Key: "k", Message: "ALPHA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := res.Image
+	if out.Rect != img.Rect {
+		t.Fatalf("bounds %v, want %v", out.Rect, img.Rect)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		if out.Pix[i] != img.Pix[i] {
+			t.Fatalf("alpha at pixel %d is %d, want %d", i/4, out.Pix[i], img.Pix[i])
+		}
+	}
+
+	d, err := Detect(ctx, out, DetectOptions{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OK || d.Message != "ALPHA" {
+		t.Errorf("detect = %+v", d)
+	}
+}
@@ -0,0 +1,173 @@
+package spectralmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	spectralimage "spectralmark/internal/image"
+	spectralwm "spectralmark/internal/wm"
+)
+
+type AnimationOptions struct {
+	EmbedOptions
+	// Format is the container to write, "gif" or "png" (APNG); empty keeps
+	// the input's. GIF output needs paletted frames, which GIF input keeps
+	// unless BitExact is set.
+	Format string
+}
+
+type AnimationReport struct {
+	// Frames is how many frames the animation has, and Marked how many of
+	// them carry the mark. Frames without room for the payload, such as the
+	// small patches an optimised GIF redraws, are left as they are.
+	Frames int
+	Marked int
+	// FrameReports holds the report of each frame, nil for unmarked ones.
+	FrameReports []*EmbedReport
+	// Format is the container written, "gif" or "png".
+	Format string
+}
+
+// IsAnimation reports whether data is a GIF or an APNG with more than one
+// frame. EmbedAnimation and DetectAnimation also take single-frame ones.
+func IsAnimation(data []byte) bool {
+	return spectralimage.IsAnimation(data)
+}
+
+// EmbedAnimation marks every frame of a GIF or APNG read from r with the
+// same message and writes the animation to w. Frames are marked as stored,
+// each in its own rectangle, and keep their placement, delay, disposal and
+// blending; the loop count and a GIF's global palette are kept too.
+// Paletted frames are marked within their palette as by Embed. An APNG's
+// default image, when it is not the first frame, is marked as well.
+// opts.Region is in canvas coordinates; opts.Orientation is ignored.
+func EmbedAnimation(ctx context.Context, r io.Reader, w io.Writer, opts AnimationOptions) (*AnimationReport, error) {
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	if w == nil {
+		return nil, errors.New("spectralmark: writer is nil")
+	}
+	data, err := readImageData(r)
+	if err != nil {
+		return nil, err
+	}
+	a, format, err := spectralimage.DecodeAnimation(data)
+	if err != nil {
+		return nil, fmt.Errorf("spectralmark: %w", err)
+	}
+	if opts.Format != "" {
+		format, err = spectralimage.ParseFormat(opts.Format)
+		if err != nil || (format != spectralimage.FormatGIF && format != spectralimage.FormatPNG) {
+			return nil, fmt.Errorf("spectralmark: animation format %q is not gif or png", opts.Format)
+		}
+	}
+	if format == spectralimage.FormatGIF && opts.BitExact {
+		return nil, errors.New("spectralmark: bit-exact mode cannot keep gif frames paletted")
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+
+	report := &AnimationReport{
+		Frames:       len(a.Frames),
+		FrameReports: make([]*EmbedReport, len(a.Frames)),
+		Format:       string(format),
+	}
+	for i := range a.Frames {
+		img, rep, err := embedAnimationImage(ctx, a.Frames[i].Image, opts.EmbedOptions)
+		if err != nil {
+			return nil, fmt.Errorf("spectralmark: frame %d: %w", i, err)
+		}
+		if img != nil {
+			a.Frames[i].Image = img
+			report.FrameReports[i] = rep
+			report.Marked++
+		}
+	}
+	if report.Marked == 0 {
+		return nil, fmt.Errorf("spectralmark: no frame has room: %w", spectralwm.ErrNoCapacity)
+	}
+	if a.Default != nil {
+		img, _, err := embedAnimationImage(ctx, a.Default, opts.EmbedOptions)
+		if err != nil {
+			return nil, fmt.Errorf("spectralmark: default image: %w", err)
+		}
+		if img != nil {
+			a.Default = img
+		}
+	}
+
+	if err := spectralimage.EncodeAnimation(w, a, format); err != nil {
+		return nil, fmt.Errorf("spectralmark: %w", err)
+	}
+	return report, nil
+}
+
+// embedAnimationImage marks one frame in place on the canvas. It returns a
+// nil image, and no error, if the frame has no room for the payload.
+func embedAnimationImage(ctx context.Context, img image.Image, opts EmbedOptions) (image.Image, *EmbedReport, error) {
+	mask, err := opts.Region.plane(img.Bounds(), 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := embed(ctx, img, opts, mask)
+	if errors.Is(err, spectralwm.ErrNoCapacity) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return spectralimage.Translate(res.Native, img.Bounds().Min), &res.Report, nil
+}
+
+// DetectAnimation reads every frame of a GIF or APNG and decodes the
+// combined evidence, as DetectVideo does; frames may differ in size.
+// opts.Region is in canvas coordinates; opts.Orientation is ignored.
+func DetectAnimation(ctx context.Context, r io.Reader, opts DetectOptions) (*VideoDetectResult, error) {
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	data, err := readImageData(r)
+	if err != nil {
+		return nil, err
+	}
+	a, _, err := spectralimage.DecodeAnimation(data)
+	if err != nil {
+		return nil, fmt.Errorf("spectralmark: %w", err)
+	}
+
+	d, err := spectralwm.NewFrameDetector(opts.Key, spectralwm.VideoDetectOptions{
+		DetectOptions: spectralwm.DetectOptions{Profile: opts.Profile},
+	})
+	if err != nil {
+		return nil, err
+	}
+	images := make([]image.Image, 0, len(a.Frames)+1)
+	if a.Default != nil {
+		images = append(images, a.Default)
+	}
+	for _, f := range a.Frames {
+		images = append(images, f.Image)
+	}
+	for i, img := range images {
+		mask, err := opts.Region.plane(img.Bounds(), 1)
+		if err != nil {
+			return nil, err
+		}
+		f := spectralimage.FromStdImage(img)
+		maskFrame(f, mask)
+		if err := d.AddFrame(ctx, f); err != nil {
+			return nil, fmt.Errorf("spectralmark: frame %d: %w", i, err)
+		}
+	}
+
+	res, err := d.Result(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newVideoDetectResult(res, len(images)), nil
+}
@@ -0,0 +1,32 @@
+package spectralmark
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSlotCacheStats(t *testing.T) {
+	ctx := context.Background()
+	img := texturedImage(256, 192)
+	before := SlotCacheStats()
+
+	res, err := Embed(ctx, img, EmbedOptions{Key: "cache-stats-key", Message: "HI"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded := SlotCacheStats()
+	if embedded.Misses <= before.Misses {
+		t.Errorf("a new key was not a miss: %+v then %+v", before, embedded)
+	}
+
+	if _, err := Detect(ctx, res.Image, DetectOptions{Key: "cache-stats-key", Profile: DefaultProfile}); err != nil {
+		t.Fatal(err)
+	}
+	detected := SlotCacheStats()
+	if detected.Hits <= embedded.Hits {
+		t.Errorf("detect with the embed key missed the cache: %+v then %+v", embedded, detected)
+	}
+	if r := detected.HitRate(); r <= 0 || r > 1 {
+		t.Errorf("hit rate %v", r)
+	}
+}
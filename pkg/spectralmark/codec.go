@@ -0,0 +1,175 @@
+package spectralmark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+var ErrUnsupportedFormat = errors.New("spectralmark: unsupported image format")
+
+// Decode reads a PNG, JPEG, GIF, BMP, TGA or netpbm image; of an animated
+// GIF or APNG it reads the first frame (see EmbedAnimation for all of them).
+// Formats registered with the standard image package by the caller are
+// accepted as well; BMP and TGA are registered there by this package.
+//
+// Netpbm covers binary and ASCII PGM and PPM (P2, P3, P5, P6) at any maxval up
+// to 65535. Grayscale decodes to *image.Gray or *image.Gray16 and colour to
+// *image.NRGBA or *image.NRGBA64, with samples rescaled to the full range of
+// the type.
+func Decode(r io.Reader) (image.Image, error) {
+	data, err := readImageData(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeData(data)
+}
+
+func readImageData(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, errors.New("spectralmark: reader is nil")
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("spectralmark: read image: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("spectralmark: image data is empty")
+	}
+	return data, nil
+}
+
+func decodeData(data []byte) (image.Image, error) {
+	img, _, err := spectralimage.DecodeImage(data)
+	if errors.Is(err, spectralimage.ErrTooLarge) {
+		return nil, fmt.Errorf("spectralmark: %w", err)
+	}
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	return img, nil
+}
+
+func EncodePNG(w io.Writer, img image.Image) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	return png.Encode(w, img)
+}
+
+// EncodeGIF writes img as a single-frame GIF. img must be *image.Paletted,
+// as Embed returns for paletted input unless BitExact is set.
+func EncodeGIF(w io.Writer, img image.Image) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	return spectralimage.EncodeImage(w, img, spectralimage.FormatGIF, spectralimage.EncodeOptions{})
+}
+
+// EncodePPM writes img as a binary PPM (P6). Alpha is dropped.
+func EncodePPM(w io.Writer, img image.Image) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	return spectralimage.WritePPMWriter(w, spectralimage.FromStdImage(img))
+}
+
+type NetpbmOptions struct {
+	// ASCII writes the plain formats (P2, P3) instead of binary (P5, P6).
+	ASCII bool
+}
+
+// EncodeNetpbm writes img as a PGM if it is an *image.Gray or *image.Gray16
+// and as a PPM otherwise. *image.Gray16, *image.RGBA64 and *image.NRGBA64 are
+// written with 16 bits per sample. Alpha is dropped.
+func EncodeNetpbm(w io.Writer, img image.Image, opts NetpbmOptions) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	p := spectralimage.NetpbmFromStd(img)
+	p.ASCII = opts.ASCII
+	return spectralimage.WriteNetpbm(w, p)
+}
+
+type BMPOptions struct {
+	// RLE writes grayscale images as RLE8. True colour BMP has no RLE form
+	// and is always written uncompressed.
+	RLE bool
+}
+
+// EncodeBMP writes img as a BMP: *image.Gray as 8-bit with a gray palette
+// (which decodes back to *image.Gray), opaque images as 24-bit and images
+// with transparency as 32-bit with alpha. 16-bit samples are rounded to 8
+// bits.
+func EncodeBMP(w io.Writer, img image.Image, opts BMPOptions) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	return spectralimage.WriteBMP(w, img, opts.RLE)
+}
+
+type TGAOptions struct {
+	// RLE writes the run-length encoded image types.
+	RLE bool
+}
+
+// EncodeTGA writes img as a TGA: *image.Gray as 8-bit grayscale, opaque
+// images as 24-bit and images with transparency as 32-bit with alpha.
+// 16-bit samples are rounded to 8 bits.
+func EncodeTGA(w io.Writer, img image.Image, opts TGAOptions) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	return spectralimage.WriteTGA(w, img, opts.RLE)
+}
+
+// WithoutAlpha makes every pixel of img opaque, keeping the colour under
+// transparent ones. Embed skips blocks with transparent pixels, so an image
+// that will be stored without alpha (netpbm, JPEG) should go through this
+// first: the mark then covers the image the detector will read back.
+// Paletted images stay paletted, with every entry made opaque.
+func WithoutAlpha(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+
+	b := img.Bounds()
+	switch src := img.(type) {
+	case *image.Paletted:
+		out := *src
+		out.Pix = append([]uint8(nil), src.Pix...)
+		out.Palette = make(color.Palette, len(src.Palette))
+		for i, c := range src.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			n.A = 255
+			out.Palette[i] = n
+		}
+		return &out
+	case *image.RGBA64, *image.NRGBA64:
+		out := image.NewNRGBA64(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				c.A = 0xffff
+				out.SetNRGBA64(x, y, c)
+			}
+		}
+		return out
+	}
+
+	out := image.NewNRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			c.A = 255
+			out.SetNRGBA(x, y, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+package spectralmark
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestEmbedKeeps16BitDepth checks that 16-bit input comes back at 16 bits
+// with its low-order detail, not as 8-bit samples widened by 257.
+func TestEmbedKeeps16BitDepth(t *testing.T) {
+	ctx := context.Background()
+	src := texturedImage(512, 384)
+	b := src.Bounds()
+	rgb := image.NewNRGBA64(b)
+	gray := image.NewGray16(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := src.NRGBAAt(x, y)
+			fine := uint16((x*7 + y*13) % 256)
+			rgb.SetNRGBA64(x, y, color.NRGBA64{
+				R: uint16(c.R)<<8 | fine,
+				G: uint16(c.G)<<8 | fine,
+				B: uint16(c.B)<<8 | fine,
+				A: 0xffff,
+			})
+			gray.SetGray16(x, y, color.Gray16{Y: uint16(c.G)<<8 | fine})
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		img  image.Image
+	}{{"nrgba64", rgb}, {"gray16", gray}} {
+		res, err := Embed(ctx, tc.img, EmbedOptions{Key: "k", Message: "DEEP"})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		var samples []uint16
+		switch out := res.Native.(type) {
+		case *image.NRGBA64:
+			for i := 0; i < len(out.Pix); i += 8 {
+				for c := i; c < i+6; c += 2 {
+					samples = append(samples, uint16(out.Pix[c])<<8|uint16(out.Pix[c+1]))
+				}
+			}
+		case *image.Gray16:
+			for i := 0; i < len(out.Pix); i += 2 {
+				samples = append(samples, uint16(out.Pix[i])<<8|uint16(out.Pix[i+1]))
+			}
+		default:
+			t.Fatalf("%s: native output is %T", tc.name, res.Native)
+		}
+		widened := 0
+		for _, s := range samples {
+			if s%257 == 0 {
+				widened++
+			}
+		}
+		if widened > len(samples)/10 {
+			t.Errorf("%s: %d of %d samples look widened from 8 bits", tc.name, widened, len(samples))
+		}
+
+		d, err := Detect(ctx, res.Native, DetectOptions{Key: "k"})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !d.OK || d.Message != "DEEP" {
+			t.Errorf("%s: detect = %+v", tc.name, d)
+		}
+	}
+}
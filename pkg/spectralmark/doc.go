@@ -0,0 +1,24 @@
+// Package spectralmark embeds and detects keyed, invisible watermarks in
+// images. It is the supported entry point for programs that link the
+// watermarker directly; the spectralmark CLI and web server are built on it.
+//
+// Images go in and come out as standard image.Image values, and Decode reads
+// PNG, JPEG and netpbm (PGM/PPM) from any io.Reader:
+//
+//	img, err := spectralmark.Decode(r)
+//	res, err := spectralmark.Embed(ctx, img, spectralmark.EmbedOptions{
+//		Key:     "secret",
+//		Message: "hello",
+//	})
+//	det, err := spectralmark.Detect(ctx, res.Image, spectralmark.DetectOptions{Key: "secret"})
+//
+// # Compatibility
+//
+// The exported API of this package follows semantic versioning: within a
+// major version, identifiers are not removed or changed incompatibly, and new
+// fields are only added to option and result structs, so callers should use
+// keyed struct literals. Images watermarked by one release are detectable by
+// every later release of the same major version. Embedded pixel values may
+// change between releases unless EmbedOptions.BitExact is set, whose output
+// is fixed for a given input, key and options.
+package spectralmark
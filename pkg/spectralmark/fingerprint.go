@@ -0,0 +1,157 @@
+package spectralmark
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	spectralimage "spectralmark/internal/image"
+	spectralwm "spectralmark/internal/wm"
+)
+
+type FingerprintOptions struct {
+	Key         string
+	Registry    *Registry
+	RecipientID string
+	// Alpha, Profile, ClosedLoopIterations and BitExact are as in
+	// EmbedOptions.
+	Alpha                float32
+	Profile              string
+	ClosedLoopIterations int
+	BitExact             bool
+}
+
+type TraceOptions struct {
+	Key      string
+	Registry *Registry
+	// Profile restricts reading to one profile; empty tries all of them.
+	Profile string
+}
+
+type TraceResult struct {
+	// SyncScore is the fraction of sync symbols read correctly; about 0.5
+	// means no fingerprint was found under this key.
+	SyncScore  float32
+	Profile    string
+	CodeLength int
+	Threshold  float64
+	// Scores holds every registered recipient, highest score first.
+	Scores []RecipientScore
+	// Accused lists the recipients whose score exceeds Threshold.
+	Accused []string
+}
+
+type RecipientScore struct {
+	ID      string
+	Score   float64
+	Accused bool
+}
+
+// EmbedFingerprint embeds the registered recipient's fingerprint codeword.
+// Codes are long, so this needs a considerably larger image than Embed.
+func EmbedFingerprint(ctx context.Context, img image.Image, opts FingerprintOptions) (*EmbedResult, error) {
+	if img == nil {
+		return nil, ErrNoImage
+	}
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	if opts.Registry == nil {
+		return nil, fmt.Errorf("spectralmark: registry is required")
+	}
+	if !opts.Registry.Has(opts.RecipientID) {
+		return nil, ErrUnknownRecipient
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+
+	code, err := fingerprintCode(opts.Key, opts.Registry)
+	if err != nil {
+		return nil, err
+	}
+	out, report, err := spectralwm.EmbedFingerprintImage(ctx, spectralimage.FromStdImage(img), opts.Key, code, opts.RecipientID, spectralwm.EmbedOptions{
+		Alpha:                opts.Alpha,
+		Profile:              opts.Profile,
+		ClosedLoopIterations: opts.ClosedLoopIterations,
+		BitExact:             opts.BitExact,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newEmbedResult(out, report), nil
+}
+
+// Trace reads the fingerprint from a suspect copy, which may be a mix of
+// several recipients' copies, and scores every registered recipient.
+func Trace(ctx context.Context, img image.Image, opts TraceOptions) (*TraceResult, error) {
+	if img == nil {
+		return nil, ErrNoImage
+	}
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	if opts.Registry == nil {
+		return nil, fmt.Errorf("spectralmark: registry is required")
+	}
+
+	code, err := fingerprintCode(opts.Key, opts.Registry)
+	if err != nil {
+		return nil, err
+	}
+	reading, err := spectralwm.ReadFingerprintImage(ctx, spectralimage.FromStdImage(img), opts.Key, code, spectralwm.DetectOptions{
+		Profile: opts.Profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(opts.Registry.Recipients))
+	for i, rec := range opts.Registry.Recipients {
+		ids[i] = rec.ID
+	}
+
+	res := &TraceResult{
+		SyncScore:  reading.SyncScore,
+		Profile:    reading.Profile,
+		CodeLength: code.Length,
+		Threshold:  code.Threshold,
+	}
+	for _, a := range code.Trace(reading.Symbols, ids) {
+		res.Scores = append(res.Scores, RecipientScore{ID: a.ID, Score: a.Score, Accused: a.Accused})
+		if a.Accused {
+			res.Accused = append(res.Accused, a.ID)
+		}
+	}
+	return res, nil
+}
+
+// FingerprintSyncSymbols is how many slots a fingerprint spends on its sync
+// word in addition to the code symbols.
+const FingerprintSyncSymbols = spectralwm.FingerprintSyncSymbols
+
+// FingerprintLength is the number of code symbols a registry's fingerprints
+// carry; each needs one embedding slot.
+func FingerprintLength(params FingerprintParams) (int, error) {
+	code, err := spectralwm.NewFingerprintCode("length", toWMParams(params))
+	if err != nil {
+		return 0, err
+	}
+	return code.Length, nil
+}
+
+func fingerprintCode(key string, reg *Registry) (*spectralwm.FingerprintCode, error) {
+	code, err := spectralwm.NewFingerprintCode(key, toWMParams(reg.Params))
+	if err != nil {
+		return nil, fmt.Errorf("spectralmark: registry params: %w", err)
+	}
+	return code, nil
+}
+
+func toWMParams(p FingerprintParams) spectralwm.FingerprintParams {
+	return spectralwm.FingerprintParams{
+		Colluders:     p.Colluders,
+		MaxRecipients: p.MaxRecipients,
+		Epsilon:       p.Epsilon,
+	}
+}
@@ -0,0 +1,77 @@
+package spectralmark
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// texturedImage is a w x h cover of gradients under noise, with enough
+// detail to carry the mark.
+func texturedImage(w, h int) *image.NRGBA {
+	clamp := func(v int) uint8 { return uint8(min(max(v, 0), 255)) }
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(7)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			n := int(seed>>27) - 16
+			img.SetNRGBA(x, y, color.NRGBA{R: clamp(48 + x*160/w + n), G: clamp(64 + y*128/h + n), B: clamp(96 + (x+y)*96/(w+h) + n), A: 0xff})
+		}
+	}
+	return img
+}
+
+// average is the pixel-wise mean of two copies, the simplest collusion.
+func average(a, b *image.NRGBA) *image.NRGBA {
+	out := image.NewNRGBA(a.Rect)
+	for i := range out.Pix {
+		out.Pix[i] = uint8((int(a.Pix[i]) + int(b.Pix[i]) + 1) / 2)
+	}
+	return out
+}
+
+func TestTraceAccusesExactlyTheColluders(t *testing.T) {
+	ctx := context.Background()
+	reg := NewRegistry(FingerprintParams{Colluders: 2, MaxRecipients: 10, Epsilon: 0.01})
+	ids := []string{"alice", "bob", "carol", "dave", "erin"}
+	for _, id := range ids {
+		if err := reg.Add(id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := texturedImage(512, 384)
+	copies := make(map[string]*image.NRGBA)
+	for _, id := range ids {
+		res, err := EmbedFingerprint(ctx, src, FingerprintOptions{Key: "k", Registry: reg, RecipientID: id})
+		if err != nil {
+			t.Fatalf("%s: %v", id, err)
+		}
+		copies[id] = res.Image
+	}
+
+	accused := func(img image.Image) []string {
+		t.Helper()
+		res, err := Trace(ctx, img, TraceOptions{Key: "k", Registry: reg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := append([]string{}, res.Accused...)
+		sort.Strings(out)
+		return out
+	}
+
+	if got := accused(average(copies["alice"], copies["bob"])); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("averaged alice and bob: accused %v", got)
+	}
+	if got := accused(copies["carol"]); !reflect.DeepEqual(got, []string{"carol"}) {
+		t.Errorf("carol's copy: accused %v", got)
+	}
+	if got := accused(src); len(got) != 0 {
+		t.Errorf("unmarked image: accused %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package spectralmark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	spectralimage "spectralmark/internal/image"
+	spectralwm "spectralmark/internal/wm"
+)
+
+// DefaultJPEGQuality is the quality EncodeJPEG uses when JPEGOptions.Quality
+// is 0.
+const DefaultJPEGQuality = 90
+
+type JPEGOptions struct {
+	// Quality is 1..100; 0 selects DefaultJPEGQuality.
+	Quality int
+}
+
+// EncodeJPEG writes img as a baseline JPEG. Alpha is dropped, so an image
+// with transparency should be embedded through WithoutAlpha first.
+func EncodeJPEG(w io.Writer, img image.Image, opts JPEGOptions) error {
+	return EncodeJPEGMetadata(w, img, opts, nil)
+}
+
+// EncodeJPEGMetadata is EncodeJPEG that also writes the EXIF and ICC profile
+// of m. PNG text chunks have no JPEG equivalent and are dropped.
+func EncodeJPEGMetadata(w io.Writer, img image.Image, opts JPEGOptions, m *Metadata) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	if w == nil {
+		return errors.New("spectralmark: writer is nil")
+	}
+	if opts.Quality == 0 {
+		opts.Quality = DefaultJPEGQuality
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return fmt.Errorf("spectralmark: jpeg quality %d is outside 1..100", opts.Quality)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: opts.Quality}); err != nil {
+		return err
+	}
+	data, err := spectralimage.AttachJPEGMetadata(buf.Bytes(), m.internal())
+	if err != nil {
+		return fmt.Errorf("spectralmark: write metadata: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// EmbedJPEG marks a JPEG in its quantized luma DCT coefficients and writes
+// the result to w. The file is not decoded to pixels: chroma, quantization
+// tables, metadata and every other segment are copied unchanged, and only
+// the Huffman tables are rebuilt, so there is no generation loss.
+//
+// Only sequential (baseline or extended) grayscale or YCbCr JPEGs are
+// accepted, and only the 8x8 profile, whose blocks are JPEG's. Slots move in
+// whole quantization steps, so the marks are usually stronger than
+// opts.Alpha asks for. An unset opts.Orientation is taken from the file's
+// EXIF; other orientations than 1 need dimensions that are multiples of 8.
+func EmbedJPEG(ctx context.Context, r io.Reader, w io.Writer, opts EmbedOptions) (*EmbedReport, error) {
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	if w == nil {
+		return nil, errors.New("spectralmark: writer is nil")
+	}
+	data, err := readImageData(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+	if opts.Orientation == 0 {
+		meta, err := spectralimage.ReadMetadata(data)
+		if err != nil {
+			return nil, fmt.Errorf("spectralmark: read metadata: %w", err)
+		}
+		opts.Orientation = meta.Orientation()
+	}
+
+	j, err := spectralimage.ReadJPEGCoefficients(data)
+	if err != nil {
+		return nil, fmt.Errorf("spectralmark: %w", err)
+	}
+	mask, err := opts.Region.plane(image.Rect(0, 0, j.W, j.H), opts.Orientation)
+	if err != nil {
+		return nil, err
+	}
+	report, err := spectralwm.EmbedJPEGCoefficients(ctx, j, opts.Orientation, opts.Key, opts.Message, spectralwm.EmbedOptions{
+		Alpha:                opts.Alpha,
+		Profile:              opts.Profile,
+		ClosedLoopIterations: opts.ClosedLoopIterations,
+		Mask:                 mask,
+		PyramidLevels:        opts.PyramidLevels,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := spectralimage.WriteJPEGCoefficients(w, j); err != nil {
+		return nil, err
+	}
+	return newEmbedReport(report), nil
+}
@@ -0,0 +1,106 @@
+package spectralmark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// Metadata is the non-pixel data of a JPEG or PNG that should survive
+// embedding: the EXIF block, the ICC colour profile and PNG text chunks.
+type Metadata struct {
+	// EXIF is the TIFF-structured EXIF data, without the "Exif\0\0" prefix
+	// JPEG puts in front of it.
+	EXIF []byte
+	// ICC is the uncompressed ICC profile.
+	ICC []byte
+	// Text holds PNG tEXt, zTXt and iTXt chunks verbatim.
+	Text []TextChunk
+}
+
+type TextChunk struct {
+	// Type is the PNG chunk type: "tEXt", "zTXt" or "iTXt".
+	Type string
+	Data []byte
+}
+
+// Orientation returns the EXIF orientation (1..8), or 1 when there is none.
+func (m *Metadata) Orientation() int {
+	if m == nil {
+		return 1
+	}
+	return spectralimage.ExifOrientation(m.EXIF)
+}
+
+// DecodeMetadata is Decode that also returns the metadata of the file. The
+// image is returned as stored; see Orient and EmbedOptions.Orientation.
+func DecodeMetadata(r io.Reader) (image.Image, *Metadata, error) {
+	data, err := readImageData(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := decodeData(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := spectralimage.ReadMetadata(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("spectralmark: read metadata: %w", err)
+	}
+	return img, fromInternalMetadata(meta), nil
+}
+
+// EncodePNGMetadata is EncodePNG that also writes m. EXIF is written as an
+// eXIf chunk, so a JPEG's orientation carries over unchanged.
+func EncodePNGMetadata(w io.Writer, img image.Image, m *Metadata) error {
+	if img == nil {
+		return ErrNoImage
+	}
+	if w == nil {
+		return errors.New("spectralmark: writer is nil")
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data, err := spectralimage.AttachPNGMetadata(buf.Bytes(), m.internal())
+	if err != nil {
+		return fmt.Errorf("spectralmark: write metadata: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Orient returns img as it is meant to be displayed under EXIF orientation o,
+// keeping its pixel type where possible. Orientation 1 and values outside
+// 1..8 return img unchanged.
+func Orient(img image.Image, o int) image.Image {
+	if img == nil {
+		return nil
+	}
+	return spectralimage.Orient(img, o)
+}
+
+func fromInternalMetadata(m *spectralimage.Metadata) *Metadata {
+	out := &Metadata{EXIF: m.EXIF, ICC: m.ICC}
+	for _, c := range m.Text {
+		out.Text = append(out.Text, TextChunk{Type: c.Type, Data: c.Data})
+	}
+	return out
+}
+
+func (m *Metadata) internal() *spectralimage.Metadata {
+	if m == nil {
+		return nil
+	}
+	out := &spectralimage.Metadata{EXIF: m.EXIF, ICC: m.ICC}
+	for _, c := range m.Text {
+		out.Text = append(out.Text, spectralimage.PNGChunk{Type: c.Type, Data: c.Data})
+	}
+	return out
+}
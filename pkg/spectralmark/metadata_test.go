@@ -0,0 +1,124 @@
+package spectralmark
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// testEXIF is a little-endian TIFF block whose IFD0 holds only the
+// orientation tag.
+func testEXIF(orientation uint16) []byte {
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	exif = append(exif, entry...)
+	return append(exif, 0, 0, 0, 0)
+}
+
+func testICC(n int) []byte {
+	icc := make([]byte, n)
+	for i := range icc {
+		icc[i] = byte(i*31 + i>>8)
+	}
+	return icc
+}
+
+func jpegSegment(marker byte, body []byte) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(body)+2))
+	return append(seg, body...)
+}
+
+// embedKeepingMetadata runs the CLI's path for one file: embed on the
+// EXIF-oriented grid, write PNG with the input's metadata, and read the
+// metadata back.
+func embedKeepingMetadata(t *testing.T, data []byte) (*Metadata, *Metadata) {
+	t.Helper()
+	ctx := context.Background()
+	_, in, err := DecodeMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := EmbedReader(ctx, bytes.NewReader(data), EmbedOptions{Key: "k", Message: "META"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := EncodePNGMetadata(&out, res.Native, in); err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := DecodeMetadata(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := DetectReader(ctx, bytes.NewReader(out.Bytes()), DetectOptions{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OK || d.Message != "META" {
+		t.Errorf("detect = %+v", d)
+	}
+	return in, got
+}
+
+func TestJPEGMetadataSurvivesEmbed(t *testing.T) {
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, texturedImage(512, 384), &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ICC profile is too large for one APP2 segment, so it is split in
+	// two numbered parts.
+	exif := testEXIF(6)
+	icc := testICC(90000)
+	var data []byte
+	data = append(data, enc.Bytes()[:2]...)
+	data = append(data, jpegSegment(0xe1, append([]byte("Exif\x00\x00"), exif...))...)
+	for i, part := range [][]byte{icc[:60000], icc[60000:]} {
+		body := append([]byte("ICC_PROFILE\x00"), byte(i+1), 2)
+		data = append(data, jpegSegment(0xe2, append(body, part...))...)
+	}
+	data = append(data, enc.Bytes()[2:]...)
+
+	in, got := embedKeepingMetadata(t, data)
+	if !bytes.Equal(in.EXIF, exif) || !bytes.Equal(in.ICC, icc) {
+		t.Fatalf("jpeg metadata read wrongly: %d bytes EXIF, %d bytes ICC", len(in.EXIF), len(in.ICC))
+	}
+	if !bytes.Equal(got.EXIF, exif) {
+		t.Errorf("EXIF changed: %x", got.EXIF)
+	}
+	if !bytes.Equal(got.ICC, icc) {
+		t.Errorf("ICC profile changed: %d bytes, want %d", len(got.ICC), len(icc))
+	}
+	if got.Orientation() != 6 {
+		t.Errorf("orientation %d, want 6", got.Orientation())
+	}
+}
+
+func TestPNGMetadataSurvivesEmbed(t *testing.T) {
+	meta := &Metadata{
+		EXIF: testEXIF(3),
+		ICC:  testICC(3000),
+		Text: []TextChunk{
+			{Type: "tEXt", Data: []byte("Author\x00Someone")},
+			{Type: "tEXt", Data: []byte("Comment\x00kept as is")},
+		},
+	}
+	var data bytes.Buffer
+	if err := EncodePNGMetadata(&data, texturedImage(512, 384), meta); err != nil {
+		t.Fatal(err)
+	}
+
+	_, got := embedKeepingMetadata(t, data.Bytes())
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("metadata = %+v, want %+v", got, meta)
+	}
+}
@@ -0,0 +1,60 @@
+package spectralmark
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"testing"
+)
+
+func TestEmbedPalettedStaysPaletted(t *testing.T) {
+	ctx := context.Background()
+	src := texturedImage(512, 384)
+	img := image.NewPaletted(src.Bounds(), palette.Plan9[:200])
+	draw.Draw(img, img.Bounds(), src, image.Point{}, draw.Src)
+
+	for _, keep := range []bool{false, true} {
+		res, err := Embed(ctx, img, EmbedOptions{Key: "k", Message: "PAL", KeepPalette: keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, ok := res.Native.(*image.Paletted)
+		if !ok {
+			t.Fatalf("keep %v: native output is %T", keep, res.Native)
+		}
+		if len(out.Palette) > 256 || len(out.Palette) < len(img.Palette) {
+			t.Fatalf("keep %v: palette has %d entries", keep, len(out.Palette))
+		}
+		for i, c := range img.Palette {
+			r0, g0, b0, a0 := c.RGBA()
+			r1, g1, b1, a1 := out.Palette[i].RGBA()
+			if r0 != r1 || g0 != g1 || b0 != b1 || a0 != a1 {
+				t.Fatalf("keep %v: palette entry %d changed", keep, i)
+			}
+		}
+		if keep && len(out.Palette) != len(img.Palette) {
+			t.Errorf("keep %v: palette grew to %d entries", keep, len(out.Palette))
+		}
+
+		var buf bytes.Buffer
+		if err := EncodePNG(&buf, res.Native); err != nil {
+			t.Fatal(err)
+		}
+		back, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := back.(*image.Paletted); !ok {
+			t.Fatalf("keep %v: PNG decodes as %T", keep, back)
+		}
+		d, err := Detect(ctx, back, DetectOptions{Key: "k"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.OK || d.Message != "PAL" {
+			t.Errorf("keep %v: detect = %+v", keep, d)
+		}
+	}
+}
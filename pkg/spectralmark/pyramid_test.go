@@ -0,0 +1,54 @@
+package spectralmark
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+// boxDownscale averages f x f blocks, as a simple thumbnailer would.
+func boxDownscale(src *image.NRGBA, f int) *image.NRGBA {
+	b := src.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx()/f, b.Dy()/f))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			var sum [4]int
+			for dy := 0; dy < f; dy++ {
+				p := src.Pix[src.PixOffset(b.Min.X+x*f, b.Min.Y+y*f+dy):]
+				for dx := 0; dx < f; dx++ {
+					for c := range sum {
+						sum[c] += int(p[4*dx+c])
+					}
+				}
+			}
+			for c := range sum {
+				out.Pix[out.PixOffset(x, y)+c] = uint8((sum[c] + f*f/2) / (f * f))
+			}
+		}
+	}
+	return out
+}
+
+func TestPyramidThumbnailDetects(t *testing.T) {
+	ctx := context.Background()
+	src := texturedImage(1024, 768)
+
+	for _, levels := range []int{0, 2} {
+		res, err := Embed(ctx, src, EmbedOptions{Key: "k", Message: "THUMB", PyramidLevels: levels})
+		if err != nil {
+			t.Fatal(err)
+		}
+		thumb := boxDownscale(res.Image, 4)
+		d, err := Detect(ctx, thumb, DetectOptions{Key: "k"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := d.OK && d.Message == "THUMB"
+		if levels > 0 && !found {
+			t.Errorf("quarter-size thumbnail with %d pyramid levels: %+v", levels, d)
+		}
+		if levels == 0 && found {
+			t.Errorf("quarter-size thumbnail decoded without pyramid levels")
+		}
+	}
+}
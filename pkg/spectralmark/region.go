@@ -0,0 +1,127 @@
+package spectralmark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	spectralimage "spectralmark/internal/image"
+)
+
+// Region confines the mark to part of an image, to keep faces, logos or text
+// overlays untouched and put the mark in background texture instead. The
+// mark is laid in whole blocks (8x8 pixels for the default profile), and a
+// block is only used if every one of its pixels is allowed.
+//
+// The keyed slots are spread over the allowed blocks only, so detection must
+// be given the same Region; without it the slots do not line up. Coordinates
+// are those of the image passed in, as stored, before any EXIF orientation.
+type Region struct {
+	// Mask, if set, must cover the image's bounds. Pixels where it is darker
+	// than mid-grey, or transparent, are kept out.
+	Mask image.Image
+	// Include, if not empty, allows only pixels inside one of these
+	// rectangles.
+	Include []image.Rectangle
+	// Exclude keeps these rectangles out, on top of Mask and Include.
+	Exclude []image.Rectangle
+}
+
+// plane renders r over bounds as a plane of bounds.Dx()*bounds.Dy() values,
+// 0 where the mark may not go, in the grid of the image as displayed under
+// EXIF orientation o. A nil Region gives a nil plane, allowing everything.
+func (r *Region) plane(bounds image.Rectangle, o int) ([]uint8, error) {
+	if r == nil {
+		return nil, nil
+	}
+	if r.Mask != nil && !bounds.In(r.Mask.Bounds()) {
+		return nil, errors.New("spectralmark: region mask does not cover the image")
+	}
+
+	g := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if r.allows(x, y) {
+				g.Pix[(y-bounds.Min.Y)*g.Stride+x-bounds.Min.X] = 255
+			}
+		}
+	}
+	if o > 1 {
+		g = spectralimage.Orient(g, o).(*image.Gray)
+	}
+	return g.Pix, nil
+}
+
+func (r *Region) allows(x, y int) bool {
+	pt := image.Pt(x, y)
+	if len(r.Include) > 0 {
+		inside := false
+		for _, rect := range r.Include {
+			if pt.In(rect) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	for _, rect := range r.Exclude {
+		if pt.In(rect) {
+			return false
+		}
+	}
+	if r.Mask != nil {
+		return color.GrayModel.Convert(r.Mask.At(x, y)).(color.Gray).Y >= 128
+	}
+	return true
+}
+
+// maskFrame keeps the pixels of f that mask excludes out of detection, by
+// making them transparent as the embedder treated them.
+func maskFrame(f *spectralimage.Image, mask []uint8) {
+	if mask == nil {
+		return
+	}
+	if f.Alpha == nil {
+		f.Alpha = mask
+		return
+	}
+	for i, m := range mask {
+		f.Alpha[i] = min(f.Alpha[i], m)
+	}
+}
+
+// ParseRectangles reads rectangles written "x0,y0,x1,y1" and separated by
+// semicolons, as the CLI and the web form take them for a Region. The empty
+// string gives none.
+func ParseRectangles(s string) ([]image.Rectangle, error) {
+	var rects []image.Rectangle
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ",")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("spectralmark: rectangle %q is not x0,y0,x1,y1", part)
+		}
+		var v [4]int
+		for i, f := range fields {
+			n, err := strconv.Atoi(strings.TrimSpace(f))
+			if err != nil {
+				return nil, fmt.Errorf("spectralmark: rectangle %q is not x0,y0,x1,y1", part)
+			}
+			v[i] = n
+		}
+		r := image.Rect(v[0], v[1], v[2], v[3])
+		if r.Empty() {
+			return nil, fmt.Errorf("spectralmark: rectangle %q is empty", part)
+		}
+		rects = append(rects, r)
+	}
+	return rects, nil
+}
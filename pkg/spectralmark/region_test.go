@@ -0,0 +1,45 @@
+package spectralmark
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestRegionExcludedPixelsUnchanged(t *testing.T) {
+	ctx := context.Background()
+	excluded := []image.Rectangle{image.Rect(64, 64, 128, 128), image.Rect(200, 13, 251, 77)}
+	transparent := image.Rect(20, 150, 90, 230)
+
+	src := texturedImage(256, 256)
+	for y := transparent.Min.Y; y < transparent.Max.Y; y++ {
+		for x := transparent.Min.X; x < transparent.Max.X; x++ {
+			src.Pix[src.PixOffset(x, y)+3] = 0
+		}
+	}
+	region := &Region{Exclude: excluded}
+
+	for levels := 0; levels <= 2; levels++ {
+		res, err := Embed(ctx, src, EmbedOptions{Key: "k", Message: "HI", Alpha: 5, PyramidLevels: levels, Region: region})
+		if err != nil {
+			t.Fatalf("pyramid %d: %v", levels, err)
+		}
+		for _, r := range append(excluded, transparent) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					if got, want := res.Image.NRGBAAt(x, y), src.NRGBAAt(x, y); got != want {
+						t.Fatalf("pyramid %d: pixel (%d,%d) = %v, want %v", levels, x, y, got, want)
+					}
+				}
+			}
+		}
+
+		d, err := Detect(ctx, res.Image, DetectOptions{Key: "k", Region: region})
+		if err != nil {
+			t.Fatalf("pyramid %d: %v", levels, err)
+		}
+		if !d.OK || d.Message != "HI" {
+			t.Fatalf("pyramid %d: detect = %+v", levels, d)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package spectralmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RegistryScheme identifies the fingerprint code a Registry was created for.
+const RegistryScheme = "tardos-v1"
+
+// Registry records the fingerprint code parameters and the recipients that
+// have been issued a fingerprinted copy. Together with the key it is all
+// Trace needs; codewords are regenerated, not stored.
+type Registry struct {
+	Scheme     string            `json:"scheme"`
+	Params     FingerprintParams `json:"params"`
+	Recipients []Recipient       `json:"recipients"`
+}
+
+type Recipient struct {
+	ID   string `json:"id"`
+	Note string `json:"note,omitempty"`
+}
+
+// FingerprintParams sizes the fingerprint code. The code length grows with
+// Colluders squared and with log(MaxRecipients/Epsilon).
+type FingerprintParams struct {
+	// Colluders is the largest coalition the code must resist.
+	Colluders int `json:"colluders"`
+	// MaxRecipients is the most recipients the registry will ever hold.
+	MaxRecipients int `json:"max_recipients"`
+	// Epsilon bounds the probability that Trace accuses any innocent
+	// recipient.
+	Epsilon float64 `json:"epsilon"`
+}
+
+var ErrUnknownRecipient = errors.New("spectralmark: recipient is not in the registry")
+
+func NewRegistry(params FingerprintParams) *Registry {
+	return &Registry{Scheme: RegistryScheme, Params: params}
+}
+
+func ReadRegistry(r io.Reader) (*Registry, error) {
+	var reg Registry
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&reg); err != nil {
+		return nil, fmt.Errorf("spectralmark: read registry: %w", err)
+	}
+	if reg.Scheme != RegistryScheme {
+		return nil, fmt.Errorf("spectralmark: unsupported registry scheme %q", reg.Scheme)
+	}
+	return &reg, nil
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Add registers a recipient. IDs must be unique and the registry may not
+// grow past Params.MaxRecipients, since the false-accusation bound assumes
+// at most that many.
+func (r *Registry) Add(id, note string) error {
+	if id == "" {
+		return fmt.Errorf("spectralmark: recipient id is required")
+	}
+	if r.Has(id) {
+		return fmt.Errorf("spectralmark: recipient %q is already registered", id)
+	}
+	if len(r.Recipients) >= r.Params.MaxRecipients {
+		return fmt.Errorf("spectralmark: registry is full (%d recipients)", r.Params.MaxRecipients)
+	}
+	r.Recipients = append(r.Recipients, Recipient{ID: id, Note: note})
+	return nil
+}
+
+func (r *Registry) Has(id string) bool {
+	for _, rec := range r.Recipients {
+		if rec.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,346 @@
+package spectralmark
+
+import (
+	"context"
+	"errors"
+	"image"
+	"io"
+
+	spectralimage "spectralmark/internal/image"
+	spectralwm "spectralmark/internal/wm"
+)
+
+// DefaultAlpha is the embedding strength used when EmbedOptions.Alpha is 0.
+const DefaultAlpha = 3.0
+
+// DefaultProfile is the block size profile used when none is given.
+const DefaultProfile = spectralwm.DefaultProfileName
+
+// MaxPyramidLevels is the largest EmbedOptions.PyramidLevels: the mark can
+// be laid down to 1/8 of the size.
+const MaxPyramidLevels = spectralwm.MaxPyramidLevels
+
+var (
+	ErrNoImage = errors.New("spectralmark: image is nil")
+	ErrNoKey   = errors.New("spectralmark: key is required")
+)
+
+type EmbedOptions struct {
+	Key     string
+	Message string
+	// Alpha is the embedding strength; 0 selects DefaultAlpha.
+	Alpha float32
+	// Profile is one of Profiles(); empty selects DefaultProfile.
+	Profile string
+	// ClosedLoopIterations re-measures each block after 8-bit rounding and
+	// tops up weak slots, up to this many times.
+	ClosedLoopIterations int
+	// BitExact uses integer arithmetic so output is byte-identical on every
+	// platform. Paletted input is then marked as truecolour.
+	BitExact bool
+	// PyramidLevels, up to MaxPyramidLevels, also marks the image's 1/2,
+	// 1/4, ... downscales, so that thumbnails at those sizes keep the mark;
+	// Detect tries the downscales of any image by itself. Each level must
+	// hold the payload. Paletted input is then marked as truecolour, and
+	// bit-exact mode is not available.
+	PyramidLevels int
+	// KeepPalette stops Embed from adding palette entries to paletted input:
+	// every marked pixel then takes one of the original colours. By default
+	// free slots may receive lighter and darker copies of the most used
+	// colours, and the ones the mark uses are kept. Paletted input always
+	// gets at least 32 closed-loop iterations, as palette rounding is coarse.
+	KeepPalette bool
+	// Orientation is the EXIF orientation (1..8) of img. The mark is laid on
+	// the grid of the image as displayed, so it survives tools that bake the
+	// rotation into the pixels; the result is returned as stored, to be
+	// saved with the same EXIF. 0 means 1, as stored.
+	Orientation int
+	// Region, if set, keeps the mark out of part of the image; Detect must
+	// then be given the same Region.
+	Region *Region
+}
+
+type EmbedResult struct {
+	// Image is the watermarked image, always an *image.NRGBA with bounds
+	// starting at (0, 0).
+	Image  *image.NRGBA
+	Report EmbedReport
+	// Native is the watermarked image in the pixel format of the input where
+	// that format is kept: *image.Gray or *image.Gray16 for grayscale input,
+	// *image.NRGBA64 for 16-bit colour input (*image.RGBA64 or
+	// *image.NRGBA64), *image.YCbCr with the input's chroma for
+	// *image.YCbCr input (as decoded from JPEG), *image.Paletted with the
+	// input's palette, possibly extended, for *image.Paletted input (as
+	// decoded from paletted PNG and GIF) unless BitExact or PyramidLevels
+	// is set, otherwise the same image as Image.
+	Native image.Image
+}
+
+// EmbedReport describes how strongly each payload slot ended up marked after
+// 8-bit quantization. Margins below Target are weaker than requested and
+// negative margins decode as the wrong bit.
+type EmbedReport struct {
+	Slots         int
+	Target        float32
+	MinMargin     float32
+	MeanMargin    float32
+	Deficient     int
+	MaxIterations int
+	Margins       []float32
+}
+
+type DetectOptions struct {
+	Key string
+	// Profile restricts detection to one profile; empty tries all of them.
+	Profile string
+	// Orientation is the EXIF orientation of img, as in EmbedOptions.
+	Orientation int
+	// Region is the one the image was embedded with, if any.
+	Region *Region
+}
+
+type DetectResult struct {
+	// Score is the normalised correlation with the keyed sync pattern.
+	Score float32
+	// Present reports whether Score indicates a watermark for this key.
+	Present bool
+	// OK reports whether the payload decoded with a valid checksum; Message
+	// is only meaningful when it is true.
+	OK      bool
+	Message string
+}
+
+// Embed watermarks img with opts.Message under opts.Key. img is not modified.
+//
+// Transparency is preserved. Blocks containing fully transparent pixels carry
+// no mark, and Detect finds the same blocks from the alpha channel, so the
+// result must be stored in a format that keeps alpha, such as PNG.
+func Embed(ctx context.Context, img image.Image, opts EmbedOptions) (*EmbedResult, error) {
+	if img == nil {
+		return nil, ErrNoImage
+	}
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	mask, err := opts.Region.plane(img.Bounds(), opts.Orientation)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Orientation <= 1 {
+		return embed(ctx, img, opts, mask)
+	}
+
+	res, err := embed(ctx, spectralimage.Orient(img, opts.Orientation), opts, mask)
+	if err != nil {
+		return nil, err
+	}
+	res.Image = spectralimage.Unorient(res.Image, opts.Orientation).(*image.NRGBA)
+	if _, ok := res.Native.(*image.NRGBA); ok {
+		res.Native = res.Image
+	} else {
+		res.Native = spectralimage.Unorient(res.Native, opts.Orientation)
+	}
+	return res, nil
+}
+
+// embed marks img as displayed; mask is opts.Region on its grid.
+func embed(ctx context.Context, img image.Image, opts EmbedOptions, mask []uint8) (*EmbedResult, error) {
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+	wmOpts := spectralwm.EmbedOptions{
+		Alpha:                opts.Alpha,
+		Profile:              opts.Profile,
+		ClosedLoopIterations: opts.ClosedLoopIterations,
+		BitExact:             opts.BitExact,
+		Mask:                 mask,
+		PyramidLevels:        opts.PyramidLevels,
+	}
+
+	// Grayscale input is its own luma plane, and so is the Y plane of YCbCr
+	// input, so they are marked directly. 16-bit input is marked at 16 bits,
+	// and paletted input within its palette.
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if src, ok := img.(*image.Paletted); ok && !opts.BitExact && opts.PyramidLevels == 0 {
+		if indexed, err := spectralimage.IndexedFromStd(src); err == nil {
+			out, report, err := spectralwm.EmbedIndexed(ctx, indexed, opts.Key, opts.Message, wmOpts, !opts.KeepPalette)
+			if err != nil {
+				return nil, err
+			}
+			return newNativeEmbedResult(out.Std(), report), nil
+		}
+	}
+	switch src := img.(type) {
+	case *image.Gray:
+		out, report, err := spectralwm.EmbedLuma8(ctx, spectralimage.GrayPlane(src), w, h, opts.Key, opts.Message, wmOpts)
+		if err != nil {
+			return nil, err
+		}
+		return newNativeEmbedResult(spectralimage.GrayFromPlane(out, w, h), report), nil
+	case *image.YCbCr:
+		out, report, err := spectralwm.EmbedLuma8(ctx, spectralimage.YCbCrLuma(src), w, h, opts.Key, opts.Message, wmOpts)
+		if err != nil {
+			return nil, err
+		}
+		return newNativeEmbedResult(spectralimage.YCbCrWithLuma(src, out), report), nil
+	case *image.Gray16:
+		out, report, err := spectralwm.EmbedLuma16(ctx, spectralimage.Gray16Plane(src), w, h, opts.Key, opts.Message, wmOpts)
+		if err != nil {
+			return nil, err
+		}
+		return newNativeEmbedResult(spectralimage.Gray16FromPlane(out, w, h), report), nil
+	case *image.RGBA64, *image.NRGBA64:
+		out, report, err := spectralwm.EmbedImage16Options(ctx, spectralimage.FromStdImage16(src), opts.Key, opts.Message, wmOpts)
+		if err != nil {
+			return nil, err
+		}
+		res := newEmbedResult(out.Image(), report)
+		res.Native = spectralimage.ToNRGBA64(out)
+		return res, nil
+	}
+
+	out, report, err := spectralwm.EmbedImageOptions(ctx, spectralimage.FromStdImage(img), opts.Key, opts.Message, wmOpts)
+	if err != nil {
+		return nil, err
+	}
+	return newEmbedResult(out, report), nil
+}
+
+func newNativeEmbedResult(out image.Image, report *spectralwm.EmbedReport) *EmbedResult {
+	res := newEmbedResult(spectralimage.FromStdImage(out), report)
+	res.Native = out
+	return res
+}
+
+func newEmbedResult(out *spectralimage.Image, report *spectralwm.EmbedReport) *EmbedResult {
+	nrgba := spectralimage.ToNRGBA(out)
+	return &EmbedResult{
+		Image:  nrgba,
+		Native: nrgba,
+		Report: *newEmbedReport(report),
+	}
+}
+
+func newEmbedReport(report *spectralwm.EmbedReport) *EmbedReport {
+	return &EmbedReport{
+		Slots:         len(report.Margins),
+		Target:        report.Target,
+		MinMargin:     report.MinMargin,
+		MeanMargin:    report.MeanMargin,
+		Deficient:     report.Deficient,
+		MaxIterations: report.MaxIterations,
+		Margins:       report.Margins,
+	}
+}
+
+// EmbedReader decodes an image from r (see Decode) and embeds into it. An
+// unset opts.Orientation is taken from the image's EXIF.
+func EmbedReader(ctx context.Context, r io.Reader, opts EmbedOptions) (*EmbedResult, error) {
+	img, meta, err := DecodeMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Orientation == 0 {
+		opts.Orientation = meta.Orientation()
+	}
+	return Embed(ctx, img, opts)
+}
+
+// Detect looks for a watermark under opts.Key and decodes its payload.
+func Detect(ctx context.Context, img image.Image, opts DetectOptions) (*DetectResult, error) {
+	if img == nil {
+		return nil, ErrNoImage
+	}
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	mask, err := opts.Region.plane(img.Bounds(), opts.Orientation)
+	if err != nil {
+		return nil, err
+	}
+	img = spectralimage.Orient(img, opts.Orientation)
+
+	wmOpts := spectralwm.DetectOptions{Profile: opts.Profile, Mask: mask}
+	var score float32
+	var present, ok bool
+	var msg string
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	switch src := img.(type) {
+	case *image.Gray:
+		score, present, msg, ok, err = spectralwm.DetectLuma8(ctx, spectralimage.GrayPlane(src), w, h, opts.Key, wmOpts)
+	case *image.YCbCr:
+		score, present, msg, ok, err = spectralwm.DetectLuma8(ctx, spectralimage.YCbCrLuma(src), w, h, opts.Key, wmOpts)
+	case *image.Gray16:
+		score, present, msg, ok, err = spectralwm.DetectLuma16(ctx, spectralimage.Gray16Plane(src), w, h, opts.Key, wmOpts)
+	case *image.RGBA64, *image.NRGBA64:
+		score, present, msg, ok, err = spectralwm.DetectImage16Options(ctx, spectralimage.FromStdImage16(src), opts.Key, wmOpts)
+	default:
+		score, present, msg, ok, err = spectralwm.DetectImageOptions(ctx, spectralimage.FromStdImage(img), opts.Key, wmOpts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DetectResult{
+		Score:   score,
+		Present: present,
+		OK:      ok,
+		Message: msg,
+	}, nil
+}
+
+// DetectReader decodes an image from r (see Decode) and runs Detect on it. An
+// unset opts.Orientation is taken from the image's EXIF.
+func DetectReader(ctx context.Context, r io.Reader, opts DetectOptions) (*DetectResult, error) {
+	img, meta, err := DecodeMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Orientation == 0 {
+		opts.Orientation = meta.Orientation()
+	}
+	return Detect(ctx, img, opts)
+}
+
+// Profiles lists the embedding profile names accepted in the options.
+func Profiles() []string {
+	return spectralwm.ProfileNames()
+}
+
+// SetWorkers bounds the goroutines used by Embed and Detect; 0 uses all
+// CPUs. Results do not depend on the setting.
+func SetWorkers(n int) {
+	spectralwm.SetWorkers(n)
+}
+
+// CacheStats counts lookups in the process-wide cache of keyed slot
+// mappings, which Embed and Detect share; a miss builds a new mapping.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	// Slots is the number of slots held by all cached mappings.
+	Slots int
+}
+
+// HitRate is Hits / (Hits + Misses), or 0 before the first lookup.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// SlotCacheStats returns the current slot mapping cache counters.
+func SlotCacheStats() CacheStats {
+	st := spectralwm.SlotCacheSnapshot()
+	return CacheStats{
+		Hits:      st.Hits,
+		Misses:    st.Misses,
+		Evictions: st.Evictions,
+		Entries:   st.Entries,
+		Slots:     st.Slots,
+	}
+}
@@ -0,0 +1,138 @@
+package spectralmark
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	spectralimage "spectralmark/internal/image"
+	spectralwm "spectralmark/internal/wm"
+)
+
+type VideoEmbedOptions struct {
+	// EmbedOptions.Region is in the coordinates of the frames, which must
+	// all have the same bounds; Orientation and PyramidLevels are ignored.
+	EmbedOptions
+	// KeyPeriod > 1 varies the slot mapping with the frame index modulo
+	// KeyPeriod; 0 or 1 uses one mapping for every frame.
+	KeyPeriod int
+}
+
+type VideoDetectOptions struct {
+	DetectOptions
+	// KeyPeriod must match the value used at embed time.
+	KeyPeriod int
+}
+
+type VideoDetectResult struct {
+	DetectResult
+	Profile string
+	// Frames is how many frames were read; FramesUsed counts those that
+	// contributed evidence.
+	Frames     int
+	FramesUsed int
+}
+
+// EmbedVideo watermarks every frame of a sequence with the same message.
+func EmbedVideo(ctx context.Context, frames []image.Image, opts VideoEmbedOptions) ([]*image.NRGBA, error) {
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+
+	in, err := fromStdFrames(frames)
+	if err != nil {
+		return nil, err
+	}
+	mask, err := framesMask(frames, opts.Region)
+	if err != nil {
+		return nil, err
+	}
+	out, err := spectralwm.EmbedFrames(ctx, in, opts.Key, opts.Message, toWMVideoEmbedOptions(opts, mask))
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*image.NRGBA, len(out))
+	for i, f := range out {
+		res[i] = spectralimage.ToNRGBA(f)
+	}
+	return res, nil
+}
+
+// DetectVideo accumulates evidence over all frames before decoding, so the
+// frames may be any subset of the marked sequence in any order.
+func DetectVideo(ctx context.Context, frames []image.Image, opts VideoDetectOptions) (*VideoDetectResult, error) {
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+
+	in, err := fromStdFrames(frames)
+	if err != nil {
+		return nil, err
+	}
+	mask, err := framesMask(frames, opts.Region)
+	if err != nil {
+		return nil, err
+	}
+	r, err := spectralwm.DetectFrames(ctx, in, opts.Key, toWMVideoDetectOptions(opts, mask))
+	if err != nil {
+		return nil, err
+	}
+	return newVideoDetectResult(r, len(frames)), nil
+}
+
+func newVideoDetectResult(r *spectralwm.VideoDetectResult, frames int) *VideoDetectResult {
+	return &VideoDetectResult{
+		DetectResult: DetectResult{
+			Score:   r.Score,
+			Present: r.Present,
+			OK:      r.OK,
+			Message: r.Msg,
+		},
+		Profile:    r.Profile,
+		Frames:     frames,
+		FramesUsed: r.FramesUsed,
+	}
+}
+
+func toWMVideoEmbedOptions(opts VideoEmbedOptions, mask []uint8) spectralwm.VideoEmbedOptions {
+	return spectralwm.VideoEmbedOptions{
+		EmbedOptions: spectralwm.EmbedOptions{
+			Alpha:                opts.Alpha,
+			Profile:              opts.Profile,
+			ClosedLoopIterations: opts.ClosedLoopIterations,
+			BitExact:             opts.BitExact,
+			Mask:                 mask,
+		},
+		KeyPeriod: opts.KeyPeriod,
+	}
+}
+
+func toWMVideoDetectOptions(opts VideoDetectOptions, mask []uint8) spectralwm.VideoDetectOptions {
+	return spectralwm.VideoDetectOptions{
+		DetectOptions: spectralwm.DetectOptions{Profile: opts.Profile, Mask: mask},
+		KeyPeriod:     opts.KeyPeriod,
+	}
+}
+
+// framesMask renders r over the bounds of the first frame.
+func framesMask(frames []image.Image, r *Region) ([]uint8, error) {
+	if r == nil || len(frames) == 0 {
+		return nil, nil
+	}
+	return r.plane(frames[0].Bounds(), 1)
+}
+
+func fromStdFrames(frames []image.Image) ([]*spectralimage.Image, error) {
+	out := make([]*spectralimage.Image, len(frames))
+	for i, f := range frames {
+		if f == nil {
+			return nil, fmt.Errorf("spectralmark: frame %d is nil", i)
+		}
+		out[i] = spectralimage.FromStdImage(f)
+	}
+	return out, nil
+}
@@ -0,0 +1,249 @@
+package spectralmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	spectralimage "spectralmark/internal/image"
+	spectralwm "spectralmark/internal/wm"
+)
+
+// RawYUVFormat describes a headerless YUV file: frame size and plane layout
+// ("i420" or "nv12").
+type RawYUVFormat struct {
+	Width  int
+	Height int
+	Layout string
+}
+
+// EmbedY4M reads a YUV4MPEG2 stream, marks the Y plane of every frame and
+// writes the stream to w with the same header and untouched chroma. Frames
+// are processed one at a time. It returns the number of frames written.
+func EmbedY4M(ctx context.Context, r io.Reader, w io.Writer, opts VideoEmbedOptions) (int, error) {
+	return EmbedYUV(ctx, r, nil, w, "", opts)
+}
+
+// DetectY4M detects over all frames of a YUV4MPEG2 stream, reading only the
+// Y planes.
+func DetectY4M(ctx context.Context, r io.Reader, opts VideoDetectOptions) (*VideoDetectResult, error) {
+	yr, err := spectralimage.NewY4MReader(r)
+	if err != nil {
+		return nil, err
+	}
+	hdr := yr.Header()
+	return detectYUVStream(ctx, hdr.W, hdr.H, yr.ReadFrame, opts)
+}
+
+// EmbedRawYUV is EmbedY4M for headerless I420 or NV12 input.
+func EmbedRawYUV(ctx context.Context, r io.Reader, w io.Writer, format RawYUVFormat, opts VideoEmbedOptions) (int, error) {
+	return EmbedYUV(ctx, r, &format, w, format.Layout, opts)
+}
+
+// EmbedYUV is EmbedY4M and EmbedRawYUV with the output container chosen
+// apart from the input's. in is nil for a YUV4MPEG2 input and the raw
+// format otherwise; outLayout is "" for YUV4MPEG2 output and the raw layout
+// otherwise. A stream header is copied from Y4M input, or is a 4:2:0 header
+// at 25 fps for raw input; raw output needs 4:2:0 input. Nothing is written
+// before the input header is read and checked.
+func EmbedYUV(ctx context.Context, r io.Reader, in *RawYUVFormat, w io.Writer, outLayout string, opts VideoEmbedOptions) (int, error) {
+	var read func() (*spectralimage.YUVFrame, error)
+	var hdr spectralimage.Y4MHeader
+	if in == nil {
+		yr, err := spectralimage.NewY4MReader(r)
+		if err != nil {
+			return 0, err
+		}
+		read = yr.ReadFrame
+		hdr = yr.Header()
+	} else {
+		layout, err := spectralimage.ParseRawYUVLayout(in.Layout)
+		if err != nil {
+			return 0, err
+		}
+		read = func() (*spectralimage.YUVFrame, error) {
+			return spectralimage.ReadRawYUVFrame(r, in.Width, in.Height, layout)
+		}
+		hdr = spectralimage.Y4MHeader{W: in.Width, H: in.Height, Subsampling: spectralimage.Subsampling420, Params: []string{"F25:1"}}
+	}
+
+	if outLayout != "" {
+		layout, err := spectralimage.ParseRawYUVLayout(outLayout)
+		if err != nil {
+			return 0, err
+		}
+		if hdr.Subsampling != spectralimage.Subsampling420 {
+			return 0, fmt.Errorf("spectralmark: raw %s output needs 4:2:0 input", layout)
+		}
+		write := func(f *spectralimage.YUVFrame) error {
+			return spectralimage.WriteRawYUVFrame(w, f, layout)
+		}
+		return embedYUVStream(ctx, read, write, opts)
+	}
+
+	yw, err := spectralimage.NewY4MWriter(w, hdr)
+	if err != nil {
+		return 0, err
+	}
+	n, err := embedYUVStream(ctx, read, yw.WriteFrame, opts)
+	if err != nil {
+		return n, err
+	}
+	return n, yw.Flush()
+}
+
+func DetectRawYUV(ctx context.Context, r io.Reader, format RawYUVFormat, opts VideoDetectOptions) (*VideoDetectResult, error) {
+	layout, err := spectralimage.ParseRawYUVLayout(format.Layout)
+	if err != nil {
+		return nil, err
+	}
+	read := func() (*spectralimage.YUVFrame, error) {
+		return spectralimage.ReadRawYUVFrame(r, format.Width, format.Height, layout)
+	}
+	return detectYUVStream(ctx, format.Width, format.Height, read, opts)
+}
+
+// WriteY4M writes frames, which must share one size, as a 4:2:0
+// YUV4MPEG2 stream at 25 fps. Samples are full-range BT.601, the conversion
+// Detect reads luma with, and the header says so.
+func WriteY4M(w io.Writer, frames []image.Image) error {
+	yuv, err := yuvFramesFromStd(frames)
+	if err != nil {
+		return err
+	}
+	yw, err := spectralimage.NewY4MWriter(w, spectralimage.Y4MHeader{
+		W:           yuv[0].W,
+		H:           yuv[0].H,
+		Subsampling: spectralimage.Subsampling420,
+		Params:      []string{"F25:1", "XCOLORRANGE=FULL"},
+	})
+	if err != nil {
+		return err
+	}
+	for i, f := range yuv {
+		if err := yw.WriteFrame(f); err != nil {
+			return fmt.Errorf("spectralmark: write frame %d: %w", i, err)
+		}
+	}
+	return yw.Flush()
+}
+
+// WriteRawYUV is WriteY4M for headerless frames in layout ("i420" or
+// "nv12").
+func WriteRawYUV(w io.Writer, frames []image.Image, layout string) error {
+	l, err := spectralimage.ParseRawYUVLayout(layout)
+	if err != nil {
+		return err
+	}
+	yuv, err := yuvFramesFromStd(frames)
+	if err != nil {
+		return err
+	}
+	for i, f := range yuv {
+		if err := spectralimage.WriteRawYUVFrame(w, f, l); err != nil {
+			return fmt.Errorf("spectralmark: write frame %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// yuvFramesFromStd converts every frame before anything is written, so a
+// frame of the wrong size fails without leaving a partial stream.
+func yuvFramesFromStd(frames []image.Image) ([]*spectralimage.YUVFrame, error) {
+	in, err := fromStdFrames(frames)
+	if err != nil {
+		return nil, err
+	}
+	if len(in) == 0 {
+		return nil, fmt.Errorf("spectralmark: no frames")
+	}
+	out := make([]*spectralimage.YUVFrame, len(in))
+	for i, f := range in {
+		if f.W != in[0].W || f.H != in[0].H {
+			return nil, fmt.Errorf("spectralmark: frame %d is %dx%d, expected %dx%d", i, f.W, f.H, in[0].W, in[0].H)
+		}
+		if out[i], err = spectralimage.YUVFrameFromImage(f); err != nil {
+			return nil, fmt.Errorf("spectralmark: frame %d: %w", i, err)
+		}
+	}
+	return out, nil
+}
+
+func embedYUVStream(ctx context.Context, read func() (*spectralimage.YUVFrame, error), write func(*spectralimage.YUVFrame) error, opts VideoEmbedOptions) (int, error) {
+	if opts.Key == "" {
+		return 0, ErrNoKey
+	}
+	if opts.Alpha == 0 {
+		opts.Alpha = DefaultAlpha
+	}
+	var wmOpts spectralwm.VideoEmbedOptions
+
+	for n := 0; ; n++ {
+		f, err := read()
+		if errors.Is(err, io.EOF) {
+			if n == 0 {
+				return 0, fmt.Errorf("spectralmark: stream has no frames")
+			}
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("spectralmark: frame %d: %w", n, err)
+		}
+		if n == 0 {
+			// The region is laid over the frame size the stream turns out
+			// to have.
+			mask, err := opts.Region.plane(image.Rect(0, 0, f.W, f.H), 1)
+			if err != nil {
+				return 0, err
+			}
+			wmOpts = toWMVideoEmbedOptions(opts, mask)
+		}
+
+		f.Y, err = spectralwm.EmbedFrameLuma8(ctx, f.Y, f.W, f.H, n, opts.Key, opts.Message, wmOpts)
+		if err != nil {
+			return n, fmt.Errorf("spectralmark: frame %d: %w", n, err)
+		}
+		if err := write(f); err != nil {
+			return n, fmt.Errorf("spectralmark: write frame %d: %w", n, err)
+		}
+	}
+}
+
+func detectYUVStream(ctx context.Context, w, h int, read func() (*spectralimage.YUVFrame, error), opts VideoDetectOptions) (*VideoDetectResult, error) {
+	if opts.Key == "" {
+		return nil, ErrNoKey
+	}
+	mask, err := opts.Region.plane(image.Rect(0, 0, w, h), 1)
+	if err != nil {
+		return nil, err
+	}
+	d, err := spectralwm.NewVideoDetector(w, h, opts.Key, toWMVideoDetectOptions(opts, mask))
+	if err != nil {
+		return nil, err
+	}
+
+	n := 0
+	for ; ; n++ {
+		f, err := read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("spectralmark: frame %d: %w", n, err)
+		}
+		if err := d.AddLuma8(ctx, f.Y); err != nil {
+			return nil, fmt.Errorf("spectralmark: frame %d: %w", n, err)
+		}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("spectralmark: stream has no frames")
+	}
+
+	r, err := d.Result(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newVideoDetectResult(r, n), nil
+}
@@ -0,0 +1,243 @@
+package spectralmark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"testing"
+)
+
+const (
+	testYUVWidth  = 256
+	testYUVHeight = 192
+	testYUVFrames = 3
+)
+
+// testYUVPlanes is one 4:2:0 frame: textured Y and patterned chroma.
+func testYUVPlanes(frame int) (y, cb, cr []byte) {
+	img := texturedImage(testYUVWidth, testYUVHeight)
+	y = make([]byte, testYUVWidth*testYUVHeight)
+	for i := range y {
+		y[i] = img.Pix[4*i+(frame%3)]
+	}
+	cw, ch := testYUVWidth/2, testYUVHeight/2
+	cb, cr = make([]byte, cw*ch), make([]byte, cw*ch)
+	for i := range cb {
+		cb[i] = byte(i*3 + frame)
+		cr[i] = byte(255 - i*5 - frame)
+	}
+	return y, cb, cr
+}
+
+func testY4M(header string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	for f := 0; f < testYUVFrames; f++ {
+		y, cb, cr := testYUVPlanes(f)
+		buf.WriteString("FRAME\n")
+		buf.Write(y)
+		buf.Write(cb)
+		buf.Write(cr)
+	}
+	return buf.Bytes()
+}
+
+func testRawYUV(layout string) []byte {
+	var buf bytes.Buffer
+	for f := 0; f < testYUVFrames; f++ {
+		y, cb, cr := testYUVPlanes(f)
+		buf.Write(y)
+		if layout == "i420" {
+			buf.Write(cb)
+			buf.Write(cr)
+			continue
+		}
+		for i := range cb {
+			buf.Write([]byte{cb[i], cr[i]})
+		}
+	}
+	return buf.Bytes()
+}
+
+// sameExceptLuma compares two streams of frameLen-byte frames that follow a
+// header of hdrLen bytes and start with a lumaLen-byte Y plane, everything
+// outside the Y planes byte for byte.
+func sameExceptLuma(t *testing.T, name string, got, want []byte, hdrLen, frameLen, lumaLen int) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: output is %d bytes, input %d", name, len(got), len(want))
+	}
+	if !bytes.Equal(got[:hdrLen], want[:hdrLen]) {
+		t.Fatalf("%s: header %q, want %q", name, got[:hdrLen], want[:hdrLen])
+	}
+	for f := 0; f < testYUVFrames; f++ {
+		off := hdrLen + f*frameLen
+		if !bytes.Equal(got[off+lumaLen:off+frameLen], want[off+lumaLen:off+frameLen]) {
+			t.Errorf("%s: frame %d chroma changed", name, f)
+		}
+		if bytes.Equal(got[off:off+lumaLen], want[off:off+lumaLen]) {
+			t.Errorf("%s: frame %d luma is unmarked", name, f)
+		}
+	}
+}
+
+func TestY4MRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	header := fmt.Sprintf("YUV4MPEG2 W%d H%d F25:1 Ip A1:1 C420mpeg2 XYSCSS=420MPEG2\n", testYUVWidth, testYUVHeight)
+	in := testY4M(header)
+
+	var out bytes.Buffer
+	n, err := EmbedY4M(ctx, bytes.NewReader(in), &out, VideoEmbedOptions{EmbedOptions: EmbedOptions{Key: "k", Message: "Y4M"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != testYUVFrames {
+		t.Fatalf("embedded %d frames, want %d", n, testYUVFrames)
+	}
+	luma := testYUVWidth * testYUVHeight
+	sameExceptLuma(t, "y4m", out.Bytes(), in, len(header), len("FRAME\n")+luma*3/2, len("FRAME\n")+luma)
+
+	d, err := DetectY4M(ctx, bytes.NewReader(out.Bytes()), VideoDetectOptions{DetectOptions: DetectOptions{Key: "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OK || d.Message != "Y4M" {
+		t.Errorf("detect = %+v", d)
+	}
+}
+
+func TestRawYUVRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	format := func(layout string) RawYUVFormat {
+		return RawYUVFormat{Width: testYUVWidth, Height: testYUVHeight, Layout: layout}
+	}
+	for _, layout := range []string{"i420", "nv12"} {
+		in := testRawYUV(layout)
+		var out bytes.Buffer
+		if _, err := EmbedRawYUV(ctx, bytes.NewReader(in), &out, format(layout), VideoEmbedOptions{EmbedOptions: EmbedOptions{Key: "k", Message: "RAW"}}); err != nil {
+			t.Fatalf("%s: %v", layout, err)
+		}
+		luma := testYUVWidth * testYUVHeight
+		sameExceptLuma(t, layout, out.Bytes(), in, 0, luma*3/2, luma)
+
+		d, err := DetectRawYUV(ctx, bytes.NewReader(out.Bytes()), format(layout), VideoDetectOptions{DetectOptions: DetectOptions{Key: "k"}})
+		if err != nil {
+			t.Fatalf("%s: %v", layout, err)
+		}
+		if !d.OK || d.Message != "RAW" {
+			t.Errorf("%s: detect = %+v", layout, d)
+		}
+	}
+}
+
+func TestYUVTruncated(t *testing.T) {
+	ctx := context.Background()
+	opts := VideoEmbedOptions{EmbedOptions: EmbedOptions{Key: "k", Message: "CUT"}}
+	header := fmt.Sprintf("YUV4MPEG2 W%d H%d C420jpeg\n", testYUVWidth, testYUVHeight)
+	y4m := testY4M(header)
+	raw := testRawYUV("i420")
+	frame := testYUVWidth * testYUVHeight * 3 / 2
+
+	// Cut inside the header, inside a frame header and inside the planes.
+	for _, n := range []int{0, 10, len(header) + 3, len(header) + 6 + frame/2, len(y4m) - 1} {
+		if _, err := EmbedY4M(ctx, bytes.NewReader(y4m[:n]), &bytes.Buffer{}, opts); err == nil {
+			t.Errorf("y4m cut at %d of %d bytes embedded", n, len(y4m))
+		}
+		if _, err := DetectY4M(ctx, bytes.NewReader(y4m[:n]), VideoDetectOptions{DetectOptions: DetectOptions{Key: "k"}}); err == nil {
+			t.Errorf("y4m cut at %d of %d bytes detected", n, len(y4m))
+		}
+	}
+	for _, n := range []int{0, frame / 3, frame + 1, len(raw) - 1} {
+		format := RawYUVFormat{Width: testYUVWidth, Height: testYUVHeight, Layout: "i420"}
+		if _, err := EmbedRawYUV(ctx, bytes.NewReader(raw[:n]), &bytes.Buffer{}, format, opts); err == nil {
+			t.Errorf("raw cut at %d of %d bytes embedded", n, len(raw))
+		}
+	}
+}
+
+func TestWriteYUVFromFrames(t *testing.T) {
+	ctx := context.Background()
+	var frames []image.Image
+	for f := 0; f < testYUVFrames; f++ {
+		frames = append(frames, texturedImage(testYUVWidth, testYUVHeight))
+	}
+	marked, err := EmbedVideo(ctx, frames, VideoEmbedOptions{EmbedOptions: EmbedOptions{Key: "k", Message: "DIR"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range marked {
+		frames[i] = f
+	}
+	opts := VideoDetectOptions{DetectOptions: DetectOptions{Key: "k"}}
+
+	var y4m bytes.Buffer
+	if err := WriteY4M(&y4m, frames); err != nil {
+		t.Fatal(err)
+	}
+	d, err := DetectY4M(ctx, bytes.NewReader(y4m.Bytes()), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OK || d.Message != "DIR" || d.Frames != testYUVFrames {
+		t.Errorf("y4m: detect = %+v", d)
+	}
+
+	var raw bytes.Buffer
+	if err := WriteRawYUV(&raw, frames, "nv12"); err != nil {
+		t.Fatal(err)
+	}
+	d, err = DetectRawYUV(ctx, bytes.NewReader(raw.Bytes()), RawYUVFormat{Width: testYUVWidth, Height: testYUVHeight, Layout: "nv12"}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OK || d.Message != "DIR" || d.Frames != testYUVFrames {
+		t.Errorf("nv12: detect = %+v", d)
+	}
+
+	var mixed bytes.Buffer
+	frames = append(frames, texturedImage(testYUVWidth/2, testYUVHeight))
+	if err := WriteY4M(&mixed, frames); err == nil || mixed.Len() != 0 {
+		t.Errorf("frames of two sizes: err %v, wrote %d bytes", err, mixed.Len())
+	}
+}
+
+func TestEmbedYUVConvertsContainer(t *testing.T) {
+	ctx := context.Background()
+	opts := VideoEmbedOptions{EmbedOptions: EmbedOptions{Key: "k", Message: "CONV"}}
+	luma := testYUVWidth * testYUVHeight
+	raw := testRawYUV("i420")
+
+	// Raw I420 to Y4M: a new header, then the same planes behind FRAME lines.
+	var y4m bytes.Buffer
+	format := RawYUVFormat{Width: testYUVWidth, Height: testYUVHeight, Layout: "i420"}
+	if _, err := EmbedYUV(ctx, bytes.NewReader(raw), &format, &y4m, "", opts); err != nil {
+		t.Fatal(err)
+	}
+	header := fmt.Sprintf("YUV4MPEG2 W%d H%d F25:1 C420jpeg\n", testYUVWidth, testYUVHeight)
+	sameExceptLuma(t, "i420 to y4m", y4m.Bytes(), testY4M(header), len(header), len("FRAME\n")+luma*3/2, len("FRAME\n")+luma)
+
+	// And back to NV12.
+	var nv12 bytes.Buffer
+	if _, err := EmbedYUV(ctx, bytes.NewReader(y4m.Bytes()), nil, &nv12, "nv12", opts); err != nil {
+		t.Fatal(err)
+	}
+	sameExceptLuma(t, "y4m to nv12", nv12.Bytes(), testRawYUV("nv12"), 0, luma*3/2, luma)
+	d, err := DetectRawYUV(ctx, bytes.NewReader(nv12.Bytes()), RawYUVFormat{Width: testYUVWidth, Height: testYUVHeight, Layout: "nv12"}, VideoDetectOptions{DetectOptions: DetectOptions{Key: "k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.OK || d.Message != "CONV" {
+		t.Errorf("detect = %+v", d)
+	}
+
+	// 4:4:4 has no raw layout, and nothing is written for a bad input.
+	var out bytes.Buffer
+	in := fmt.Sprintf("YUV4MPEG2 W%d H%d C444\n", testYUVWidth, testYUVHeight)
+	if _, err := EmbedYUV(ctx, bytes.NewReader([]byte(in)), nil, &out, "i420", opts); err == nil || out.Len() != 0 {
+		t.Errorf("4:4:4 to i420: err %v, wrote %d bytes", err, out.Len())
+	}
+	if _, err := EmbedYUV(ctx, bytes.NewReader(y4m.Bytes()[:len(header)+100]), nil, &out, "", opts); err == nil || out.Len() != 0 {
+		t.Errorf("truncated y4m: err %v, wrote %d bytes", err, out.Len())
+	}
+}